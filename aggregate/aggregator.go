@@ -3,6 +3,7 @@ package aggregate
 import (
 	"crypto/md5"
 	"fmt"
+	"math/big"
 
 	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/util"
 	"github.com/aws/aws-sdk-go/aws"
@@ -23,6 +24,9 @@ const (
 	defaultMaxAggRecordSize = 20 * 1024   // 20K
 	initialAggRecordSize    = 0
 	fieldNumberSize         = 1 // All field numbers are below 16, meaning they will only take up 1 byte
+
+	// An explicit hash key is a 128 bit unsigned integer, which has at most 39 decimal digits
+	maxExplicitHashKeyLen = 39
 )
 
 // Aggregator kinesis aggregator
@@ -32,6 +36,11 @@ type Aggregator struct {
 	aggSize          int // Size of both records, and partitionKeys in bytes
 	maxAggRecordSize int
 	stringGen        *util.RandomStringGenerator
+	// If set, chooses the partition key each aggregated record is sent with,
+	// instead of the key of its first user record
+	routingKey func() string
+	// If non-zero, the most user records an aggregated record holds
+	maxRecords int
 }
 
 // NewAggregator create a new aggregator
@@ -46,6 +55,20 @@ func NewAggregator(stringGen *util.RandomStringGenerator) *Aggregator {
 	}
 }
 
+// SetRoutingKey makes aggregated records be sent with the partition key returned by
+// routingKey, rather than the key of their first user record. Each user record is
+// then given the explicit hash key of that partition key, so the KCL, which drops
+// de-aggregated records that hash outside the shard they were read from, keeps them.
+// User records still carry their own partition keys for consumers.
+func (a *Aggregator) SetRoutingKey(routingKey func() string) {
+	a.routingKey = routingKey
+}
+
+// SetMaxRecords caps the number of user records in each aggregated record, on top of its size
+func (a *Aggregator) SetMaxRecords(maxRecords int) {
+	a.maxRecords = maxRecords
+}
+
 // AddRecord to the aggregate buffer.
 // Will return a kinesis PutRecordsRequest once buffer is full, or if the data exceeds the aggregate limit.
 func (a *Aggregator) AddRecord(partitionKey string, hasPartitionKey bool, data []byte) (entry *kinesis.PutRecordsRequestEntry, err error) {
@@ -88,10 +111,11 @@ func (a *Aggregator) AddRecord(partitionKey string, hasPartitionKey bool, data [
 	// data field size is proto size of data + data field number size
 	// partition key field size is varint of index size + field number size
 	dataFieldSize := protowire.SizeBytes(dataSize) + fieldNumberSize
-	pkeyFieldSize := protowire.SizeVarint(pKeyIdx) + fieldNumberSize
+	pkeyFieldSize := protowire.SizeVarint(pKeyIdx) + fieldNumberSize + a.explicitHashKeyIndexSize()
 	// Total size is byte size of data + pkey field + field number of parent proto
 
-	if a.getSize()+protowire.SizeBytes(dataFieldSize+pkeyFieldSize)+fieldNumberSize+pKeyAddedSize >= maximumRecordSize {
+	full := a.maxRecords > 0 && len(a.records) >= a.maxRecords
+	if full || a.getSize()+protowire.SizeBytes(dataFieldSize+pkeyFieldSize)+fieldNumberSize+pKeyAddedSize >= maximumRecordSize {
 		// Aggregate records, and return if error
 		entry, err = a.AggregateRecords()
 		if err != nil {
@@ -104,7 +128,7 @@ func (a *Aggregator) AddRecord(partitionKey string, hasPartitionKey bool, data [
 		}
 		// Recompute field size, since it changed
 		pKeyIdx, _ = a.checkPartitionKey(partitionKey)
-		pkeyFieldSize = protowire.SizeVarint(pKeyIdx) + fieldNumberSize
+		pkeyFieldSize = protowire.SizeVarint(pKeyIdx) + fieldNumberSize + a.explicitHashKeyIndexSize()
 	}
 
 	// Add new record, and update aggSize
@@ -134,6 +158,16 @@ func (a *Aggregator) AggregateRecords() (entry *kinesis.PutRecordsRequestEntry,
 		Records:           a.records,
 	}
 
+	partitionKey := pkeys[0]
+	if a.routingKey != nil {
+		partitionKey = a.routingKey()
+		agg.ExplicitHashKeyTable = []string{explicitHashKey(partitionKey)}
+		explicitHashKeyIndex := uint64(0)
+		for _, record := range a.records {
+			record.ExplicitHashKeyIndex = &explicitHashKeyIndex
+		}
+	}
+
 	protoBufData, err := proto.Marshal(agg)
 	if err != nil {
 		logrus.Errorf("Failed to encode record: %v", err)
@@ -147,14 +181,14 @@ func (a *Aggregator) AggregateRecords() (entry *kinesis.PutRecordsRequestEntry,
 	kclData := append(kclMagicNumber, protoBufData...)
 	kclData = append(kclData, md5CheckSum...)
 
-	logrus.Debugf("[kinesis ] Aggregated (%d) records of size (%d) with total size (%d), partition key (%s)\n", len(a.records), a.getSize(), len(kclData), pkeys[0])
+	logrus.Debugf("[kinesis ] Aggregated (%d) records of size (%d) with total size (%d), partition key (%s)\n", len(a.records), a.getSize(), len(kclData), partitionKey)
 
 	// Clear buffer if aggregation didn't fail
 	a.clearBuffer()
 
 	return &kinesis.PutRecordsRequestEntry{
 		Data:         kclData,
-		PartitionKey: aws.String(pkeys[0]),
+		PartitionKey: aws.String(partitionKey),
 	}, nil
 }
 
@@ -187,17 +221,38 @@ func (a *Aggregator) checkPartitionKey(partitionKey string) (uint64, int) {
 	return idx, protowire.SizeBytes(partitionKeyLen) + fieldNumberSize
 }
 
+// getPartitionKeys returns the partition key table, in the order of the indexes records refer to
 func (a *Aggregator) getPartitionKeys() []string {
-	keys := make([]string, 0)
-	for pk := range a.partitionKeys {
-		keys = append(keys, pk)
+	keys := make([]string, len(a.partitionKeys))
+	for pk, idx := range a.partitionKeys {
+		keys[idx] = pk
 	}
 	return keys
 }
 
+// explicitHashKeyIndexSize is the size of the explicit hash key index field of each record
+func (a *Aggregator) explicitHashKeyIndexSize() int {
+	if a.routingKey == nil {
+		return 0
+	}
+	return protowire.SizeVarint(0) + fieldNumberSize
+}
+
 // getSize of protobuf records, partitionKeys, magicNumber, and md5sum in bytes
 func (a *Aggregator) getSize() int {
-	return kclMagicNumberLen + md5.Size + a.aggSize
+	size := kclMagicNumberLen + md5.Size + a.aggSize
+	if a.routingKey != nil {
+		// room for the single entry explicit hash key table
+		size += protowire.SizeBytes(maxExplicitHashKeyLen) + fieldNumberSize
+	}
+	return size
+}
+
+// explicitHashKey returns the hash key Kinesis derives from a partition key: the
+// MD5 of the key as an unsigned 128 bit integer, in decimal
+func explicitHashKey(partitionKey string) string {
+	sum := md5.Sum([]byte(partitionKey))
+	return new(big.Int).SetBytes(sum[:]).String()
 }
 
 func (a *Aggregator) clearBuffer() {
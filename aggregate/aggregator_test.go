@@ -1,10 +1,14 @@
 package aggregate
 
 import (
+	"crypto/md5"
 	"testing"
 
 	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 const concurrencyRetryLimit = 4
@@ -34,3 +38,86 @@ func TestAddRecordDoesNotAddNewRandomPartitionKey(t *testing.T) {
 	assert.Equal(t, nil, err, "Expected aggregator not to return error")
 	assert.Equal(t, 1, len(aggregator.partitionKeys), "Expected aggregator to reuse partitionKey value")
 }
+
+func TestAggregateRecordsPartitionKeyTableOrder(t *testing.T) {
+	generator := util.NewRandomStringGenerator(18)
+	aggregator := NewAggregator(generator)
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, key := range keys {
+		_, err := aggregator.AddRecord(key, true, []byte("value "+key))
+		assert.NoError(t, err)
+	}
+	entry, err := aggregator.AggregateRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", aws.StringValue(entry.PartitionKey), "Expected the key of the first record")
+
+	agg := decodeAggregate(t, entry.Data)
+	assert.Equal(t, keys, agg.PartitionKeyTable, "Expected keys in the order their indexes were assigned")
+	for i, record := range agg.Records {
+		assert.Equal(t, "value "+agg.PartitionKeyTable[record.GetPartitionKeyIndex()], string(record.Data))
+		assert.Equal(t, uint64(i), record.GetPartitionKeyIndex())
+	}
+}
+
+func TestAggregateRecordsRoutingKey(t *testing.T) {
+	generator := util.NewRandomStringGenerator(18)
+	aggregator := NewAggregator(generator)
+	aggregator.SetRoutingKey(func() string { return "route" })
+
+	for _, key := range []string{"a", "b"} {
+		_, err := aggregator.AddRecord(key, true, []byte("value"))
+		assert.NoError(t, err)
+	}
+	size := aggregator.getSize()
+	entry, err := aggregator.AggregateRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, "route", aws.StringValue(entry.PartitionKey))
+	assert.LessOrEqual(t, len(entry.Data), size, "Expected the size to account for the explicit hash keys")
+
+	agg := decodeAggregate(t, entry.Data)
+	assert.Equal(t, []string{"a", "b"}, agg.PartitionKeyTable, "Expected user records to keep their keys")
+	// the MD5 of "route" as a decimal integer, so user records hash to the shard the record is sent to
+	assert.Equal(t, []string{"207724337148744762737311467335618839790"}, agg.ExplicitHashKeyTable)
+	for _, record := range agg.Records {
+		if assert.NotNil(t, record.ExplicitHashKeyIndex) {
+			assert.Equal(t, uint64(0), *record.ExplicitHashKeyIndex)
+		}
+	}
+}
+
+func decodeAggregate(t *testing.T, data []byte) *AggregatedRecord {
+	// strip the magic number and the MD5 checksum
+	agg := &AggregatedRecord{}
+	assert.NoError(t, proto.Unmarshal(data[kclMagicNumberLen:len(data)-md5.Size], agg))
+	return agg
+}
+
+func TestAddRecordMaxRecords(t *testing.T) {
+	generator := util.NewRandomStringGenerator(18)
+	aggregator := NewAggregator(generator)
+	aggregator.SetMaxRecords(3)
+
+	var entries []*kinesis.PutRecordsRequestEntry
+	for i := 0; i < 10; i++ {
+		entry, err := aggregator.AddRecord("key", true, []byte("small value"))
+		assert.NoError(t, err)
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	entry, err := aggregator.AggregateRecords()
+	assert.NoError(t, err)
+	entries = append(entries, entry)
+
+	if !assert.Len(t, entries, 4) {
+		return
+	}
+	total := 0
+	for _, entry := range entries {
+		agg := decodeAggregate(t, entry.Data)
+		assert.LessOrEqual(t, len(agg.Records), 3, "Expected aggregates to hold at most 3 records")
+		total += len(agg.Records)
+	}
+	assert.Equal(t, 10, total)
+}
@@ -0,0 +1,109 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads plugin parameters from a JSON or YAML file. The file must contain
+// a single object whose keys are plugin parameter names. Scalar values are used as is,
+// lists of scalars are joined with commas, and any other structured value is passed on
+// encoded as JSON. An empty path returns an empty config.
+func loadConfigFile(path string) (map[string]string, error) {
+	config := make(map[string]string)
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension '%s', expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	for key, value := range raw {
+		str, err := configValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for '%s' in %s: %v", key, path, err)
+		}
+		// Fluent Bit config keys are case insensitive
+		config[strings.ToLower(key)] = str
+	}
+	return config, nil
+}
+
+func configValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return encodeConfigValue(value)
+			}
+			str, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, str)
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return encodeConfigValue(value)
+	}
+}
+
+func encodeConfigValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// newConfigKeyGetter returns a lookup for plugin parameters which prefers values set
+// inline in the Fluent Bit configuration over those loaded from config_file
+func newConfigKeyGetter(inline func(key string) string, fileConfig map[string]string) func(key string) string {
+	return func(key string) string {
+		if value := inline(key); value != "" {
+			return value
+		}
+		return fileConfig[strings.ToLower(key)]
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func inlineConfig(values map[string]string) func(key string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func TestConfigFileOnly(t *testing.T) {
+	path := writeConfigFile(t, "kinesis.yaml", `
+stream: file-stream
+Region: us-west-2
+experimental_concurrency: 4
+aggregation: true
+data_keys:
+  - log
+  - level
+`)
+	fileConfig, err := loadConfigFile(path)
+	assert.NoError(t, err)
+
+	getConfigKey := newConfigKeyGetter(inlineConfig(nil), fileConfig)
+	assert.Equal(t, "file-stream", getConfigKey("stream"))
+	assert.Equal(t, "us-west-2", getConfigKey("region"), "Expected keys to be case insensitive")
+	assert.Equal(t, "4", getConfigKey("experimental_concurrency"))
+	assert.Equal(t, "true", getConfigKey("aggregation"))
+	assert.Equal(t, "log,level", getConfigKey("data_keys"))
+	assert.Equal(t, "", getConfigKey("partition_key"))
+}
+
+func TestConfigInlineOnly(t *testing.T) {
+	fileConfig, err := loadConfigFile("")
+	assert.NoError(t, err)
+
+	getConfigKey := newConfigKeyGetter(inlineConfig(map[string]string{
+		"stream": "inline-stream",
+	}), fileConfig)
+	assert.Equal(t, "inline-stream", getConfigKey("stream"))
+	assert.Equal(t, "", getConfigKey("region"))
+}
+
+func TestConfigFileMerged(t *testing.T) {
+	path := writeConfigFile(t, "kinesis.json", `{
+		"stream": "file-stream",
+		"region": "us-west-2",
+		"partition_key": "kubernetes->pod_name",
+		"extra_fields": {"env": "prod", "team": ["a", "b"]}
+	}`)
+	fileConfig, err := loadConfigFile(path)
+	assert.NoError(t, err)
+
+	getConfigKey := newConfigKeyGetter(inlineConfig(map[string]string{
+		"stream": "inline-stream",
+	}), fileConfig)
+	assert.Equal(t, "inline-stream", getConfigKey("stream"), "Expected inline keys to override the file")
+	assert.Equal(t, "us-west-2", getConfigKey("region"))
+	assert.Equal(t, "kubernetes->pod_name", getConfigKey("partition_key"))
+	assert.JSONEq(t, `{"env": "prod", "team": ["a", "b"]}`, getConfigKey("extra_fields"), "Expected structured values to be encoded as JSON")
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	_, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	_, err = loadConfigFile(writeConfigFile(t, "kinesis.toml", "stream = 'x'"))
+	assert.Error(t, err, "Expected unsupported extensions to be rejected")
+
+	_, err = loadConfigFile(writeConfigFile(t, "kinesis.json", "[1, 2]"))
+	assert.Error(t, err, "Expected a top level list to be rejected")
+}
@@ -15,6 +15,7 @@ package main
 
 import (
 	"C"
+	"crypto/tls"
 	"fmt"
 	"strconv"
 	"strings"
@@ -23,6 +24,8 @@ import (
 
 	"github.com/aws/amazon-kinesis-firehose-for-fluent-bit/plugins"
 	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis"
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/aws/aws-sdk-go/aws"
 	kinesisAPI "github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/fluent/fluent-bit-go/output"
 	"github.com/sirupsen/logrus"
@@ -33,77 +36,549 @@ const (
 	maximumRecordsPerPut     = 500
 	maximumConcurrency       = 10
 	defaultConcurrentRetries = 4
+
+	defaultPartitionKeyHistogramTopN = 10
+
+	// far more output sections than any real configuration defines, but few enough
+	// to stop a templating mistake from creating instances without bound
+	defaultMaxPluginInstances = 256
 )
 
 var (
 	pluginInstances []*kinesis.OutputPlugin
+	// the metrics server is shared by all plugin instances, and started by the first to configure it
+	metricsServer  *metrics.Server
+	metricsAddress string
+	// the limit set by max_plugin_instances, shared by all instances, and whether an instance set it
+	maxPluginInstances    = defaultMaxPluginInstances
+	maxPluginInstancesSet bool
 )
 
 func addPluginInstance(ctx unsafe.Pointer) error {
 	pluginID := len(pluginInstances)
+	getConfigKey, err := newPluginConfigKeyGetter(ctx, pluginID)
+	if err != nil {
+		return err
+	}
+	if err := checkPluginInstanceLimit(getConfigKey("max_plugin_instances"), pluginID); err != nil {
+		return err
+	}
 	output.FLBPluginSetContext(ctx, pluginID)
-	instance, err := newKinesisOutput(ctx, pluginID)
+	instance, err := newKinesisOutput(getConfigKey, pluginID)
 	if err != nil {
 		return err
 	}
 
 	pluginInstances = append(pluginInstances, instance)
+	// records left unsent by a crash are sent before the instance accepts new ones
+	if instance.ReplayCheckpoints() != output.FLB_OK {
+		instance.Logger().Warnf("Not every record of checkpoint_dir could be replayed, the rest will be replayed before the next flush\n")
+	}
 	return nil
 }
 
+// checkPluginInstanceLimit returns an error if creating the instance with pluginID, which
+// is the number of instances created so far, would exceed max_plugin_instances. The limit
+// applies to all instances, so it is an error for an instance to set a different one.
+func checkPluginInstanceLimit(limit string, pluginID int) error {
+	if limit != "" {
+		logrus.Infof("[kinesis %d] plugin parameter max_plugin_instances = '%s'", pluginID, limit)
+		limitInt, err := parseNonNegativeConfig("max_plugin_instances", limit, pluginID)
+		if err != nil {
+			return err
+		}
+		if limitInt == 0 {
+			return fmt.Errorf("[kinesis %d] Invalid 'max_plugin_instances' %s, must be at least 1", pluginID, limit)
+		}
+		if maxPluginInstancesSet && limitInt != maxPluginInstances {
+			return fmt.Errorf("[kinesis %d] 'max_plugin_instances' %s conflicts with the limit of %d set by another kinesis output, the limit is shared by all of them", pluginID, limit, maxPluginInstances)
+		}
+		maxPluginInstances, maxPluginInstancesSet = limitInt, true
+	}
+	if pluginID >= maxPluginInstances {
+		return fmt.Errorf("[kinesis %d] More than %d kinesis output instances are configured, which exceeds 'max_plugin_instances'. Check the configuration for repeated output sections, or raise 'max_plugin_instances' if they are intended", pluginID, maxPluginInstances)
+	}
+	if pluginID+1 == maxPluginInstances {
+		logrus.Warnf("[kinesis %d] %d kinesis output instances are configured, the most 'max_plugin_instances' allows", pluginID, maxPluginInstances)
+	}
+	return nil
+}
+
+func startMetricsServer(address string, pluginID int) error {
+	if metricsServer != nil {
+		if address != metricsAddress {
+			logrus.Warnf("[kinesis %d] metrics are already served on %s, ignoring 'metrics_address' %s", pluginID, metricsAddress, address)
+		}
+		return nil
+	}
+
+	server, err := metrics.DefaultRegistry.Serve(address)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("[kinesis %d] serving metrics on http://%s/metrics", pluginID, server.Addr)
+	metricsServer, metricsAddress = server, address
+	return nil
+}
+
+func stopMetricsServer() {
+	if metricsServer == nil {
+		return
+	}
+	if err := metricsServer.Close(); err != nil {
+		logrus.Errorf("[kinesis] failed to stop metrics server: %v", err)
+	}
+	metricsServer, metricsAddress = nil, ""
+}
+
+// closePluginInstances releases every plugin instance and the shared metrics server
+func closePluginInstances() {
+	for _, instance := range pluginInstances {
+		if err := instance.Close(); err != nil {
+			instance.Logger().Errorf("Failed to close plugin: %v\n", err)
+		}
+	}
+	pluginInstances = nil
+	maxPluginInstances, maxPluginInstancesSet = defaultMaxPluginInstances, false
+	stopMetricsServer()
+}
+
 func getPluginInstance(ctx unsafe.Pointer) *kinesis.OutputPlugin {
 	pluginID := output.FLBPluginGetContext(ctx).(int)
 	return pluginInstances[pluginID]
 }
 
-func newKinesisOutput(ctx unsafe.Pointer, pluginID int) (*kinesis.OutputPlugin, error) {
-	stream := output.FLBPluginConfigKey(ctx, "stream")
+// newPluginConfigKeyGetter returns the lookup for the plugin parameters of an output
+// section, which also reads those of its config_file
+func newPluginConfigKeyGetter(ctx unsafe.Pointer, pluginID int) (func(key string) string, error) {
+	configFile := output.FLBPluginConfigKey(ctx, "config_file")
+	logrus.Infof("[kinesis %d] plugin parameter config_file = '%s'", pluginID, configFile)
+	fileConfig, err := loadConfigFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("[kinesis %d] Failed to load config_file: %v", pluginID, err)
+	}
+	return newConfigKeyGetter(func(key string) string {
+		return output.FLBPluginConfigKey(ctx, key)
+	}, fileConfig), nil
+}
+
+func newKinesisOutput(getConfigKey func(key string) string, pluginID int) (*kinesis.OutputPlugin, error) {
+	var err error
+	stream := getConfigKey("stream")
 	logrus.Infof("[kinesis %d] plugin parameter stream = '%s'", pluginID, stream)
-	region := output.FLBPluginConfigKey(ctx, "region")
+	region := getConfigKey("region")
 	logrus.Infof("[kinesis %d] plugin parameter region = '%s'", pluginID, region)
-	dataKeys := output.FLBPluginConfigKey(ctx, "data_keys")
+	dataKeys := getConfigKey("data_keys")
 	logrus.Infof("[kinesis %d] plugin parameter data_keys = '%s'", pluginID, dataKeys)
-	partitionKey := output.FLBPluginConfigKey(ctx, "partition_key")
+	partitionKey := getConfigKey("partition_key")
 	logrus.Infof("[kinesis %d] plugin parameter partition_key = '%s'", pluginID, partitionKey)
-	roleARN := output.FLBPluginConfigKey(ctx, "role_arn")
+	roleARN := getConfigKey("role_arn")
 	logrus.Infof("[kinesis %d] plugin parameter role_arn = '%s'", pluginID, roleARN)
-	kinesisEndpoint := output.FLBPluginConfigKey(ctx, "endpoint")
+	externalID := getConfigKey("external_id")
+	logrus.Infof("[kinesis %d] plugin parameter external_id = '%s'", pluginID, externalID)
+	kinesisEndpoint := getConfigKey("endpoint")
 	logrus.Infof("[kinesis %d] plugin parameter endpoint = '%s'", pluginID, kinesisEndpoint)
-	stsEndpoint := output.FLBPluginConfigKey(ctx, "sts_endpoint")
+	stsEndpoint := getConfigKey("sts_endpoint")
 	logrus.Infof("[kinesis %d] plugin parameter sts_endpoint = '%s'", pluginID, stsEndpoint)
-	appendNewline := output.FLBPluginConfigKey(ctx, "append_newline")
+	appendNewline := getConfigKey("append_newline")
 	logrus.Infof("[kinesis %d] plugin parameter append_newline = %s", pluginID, appendNewline)
-	timeKey := output.FLBPluginConfigKey(ctx, "time_key")
+	timeKey := getConfigKey("time_key")
 	logrus.Infof("[kinesis %d] plugin parameter time_key = '%s'", pluginID, timeKey)
-	timeKeyFmt := output.FLBPluginConfigKey(ctx, "time_key_format")
+	timeKeyFmt := getConfigKey("time_key_format")
 	logrus.Infof("[kinesis %d] plugin parameter time_key_format = '%s'", pluginID, timeKeyFmt)
-	concurrency := output.FLBPluginConfigKey(ctx, "experimental_concurrency")
+	concurrency := getConfigKey("experimental_concurrency")
 	logrus.Infof("[kinesis %d] plugin parameter experimental_concurrency = '%s'", pluginID, concurrency)
-	concurrencyRetries := output.FLBPluginConfigKey(ctx, "experimental_concurrency_retries")
+	concurrencyRetries := getConfigKey("experimental_concurrency_retries")
 	logrus.Infof("[kinesis %d] plugin parameter experimental_concurrency_retries = '%s'", pluginID, concurrencyRetries)
-	logKey := output.FLBPluginConfigKey(ctx, "log_key")
+	logKey := getConfigKey("log_key")
 	logrus.Infof("[kinesis %d] plugin parameter log_key = '%s'", pluginID, logKey)
-	aggregation := output.FLBPluginConfigKey(ctx, "aggregation")
+	aggregation := getConfigKey("aggregation")
 	logrus.Infof("[kinesis %d] plugin parameter aggregation = '%s'", pluginID, aggregation)
-	compression := output.FLBPluginConfigKey(ctx, "compression")
+	compression := getConfigKey("compression")
 	logrus.Infof("[kinesis %d] plugin parameter compression = '%s'", pluginID, compression)
-	replaceDots := output.FLBPluginConfigKey(ctx, "replace_dots")
+	replaceDots := getConfigKey("replace_dots")
 	logrus.Infof("[kinesis %d] plugin parameter replace_dots = '%s'", pluginID, replaceDots)
-	httpRequestTimeout := output.FLBPluginConfigKey(ctx, "http_request_timeout")
+	httpRequestTimeout := getConfigKey("http_request_timeout")
 	logrus.Infof("[kinesis %d] plugin parameter http_request_timeout = '%s'", pluginID, httpRequestTimeout)
+	partitionKeyHistogram := getConfigKey("partition_key_histogram")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_histogram = '%s'", pluginID, partitionKeyHistogram)
+	partitionKeyHistogramTopN := getConfigKey("partition_key_histogram_top_n")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_histogram_top_n = '%s'", pluginID, partitionKeyHistogramTopN)
+	partitionKeyHistogramInterval := getConfigKey("partition_key_histogram_interval")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_histogram_interval = '%s'", pluginID, partitionKeyHistogramInterval)
+	httpProxy := getConfigKey("http_proxy")
+	logrus.Infof("[kinesis %d] plugin parameter http_proxy = '%s'", pluginID, httpProxy)
+	httpsProxy := getConfigKey("https_proxy")
+	logrus.Infof("[kinesis %d] plugin parameter https_proxy = '%s'", pluginID, httpsProxy)
+	noProxy := getConfigKey("no_proxy")
+	logrus.Infof("[kinesis %d] plugin parameter no_proxy = '%s'", pluginID, noProxy)
+	bufferMaxBytes := getConfigKey("buffer_max_bytes")
+	logrus.Infof("[kinesis %d] plugin parameter buffer_max_bytes = '%s'", pluginID, bufferMaxBytes)
+	spillDir := getConfigKey("spill_dir")
+	logrus.Infof("[kinesis %d] plugin parameter spill_dir = '%s'", pluginID, spillDir)
+	partitionKeySource := getConfigKey("partition_key_source")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_source = '%s'", pluginID, partitionKeySource)
+	recordHashAlgorithm := getConfigKey("record_hash_algorithm")
+	logrus.Infof("[kinesis %d] plugin parameter record_hash_algorithm = '%s'", pluginID, recordHashAlgorithm)
+	partitionKeyHash := getConfigKey("partition_key_hash")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_hash = '%s'", pluginID, partitionKeyHash)
+	roundRobinKeys := getConfigKey("round_robin_keys")
+	logrus.Infof("[kinesis %d] plugin parameter round_robin_keys = '%s'", pluginID, roundRobinKeys)
+	retryBudgetPerMinute := getConfigKey("retry_budget_per_minute")
+	logrus.Infof("[kinesis %d] plugin parameter retry_budget_per_minute = '%s'", pluginID, retryBudgetPerMinute)
+	framing := getConfigKey("framing")
+	logrus.Infof("[kinesis %d] plugin parameter framing = '%s'", pluginID, framing)
+	quiet := getConfigKey("quiet")
+	logrus.Infof("[kinesis %d] plugin parameter quiet = '%s'", pluginID, quiet)
+	useFIPSEndpoint := getConfigKey("use_fips_endpoint")
+	logrus.Infof("[kinesis %d] plugin parameter use_fips_endpoint = '%s'", pluginID, useFIPSEndpoint)
+	metricsAddr := getConfigKey("metrics_address")
+	logrus.Infof("[kinesis %d] plugin parameter metrics_address = '%s'", pluginID, metricsAddr)
+	sdkMaxRetries := getConfigKey("aws_sdk_max_retries")
+	logrus.Infof("[kinesis %d] plugin parameter aws_sdk_max_retries = '%s'", pluginID, sdkMaxRetries)
+	maxIdleConns := getConfigKey("max_idle_conns")
+	logrus.Infof("[kinesis %d] plugin parameter max_idle_conns = '%s'", pluginID, maxIdleConns)
+	httpTimeout := getConfigKey("http_timeout")
+	logrus.Infof("[kinesis %d] plugin parameter http_timeout = '%s'", pluginID, httpTimeout)
+	teeStdout := getConfigKey("tee_stdout")
+	logrus.Infof("[kinesis %d] plugin parameter tee_stdout = '%s'", pluginID, teeStdout)
+	teeStdoutRecordsPerSecond := getConfigKey("tee_stdout_records_per_second")
+	logrus.Infof("[kinesis %d] plugin parameter tee_stdout_records_per_second = '%s'", pluginID, teeStdoutRecordsPerSecond)
+	workers := getConfigKey("workers")
+	logrus.Infof("[kinesis %d] plugin parameter workers = '%s'", pluginID, workers)
+	workerHash := getConfigKey("worker_hash")
+	logrus.Infof("[kinesis %d] plugin parameter worker_hash = '%s'", pluginID, workerHash)
+	addHostMetadata := getConfigKey("add_host_metadata")
+	logrus.Infof("[kinesis %d] plugin parameter add_host_metadata = '%s'", pluginID, addHostMetadata)
+	hostnameKey := getConfigKey("hostname_key")
+	logrus.Infof("[kinesis %d] plugin parameter hostname_key = '%s'", pluginID, hostnameKey)
+	hostIPKey := getConfigKey("host_ip_key")
+	logrus.Infof("[kinesis %d] plugin parameter host_ip_key = '%s'", pluginID, hostIPKey)
+	compressionDictFile := getConfigKey("compression_dict_file")
+	logrus.Infof("[kinesis %d] plugin parameter compression_dict_file = '%s'", pluginID, compressionDictFile)
+	failOpen := getConfigKey("fail_open")
+	logrus.Infof("[kinesis %d] plugin parameter fail_open = '%s'", pluginID, failOpen)
+	sizeKey := getConfigKey("size_key")
+	logrus.Infof("[kinesis %d] plugin parameter size_key = '%s'", pluginID, sizeKey)
+	mirrorStream := getConfigKey("mirror_stream")
+	logrus.Infof("[kinesis %d] plugin parameter mirror_stream = '%s'", pluginID, mirrorStream)
+	mirrorCondition := getConfigKey("mirror_condition")
+	logrus.Infof("[kinesis %d] plugin parameter mirror_condition = '%s'", pluginID, mirrorCondition)
+	maxIngestRecordsPerSec := getConfigKey("max_ingest_records_per_sec")
+	logrus.Infof("[kinesis %d] plugin parameter max_ingest_records_per_sec = '%s'", pluginID, maxIngestRecordsPerSec)
+	enrichmentFile := getConfigKey("enrichment_file")
+	logrus.Infof("[kinesis %d] plugin parameter enrichment_file = '%s'", pluginID, enrichmentFile)
+	enrichmentKey := getConfigKey("enrichment_key")
+	logrus.Infof("[kinesis %d] plugin parameter enrichment_key = '%s'", pluginID, enrichmentKey)
+	enrichmentReloadInterval := getConfigKey("enrichment_reload_interval")
+	logrus.Infof("[kinesis %d] plugin parameter enrichment_reload_interval = '%s'", pluginID, enrichmentReloadInterval)
+	dlqStream := getConfigKey("dlq_stream")
+	logrus.Infof("[kinesis %d] plugin parameter dlq_stream = '%s'", pluginID, dlqStream)
+	groupByPartitionKey := getConfigKey("group_by_partition_key")
+	logrus.Infof("[kinesis %d] plugin parameter group_by_partition_key = '%s'", pluginID, groupByPartitionKey)
+	recordPrefix := getConfigKey("record_prefix")
+	logrus.Infof("[kinesis %d] plugin parameter record_prefix = '%s'", pluginID, recordPrefix)
+	recordSuffix := getConfigKey("record_suffix")
+	logrus.Infof("[kinesis %d] plugin parameter record_suffix = '%s'", pluginID, recordSuffix)
+	dataKeysOutput := getConfigKey("data_keys_output")
+	logrus.Infof("[kinesis %d] plugin parameter data_keys_output = '%s'", pluginID, dataKeysOutput)
+	dataKeysDelimiter := getConfigKey("data_keys_delimiter")
+	logrus.Infof("[kinesis %d] plugin parameter data_keys_delimiter = '%s'", pluginID, dataKeysDelimiter)
+	lazyClientInit := getConfigKey("lazy_client_init")
+	logrus.Infof("[kinesis %d] plugin parameter lazy_client_init = '%s'", pluginID, lazyClientInit)
+	timestampUnit := getConfigKey("timestamp_unit")
+	logrus.Infof("[kinesis %d] plugin parameter timestamp_unit = '%s'", pluginID, timestampUnit)
+	onMarshalError := getConfigKey("on_marshal_error")
+	logrus.Infof("[kinesis %d] plugin parameter on_marshal_error = '%s'", pluginID, onMarshalError)
+	credentialsRefreshBefore := getConfigKey("credentials_refresh_before")
+	logrus.Infof("[kinesis %d] plugin parameter credentials_refresh_before = '%s'", pluginID, credentialsRefreshBefore)
+	compressKeys := getConfigKey("compress_keys")
+	logrus.Infof("[kinesis %d] plugin parameter compress_keys = '%s'", pluginID, compressKeys)
+	timeKeyTimezone := getConfigKey("time_key_timezone")
+	logrus.Infof("[kinesis %d] plugin parameter time_key_timezone = '%s'", pluginID, timeKeyTimezone)
+	aggregationPartitionStrategy := getConfigKey("aggregation_partition_strategy")
+	logrus.Infof("[kinesis %d] plugin parameter aggregation_partition_strategy = '%s'", pluginID, aggregationPartitionStrategy)
+	latencyLogInterval := getConfigKey("latency_log_interval")
+	logrus.Infof("[kinesis %d] plugin parameter latency_log_interval = '%s'", pluginID, latencyLogInterval)
+	stringifyKeys := getConfigKey("stringify_keys")
+	logrus.Infof("[kinesis %d] plugin parameter stringify_keys = '%s'", pluginID, stringifyKeys)
+	aggregationMaxRecords := getConfigKey("aggregation_max_records")
+	logrus.Infof("[kinesis %d] plugin parameter aggregation_max_records = '%s'", pluginID, aggregationMaxRecords)
+	shutdownDump := getConfigKey("shutdown_dump")
+	logrus.Infof("[kinesis %d] plugin parameter shutdown_dump = '%s'", pluginID, shutdownDump)
+	shutdownDumpPath := getConfigKey("shutdown_dump_path")
+	logrus.Infof("[kinesis %d] plugin parameter shutdown_dump_path = '%s'", pluginID, shutdownDumpPath)
+	maxFields := getConfigKey("max_fields")
+	logrus.Infof("[kinesis %d] plugin parameter max_fields = '%s'", pluginID, maxFields)
+	timeBucket := getConfigKey("time_bucket")
+	logrus.Infof("[kinesis %d] plugin parameter time_bucket = '%s'", pluginID, timeBucket)
+	codecHeader := getConfigKey("codec_header")
+	logrus.Infof("[kinesis %d] plugin parameter codec_header = '%s'", pluginID, codecHeader)
+	flushDeadline := getConfigKey("flush_deadline")
+	logrus.Infof("[kinesis %d] plugin parameter flush_deadline = '%s'", pluginID, flushDeadline)
+	metadataStream := getConfigKey("metadata_stream")
+	logrus.Infof("[kinesis %d] plugin parameter metadata_stream = '%s'", pluginID, metadataStream)
+	timestampSourceKeys := getConfigKey("timestamp_source_keys")
+	logrus.Infof("[kinesis %d] plugin parameter timestamp_source_keys = '%s'", pluginID, timestampSourceKeys)
+	timestampTargetKey := getConfigKey("timestamp_target_key")
+	logrus.Infof("[kinesis %d] plugin parameter timestamp_target_key = '%s'", pluginID, timestampTargetKey)
+	timestampFormats := getConfigKey("timestamp_formats")
+	logrus.Infof("[kinesis %d] plugin parameter timestamp_formats = '%s'", pluginID, timestampFormats)
+	timestampTargetFormat := getConfigKey("timestamp_target_format")
+	logrus.Infof("[kinesis %d] plugin parameter timestamp_target_format = '%s'", pluginID, timestampTargetFormat)
+	spillHighWatermark := getConfigKey("spill_high_watermark")
+	logrus.Infof("[kinesis %d] plugin parameter spill_high_watermark = '%s'", pluginID, spillHighWatermark)
+	spillLowWatermark := getConfigKey("spill_low_watermark")
+	logrus.Infof("[kinesis %d] plugin parameter spill_low_watermark = '%s'", pluginID, spillLowWatermark)
+	sink := getConfigKey("sink")
+	logrus.Infof("[kinesis %d] plugin parameter sink = '%s'", pluginID, sink)
+	sequenceKey := getConfigKey("sequence_key")
+	logrus.Infof("[kinesis %d] plugin parameter sequence_key = '%s'", pluginID, sequenceKey)
+	dedupKey := getConfigKey("dedup_key")
+	logrus.Infof("[kinesis %d] plugin parameter dedup_key = '%s'", pluginID, dedupKey)
+	dedupWindow := getConfigKey("dedup_window")
+	logrus.Infof("[kinesis %d] plugin parameter dedup_window = '%s'", pluginID, dedupWindow)
+	consistentHashRing := getConfigKey("consistent_hash_ring")
+	logrus.Infof("[kinesis %d] plugin parameter consistent_hash_ring = '%s'", pluginID, consistentHashRing)
+	errorLogSampleRate := getConfigKey("error_log_sample_rate")
+	logrus.Infof("[kinesis %d] plugin parameter error_log_sample_rate = '%s'", pluginID, errorLogSampleRate)
+	errorLogDedupInterval := getConfigKey("error_log_dedup_interval")
+	logrus.Infof("[kinesis %d] plugin parameter error_log_dedup_interval = '%s'", pluginID, errorLogDedupInterval)
+	stripInternalFields := getConfigKey("strip_internal_fields")
+	logrus.Infof("[kinesis %d] plugin parameter strip_internal_fields = '%s'", pluginID, stripInternalFields)
+	statusFile := getConfigKey("status_file")
+	logrus.Infof("[kinesis %d] plugin parameter status_file = '%s'", pluginID, statusFile)
+	statusInterval := getConfigKey("status_interval")
+	logrus.Infof("[kinesis %d] plugin parameter status_interval = '%s'", pluginID, statusInterval)
+	partitionKeyPattern := getConfigKey("partition_key_pattern")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_pattern = '%s'", pluginID, partitionKeyPattern)
+	partitionKeyInvalidAction := getConfigKey("partition_key_invalid_action")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_invalid_action = '%s'", pluginID, partitionKeyInvalidAction)
+	coalesceLingerMs := getConfigKey("coalesce_linger_ms")
+	logrus.Infof("[kinesis %d] plugin parameter coalesce_linger_ms = '%s'", pluginID, coalesceLingerMs)
+	flushOnRecords := getConfigKey("flush_on_records")
+	logrus.Infof("[kinesis %d] plugin parameter flush_on_records = '%s'", pluginID, flushOnRecords)
+	recordFormat := getConfigKey("record_format")
+	logrus.Infof("[kinesis %d] plugin parameter record_format = '%s'", pluginID, recordFormat)
+	avroSchemaFile := getConfigKey("avro_schema_file")
+	logrus.Infof("[kinesis %d] plugin parameter avro_schema_file = '%s'", pluginID, avroSchemaFile)
+	avroUnknownFields := getConfigKey("avro_unknown_fields")
+	logrus.Infof("[kinesis %d] plugin parameter avro_unknown_fields = '%s'", pluginID, avroUnknownFields)
+	retryMaxDelay := getConfigKey("retry_max_delay")
+	logrus.Infof("[kinesis %d] plugin parameter retry_max_delay = '%s'", pluginID, retryMaxDelay)
+	chunkField := getConfigKey("chunk_field")
+	logrus.Infof("[kinesis %d] plugin parameter chunk_field = '%s'", pluginID, chunkField)
+	chunkSize := getConfigKey("chunk_size")
+	logrus.Infof("[kinesis %d] plugin parameter chunk_size = '%s'", pluginID, chunkSize)
+	signingRegion := getConfigKey("signing_region")
+	logrus.Infof("[kinesis %d] plugin parameter signing_region = '%s'", pluginID, signingRegion)
+	protobufDescriptorFile := getConfigKey("protobuf_descriptor_file")
+	logrus.Infof("[kinesis %d] plugin parameter protobuf_descriptor_file = '%s'", pluginID, protobufDescriptorFile)
+	protobufMessage := getConfigKey("protobuf_message")
+	logrus.Infof("[kinesis %d] plugin parameter protobuf_message = '%s'", pluginID, protobufMessage)
+	protobufUnknownFields := getConfigKey("protobuf_unknown_fields")
+	logrus.Infof("[kinesis %d] plugin parameter protobuf_unknown_fields = '%s'", pluginID, protobufUnknownFields)
+	versionKey := getConfigKey("version_key")
+	logrus.Infof("[kinesis %d] plugin parameter version_key = '%s'", pluginID, versionKey)
+	adaptiveConcurrencyMin := getConfigKey("adaptive_concurrency_min")
+	logrus.Infof("[kinesis %d] plugin parameter adaptive_concurrency_min = '%s'", pluginID, adaptiveConcurrencyMin)
+	adaptiveConcurrencyMax := getConfigKey("adaptive_concurrency_max")
+	logrus.Infof("[kinesis %d] plugin parameter adaptive_concurrency_max = '%s'", pluginID, adaptiveConcurrencyMax)
+	adaptiveConcurrencyTargetLatencyMs := getConfigKey("adaptive_concurrency_target_latency_ms")
+	logrus.Infof("[kinesis %d] plugin parameter adaptive_concurrency_target_latency_ms = '%s'", pluginID, adaptiveConcurrencyTargetLatencyMs)
+	partitionKeyFromMetadata := getConfigKey("partition_key_from_metadata")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_from_metadata = '%s'", pluginID, partitionKeyFromMetadata)
+	targetBatchBytes := getConfigKey("target_batch_bytes")
+	logrus.Infof("[kinesis %d] plugin parameter target_batch_bytes = '%s'", pluginID, targetBatchBytes)
+	emfMetrics := getConfigKey("emf_metrics")
+	logrus.Infof("[kinesis %d] plugin parameter emf_metrics = '%s'", pluginID, emfMetrics)
+	emfNamespace := getConfigKey("emf_namespace")
+	logrus.Infof("[kinesis %d] plugin parameter emf_namespace = '%s'", pluginID, emfNamespace)
+	emfInterval := getConfigKey("emf_interval")
+	logrus.Infof("[kinesis %d] plugin parameter emf_interval = '%s'", pluginID, emfInterval)
+	auditLog := getConfigKey("audit_log")
+	logrus.Infof("[kinesis %d] plugin parameter audit_log = '%s'", pluginID, auditLog)
+	auditLogMaxBytes := getConfigKey("audit_log_max_bytes")
+	logrus.Infof("[kinesis %d] plugin parameter audit_log_max_bytes = '%s'", pluginID, auditLogMaxBytes)
+	auditLogMaxFiles := getConfigKey("audit_log_max_files")
+	logrus.Infof("[kinesis %d] plugin parameter audit_log_max_files = '%s'", pluginID, auditLogMaxFiles)
+	partitionKeyTrim := getConfigKey("partition_key_trim")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_trim = '%s'", pluginID, partitionKeyTrim)
+	partitionKeyLowercase := getConfigKey("partition_key_lowercase")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_lowercase = '%s'", pluginID, partitionKeyLowercase)
+	fanoutRegions := getConfigKey("fanout_regions")
+	logrus.Infof("[kinesis %d] plugin parameter fanout_regions = '%s'", pluginID, fanoutRegions)
+	fanoutStreams := getConfigKey("fanout_streams")
+	logrus.Infof("[kinesis %d] plugin parameter fanout_streams = '%s'", pluginID, fanoutStreams)
+	fanoutQuorum := getConfigKey("fanout_quorum")
+	logrus.Infof("[kinesis %d] plugin parameter fanout_quorum = '%s'", pluginID, fanoutQuorum)
+	escapeHTML := getConfigKey("escape_html")
+	logrus.Infof("[kinesis %d] plugin parameter escape_html = '%s'", pluginID, escapeHTML)
+	projection := getConfigKey("projection")
+	logrus.Infof("[kinesis %d] plugin parameter projection = '%s'", pluginID, projection)
+	projectionNoMatch := getConfigKey("projection_no_match")
+	logrus.Infof("[kinesis %d] plugin parameter projection_no_match = '%s'", pluginID, projectionNoMatch)
+	tlsMinVersion := getConfigKey("tls_min_version")
+	logrus.Infof("[kinesis %d] plugin parameter tls_min_version = '%s'", pluginID, tlsMinVersion)
+	tlsCipherSuites := getConfigKey("tls_cipher_suites")
+	logrus.Infof("[kinesis %d] plugin parameter tls_cipher_suites = '%s'", pluginID, tlsCipherSuites)
+	independentSecondaryFlush := getConfigKey("independent_secondary_flush")
+	logrus.Infof("[kinesis %d] plugin parameter independent_secondary_flush = '%s'", pluginID, independentSecondaryFlush)
+	compressionEntropyThreshold := getConfigKey("compression_entropy_threshold")
+	logrus.Infof("[kinesis %d] plugin parameter compression_entropy_threshold = '%s'", pluginID, compressionEntropyThreshold)
+	checksumKey := getConfigKey("checksum_key")
+	logrus.Infof("[kinesis %d] plugin parameter checksum_key = '%s'", pluginID, checksumKey)
+	checksumAlgo := getConfigKey("checksum_algo")
+	logrus.Infof("[kinesis %d] plugin parameter checksum_algo = '%s'", pluginID, checksumAlgo)
+	weightedPartitionKeys := getConfigKey("weighted_partition_keys")
+	logrus.Infof("[kinesis %d] plugin parameter weighted_partition_keys = '%s'", pluginID, weightedPartitionKeys)
+	gzipFlushMode := getConfigKey("gzip_flush_mode")
+	logrus.Infof("[kinesis %d] plugin parameter gzip_flush_mode = '%s'", pluginID, gzipFlushMode)
+	dropWhere := getConfigKey("drop_where")
+	logrus.Infof("[kinesis %d] plugin parameter drop_where = '%s'", pluginID, dropWhere)
+	rebuildClientOnAuthError := getConfigKey("rebuild_client_on_auth_error")
+	logrus.Infof("[kinesis %d] plugin parameter rebuild_client_on_auth_error = '%s'", pluginID, rebuildClientOnAuthError)
+	clientRebuildCooldown := getConfigKey("client_rebuild_cooldown")
+	logrus.Infof("[kinesis %d] plugin parameter client_rebuild_cooldown = '%s'", pluginID, clientRebuildCooldown)
+	preserveChunkOrder := getConfigKey("preserve_chunk_order")
+	logrus.Infof("[kinesis %d] plugin parameter preserve_chunk_order = '%s'", pluginID, preserveChunkOrder)
+	sourcePathKey := getConfigKey("source_path_key")
+	logrus.Infof("[kinesis %d] plugin parameter source_path_key = '%s'", pluginID, sourcePathKey)
+	sourcePathField := getConfigKey("source_path_field")
+	logrus.Infof("[kinesis %d] plugin parameter source_path_field = '%s'", pluginID, sourcePathField)
+	sourcePathTagRegex := getConfigKey("source_path_tag_regex")
+	logrus.Infof("[kinesis %d] plugin parameter source_path_tag_regex = '%s'", pluginID, sourcePathTagRegex)
+	sourcePathTagFormat := getConfigKey("source_path_tag_format")
+	logrus.Infof("[kinesis %d] plugin parameter source_path_tag_format = '%s'", pluginID, sourcePathTagFormat)
+	errorRetryStrategy := getConfigKey("error_retry_strategy")
+	logrus.Infof("[kinesis %d] plugin parameter error_retry_strategy = '%s'", pluginID, errorRetryStrategy)
+	errorRetryFixedDelay := getConfigKey("error_retry_fixed_delay_ms")
+	logrus.Infof("[kinesis %d] plugin parameter error_retry_fixed_delay_ms = '%s'", pluginID, errorRetryFixedDelay)
+	errorRetryAttempts := getConfigKey("error_retry_attempts")
+	logrus.Infof("[kinesis %d] plugin parameter error_retry_attempts = '%s'", pluginID, errorRetryAttempts)
+	gapMarker := getConfigKey("gap_marker")
+	logrus.Infof("[kinesis %d] plugin parameter gap_marker = '%s'", pluginID, gapMarker)
+	sourceEncoding := getConfigKey("source_encoding")
+	logrus.Infof("[kinesis %d] plugin parameter source_encoding = '%s'", pluginID, sourceEncoding)
+	sourceEncodingInvalid := getConfigKey("source_encoding_invalid")
+	logrus.Infof("[kinesis %d] plugin parameter source_encoding_invalid = '%s'", pluginID, sourceEncodingInvalid)
+	checkpointDir := getConfigKey("checkpoint_dir")
+	logrus.Infof("[kinesis %d] plugin parameter checkpoint_dir = '%s'", pluginID, checkpointDir)
+	shardLoadLogInterval := getConfigKey("shard_load_log_interval")
+	logrus.Infof("[kinesis %d] plugin parameter shard_load_log_interval = '%s'", pluginID, shardLoadLogInterval)
+	partialAck := getConfigKey("partial_ack")
+	logrus.Infof("[kinesis %d] plugin parameter partial_ack = '%s'", pluginID, partialAck)
+	recordPool := getConfigKey("record_pool")
+	logrus.Infof("[kinesis %d] plugin parameter record_pool = '%s'", pluginID, recordPool)
+	routeFlagKey := getConfigKey("route_flag_key")
+	logrus.Infof("[kinesis %d] plugin parameter route_flag_key = '%s'", pluginID, routeFlagKey)
+	routeFlagValue := getConfigKey("route_flag_value")
+	logrus.Infof("[kinesis %d] plugin parameter route_flag_value = '%s'", pluginID, routeFlagValue)
+	envelope := getConfigKey("envelope")
+	logrus.Infof("[kinesis %d] plugin parameter envelope = '%s'", pluginID, envelope)
+	validateStream := getConfigKey("validate_stream")
+	logrus.Infof("[kinesis %d] plugin parameter validate_stream = '%s'", pluginID, validateStream)
+	validateStreamTimeout := getConfigKey("validate_stream_timeout")
+	logrus.Infof("[kinesis %d] plugin parameter validate_stream_timeout = '%s'", pluginID, validateStreamTimeout)
+	batchTrailer := getConfigKey("batch_trailer")
+	logrus.Infof("[kinesis %d] plugin parameter batch_trailer = '%s'", pluginID, batchTrailer)
+	partitionKeyCacheSize := getConfigKey("partition_key_cache_size")
+	logrus.Infof("[kinesis %d] plugin parameter partition_key_cache_size = '%s'", pluginID, partitionKeyCacheSize)
 
 	if stream == "" || region == "" {
 		return nil, fmt.Errorf("[kinesis %d] stream and region are required configuration parameters", pluginID)
 	}
 
-	if partitionKey == "log" {
-		return nil, fmt.Errorf("[kinesis %d] 'log' cannot be set as the partition key", pluginID)
+	if err := validatePartitionKeyConfig(partitionKey, logKey); err != nil {
+		return nil, fmt.Errorf("[kinesis %d] %v", pluginID, err)
+	}
+
+	var keySource kinesis.PartitionKeySource
+	switch strings.ToLower(partitionKeySource) {
+	case string(kinesis.PartitionKeySourceField), "":
+		keySource = kinesis.PartitionKeySourceField
+	case string(kinesis.PartitionKeySourceRecordHash):
+		keySource = kinesis.PartitionKeySourceRecordHash
+	case string(kinesis.PartitionKeySourceRoundRobin):
+		keySource = kinesis.PartitionKeySourceRoundRobin
+	case string(kinesis.PartitionKeySourceTimeBucket):
+		keySource = kinesis.PartitionKeySourceTimeBucket
+	case string(kinesis.PartitionKeySourceWeighted):
+		keySource = kinesis.PartitionKeySourceWeighted
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'partition_key_source' value (%s) specified, must be 'field', 'record_hash', 'round_robin', 'time_bucket', 'weighted', or undefined", pluginID, partitionKeySource)
+	}
+
+	var weightedKeyList []kinesis.WeightedPartitionKey
+	if keySource == kinesis.PartitionKeySourceWeighted {
+		weightedKeyList, err = parseWeightedPartitionKeys(weightedPartitionKeys, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	} else if weightedPartitionKeys != "" {
+		logrus.Warnf("[kinesis %d] 'weighted_partition_keys' is ignored unless 'partition_key_source' is weighted", pluginID)
+	}
+
+	var timeBucketDuration time.Duration
+	if keySource == kinesis.PartitionKeySourceTimeBucket && timeBucket != "" {
+		timeBucketInt, err := parseNonNegativeConfig("time_bucket", timeBucket, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if timeBucketInt == 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'time_bucket' value (%s) specified, must be at least 1 second", pluginID, timeBucket)
+		}
+		timeBucketDuration = time.Duration(timeBucketInt) * time.Second
+	} else if timeBucket != "" {
+		logrus.Warnf("[kinesis %d] 'time_bucket' is ignored unless 'partition_key_source' is time_bucket", pluginID)
 	}
 
-	if partitionKey == "" {
+	keyFromMetadata := strings.ToLower(partitionKeyFromMetadata) == "true"
+	if keyFromMetadata && (partitionKey == "" || keySource != kinesis.PartitionKeySourceField) {
+		logrus.Warnf("[kinesis %d] 'partition_key_from_metadata' is ignored unless 'partition_key' is set and 'partition_key_source' is field", pluginID)
+		keyFromMetadata = false
+	}
+
+	if (partitionKeyTrim != "" || partitionKeyLowercase != "") && (partitionKey == "" || keySource != kinesis.PartitionKeySourceField) {
+		logrus.Warnf("[kinesis %d] 'partition_key_trim' and 'partition_key_lowercase' are ignored unless 'partition_key' is set and 'partition_key_source' is field", pluginID)
+	}
+
+	var aggStrategy kinesis.AggregationPartitionStrategy
+	switch strings.ToLower(aggregationPartitionStrategy) {
+	case string(kinesis.AggregationPartitionFirstRecord), "":
+		aggStrategy = kinesis.AggregationPartitionFirstRecord
+	case string(kinesis.AggregationPartitionRandom):
+		aggStrategy = kinesis.AggregationPartitionRandom
+	case string(kinesis.AggregationPartitionRoundRobin):
+		aggStrategy = kinesis.AggregationPartitionRoundRobin
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'aggregation_partition_strategy' value (%s) specified, must be 'first_record', 'random', 'round_robin', or undefined", pluginID, aggregationPartitionStrategy)
+	}
+
+	var roundRobinKeyList []string
+	var roundRobinKeyCount int
+	aggregatesRoundRobin := aggStrategy == kinesis.AggregationPartitionRoundRobin && strings.ToLower(aggregation) == "true"
+	if keySource == kinesis.PartitionKeySourceRoundRobin || aggregatesRoundRobin {
+		roundRobinKeyList, roundRobinKeyCount, err = parseRoundRobinKeys(roundRobinKeys, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	} else if roundRobinKeys != "" {
+		logrus.Warnf("[kinesis %d] 'round_robin_keys' is ignored unless 'partition_key_source' or 'aggregation_partition_strategy' is round_robin", pluginID)
+	}
+
+	if keySource == kinesis.PartitionKeySourceField && partitionKey == "" {
 		logrus.Infof("[kinesis %d] no partition key provided. A random one will be generated.", pluginID)
 	}
 
+	if keySource != kinesis.PartitionKeySourceField && partitionKey != "" {
+		logrus.Warnf("[kinesis %d] 'partition_key' is ignored when 'partition_key_source' is %s", pluginID, keySource)
+	}
+
+	if partitionKeyHash != "" && (keySource != kinesis.PartitionKeySourceField || partitionKey == "") {
+		logrus.Warnf("[kinesis %d] 'partition_key_hash' is ignored unless 'partition_key' is set", pluginID)
+	}
+
 	appendNL := false
 	if strings.ToLower(appendNewline) == "true" {
 		appendNL = true
@@ -114,12 +589,27 @@ func newKinesisOutput(ctx unsafe.Pointer, pluginID int) (*kinesis.OutputPlugin,
 		isAggregate = true
 	}
 
+	var sinkType kinesis.Sink
+	switch strings.ToLower(sink) {
+	case string(kinesis.SinkKinesis), "":
+		sinkType = kinesis.SinkKinesis
+	case string(kinesis.SinkFirehose):
+		sinkType = kinesis.SinkFirehose
+		if isAggregate {
+			return nil, fmt.Errorf("[kinesis %d] 'aggregation' can't be used with 'sink' firehose, delivery streams don't de-aggregate records sent to them directly", pluginID)
+		}
+		if partitionKey != "" || partitionKeySource != "" {
+			logrus.Warnf("[kinesis %d] 'partition_key' and 'partition_key_source' are ignored when 'sink' is firehose, delivery streams have no shards", pluginID)
+		}
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'sink' value (%s) specified, must be 'kinesis', 'firehose', or undefined", pluginID, sink)
+	}
+
 	if isAggregate && partitionKey != "" {
 		logrus.Warnf("[kinesis %d] 'partition_key' has different behavior when 'aggregation' enabled. All aggregated records will use a partition key sourced from the first record in the batch", pluginID)
 	}
 
 	var concurrencyInt, concurrencyRetriesInt int
-	var err error
 	if concurrency != "" {
 		concurrencyInt, err = parseNonNegativeConfig("experimental_concurrency", concurrency, pluginID)
 		if err != nil {
@@ -164,7 +654,1161 @@ func newKinesisOutput(ctx unsafe.Pointer, pluginID int) (*kinesis.OutputPlugin,
 		httpRequestTimeoutDuration = time.Duration(httpRequestTimeoutInt) * time.Second
 	}
 
-	return kinesis.NewOutputPlugin(region, stream, dataKeys, partitionKey, roleARN, kinesisEndpoint, stsEndpoint, timeKey, timeKeyFmt, logKey, replaceDots, concurrencyInt, concurrencyRetriesInt, isAggregate, appendNL, comp, pluginID, httpRequestTimeoutDuration)
+	sdkMaxRetriesInt := aws.UseServiceDefaultRetries
+	if sdkMaxRetries != "" {
+		sdkMaxRetriesInt, err = parseNonNegativeConfig("aws_sdk_max_retries", sdkMaxRetries, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var maxIdleConnsInt int
+	if maxIdleConns != "" {
+		maxIdleConnsInt, err = parseNonNegativeConfig("max_idle_conns", maxIdleConns, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var httpTimeoutDuration time.Duration
+	if httpTimeout != "" {
+		httpTimeoutInt, err := parseNonNegativeConfig("http_timeout", httpTimeout, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		httpTimeoutDuration = time.Duration(httpTimeoutInt) * time.Second
+	}
+
+	var dataKeysOutputType kinesis.DataKeysOutput
+	switch strings.ToLower(dataKeysOutput) {
+	case string(kinesis.DataKeysOutputJSON), "":
+		dataKeysOutputType = kinesis.DataKeysOutputJSON
+	case string(kinesis.DataKeysOutputValues):
+		dataKeysOutputType = kinesis.DataKeysOutputValues
+		if dataKeys == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'data_keys_output' %s requires 'data_keys'", pluginID, dataKeysOutput)
+		}
+		if logKey != "" {
+			logrus.Warnf("[kinesis %d] 'data_keys_output' is ignored when 'log_key' is set", pluginID)
+		}
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'data_keys_output' value (%s) specified, must be 'json', 'values', or undefined", pluginID, dataKeysOutput)
+	}
+
+	var timestampUnitType kinesis.TimestampUnit
+	switch strings.ToLower(timestampUnit) {
+	case string(kinesis.TimestampUnitSeconds), "":
+		timestampUnitType = kinesis.TimestampUnitSeconds
+	case string(kinesis.TimestampUnitAuto), string(kinesis.TimestampUnitMilliseconds), string(kinesis.TimestampUnitMicroseconds), string(kinesis.TimestampUnitNanoseconds):
+		timestampUnitType = kinesis.TimestampUnit(strings.ToLower(timestampUnit))
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'timestamp_unit' value (%s) specified, must be 'auto', 's', 'ms', 'us', 'ns', or undefined", pluginID, timestampUnit)
+	}
+	dataKeysDelimiterBytes, err := parseEscapedConfig("data_keys_delimiter", dataKeysDelimiter, pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	recordPrefixBytes, err := parseEscapedConfig("record_prefix", recordPrefix, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	recordSuffixBytes, err := parseEscapedConfig("record_suffix", recordSuffix, pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(groupByPartitionKey) == "true" && !isAggregate {
+		logrus.Warnf("[kinesis %d] 'group_by_partition_key' is ignored unless 'aggregation' is enabled", pluginID)
+	}
+
+	if aggStrategy != kinesis.AggregationPartitionFirstRecord {
+		if !isAggregate {
+			logrus.Warnf("[kinesis %d] 'aggregation_partition_strategy' is ignored unless 'aggregation' is enabled", pluginID)
+		} else if strings.ToLower(groupByPartitionKey) == "true" {
+			return nil, fmt.Errorf("[kinesis %d] 'aggregation_partition_strategy' %s can't be combined with 'group_by_partition_key', which routes each aggregated record by its key", pluginID, aggStrategy)
+		}
+	}
+
+	if dlqStream != "" && dlqStream == stream {
+		return nil, fmt.Errorf("[kinesis %d] 'dlq_stream' must be different from 'stream'", pluginID)
+	}
+
+	var onMarshalErrorType kinesis.OnMarshalError
+	switch strings.ToLower(onMarshalError) {
+	case string(kinesis.OnMarshalErrorDrop), "":
+		onMarshalErrorType = kinesis.OnMarshalErrorDrop
+	case string(kinesis.OnMarshalErrorDLQ):
+		if dlqStream == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'on_marshal_error' %s requires 'dlq_stream'", pluginID, onMarshalError)
+		}
+		onMarshalErrorType = kinesis.OnMarshalErrorDLQ
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'on_marshal_error' value (%s) specified, must be 'drop', 'dlq', or undefined", pluginID, onMarshalError)
+	}
+
+	if (enrichmentFile == "") != (enrichmentKey == "") {
+		return nil, fmt.Errorf("[kinesis %d] 'enrichment_file' and 'enrichment_key' must be set together", pluginID)
+	}
+	var enrichmentReloadDuration time.Duration
+	if enrichmentReloadInterval != "" {
+		enrichmentReloadInt, err := parseNonNegativeConfig("enrichment_reload_interval", enrichmentReloadInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		enrichmentReloadDuration = time.Duration(enrichmentReloadInt) * time.Second
+	}
+
+	timeKeyLocation, err := parseTimeKeyTimezone(timeKeyTimezone, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	if timeKeyLocation != nil && timeKey == "" {
+		logrus.Warnf("[kinesis %d] 'time_key_timezone' is ignored unless 'time_key' is set", pluginID)
+	}
+
+	compressKeyList := splitConfigList(compressKeys)
+
+	timestampSourceKeyList := splitConfigList(timestampSourceKeys)
+	if (len(timestampSourceKeyList) == 0) != (timestampTargetKey == "") {
+		return nil, fmt.Errorf("[kinesis %d] 'timestamp_source_keys' and 'timestamp_target_key' must be set together", pluginID)
+	}
+	if len(timestampSourceKeyList) == 0 && (timestampFormats != "" || timestampTargetFormat != "") {
+		logrus.Warnf("[kinesis %d] 'timestamp_formats' and 'timestamp_target_format' are ignored unless 'timestamp_source_keys' is set", pluginID)
+	}
+
+	var aggregationMaxRecordsInt int
+	if aggregationMaxRecords != "" {
+		aggregationMaxRecordsInt, err = parseNonNegativeConfig("aggregation_max_records", aggregationMaxRecords, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if !isAggregate {
+			logrus.Warnf("[kinesis %d] 'aggregation_max_records' is ignored unless 'aggregation' is enabled", pluginID)
+		}
+	}
+
+	var maxFieldsInt int
+	if maxFields != "" {
+		maxFieldsInt, err = parseNonNegativeConfig("max_fields", maxFields, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var shutdownDumpType kinesis.ShutdownDump
+	switch strings.ToLower(shutdownDump) {
+	case string(kinesis.ShutdownDumpNone), "":
+		shutdownDumpType = kinesis.ShutdownDumpNone
+	case string(kinesis.ShutdownDumpStderr):
+		shutdownDumpType = kinesis.ShutdownDumpStderr
+	case string(kinesis.ShutdownDumpFile):
+		if shutdownDumpPath == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'shutdown_dump' file requires 'shutdown_dump_path'", pluginID)
+		}
+		shutdownDumpType = kinesis.ShutdownDumpFile
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'shutdown_dump' value (%s) specified, must be 'none', 'stderr', 'file', or undefined", pluginID, shutdownDump)
+	}
+	if shutdownDumpType != kinesis.ShutdownDumpFile && shutdownDumpPath != "" {
+		logrus.Warnf("[kinesis %d] 'shutdown_dump_path' is ignored unless 'shutdown_dump' is file", pluginID)
+	}
+
+	var flushDeadlineDuration time.Duration
+	if flushDeadline != "" {
+		flushDeadlineInt, err := parseNonNegativeConfig("flush_deadline", flushDeadline, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		flushDeadlineDuration = time.Duration(flushDeadlineInt) * time.Second
+	}
+
+	var dedupWindowDuration time.Duration
+	if dedupWindow != "" {
+		dedupWindowInt, err := parseNonNegativeConfig("dedup_window", dedupWindow, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		dedupWindowDuration = time.Duration(dedupWindowInt) * time.Second
+	}
+	if (dedupKey == "") != (dedupWindowDuration == 0) {
+		return nil, fmt.Errorf("[kinesis %d] 'dedup_key' and 'dedup_window' must be set together", pluginID)
+	}
+
+	var hashRingWeights []int
+	if consistentHashRing != "" {
+		if sinkType == kinesis.SinkFirehose {
+			logrus.Warnf("[kinesis %d] 'consistent_hash_ring' is ignored when 'sink' is firehose, delivery streams have no shards", pluginID)
+		} else if keySource != kinesis.PartitionKeySourceField || partitionKey == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'consistent_hash_ring' requires 'partition_key', the ring places records by their partition key", pluginID)
+		} else {
+			for _, weight := range splitConfigList(consistentHashRing) {
+				weightInt, err := parseNonNegativeConfig("consistent_hash_ring", weight, pluginID)
+				if err != nil {
+					return nil, err
+				}
+				hashRingWeights = append(hashRingWeights, weightInt)
+			}
+		}
+	}
+
+	var errorLogSampleRateInt int
+	if errorLogSampleRate != "" {
+		errorLogSampleRateInt, err = parseNonNegativeConfig("error_log_sample_rate", errorLogSampleRate, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errorLogDedupDuration time.Duration
+	if errorLogDedupInterval != "" {
+		errorLogDedupInt, err := parseNonNegativeConfig("error_log_dedup_interval", errorLogDedupInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		errorLogDedupDuration = time.Duration(errorLogDedupInt) * time.Second
+	}
+
+	var invalidKeyAction kinesis.PartitionKeyInvalidAction
+	switch strings.ToLower(partitionKeyInvalidAction) {
+	case string(kinesis.PartitionKeyInvalidSanitize), "":
+		invalidKeyAction = kinesis.PartitionKeyInvalidSanitize
+	case string(kinesis.PartitionKeyInvalidFallback):
+		invalidKeyAction = kinesis.PartitionKeyInvalidFallback
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'partition_key_invalid_action' value (%s) specified, must be 'sanitize', 'fallback', or undefined", pluginID, partitionKeyInvalidAction)
+	}
+	if partitionKeyPattern != "" && (keySource != kinesis.PartitionKeySourceField || partitionKey == "") {
+		logrus.Warnf("[kinesis %d] 'partition_key_pattern' is ignored unless 'partition_key' is set", pluginID)
+	}
+
+	var statusIntervalDuration time.Duration
+	if statusInterval != "" {
+		statusIntervalInt, err := parseNonNegativeConfig("status_interval", statusInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		statusIntervalDuration = time.Duration(statusIntervalInt) * time.Second
+		if statusFile == "" {
+			logrus.Warnf("[kinesis %d] 'status_interval' is ignored unless 'status_file' is set", pluginID)
+		}
+	}
+
+	var latencyLogDuration time.Duration
+	if latencyLogInterval != "" {
+		latencyLogInt, err := parseNonNegativeConfig("latency_log_interval", latencyLogInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		latencyLogDuration = time.Duration(latencyLogInt) * time.Second
+	}
+
+	credentialsRefreshDuration := kinesis.DefaultCredentialsRefreshBefore
+	if credentialsRefreshBefore != "" {
+		credentialsRefreshInt, err := parseNonNegativeConfig("credentials_refresh_before", credentialsRefreshBefore, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		credentialsRefreshDuration = time.Duration(credentialsRefreshInt) * time.Second
+		if credentialsRefreshDuration > kinesis.MaxCredentialsRefreshBefore {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'credentials_refresh_before' %s, must be at most %d seconds", pluginID, credentialsRefreshBefore, int(kinesis.MaxCredentialsRefreshBefore.Seconds()))
+		}
+	}
+
+	var maxIngestRecordsPerSecInt int
+	if maxIngestRecordsPerSec != "" {
+		maxIngestRecordsPerSecInt, err = parseNonNegativeConfig("max_ingest_records_per_sec", maxIngestRecordsPerSec, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if (mirrorStream == "") != (mirrorCondition == "") {
+		return nil, fmt.Errorf("[kinesis %d] 'mirror_stream' and 'mirror_condition' must be set together", pluginID)
+	}
+	if mirrorStream != "" && mirrorStream == stream {
+		return nil, fmt.Errorf("[kinesis %d] 'mirror_stream' must be different from 'stream'", pluginID)
+	}
+	if metadataStream != "" && metadataStream == stream {
+		return nil, fmt.Errorf("[kinesis %d] 'metadata_stream' must be different from 'stream'", pluginID)
+	}
+
+	if sizeKey != "" && logKey != "" {
+		logrus.Warnf("[kinesis %d] 'size_key' is ignored when 'log_key' is set, since only the log value is sent", pluginID)
+	}
+
+	var recordFormatType kinesis.RecordFormat
+	switch strings.ToLower(recordFormat) {
+	case string(kinesis.RecordFormatJSON), "":
+		recordFormatType = kinesis.RecordFormatJSON
+	case string(kinesis.RecordFormatAvro):
+		recordFormatType = kinesis.RecordFormatAvro
+		if avroSchemaFile == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'avro_schema_file' is required when 'record_format' is avro", pluginID)
+		}
+		if logKey != "" || dataKeysOutputType == kinesis.DataKeysOutputValues {
+			return nil, fmt.Errorf("[kinesis %d] 'record_format' avro can't be used with 'log_key' or 'data_keys_output' values, which send records in their own format", pluginID)
+		}
+		if sizeKey != "" {
+			logrus.Warnf("[kinesis %d] 'size_key' is ignored when 'record_format' is avro", pluginID)
+		}
+	case string(kinesis.RecordFormatProtobuf):
+		recordFormatType = kinesis.RecordFormatProtobuf
+		if protobufDescriptorFile == "" || protobufMessage == "" {
+			return nil, fmt.Errorf("[kinesis %d] 'protobuf_descriptor_file' and 'protobuf_message' are required when 'record_format' is protobuf", pluginID)
+		}
+		if logKey != "" || dataKeysOutputType == kinesis.DataKeysOutputValues {
+			return nil, fmt.Errorf("[kinesis %d] 'record_format' protobuf can't be used with 'log_key' or 'data_keys_output' values, which send records in their own format", pluginID)
+		}
+		if sizeKey != "" {
+			logrus.Warnf("[kinesis %d] 'size_key' is ignored when 'record_format' is protobuf", pluginID)
+		}
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'record_format' value (%s) specified, must be 'json', 'avro', 'protobuf', or undefined", pluginID, recordFormat)
+	}
+	if recordFormatType != kinesis.RecordFormatAvro && (avroSchemaFile != "" || avroUnknownFields != "") {
+		logrus.Warnf("[kinesis %d] 'avro_schema_file' and 'avro_unknown_fields' are ignored unless 'record_format' is avro", pluginID)
+	}
+
+	if recordFormatType != kinesis.RecordFormatProtobuf && (protobufDescriptorFile != "" || protobufMessage != "" || protobufUnknownFields != "") {
+		logrus.Warnf("[kinesis %d] 'protobuf_descriptor_file', 'protobuf_message', and 'protobuf_unknown_fields' are ignored unless 'record_format' is protobuf", pluginID)
+	}
+
+	var protobufUnknownFieldsType kinesis.ProtobufUnknownFields
+	switch strings.ToLower(protobufUnknownFields) {
+	case string(kinesis.ProtobufUnknownFieldsDrop), "":
+		protobufUnknownFieldsType = kinesis.ProtobufUnknownFieldsDrop
+	case string(kinesis.ProtobufUnknownFieldsError):
+		protobufUnknownFieldsType = kinesis.ProtobufUnknownFieldsError
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'protobuf_unknown_fields' value (%s) specified, must be 'drop', 'error', or undefined", pluginID, protobufUnknownFields)
+	}
+
+	var avroUnknownFieldsType kinesis.AvroUnknownFields
+	switch strings.ToLower(avroUnknownFields) {
+	case string(kinesis.AvroUnknownFieldsDrop), "":
+		avroUnknownFieldsType = kinesis.AvroUnknownFieldsDrop
+	case string(kinesis.AvroUnknownFieldsError):
+		avroUnknownFieldsType = kinesis.AvroUnknownFieldsError
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'avro_unknown_fields' value (%s) specified, must be 'drop', 'error', or undefined", pluginID, avroUnknownFields)
+	}
+
+	var workersInt int
+	if workers != "" {
+		workersInt, err = parseNonNegativeConfig("workers", workers, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if workersInt > 0 && concurrencyInt > 0 {
+			logrus.Warnf("[kinesis %d] 'experimental_concurrency' is ignored when 'workers' is set", pluginID)
+		}
+		if workersInt > 0 && isAggregate {
+			logrus.Warnf("[kinesis %d] With 'aggregation' enabled, 'workers' route aggregated records by the partition key of the aggregated record", pluginID)
+		}
+	}
+
+	var teeStdoutRecordsPerSecondInt int
+	if teeStdoutRecordsPerSecond != "" {
+		teeStdoutRecordsPerSecondInt, err = parseNonNegativeConfig("tee_stdout_records_per_second", teeStdoutRecordsPerSecond, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var histogramTopN int
+	var histogramInterval time.Duration
+	if strings.ToLower(partitionKeyHistogram) == "true" {
+		histogramTopN = defaultPartitionKeyHistogramTopN
+		if partitionKeyHistogramTopN != "" {
+			histogramTopN, err = parseNonNegativeConfig("partition_key_histogram_top_n", partitionKeyHistogramTopN, pluginID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if partitionKeyHistogramInterval != "" {
+			histogramIntervalInt, err := parseNonNegativeConfig("partition_key_histogram_interval", partitionKeyHistogramInterval, pluginID)
+			if err != nil {
+				return nil, err
+			}
+			histogramInterval = time.Duration(histogramIntervalInt) * time.Second
+		}
+	}
+
+	var bufferMaxBytesInt int
+	if bufferMaxBytes != "" {
+		bufferMaxBytesInt, err = parseNonNegativeConfig("buffer_max_bytes", bufferMaxBytes, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var spillHighWatermarkInt, spillLowWatermarkInt int
+	if spillHighWatermark != "" {
+		spillHighWatermarkInt, err = parseNonNegativeConfig("spill_high_watermark", spillHighWatermark, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if spillLowWatermark != "" {
+		spillLowWatermarkInt, err = parseNonNegativeConfig("spill_low_watermark", spillLowWatermark, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (spillHighWatermarkInt > 0 || spillLowWatermarkInt > 0) && spillDir == "" {
+		logrus.Warnf("[kinesis %d] 'spill_high_watermark' and 'spill_low_watermark' are ignored unless 'spill_dir' is set", pluginID)
+	}
+
+	if (bufferMaxBytesInt > 0 || spillDir != "") && concurrencyInt == 0 && workersInt == 0 {
+		logrus.Warnf("[kinesis %d] 'buffer_max_bytes' and 'spill_dir' only take effect when 'experimental_concurrency' or 'workers' is enabled", pluginID)
+	}
+
+	var retryMaxDelayDuration time.Duration
+	if retryMaxDelay != "" {
+		retryMaxDelayInt, err := parseNonNegativeConfig("retry_max_delay", retryMaxDelay, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if concurrencyInt == 0 && workersInt == 0 {
+			logrus.Warnf("[kinesis %d] 'retry_max_delay' only takes effect when 'experimental_concurrency' or 'workers' is enabled, otherwise Fluent Bit schedules retries", pluginID)
+		}
+		retryMaxDelayDuration = time.Duration(retryMaxDelayInt) * time.Second
+	}
+
+	var adaptiveConcurrencyMinInt, adaptiveConcurrencyMaxInt int
+	var adaptiveConcurrencyTargetLatency time.Duration
+	if adaptiveConcurrencyMax != "" {
+		adaptiveConcurrencyMaxInt, err = parseNonNegativeConfig("adaptive_concurrency_max", adaptiveConcurrencyMax, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if adaptiveConcurrencyMin != "" {
+		adaptiveConcurrencyMinInt, err = parseNonNegativeConfig("adaptive_concurrency_min", adaptiveConcurrencyMin, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if adaptiveConcurrencyTargetLatencyMs != "" {
+		targetLatencyInt, err := parseNonNegativeConfig("adaptive_concurrency_target_latency_ms", adaptiveConcurrencyTargetLatencyMs, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		adaptiveConcurrencyTargetLatency = time.Duration(targetLatencyInt) * time.Millisecond
+	}
+	if adaptiveConcurrencyMaxInt > 0 {
+		if adaptiveConcurrencyMinInt > adaptiveConcurrencyMaxInt {
+			return nil, fmt.Errorf("[kinesis %d] 'adaptive_concurrency_min' (%d) must not be greater than 'adaptive_concurrency_max' (%d)", pluginID, adaptiveConcurrencyMinInt, adaptiveConcurrencyMaxInt)
+		}
+		senders := workersInt
+		if senders == 0 {
+			senders = concurrencyInt
+		}
+		if senders == 0 {
+			logrus.Warnf("[kinesis %d] 'adaptive_concurrency_max' only takes effect when 'experimental_concurrency' or 'workers' is enabled, otherwise records are sent one request at a time", pluginID)
+		} else if adaptiveConcurrencyMaxInt > senders {
+			logrus.Warnf("[kinesis %d] 'adaptive_concurrency_max' (%d) is more than the %d requests 'experimental_concurrency' or 'workers' can send at once, which bounds it", pluginID, adaptiveConcurrencyMaxInt, senders)
+		}
+	} else if adaptiveConcurrencyMin != "" || adaptiveConcurrencyTargetLatencyMs != "" {
+		logrus.Warnf("[kinesis %d] 'adaptive_concurrency_min' and 'adaptive_concurrency_target_latency_ms' are ignored unless 'adaptive_concurrency_max' is set", pluginID)
+	}
+
+	var coalesceLinger time.Duration
+	if coalesceLingerMs != "" {
+		coalesceLingerInt, err := parseNonNegativeConfig("coalesce_linger_ms", coalesceLingerMs, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if concurrencyInt == 0 && workersInt == 0 {
+			logrus.Warnf("[kinesis %d] 'coalesce_linger_ms' only takes effect when 'experimental_concurrency' or 'workers' is enabled", pluginID)
+		} else {
+			coalesceLinger = time.Duration(coalesceLingerInt) * time.Millisecond
+		}
+	}
+
+	var flushOnRecordsInt int
+	if flushOnRecords != "" {
+		flushOnRecordsInt, err = parseNonNegativeConfig("flush_on_records", flushOnRecords, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if coalesceLinger == 0 {
+			logrus.Warnf("[kinesis %d] 'flush_on_records' only takes effect when 'coalesce_linger_ms' is set", pluginID)
+			flushOnRecordsInt = 0
+		}
+	}
+
+	var targetBatchBytesInt int
+	if targetBatchBytes != "" {
+		targetBatchBytesInt, err = parseNonNegativeConfig("target_batch_bytes", targetBatchBytes, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	isEMFMetrics := strings.ToLower(emfMetrics) == "true"
+	var emfIntervalDuration time.Duration
+	if emfInterval != "" {
+		emfIntervalInt, err := parseNonNegativeConfig("emf_interval", emfInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		emfIntervalDuration = time.Duration(emfIntervalInt) * time.Second
+	}
+	if !isEMFMetrics && (emfNamespace != "" || emfInterval != "") {
+		logrus.Warnf("[kinesis %d] 'emf_namespace' and 'emf_interval' are ignored unless 'emf_metrics' is true", pluginID)
+	}
+
+	var auditLogMaxBytesInt, auditLogMaxFilesInt int
+	if auditLogMaxBytes != "" {
+		auditLogMaxBytesInt, err = parseNonNegativeConfig("audit_log_max_bytes", auditLogMaxBytes, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if auditLogMaxFiles != "" {
+		auditLogMaxFilesInt, err = parseNonNegativeConfig("audit_log_max_files", auditLogMaxFiles, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if auditLog == "" && (auditLogMaxBytes != "" || auditLogMaxFiles != "") {
+		logrus.Warnf("[kinesis %d] 'audit_log_max_bytes' and 'audit_log_max_files' are ignored unless 'audit_log' is set", pluginID)
+	}
+
+	fanoutRegionList := splitConfigList(fanoutRegions)
+	fanoutStreamList := splitConfigList(fanoutStreams)
+	if len(fanoutStreamList) > 0 && len(fanoutStreamList) != len(fanoutRegionList) {
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'fanout_streams' value (%s) specified, must name one stream for each of the %d 'fanout_regions'", pluginID, fanoutStreams, len(fanoutRegionList))
+	}
+	var fanoutQuorumInt int
+	if fanoutQuorum != "" {
+		fanoutQuorumInt, err = parseNonNegativeConfig("fanout_quorum", fanoutQuorum, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if fanoutQuorumInt > len(fanoutRegionList)+1 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'fanout_quorum' value (%s) specified, must be at most %d, the number of 'fanout_regions' plus the main stream", pluginID, fanoutQuorum, len(fanoutRegionList)+1)
+		}
+	}
+	if len(fanoutRegionList) == 0 && (fanoutStreams != "" || fanoutQuorum != "") {
+		logrus.Warnf("[kinesis %d] 'fanout_streams' and 'fanout_quorum' are ignored unless 'fanout_regions' is set", pluginID)
+	}
+
+	if projection != "" && (logKey != "" || dataKeysOutputType == kinesis.DataKeysOutputValues || recordFormatType != kinesis.RecordFormatJSON) {
+		return nil, fmt.Errorf("[kinesis %d] 'projection' can't be used with 'log_key', 'data_keys_output' values, or a 'record_format' other than json, which send records in their own format", pluginID)
+	}
+	var projectionNoMatchType kinesis.ProjectionNoMatch
+	switch strings.ToLower(projectionNoMatch) {
+	case string(kinesis.ProjectionNoMatchDrop), "":
+		projectionNoMatchType = kinesis.ProjectionNoMatchDrop
+	case string(kinesis.ProjectionNoMatchEmpty):
+		projectionNoMatchType = kinesis.ProjectionNoMatchEmpty
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'projection_no_match' value (%s) specified, must be 'drop', 'empty', or undefined", pluginID, projectionNoMatch)
+	}
+	if projection == "" && projectionNoMatch != "" {
+		logrus.Warnf("[kinesis %d] 'projection_no_match' is ignored unless 'projection' is set", pluginID)
+	}
+	if projection != "" && sizeKey != "" {
+		logrus.Warnf("[kinesis %d] 'size_key' is ignored when 'projection' is set", pluginID)
+	}
+
+	tlsMinVersionID, err := parseTLSMinVersion(tlsMinVersion, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	tlsCipherSuiteIDs, err := parseTLSCipherSuites(tlsCipherSuites, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	if tlsMinVersionID == tls.VersionTLS13 && len(tlsCipherSuiteIDs) > 0 {
+		logrus.Warnf("[kinesis %d] 'tls_cipher_suites' is ignored when 'tls_min_version' is 1.3, TLS 1.3 cipher suites are not configurable", pluginID)
+	}
+
+	isIndependentSecondaryFlush := strings.ToLower(independentSecondaryFlush) == "true"
+	if isIndependentSecondaryFlush && mirrorStream == "" && metadataStream == "" && dlqStream == "" {
+		logrus.Warnf("[kinesis %d] 'independent_secondary_flush' is ignored unless 'mirror_stream', 'metadata_stream' or 'dlq_stream' is set", pluginID)
+	}
+
+	var entropyThreshold float64
+	if compressionEntropyThreshold != "" {
+		entropyThreshold, err = strconv.ParseFloat(compressionEntropyThreshold, 64)
+		if err != nil || entropyThreshold < 0 || entropyThreshold > 8 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'compression_entropy_threshold' value (%s) specified, must be a number of bits per byte between 0 and 8", pluginID, compressionEntropyThreshold)
+		}
+		if entropyThreshold > 0 && comp == kinesis.CompressionNone {
+			logrus.Warnf("[kinesis %d] 'compression_entropy_threshold' is ignored unless 'compression' is set", pluginID)
+		} else if entropyThreshold > 0 && strings.ToLower(codecHeader) != "true" {
+			return nil, fmt.Errorf("[kinesis %d] 'compression_entropy_threshold' requires 'codec_header' true, so consumers can tell which records were sent uncompressed", pluginID)
+		}
+	}
+
+	var checksumAlgoType kinesis.ChecksumAlgo
+	switch strings.ToLower(checksumAlgo) {
+	case string(kinesis.ChecksumCRC32), "":
+		checksumAlgoType = kinesis.ChecksumCRC32
+	case string(kinesis.ChecksumSHA256):
+		checksumAlgoType = kinesis.ChecksumSHA256
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'checksum_algo' value (%s) specified, must be 'crc32', 'sha256', or undefined", pluginID, checksumAlgo)
+	}
+	if checksumKey == "" && checksumAlgo != "" {
+		logrus.Warnf("[kinesis %d] 'checksum_algo' is ignored unless 'checksum_key' is set", pluginID)
+	}
+	if checksumKey != "" && (logKey != "" || dataKeysOutputType == kinesis.DataKeysOutputValues || recordFormatType != kinesis.RecordFormatJSON || projection != "") {
+		logrus.Warnf("[kinesis %d] 'checksum_key' is ignored when 'log_key', 'data_keys_output' values, 'projection', or a 'record_format' other than json is set, since records are not sent as JSON objects", pluginID)
+	}
+
+	var gzipFlushModeType kinesis.GzipFlushMode
+	switch strings.ToLower(gzipFlushMode) {
+	case string(kinesis.GzipFlushFull), "":
+		gzipFlushModeType = kinesis.GzipFlushFull
+	case string(kinesis.GzipFlushSync):
+		gzipFlushModeType = kinesis.GzipFlushSync
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'gzip_flush_mode' value (%s) specified, must be 'full', 'sync', or undefined", pluginID, gzipFlushMode)
+	}
+	if gzipFlushMode != "" && comp != kinesis.CompressionGzip {
+		logrus.Warnf("[kinesis %d] 'gzip_flush_mode' is ignored unless 'compression' is gzip", pluginID)
+	}
+
+	isRebuildClientOnAuthError := strings.ToLower(rebuildClientOnAuthError) == "true"
+	var clientRebuildCooldownDuration time.Duration
+	if clientRebuildCooldown != "" {
+		clientRebuildCooldownInt, err := parseNonNegativeConfig("client_rebuild_cooldown", clientRebuildCooldown, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if clientRebuildCooldownInt == 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'client_rebuild_cooldown' %s, must be at least 1 second", pluginID, clientRebuildCooldown)
+		}
+		clientRebuildCooldownDuration = time.Duration(clientRebuildCooldownInt) * time.Second
+		if !isRebuildClientOnAuthError {
+			logrus.Warnf("[kinesis %d] 'client_rebuild_cooldown' is ignored unless 'rebuild_client_on_auth_error' is true", pluginID)
+		}
+	}
+
+	isPreserveChunkOrder := strings.ToLower(preserveChunkOrder) == "true"
+	if isPreserveChunkOrder && isAggregate && strings.ToLower(groupByPartitionKey) == "true" {
+		return nil, fmt.Errorf("[kinesis %d] 'preserve_chunk_order' can't be combined with 'group_by_partition_key', which aggregates the records of a chunk by key", pluginID)
+	}
+
+	if sourcePathKey == "" && (sourcePathField != "" || sourcePathTagRegex != "" || sourcePathTagFormat != "") {
+		logrus.Warnf("[kinesis %d] 'source_path_field', 'source_path_tag_regex' and 'source_path_tag_format' are ignored unless 'source_path_key' is set", pluginID)
+	}
+	if sourcePathKey != "" && sourcePathField == "" && sourcePathTagRegex == "" {
+		return nil, fmt.Errorf("[kinesis %d] 'source_path_key' needs 'source_path_field' or 'source_path_tag_regex' to find the source path", pluginID)
+	}
+
+	errorRetryStrategies, err := parseErrorRetryStrategies(errorRetryStrategy, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	var errorRetryFixedDelayDuration time.Duration
+	if errorRetryFixedDelay != "" {
+		errorRetryFixedDelayInt, err := parseNonNegativeConfig("error_retry_fixed_delay_ms", errorRetryFixedDelay, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		errorRetryFixedDelayDuration = time.Duration(errorRetryFixedDelayInt) * time.Millisecond
+	}
+	var errorRetryAttemptsInt int
+	if errorRetryAttempts != "" {
+		errorRetryAttemptsInt, err = parseNonNegativeConfig("error_retry_attempts", errorRetryAttempts, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if errorRetryAttemptsInt == 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'error_retry_attempts' %s, must be at least 1", pluginID, errorRetryAttempts)
+		}
+	}
+	if len(errorRetryStrategies) == 0 && (errorRetryFixedDelay != "" || errorRetryAttempts != "") {
+		logrus.Warnf("[kinesis %d] 'error_retry_fixed_delay_ms' and 'error_retry_attempts' are ignored unless 'error_retry_strategy' is set", pluginID)
+	}
+
+	if gapMarker != "" && sequenceKey == "" {
+		logrus.Warnf("[kinesis %d] 'gap_marker' is ignored unless 'sequence_key' is set", pluginID)
+	}
+	if gapMarker != "" && gapMarker == sequenceKey {
+		return nil, fmt.Errorf("[kinesis %d] 'gap_marker' must be different from 'sequence_key'", pluginID)
+	}
+
+	var sourceEncodingType kinesis.SourceEncoding
+	switch strings.ToLower(sourceEncoding) {
+	case "":
+	case string(kinesis.SourceEncodingUTF8), "utf8":
+		sourceEncodingType = kinesis.SourceEncodingUTF8
+	case string(kinesis.SourceEncodingLatin1), "iso-8859-1":
+		sourceEncodingType = kinesis.SourceEncodingLatin1
+	case string(kinesis.SourceEncodingWindows1252):
+		sourceEncodingType = kinesis.SourceEncodingWindows1252
+	case string(kinesis.SourceEncodingUTF16LE), "utf-16":
+		sourceEncodingType = kinesis.SourceEncodingUTF16LE
+	case string(kinesis.SourceEncodingUTF16BE):
+		sourceEncodingType = kinesis.SourceEncodingUTF16BE
+	case string(kinesis.SourceEncodingAuto):
+		sourceEncodingType = kinesis.SourceEncodingAuto
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'source_encoding' value (%s) specified, must be 'utf-8', 'latin1', 'windows-1252', 'utf-16le', 'utf-16be', 'auto', or undefined", pluginID, sourceEncoding)
+	}
+
+	var sourceEncodingInvalidType kinesis.InvalidEncoding
+	switch strings.ToLower(sourceEncodingInvalid) {
+	case string(kinesis.InvalidEncodingReplace), "":
+		sourceEncodingInvalidType = kinesis.InvalidEncodingReplace
+	case string(kinesis.InvalidEncodingDrop):
+		sourceEncodingInvalidType = kinesis.InvalidEncodingDrop
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'source_encoding_invalid' value (%s) specified, must be 'replace', 'drop', or undefined", pluginID, sourceEncodingInvalid)
+	}
+	if sourceEncodingInvalid != "" && sourceEncoding == "" {
+		logrus.Warnf("[kinesis %d] 'source_encoding_invalid' is ignored unless 'source_encoding' is set", pluginID)
+	}
+
+	if checkpointDir != "" && workersInt > 0 {
+		return nil, fmt.Errorf("[kinesis %d] 'checkpoint_dir' can't be combined with 'workers', since the batches queued on the workers are not checkpointed", pluginID)
+	}
+	if checkpointDir != "" && checkpointDir == spillDir {
+		return nil, fmt.Errorf("[kinesis %d] 'checkpoint_dir' must be different from 'spill_dir'", pluginID)
+	}
+
+	if (routeFlagKey == "") != (routeFlagValue == "") {
+		return nil, fmt.Errorf("[kinesis %d] 'route_flag_key' and 'route_flag_value' must be set together", pluginID)
+	}
+	if routeFlagKey != "" {
+		for _, key := range strings.Split(routeFlagKey, "->") {
+			if key == "" {
+				return nil, fmt.Errorf("[kinesis %d] Invalid 'route_flag_key' value (%s) specified, nested keys must not be empty", pluginID, routeFlagKey)
+			}
+		}
+	}
+
+	var envelopeFields []kinesis.EnvelopeField
+	for _, field := range splitConfigList(envelope) {
+		switch kinesis.EnvelopeField(strings.ToLower(field)) {
+		case kinesis.EnvelopeFieldTag, kinesis.EnvelopeFieldTimestamp, kinesis.EnvelopeFieldPartitionKey, kinesis.EnvelopeFieldStream:
+			envelopeFields = append(envelopeFields, kinesis.EnvelopeField(strings.ToLower(field)))
+		default:
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'envelope' field (%s) specified, must be 'tag', 'timestamp', 'partition_key', or 'stream'", pluginID, field)
+		}
+	}
+	if len(envelopeFields) > 0 {
+		if logKey != "" || dataKeysOutputType == kinesis.DataKeysOutputValues || recordFormatType != kinesis.RecordFormatJSON {
+			return nil, fmt.Errorf("[kinesis %d] 'envelope' can't be used with 'log_key', 'data_keys_output' values, or a 'record_format' other than json, since it wraps records marshaled to JSON", pluginID)
+		}
+		if keySource == kinesis.PartitionKeySourceRecordHash {
+			for _, field := range envelopeFields {
+				if field == kinesis.EnvelopeFieldPartitionKey {
+					return nil, fmt.Errorf("[kinesis %d] 'envelope' field partition_key can't be used with 'partition_key_source' record_hash, which hashes the record once it is wrapped", pluginID)
+				}
+			}
+		}
+	}
+
+	isValidateStream := strings.ToLower(validateStream) == "true"
+	if isValidateStream && sinkType == kinesis.SinkFirehose {
+		logrus.Warnf("[kinesis %d] 'validate_stream' is ignored when 'sink' is firehose", pluginID)
+		isValidateStream = false
+	}
+	if isValidateStream && strings.ToLower(lazyClientInit) == "true" {
+		logrus.Warnf("[kinesis %d] 'validate_stream' is ignored when 'lazy_client_init' is set, since the client is only created on the first flush", pluginID)
+		isValidateStream = false
+	}
+	var validateStreamTimeoutDuration time.Duration
+	if validateStreamTimeout != "" {
+		validateStreamTimeoutInt, err := parseNonNegativeConfig("validate_stream_timeout", validateStreamTimeout, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if validateStreamTimeoutInt == 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'validate_stream_timeout' %s, must be at least 1 second", pluginID, validateStreamTimeout)
+		}
+		validateStreamTimeoutDuration = time.Duration(validateStreamTimeoutInt) * time.Second
+		if !isValidateStream {
+			logrus.Warnf("[kinesis %d] 'validate_stream_timeout' is ignored unless 'validate_stream' is true", pluginID)
+		}
+	}
+
+	var partitionKeyCacheSizeInt int
+	if partitionKeyCacheSize != "" {
+		var err error
+		partitionKeyCacheSizeInt, err = parseNonNegativeConfig("partition_key_cache_size", partitionKeyCacheSize, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if partitionKeyCacheSizeInt > 0 && (keySource != kinesis.PartitionKeySourceField || partitionKey == "") {
+			logrus.Warnf("[kinesis %d] 'partition_key_cache_size' is ignored unless 'partition_key' is set", pluginID)
+			partitionKeyCacheSizeInt = 0
+		}
+	}
+
+	var shardLoadLogIntervalDuration time.Duration
+	if shardLoadLogInterval != "" {
+		shardLoadLogIntervalInt, err := parseNonNegativeConfig("shard_load_log_interval", shardLoadLogInterval, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if sinkType == kinesis.SinkFirehose {
+			logrus.Warnf("[kinesis %d] 'shard_load_log_interval' is ignored when 'sink' is firehose, delivery streams have no shards", pluginID)
+		} else if fanoutRegions != "" {
+			logrus.Warnf("[kinesis %d] 'shard_load_log_interval' is ignored when 'fanout_regions' is set", pluginID)
+		} else {
+			shardLoadLogIntervalDuration = time.Duration(shardLoadLogIntervalInt) * time.Second
+		}
+	}
+
+	var chunkSizeInt int
+	if chunkSize != "" {
+		chunkSizeInt, err = parseNonNegativeConfig("chunk_size", chunkSize, pluginID)
+		if err != nil {
+			return nil, err
+		}
+		if chunkField == "" {
+			logrus.Warnf("[kinesis %d] 'chunk_size' is ignored unless 'chunk_field' is set", pluginID)
+		}
+	}
+
+	var frame kinesis.FramingType
+	switch strings.ToLower(framing) {
+	case "", string(kinesis.FramingNone):
+		frame = kinesis.FramingNone
+	case string(kinesis.FramingLengthPrefixed):
+		frame = kinesis.FramingLengthPrefixed
+		if appendNL {
+			logrus.Warnf("[kinesis %d] 'append_newline' is redundant with 'framing' %s, the newline will be included in each framed event", pluginID, frame)
+		}
+	default:
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'framing' value (%s) specified, must be 'length_prefixed', 'none', or undefined", pluginID, framing)
+	}
+
+	if metricsAddr != "" {
+		if err := startMetricsServer(metricsAddr, pluginID); err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to serve metrics on %s: %v", pluginID, metricsAddr, err)
+		}
+	}
+
+	var retryBudgetPerMinuteInt int
+	if retryBudgetPerMinute != "" {
+		retryBudgetPerMinuteInt, err = parseNonNegativeConfig("retry_budget_per_minute", retryBudgetPerMinute, pluginID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kinesis.NewOutputPlugin(&kinesis.OutputPluginConfig{
+		Region:                        region,
+		Stream:                        stream,
+		DataKeys:                      dataKeys,
+		PartitionKey:                  partitionKey,
+		RoleARN:                       roleARN,
+		KinesisEndpoint:               kinesisEndpoint,
+		STSEndpoint:                   stsEndpoint,
+		TimeKey:                       timeKey,
+		TimeFmt:                       timeKeyFmt,
+		LogKey:                        logKey,
+		ReplaceDots:                   replaceDots,
+		Concurrency:                   concurrencyInt,
+		RetryLimit:                    concurrencyRetriesInt,
+		IsAggregate:                   isAggregate,
+		AppendNewline:                 appendNL,
+		Compression:                   comp,
+		PluginID:                      pluginID,
+		HTTPRequestTimeout:            httpRequestTimeoutDuration,
+		PartitionKeyHistogramTopN:     histogramTopN,
+		PartitionKeyHistogramInterval: histogramInterval,
+		HTTPProxy:                     httpProxy,
+		HTTPSProxy:                    httpsProxy,
+		NoProxy:                       noProxy,
+		BufferMaxBytes:                int64(bufferMaxBytesInt),
+		SpillDir:                      spillDir,
+		PartitionKeySource:            keySource,
+		RecordHashAlgorithm:           recordHashAlgorithm,
+		PartitionKeyHash:              partitionKeyHash,
+		RoundRobinKeys:                roundRobinKeyList,
+		RoundRobinKeyCount:            roundRobinKeyCount,
+		RetryBudgetPerMinute:          retryBudgetPerMinuteInt,
+		Framing:                       frame,
+		Quiet:                         strings.ToLower(quiet) == "true",
+		UseFIPSEndpoint:               strings.ToLower(useFIPSEndpoint) == "true",
+		SDKMaxRetries:                 sdkMaxRetriesInt,
+		MaxIdleConns:                  maxIdleConnsInt,
+		HTTPTimeout:                   httpTimeoutDuration,
+		TeeStdout:                     strings.ToLower(teeStdout) == "true",
+		TeeStdoutRecordsPerSecond:     teeStdoutRecordsPerSecondInt,
+		Workers:                       workersInt,
+		WorkerHash:                    strings.ToLower(workerHash),
+		AddHostMetadata:               strings.ToLower(addHostMetadata) == "true",
+		HostnameKey:                   hostnameKey,
+		HostIPKey:                     hostIPKey,
+		CompressionDictFile:           compressionDictFile,
+		FailOpen:                      strings.ToLower(failOpen) == "true",
+		SizeKey:                       sizeKey,
+		MirrorStream:                  mirrorStream,
+		MirrorCondition:               mirrorCondition,
+		MaxIngestRecordsPerSec:        maxIngestRecordsPerSecInt,
+		EnrichmentFile:                enrichmentFile,
+		EnrichmentKey:                 enrichmentKey,
+		EnrichmentReloadInterval:      enrichmentReloadDuration,
+		DLQStream:                     dlqStream,
+		GroupByPartitionKey:           strings.ToLower(groupByPartitionKey) == "true",
+		RecordPrefix:                  recordPrefixBytes,
+		RecordSuffix:                  recordSuffixBytes,
+		DataKeysOutput:                dataKeysOutputType,
+		DataKeysDelimiter:             string(dataKeysDelimiterBytes),
+		LazyClientInit:                strings.ToLower(lazyClientInit) == "true",
+		TimestampUnit:                 timestampUnitType,
+		OnMarshalError:                onMarshalErrorType,
+		CredentialsRefreshBefore:      credentialsRefreshDuration,
+		CompressKeys:                  compressKeyList,
+		TimeKeyLocation:               timeKeyLocation,
+		AggregationPartitionStrategy:  aggStrategy,
+		LatencyLogInterval:            latencyLogDuration,
+		StringifyKeys:                 strings.ToLower(stringifyKeys) != "false",
+		AggregationMaxRecords:         aggregationMaxRecordsInt,
+		ShutdownDump:                  shutdownDumpType,
+		ShutdownDumpPath:              shutdownDumpPath,
+		MaxFields:                     maxFieldsInt,
+		ExternalID:                    externalID,
+		TimeBucket:                    timeBucketDuration,
+		CodecHeader:                   strings.ToLower(codecHeader) == "true",
+		FlushDeadline:                 flushDeadlineDuration,
+		MetadataStream:                metadataStream,
+		TimestampSourceKeys:           timestampSourceKeyList,
+		TimestampTargetKey:            timestampTargetKey,
+		TimestampFormats:              splitConfigList(timestampFormats),
+		TimestampTargetFormat:         timestampTargetFormat,
+		SpillHighWatermark:            int64(spillHighWatermarkInt),
+		SpillLowWatermark:             int64(spillLowWatermarkInt),
+		Sink:                          sinkType,
+		SequenceKey:                   sequenceKey,
+		DedupKey:                      dedupKey,
+		DedupWindow:                   dedupWindowDuration,
+		ConsistentHashRing:            hashRingWeights,
+		ErrorLogDedupInterval:         errorLogDedupDuration,
+		ErrorLogSampleRate:            errorLogSampleRateInt,
+		StripInternalFields:           splitConfigList(stripInternalFields),
+		StatusFile:                    statusFile,
+		StatusInterval:                statusIntervalDuration,
+		PartitionKeyPattern:           partitionKeyPattern,
+		PartitionKeyInvalidAction:     invalidKeyAction,
+		CoalesceLinger:                coalesceLinger,
+		FlushOnRecords:                flushOnRecordsInt,
+		RecordFormat:                  recordFormatType,
+		AvroSchemaFile:                avroSchemaFile,
+		AvroUnknownFields:             avroUnknownFieldsType,
+		RetryMaxDelay:                 retryMaxDelayDuration,
+		ChunkField:                    chunkField,
+		ChunkSize:                     chunkSizeInt,
+		SigningRegion:                 signingRegion,
+		ProtobufDescriptorFile:        protobufDescriptorFile,
+		ProtobufMessage:               protobufMessage,
+		ProtobufUnknownFields:         protobufUnknownFieldsType,
+		VersionKey:                    versionKey,
+		AdaptiveConcurrencyMin:        adaptiveConcurrencyMinInt,
+		AdaptiveConcurrencyMax:        adaptiveConcurrencyMaxInt,
+		AdaptiveConcurrencyLatency:    adaptiveConcurrencyTargetLatency,
+		PartitionKeyFromMetadata:      keyFromMetadata,
+		TargetBatchBytes:              targetBatchBytesInt,
+		EMFMetrics:                    isEMFMetrics,
+		EMFNamespace:                  emfNamespace,
+		EMFInterval:                   emfIntervalDuration,
+		AuditLog:                      auditLog,
+		AuditLogMaxBytes:              int64(auditLogMaxBytesInt),
+		AuditLogMaxFiles:              auditLogMaxFilesInt,
+		PartitionKeyTrim:              strings.ToLower(partitionKeyTrim) == "true",
+		PartitionKeyLowercase:         strings.ToLower(partitionKeyLowercase) == "true",
+		FanoutRegions:                 fanoutRegionList,
+		FanoutStreams:                 fanoutStreamList,
+		FanoutQuorum:                  fanoutQuorumInt,
+		EscapeHTML:                    strings.ToLower(escapeHTML) != "false",
+		Projection:                    projection,
+		ProjectionNoMatch:             projectionNoMatchType,
+		TLSMinVersion:                 tlsMinVersionID,
+		TLSCipherSuites:               tlsCipherSuiteIDs,
+		IndependentSecondaryFlush:     isIndependentSecondaryFlush,
+		CompressionEntropyThreshold:   entropyThreshold,
+		ChecksumKey:                   checksumKey,
+		ChecksumAlgo:                  checksumAlgoType,
+		WeightedPartitionKeys:         weightedKeyList,
+		GzipFlushMode:                 gzipFlushModeType,
+		DropWhere:                     dropWhere,
+		RebuildClientOnAuthError:      isRebuildClientOnAuthError,
+		ClientRebuildCooldown:         clientRebuildCooldownDuration,
+		PreserveChunkOrder:            isPreserveChunkOrder,
+		SourcePathKey:                 sourcePathKey,
+		SourcePathField:               sourcePathField,
+		SourcePathTagRegex:            sourcePathTagRegex,
+		SourcePathTagFormat:           sourcePathTagFormat,
+		ErrorRetryStrategies:          errorRetryStrategies,
+		ErrorRetryFixedDelay:          errorRetryFixedDelayDuration,
+		ErrorRetryAttempts:            errorRetryAttemptsInt,
+		GapMarker:                     gapMarker,
+		SourceEncoding:                sourceEncodingType,
+		SourceEncodingInvalid:         sourceEncodingInvalidType,
+		CheckpointDir:                 checkpointDir,
+		ShardLoadLogInterval:          shardLoadLogIntervalDuration,
+		PartialAck:                    strings.ToLower(partialAck) == "true",
+		RecordPool:                    strings.ToLower(recordPool) == "true",
+		RouteFlagKey:                  routeFlagKey,
+		RouteFlagValue:                routeFlagValue,
+		Envelope:                      envelopeFields,
+		ValidateStream:                isValidateStream,
+		ValidateStreamTimeout:         validateStreamTimeoutDuration,
+		BatchTrailer:                  strings.ToLower(batchTrailer) == "true",
+		PartitionKeyCacheSize:         partitionKeyCacheSizeInt,
+	})
+}
+
+// parseTimeKeyTimezone loads the location for time_key_timezone, or returns nil if it is not set
+func parseTimeKeyTimezone(timezone string, pluginID int) (*time.Location, error) {
+	if timezone == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("[kinesis %d] Invalid 'time_key_timezone' %s, must be an IANA time zone name such as UTC or America/New_York: %v", pluginID, timezone, err)
+	}
+	return location, nil
+}
+
+// parseRoundRobinKeys parses round_robin_keys, which is either a number of keys to
+// generate or a comma separated list of keys
+func parseRoundRobinKeys(roundRobinKeys string, pluginID int) ([]string, int, error) {
+	if roundRobinKeys == "" {
+		return nil, 0, fmt.Errorf("[kinesis %d] 'round_robin_keys' is required for round_robin partition keys", pluginID)
+	}
+	if count, err := strconv.Atoi(roundRobinKeys); err == nil {
+		if count <= 0 {
+			return nil, 0, fmt.Errorf("[kinesis %d] Invalid 'round_robin_keys' %d, must be greater than 0", pluginID, count)
+		}
+		return nil, count, nil
+	}
+
+	var keys []string
+	for _, key := range strings.Split(roundRobinKeys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" || len(key) > 256 {
+			return nil, 0, fmt.Errorf("[kinesis %d] Invalid 'round_robin_keys', each key must be between 1 and 256 characters", pluginID)
+		}
+		keys = append(keys, key)
+	}
+	return keys, 0, nil
+}
+
+// parseWeightedPartitionKeys parses weighted_partition_keys, a comma separated list of
+// key:weight pairs. The weight follows the last colon, so keys may contain colons.
+func parseWeightedPartitionKeys(weightedPartitionKeys string, pluginID int) ([]kinesis.WeightedPartitionKey, error) {
+	if weightedPartitionKeys == "" {
+		return nil, fmt.Errorf("[kinesis %d] 'weighted_partition_keys' is required for weighted partition keys", pluginID)
+	}
+
+	var keys []kinesis.WeightedPartitionKey
+	for _, pair := range splitConfigList(weightedPartitionKeys) {
+		separator := strings.LastIndex(pair, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'weighted_partition_keys' entry %s, must be of the form key:weight", pluginID, pair)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(pair[separator+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'weighted_partition_keys' entry %s, the weight must be a whole number: %v", pluginID, pair, err)
+		}
+		keys = append(keys, kinesis.WeightedPartitionKey{
+			Key:    strings.TrimSpace(pair[:separator]),
+			Weight: weight,
+		})
+	}
+	return keys, nil
+}
+
+// parseErrorRetryStrategies parses error_retry_strategy, a comma separated list of
+// code:strategy pairs, or returns nil if it is not set
+func parseErrorRetryStrategies(errorRetryStrategy string, pluginID int) (map[string]kinesis.RetryStrategy, error) {
+	if errorRetryStrategy == "" {
+		return nil, nil
+	}
+
+	strategies := make(map[string]kinesis.RetryStrategy)
+	for _, pair := range splitConfigList(errorRetryStrategy) {
+		separator := strings.LastIndex(pair, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'error_retry_strategy' entry %s, must be of the form code:strategy", pluginID, pair)
+		}
+		code := strings.TrimSpace(pair[:separator])
+		strategy := kinesis.RetryStrategy(strings.ToLower(strings.TrimSpace(pair[separator+1:])))
+		switch strategy {
+		case kinesis.RetryStrategyBackoff, kinesis.RetryStrategyFixed, kinesis.RetryStrategyImmediate, kinesis.RetryStrategyDrop:
+		default:
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'error_retry_strategy' entry %s, the strategy must be 'backoff', 'fixed', 'immediate' or 'drop'", pluginID, pair)
+		}
+		if code == "" {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'error_retry_strategy' entry %s, the error code is empty", pluginID, pair)
+		}
+		strategies[code] = strategy
+	}
+	return strategies, nil
+}
+
+// parseEscapedConfig interprets Go escape sequences in a config value, such as \n, \t,
+// \x00 or \u00e9, so arbitrary bytes can be configured
+func parseEscapedConfig(configName string, configValue string, pluginID int) ([]byte, error) {
+	if configValue == "" {
+		return nil, nil
+	}
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(configValue, `"`, `\"`) + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("[kinesis %d] Invalid escape sequence in '%s': %v", pluginID, configName, err)
+	}
+	return []byte(unquoted), nil
+}
+
+// parseTLSMinVersion parses tls_min_version, such as 1.2, or returns 0 if it is not set
+func parseTLSMinVersion(version string, pluginID int) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("[kinesis %d] Invalid 'tls_min_version' value (%s) specified, must be '1.0', '1.1', '1.2', '1.3', or undefined", pluginID, version)
+	}
+}
+
+// parseTLSCipherSuites parses tls_cipher_suites, a comma separated list of cipher suite
+// names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites with known security issues
+// are rejected.
+func parseTLSCipherSuites(suites string, pluginID int) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range splitConfigList(suites) {
+		id, ok := byName[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'tls_cipher_suites' value (%s) specified, %s is not a supported secure cipher suite", pluginID, suites, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// splitConfigList splits a comma separated list, ignoring empty items
+func splitConfigList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func parseNonNegativeConfig(configName string, configValue string, pluginID int) (int, error) {
@@ -203,62 +1847,100 @@ func FLBPluginFlushCtx(ctx, data unsafe.Pointer, length C.int, tag *C.char) int
 
 	fluentTag := C.GoString(tag)
 
-	events, count, retCode := unpackRecords(kinesisOutput, data, length)
+	if kinesisOutput.SpillBackpressure() {
+		return output.FLB_RETRY
+	}
+
+	var chunkKey string
+	if kinesisOutput.ResumesChunks() {
+		chunkKey = kinesis.ChunkKey(fluentTag, C.GoBytes(data, length))
+		if events, ok := kinesisOutput.ResumeChunk(chunkKey); ok {
+			kinesisOutput.Logger().Debugf("Flushing %d logs left unsent by an earlier flush with tag: %s\n", len(events), fluentTag)
+			return flushRecords(kinesisOutput, chunkKey, len(events), events)
+		}
+	}
+
+	events, count, retCode := unpackRecords(kinesisOutput, data, length, fluentTag)
 	if retCode != output.FLB_OK {
-		logrus.Errorf("[kinesis %d] failed to unpackRecords with tag: %s\n", kinesisOutput.PluginID, fluentTag)
+		kinesisOutput.Logger().Errorf("failed to unpackRecords with tag: %s\n", fluentTag)
 
 		return retCode
 	}
 
-	logrus.Debugf("[kinesis %d] Flushing %d logs with tag: %s\n", kinesisOutput.PluginID, count, fluentTag)
-	if kinesisOutput.Concurrency > 0 {
-		return kinesisOutput.FlushConcurrent(count, events)
-	}
+	kinesisOutput.Logger().Debugf("Flushing %d logs with tag: %s\n", count, fluentTag)
+	return flushRecords(kinesisOutput, chunkKey, count, events)
+}
 
-	return kinesisOutput.Flush(&events)
+// flushRecords sends the records of a chunk in the configured flush mode. FLB_RETRY
+// tells Fluent Bit to hold the chunk and deliver it again later, which is returned
+// whenever the workers' queues, the concurrency limit or buffer_max_bytes can't
+// accept the chunk, so a saturated plugin applies back-pressure instead of
+// accepting records it can't buffer. If chunkKey is set, the records left unsent
+// when FLB_RETRY is returned are kept, so the retry only sends those.
+func flushRecords(kinesisOutput *kinesis.OutputPlugin, chunkKey string, count int, events []*kinesisAPI.PutRecordsRequestEntry) int {
+	var retCode int
+	if kinesisOutput.Workers() > 0 {
+		retCode = kinesisOutput.DispatchToWorkers(count, events)
+	} else if kinesisOutput.Concurrency > 0 {
+		retCode = kinesisOutput.FlushConcurrent(count, events)
+	} else {
+		retCode = kinesisOutput.Flush(&events)
+		if retCode == output.FLB_RETRY && !kinesisOutput.AllowRetry() {
+			kinesisOutput.DiscardRecords(events)
+			if kinesisOutput.DeadLetter(events, fmt.Errorf("retry budget exhausted")) {
+				return output.FLB_OK
+			}
+			kinesisOutput.Logger().Errorf("Failed to send (%d) records, dropping them since the retry budget is exhausted\n", len(events))
+			return output.FLB_ERROR
+		}
+		if retCode == output.FLB_RETRY && chunkKey != "" {
+			kinesisOutput.KeepUnsent(chunkKey, events)
+			return retCode
+		}
+	}
+	if retCode == output.FLB_RETRY {
+		// Fluent Bit delivers the chunk again, and its records are added again
+		kinesisOutput.DiscardRecords(events)
+	}
+	return retCode
 }
 
-func unpackRecords(kinesisOutput *kinesis.OutputPlugin, data unsafe.Pointer, length C.int) ([]*kinesisAPI.PutRecordsRequestEntry, int, int) {
-	var ret int
-	var ts interface{}
+func unpackRecords(kinesisOutput *kinesis.OutputPlugin, data unsafe.Pointer, length C.int, tag string) ([]*kinesisAPI.PutRecordsRequestEntry, int, int) {
 	var timestamp time.Time
-	var record map[interface{}]interface{}
 	count := 0
 
 	records := make([]*kinesisAPI.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
 
-	// Create Fluent Bit decoder
-	dec := output.NewDecoder(data, int(length))
-
-	for {
-		//Extract Record
-		ret, ts, record = output.GetRecord(dec)
-		if ret != 0 {
-			break
-		}
-
+	stats, retCode := decodeChunk(C.GoBytes(data, length), func(ts interface{}, metadata map[interface{}]interface{}, record map[interface{}]interface{}) int {
 		switch tts := ts.(type) {
 		case output.FLBTime:
 			timestamp = tts.Time
 		case uint64:
-			// when ts is of type uint64 it appears to
-			// be the amount of seconds since unix epoch.
-			timestamp = time.Unix(int64(tts), 0)
+			// inputs differ in the unit of integer timestamps, seconds
+			// since the unix epoch unless timestamp_unit says otherwise
+			timestamp = kinesisOutput.IntegerTimestamp(tts)
 		default:
 			timestamp = time.Now()
 		}
 
-		retCode := kinesisOutput.AddRecord(&records, record, &timestamp)
+		retCode := kinesisOutput.AddTaggedRecord(&records, record, metadata, tag, &timestamp)
 		if retCode != output.FLB_OK {
-			return nil, 0, retCode
+			return retCode
 		}
 
 		count++
+		return output.FLB_OK
+	})
+	recordUnpackStats(kinesisOutput.PluginID, kinesisOutput.Logger(), stats)
+	if retCode != output.FLB_OK {
+		kinesisOutput.DiscardRecords(records)
+		return nil, 0, retCode
 	}
 
 	if kinesisOutput.IsAggregate() {
 		retCode := kinesisOutput.FlushAggregatedRecords(&records)
 		if retCode != output.FLB_OK {
+			kinesisOutput.DiscardRecords(records)
 			return nil, 0, retCode
 		}
 	}
@@ -268,7 +1950,9 @@ func unpackRecords(kinesisOutput *kinesis.OutputPlugin, data unsafe.Pointer, len
 
 //export FLBPluginExit
 func FLBPluginExit() int {
-
+	// Fluent Bit exits the plugin before a hot reload initializes it again,
+	// so release everything the old instances hold before new ones take over
+	closePluginInstances()
 	return output.FLB_OK
 }
 
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	kinesisAPI "github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosePluginInstances(t *testing.T) {
+	instance, err := kinesis.NewOutputPlugin(&kinesis.OutputPluginConfig{
+		Region:                    "us-east-1",
+		Stream:                    "stream",
+		PartitionKeyHistogramTopN: 5,
+	})
+	assert.NoError(t, err)
+	pluginInstances = append(pluginInstances, instance)
+
+	assert.NoError(t, startMetricsServer("127.0.0.1:0", 0))
+	address := metricsServer.Addr
+
+	maxPluginInstances, maxPluginInstancesSet = 1, true
+	closePluginInstances()
+	assert.Empty(t, pluginInstances)
+	assert.Nil(t, metricsServer)
+	assert.Equal(t, defaultMaxPluginInstances, maxPluginInstances, "Expected the limit to be set again after a reload")
+	assert.False(t, maxPluginInstancesSet)
+
+	// the metrics address can be reused by the instances created after a reload
+	listener, err := net.Listen("tcp", address)
+	assert.NoError(t, err, "Expected the metrics server to be stopped")
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+func TestCheckPluginInstanceLimit(t *testing.T) {
+	defer func() { maxPluginInstances, maxPluginInstancesSet = defaultMaxPluginInstances, false }()
+
+	assert.NoError(t, checkPluginInstanceLimit("", defaultMaxPluginInstances-1))
+	assert.Error(t, checkPluginInstanceLimit("", defaultMaxPluginInstances), "Expected the default limit to apply")
+
+	// the limit an instance sets applies to every later instance
+	assert.NoError(t, checkPluginInstanceLimit("3", 2))
+	assert.Equal(t, 3, maxPluginInstances)
+	assert.EqualError(t, checkPluginInstanceLimit("", 3), "[kinesis 3] More than 3 kinesis output instances are configured, which exceeds 'max_plugin_instances'. Check the configuration for repeated output sections, or raise 'max_plugin_instances' if they are intended")
+	assert.EqualError(t, checkPluginInstanceLimit("10", 1), "[kinesis 1] 'max_plugin_instances' 10 conflicts with the limit of 3 set by another kinesis output, the limit is shared by all of them")
+	assert.NoError(t, checkPluginInstanceLimit("3", 1), "Expected the same limit to be accepted")
+	assert.Equal(t, 3, maxPluginInstances)
+
+	assert.EqualError(t, checkPluginInstanceLimit("0", 0), "[kinesis 0] Invalid 'max_plugin_instances' 0, must be at least 1")
+	assert.Error(t, checkPluginInstanceLimit("many", 0))
+}
+
+func TestParseEscapedConfig(t *testing.T) {
+	for value, expected := range map[string][]byte{
+		"":          nil,
+		"plain":     []byte("plain"),
+		`\x01SRC`:   {0x01, 'S', 'R', 'C'},
+		`\t\n\\`:    []byte("\t\n\\"),
+		`"quoted"`:  []byte(`"quoted"`),
+		`caf\u00e9`: []byte("caf\u00e9"),
+		`\x00\xff`:  {0x00, 0xff},
+	} {
+		parsed, err := parseEscapedConfig("record_prefix", value, 0)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, parsed, value)
+	}
+
+	_, err := parseEscapedConfig("record_prefix", `\q`, 0)
+	assert.Error(t, err)
+}
+
+func TestParseRoundRobinKeys(t *testing.T) {
+	keys, count, err := parseRoundRobinKeys("8", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+	assert.Equal(t, 8, count)
+
+	keys, count, err = parseRoundRobinKeys("shard-a, shard-b,shard-c", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"shard-a", "shard-b", "shard-c"}, keys)
+	assert.Equal(t, 0, count)
+
+	for _, invalid := range []string{"", "0", "-2", "a,,b"} {
+		_, _, err = parseRoundRobinKeys(invalid, 0)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseWeightedPartitionKeys(t *testing.T) {
+	keys, err := parseWeightedPartitionKeys("canary:1, main:9,tenant:a:2", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []kinesis.WeightedPartitionKey{
+		{Key: "canary", Weight: 1},
+		{Key: "main", Weight: 9},
+		{Key: "tenant:a", Weight: 2},
+	}, keys)
+
+	_, err = parseWeightedPartitionKeys("", 0)
+	assert.EqualError(t, err, "[kinesis 0] 'weighted_partition_keys' is required for weighted partition keys")
+
+	_, err = parseWeightedPartitionKeys("canary", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'weighted_partition_keys' entry canary, must be of the form key:weight")
+
+	_, err = parseWeightedPartitionKeys("canary:high", 0)
+	assert.Error(t, err)
+}
+
+func TestParseErrorRetryStrategies(t *testing.T) {
+	strategies, err := parseErrorRetryStrategies("ProvisionedThroughputExceededException:backoff, InternalFailure:Fixed,ValidationException:drop", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]kinesis.RetryStrategy{
+		"ProvisionedThroughputExceededException": kinesis.RetryStrategyBackoff,
+		"InternalFailure":                        kinesis.RetryStrategyFixed,
+		"ValidationException":                    kinesis.RetryStrategyDrop,
+	}, strategies)
+
+	strategies, err = parseErrorRetryStrategies("", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, strategies)
+
+	_, err = parseErrorRetryStrategies("InternalFailure", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'error_retry_strategy' entry InternalFailure, must be of the form code:strategy")
+
+	_, err = parseErrorRetryStrategies("InternalFailure:later", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'error_retry_strategy' entry InternalFailure:later, the strategy must be 'backoff', 'fixed', 'immediate' or 'drop'")
+}
+
+func TestParseTimeKeyTimezone(t *testing.T) {
+	location, err := parseTimeKeyTimezone("", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, location)
+
+	location, err = parseTimeKeyTimezone("America/New_York", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "America/New_York", location.String())
+
+	_, err = parseTimeKeyTimezone("Mars/Olympus_Mons", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'time_key_timezone' Mars/Olympus_Mons, must be an IANA time zone name such as UTC or America/New_York: unknown time zone Mars/Olympus_Mons")
+}
+
+func TestParseTLSSettings(t *testing.T) {
+	version, err := parseTLSMinVersion("", 0)
+	assert.NoError(t, err)
+	assert.Zero(t, version)
+
+	version, err = parseTLSMinVersion("1.2", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	version, err = parseTLSMinVersion("TLS1.3", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = parseTLSMinVersion("1.4", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'tls_min_version' value (1.4) specified, must be '1.0', '1.1', '1.2', '1.3', or undefined")
+
+	suites, err := parseTLSCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls_ecdhe_ecdsa_with_aes_256_gcm_sha384", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	// known to be insecure
+	_, err = parseTLSCipherSuites("TLS_RSA_WITH_RC4_128_SHA", 0)
+	assert.EqualError(t, err, "[kinesis 0] Invalid 'tls_cipher_suites' value (TLS_RSA_WITH_RC4_128_SHA) specified, TLS_RSA_WITH_RC4_128_SHA is not a supported secure cipher suite")
+}
+
+func TestFlushRecordsRetriesWhenSaturated(t *testing.T) {
+	for name, config := range map[string]*kinesis.OutputPluginConfig{
+		"concurrent": {Concurrency: 2},
+		"workers":    {Workers: 2},
+	} {
+		config.Region = "us-east-1"
+		config.Stream = "stream"
+		config.BufferMaxBytes = 16
+		// the client is never needed, since the chunk is rejected before it is sent
+		config.LazyClientInit = true
+		instance, err := kinesis.NewOutputPlugin(config)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+
+		records := []*kinesisAPI.PutRecordsRequestEntry{
+			{Data: []byte("a record larger than the buffer"), PartitionKey: aws.String("key")},
+		}
+		assert.Equal(t, output.FLB_RETRY, flushRecords(instance, "", len(records), records), "Expected %s flushes to hold the chunk", name)
+		instance.Close()
+	}
+}
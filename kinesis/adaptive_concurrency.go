@@ -0,0 +1,100 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAdaptiveConcurrencyTargetLatency is the PutRecords latency adaptive concurrency
+	// backs off above if adaptive_concurrency_target_latency_ms is not set
+	DefaultAdaptiveConcurrencyTargetLatency = time.Second
+	// the factor the limit is multiplied by when a request is slow or fails
+	adaptiveBackoffRatio = 0.5
+)
+
+// adaptiveLimiter bounds the number of concurrent PutRecords calls with a limit which
+// adapts to how the stream responds, increasing additively while requests succeed within
+// the target latency and backing off multiplicatively when a request is slower or fails,
+// including partial failures from throttling, so throughput follows the capacity of the
+// stream without tuning. The limit stays between min and max.
+type adaptiveLimiter struct {
+	min           float64
+	max           float64
+	targetLatency time.Duration
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	inflight int
+}
+
+func newAdaptiveLimiter(min, max int, targetLatency time.Duration) *adaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	if targetLatency <= 0 {
+		targetLatency = DefaultAdaptiveConcurrencyTargetLatency
+	}
+	l := &adaptiveLimiter{
+		min:           float64(min),
+		max:           float64(max),
+		targetLatency: targetLatency,
+		limit:         float64(min),
+	}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// acquire blocks until a call can start within the current limit
+func (l *adaptiveLimiter) acquire() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.inflight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inflight++
+}
+
+// release ends a call which took latency, adapting the limit to its outcome
+func (l *adaptiveLimiter) release(latency time.Duration, failed bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inflight--
+	if failed || latency > l.targetLatency {
+		l.limit *= adaptiveBackoffRatio
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+	} else {
+		l.limit++
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+	// the limit may have grown by more than one slot
+	l.cond.Broadcast()
+}
+
+// current returns the number of concurrent calls currently allowed
+func (l *adaptiveLimiter) current() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit)
+}
@@ -0,0 +1,121 @@
+package kinesis
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiterBounds(t *testing.T) {
+	limiter := newAdaptiveLimiter(2, 5, 100*time.Millisecond)
+	assert.Equal(t, 2, limiter.current(), "Expected the limit to start at the minimum")
+
+	for i := 0; i < 10; i++ {
+		limiter.acquire()
+		limiter.release(time.Millisecond, false)
+	}
+	assert.Equal(t, 5, limiter.current(), "Expected fast calls to raise the limit to the maximum")
+
+	limiter.acquire()
+	limiter.release(time.Millisecond, true)
+	assert.Equal(t, 2, limiter.current(), "Expected a failed call to halve the limit, no lower than the minimum")
+
+	limiter.acquire()
+	limiter.release(time.Second, false)
+	assert.Equal(t, 2, limiter.current(), "Expected a slow call to keep the limit at the minimum")
+}
+
+func TestAdaptiveConcurrencyFollowsLatency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var latency atomic.Int64
+	var inflight, maxInflight atomic.Int32
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			max := maxInflight.Load()
+			if n <= max || maxInflight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(latency.Load()))
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.adaptive = newAdaptiveLimiter(1, 4, 20*time.Millisecond)
+
+	send := func(batches int) {
+		var wg sync.WaitGroup
+		for i := 0; i < batches; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				records := []*kinesis.PutRecordsRequestEntry{{Data: []byte("data"), PartitionKey: aws.String("key")}}
+				dataLength := 0
+				retCode, err := outputPlugin.sendCurrentBatch("stream", &records, &dataLength)
+				assert.NoError(t, err)
+				assert.Equal(t, fluentbit.FLB_OK, retCode)
+			}()
+		}
+		wg.Wait()
+	}
+
+	// low latency raises the limit to the maximum
+	latency.Store(int64(time.Millisecond))
+	send(20)
+	assert.Equal(t, 4, outputPlugin.adaptive.current())
+	assert.LessOrEqual(t, maxInflight.Load(), int32(4), "Expected no more concurrent calls than the maximum")
+
+	// high latency backs off to the minimum
+	latency.Store(int64(40 * time.Millisecond))
+	send(4)
+	assert.Equal(t, 1, outputPlugin.adaptive.current())
+
+	// and calls are sent one at a time until latency recovers
+	maxInflight.Store(0)
+	send(3)
+	assert.Equal(t, int32(1), maxInflight.Load())
+	assert.Equal(t, 1, outputPlugin.adaptive.current(), "Expected slow calls to keep the limit at the minimum")
+
+	latency.Store(int64(time.Millisecond))
+	send(3)
+	assert.Equal(t, 4, outputPlugin.adaptive.current(), "Expected the limit to grow again once latency recovers")
+}
+
+func TestAdaptiveConcurrencyBacksOffOnErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.adaptive = newAdaptiveLimiter(1, 8, time.Second)
+	outputPlugin.adaptive.limit = 8
+
+	records := []*kinesis.PutRecordsRequestEntry{{Data: []byte("data"), PartitionKey: aws.String("key")}}
+	dataLength := 0
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("throttled"))
+	outputPlugin.sendCurrentBatch("stream", &records, &dataLength)
+	assert.Equal(t, 4, outputPlugin.adaptive.current(), "Expected a failed request to halve the limit")
+
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(1),
+		Records: []*kinesis.PutRecordsResultEntry{
+			{ErrorCode: aws.String(kinesis.ErrCodeProvisionedThroughputExceededException)},
+		},
+	}, nil)
+	outputPlugin.sendCurrentBatch("stream", &records, &dataLength)
+	assert.Equal(t, 2, outputPlugin.adaptive.current(), "Expected throttled records to halve the limit")
+}
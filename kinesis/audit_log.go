@@ -0,0 +1,239 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultAuditLogMaxBytes is the size the audit log is rotated at if audit_log_max_bytes is not set
+	DefaultAuditLogMaxBytes = 100 * 1024 * 1024
+	// DefaultAuditLogMaxFiles is the number of rotated audit logs kept if audit_log_max_files is not set
+	DefaultAuditLogMaxFiles = 5
+
+	// entries queued for the audit log writer, beyond which entries are dropped rather than block a flush
+	auditLogQueueSize = 64 * 1024
+)
+
+// auditEntry is the JSON line written to the audit log for each record a stream accepted
+type auditEntry struct {
+	Time           time.Time `json:"time"`
+	Stream         string    `json:"stream"`
+	PartitionKey   string    `json:"partition_key"`
+	ShardID        string    `json:"shard_id"`
+	SequenceNumber string    `json:"sequence_number"`
+}
+
+// auditLog appends an entry for each record sent to a local file, which is rotated once it
+// reaches maxBytes, keeping maxFiles rotated files as path.1, the newest, to path.N. Entries
+// are written by a goroutine of its own, so flushes never wait on the disk; if the writer
+// falls behind by more than auditLogQueueSize entries, new entries are dropped and counted.
+type auditLog struct {
+	logger   *logrus.Entry
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	// held while queueing entries, so the queue is never written to once closed
+	mutex   sync.RWMutex
+	closed  bool
+	entries chan auditEntry
+	done    chan struct{}
+	dropped uint64
+
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	// the first error the writer hit, reported when the log is closed
+	errOnce sync.Once
+	err     error
+}
+
+// openAuditFile opens the audit log for appending, tests replace it to fail rotations
+var openAuditFile = func(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+func newAuditLog(logger *logrus.Entry, path string, maxBytes int64, maxFiles int) (*auditLog, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultAuditLogMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultAuditLogMaxFiles
+	}
+	a := &auditLog{
+		logger:   logger,
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		entries:  make(chan auditEntry, auditLogQueueSize),
+		done:     make(chan struct{}),
+	}
+	file, size, err := a.open(path)
+	if err != nil {
+		return nil, err
+	}
+	a.file, a.writer, a.size = file, bufio.NewWriter(file), size
+	go a.run()
+	return a, nil
+}
+
+// record queues an entry for each record the PutRecords response shows was accepted,
+// returning the number of entries dropped because the queue was full
+func (a *auditLog) record(stream string, records []*kinesis.PutRecordsRequestEntry, response *kinesis.PutRecordsOutput) int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		return 0
+	}
+	now := time.Now().UTC()
+	dropped := 0
+	for i, result := range response.Records {
+		if i >= len(records) || result.ErrorCode != nil {
+			continue
+		}
+		entry := auditEntry{
+			Time:           now,
+			Stream:         stream,
+			PartitionKey:   aws.StringValue(records[i].PartitionKey),
+			ShardID:        aws.StringValue(result.ShardId),
+			SequenceNumber: aws.StringValue(result.SequenceNumber),
+		}
+		select {
+		case a.entries <- entry:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		atomic.AddUint64(&a.dropped, uint64(dropped))
+	}
+	return dropped
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	for entry := range a.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		line = append(line, '\n')
+		if a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+			if err := a.rotate(); err != nil {
+				// the entries go on to the current file, and rotating is tried again once
+				// another maxBytes have been written
+				a.logger.Errorf("Failed to rotate audit log %s, writing to the current file: %v\n", a.path, err)
+				a.fail(err)
+				a.size = 0
+			}
+		}
+		n, err := a.writer.Write(line)
+		a.size += int64(n)
+		if err != nil {
+			a.fail(err)
+		}
+		// flush once the queue is drained, so entries reach the file without a write per entry
+		if len(a.entries) == 0 {
+			if err := a.writer.Flush(); err != nil {
+				a.fail(err)
+			}
+		}
+	}
+	if err := a.writer.Flush(); err != nil {
+		a.fail(err)
+	}
+	if err := a.file.Close(); err != nil {
+		a.fail(err)
+	}
+}
+
+func (a *auditLog) fail(err error) {
+	a.errOnce.Do(func() { a.err = err })
+}
+
+// open opens the file at path, returning it with its size
+func (a *auditLog) open(path string) (*os.File, int64, error) {
+	file, err := openAuditFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// rotate moves the current file to path.1, shifting older files up and removing the
+// oldest beyond maxFiles, then starts a new file. The new file is opened before anything
+// is moved, so if it fails to open the writer keeps the current file where it is.
+func (a *auditLog) rotate() error {
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	next := a.path + ".new"
+	file, size, err := a.open(next)
+	if err != nil {
+		return err
+	}
+	os.Remove(a.rotatedPath(a.maxFiles))
+	for i := a.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(a.rotatedPath(i), a.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			file.Close()
+			return err
+		}
+	}
+	if err := os.Rename(a.path, a.rotatedPath(1)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(next, a.path); err != nil {
+		file.Close()
+		return err
+	}
+	if err := a.file.Close(); err != nil {
+		a.fail(err)
+	}
+	a.file, a.writer, a.size = file, bufio.NewWriter(file), size
+	return nil
+}
+
+func (a *auditLog) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", a.path, n)
+}
+
+// close writes the queued entries and closes the file, returning the first error the writer
+// hit. Records sent once the log is closed, by flushes which outlived Close, are not logged.
+func (a *auditLog) close() error {
+	a.mutex.Lock()
+	a.closed = true
+	close(a.entries)
+	a.mutex.Unlock()
+	<-a.done
+	return a.err
+}
@@ -0,0 +1,149 @@
+package kinesis
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer file.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLogMatchesPutRecordsResponse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	response := &kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(1),
+		Records: []*kinesis.PutRecordsResultEntry{
+			{ShardId: aws.String("shardId-000000000001"), SequenceNumber: aws.String("100")},
+			{ErrorCode: aws.String(kinesis.ErrCodeInternalFailureException), ErrorMessage: aws.String("internal failure")},
+			{ShardId: aws.String("shardId-000000000002"), SequenceNumber: aws.String("200")},
+		},
+	}
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(response, nil)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	audit, err := newAuditLog(logrus.WithField("plugin_id", 0), path, 0, 0)
+	assert.NoError(t, err)
+	outputPlugin.audit = audit
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("a"), PartitionKey: aws.String("key-a")},
+		{Data: []byte("b"), PartitionKey: aws.String("key-b")},
+		{Data: []byte("c"), PartitionKey: aws.String("key-c")},
+	}
+	dataLength := 3
+	retCode, err := outputPlugin.sendCurrentBatch("stream", &records, &dataLength)
+	assert.NoError(t, err)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.NoError(t, audit.close())
+
+	entries := readAuditLog(t, path)
+	if assert.Len(t, entries, 2, "Expected an entry for each accepted record only") {
+		assert.Equal(t, "stream", entries[0].Stream)
+		assert.Equal(t, "key-a", entries[0].PartitionKey)
+		assert.Equal(t, "shardId-000000000001", entries[0].ShardID)
+		assert.Equal(t, "100", entries[0].SequenceNumber)
+		assert.False(t, entries[0].Time.IsZero())
+		assert.Equal(t, "key-c", entries[1].PartitionKey)
+		assert.Equal(t, "shardId-000000000002", entries[1].ShardID)
+		assert.Equal(t, "200", entries[1].SequenceNumber)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(logrus.WithField("plugin_id", 0), path, 1024, 2)
+	assert.NoError(t, err)
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		audit.record("stream", []*kinesis.PutRecordsRequestEntry{
+			{PartitionKey: aws.String(fmt.Sprintf("key-%03d", i))},
+		}, &kinesis.PutRecordsOutput{Records: []*kinesis.PutRecordsResultEntry{
+			{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String(fmt.Sprint(i))},
+		}})
+	}
+	assert.NoError(t, audit.close())
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "Expected only audit_log_max_files rotated files to be kept")
+
+	var entries []auditEntry
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		info, err := os.Stat(p)
+		if assert.NoError(t, err) {
+			assert.LessOrEqual(t, info.Size(), int64(1024))
+		}
+		entries = append(entries, readAuditLog(t, p)...)
+	}
+	// the newest entries are kept, in order
+	if assert.NotEmpty(t, entries) {
+		assert.Equal(t, fmt.Sprint(count-1), entries[len(entries)-1].SequenceNumber)
+		first := len(entries)
+		for i, entry := range entries {
+			assert.Equal(t, fmt.Sprint(count-first+i), entry.SequenceNumber)
+		}
+	}
+
+	// records sent after the log is closed are not logged
+	assert.Equal(t, 0, audit.record("stream", []*kinesis.PutRecordsRequestEntry{{PartitionKey: aws.String("late")}},
+		&kinesis.PutRecordsOutput{Records: []*kinesis.PutRecordsResultEntry{{SequenceNumber: aws.String("late")}}}))
+}
+
+func TestAuditLogRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(logrus.WithField("plugin_id", 0), path, 256, 2)
+	assert.NoError(t, err)
+
+	errOpen := errors.New("too many open files")
+	openAuditFile = func(string) (*os.File, error) { return nil, errOpen }
+	defer func() {
+		openAuditFile = func(path string) (*os.File, error) {
+			return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		}
+	}()
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		audit.record("stream", []*kinesis.PutRecordsRequestEntry{
+			{PartitionKey: aws.String(fmt.Sprintf("key-%03d", i))},
+		}, &kinesis.PutRecordsOutput{Records: []*kinesis.PutRecordsResultEntry{
+			{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String(fmt.Sprint(i))},
+		}})
+	}
+	assert.Equal(t, errOpen, audit.close(), "Expected the failed rotation to be reported")
+
+	// no entry is lost to a closed file, the writer kept the file it had
+	assert.Len(t, readAuditLog(t, path), count)
+	_, err = os.Stat(path + ".1")
+	assert.True(t, os.IsNotExist(err), "Expected the current file not to be moved")
+}
@@ -0,0 +1,628 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RecordFormat is the encoding records are sent in
+type RecordFormat string
+
+const (
+	// RecordFormatJSON sends each record as a JSON object
+	RecordFormatJSON RecordFormat = "json"
+	// RecordFormatAvro sends each record as Avro binary, in the single object encoding
+	RecordFormatAvro RecordFormat = "avro"
+)
+
+// AvroUnknownFields decides what happens to record fields which are not in the Avro schema
+type AvroUnknownFields string
+
+const (
+	// AvroUnknownFieldsDrop leaves fields which are not in the schema out of the record
+	AvroUnknownFieldsDrop AvroUnknownFields = "drop"
+	// AvroUnknownFieldsError rejects records with fields which are not in the schema
+	AvroUnknownFieldsError AvroUnknownFields = "error"
+)
+
+const (
+	avroEmptyFingerprint uint64 = 0xc15d213aa4d7a795
+)
+
+var (
+	// the marker which starts every single object encoded record
+	avroSingleObjectMagic = []byte{0xc3, 0x01}
+
+	avroFingerprintTable = func() [256]uint64 {
+		var table [256]uint64
+		for i := range table {
+			fp := uint64(i)
+			for j := 0; j < 8; j++ {
+				fp = (fp >> 1) ^ (avroEmptyFingerprint & -(fp & 1))
+			}
+			table[i] = fp
+		}
+		return table
+	}()
+)
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// avroSchema is a parsed Avro schema. kind is the name of a primitive type, or
+// record, enum, array, map, fixed or union.
+type avroSchema struct {
+	kind string
+	// the full name of records, enums and fixed types
+	name     string
+	fields   []*avroField
+	symbols  []string
+	items    *avroSchema
+	values   *avroSchema
+	size     int
+	branches []*avroSchema
+}
+
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+// avroCodec encodes records as Avro binary against a schema. Each record starts with
+// the single object encoding header: the marker 0xC3 0x01 and the little endian
+// CRC-64-AVRO fingerprint of the schema's Parsing Canonical Form, so consumers can
+// check which schema a record was written with.
+type avroCodec struct {
+	schema        *avroSchema
+	fingerprint   uint64
+	header        []byte
+	unknownFields AvroUnknownFields
+}
+
+func loadAvroCodec(path string, unknownFields AvroUnknownFields) (*avroCodec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newAvroCodec(data, unknownFields)
+}
+
+func newAvroCodec(schemaJSON []byte, unknownFields AvroUnknownFields) (*avroCodec, error) {
+	var raw interface{}
+	if err := json.Unmarshal(schemaJSON, &raw); err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	parser := &avroParser{named: make(map[string]*avroSchema)}
+	schema, err := parser.parse(raw, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	if schema.kind != "record" {
+		return nil, fmt.Errorf("invalid Avro schema: the top level type must be a record, not %s", schema.kind)
+	}
+
+	fingerprint := avroFingerprint([]byte(avroCanonicalForm(schema)))
+	header := make([]byte, len(avroSingleObjectMagic)+8)
+	copy(header, avroSingleObjectMagic)
+	binary.LittleEndian.PutUint64(header[len(avroSingleObjectMagic):], fingerprint)
+
+	return &avroCodec{
+		schema:        schema,
+		fingerprint:   fingerprint,
+		header:        header,
+		unknownFields: unknownFields,
+	}, nil
+}
+
+type avroParser struct {
+	// named types by full name, so later parts of the schema can refer to them
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch v := raw.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{kind: v}, nil
+		}
+		if named, ok := p.named[avroFullName(v, namespace)]; ok {
+			return named, nil
+		}
+		if named, ok := p.named[v]; ok {
+			return named, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", v)
+	case []interface{}:
+		union := &avroSchema{kind: "union"}
+		for _, branch := range v {
+			schema, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if schema.kind == "union" {
+				return nil, fmt.Errorf("unions may not immediately contain other unions")
+			}
+			union.branches = append(union.branches, schema)
+		}
+		if len(union.branches) == 0 {
+			return nil, fmt.Errorf("unions must have at least one type")
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	default:
+		return nil, fmt.Errorf("unexpected schema %v", raw)
+	}
+}
+
+func (p *avroParser) parseComplex(raw map[string]interface{}, namespace string) (*avroSchema, error) {
+	kind, _ := raw["type"].(string)
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		name, _ := raw["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s types must have a name", kind)
+		}
+		if ns, ok := raw["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		schema := &avroSchema{kind: kind, name: avroFullName(name, namespace)}
+		if kind == "error" {
+			schema.kind = "record"
+		}
+		if _, ok := p.named[schema.name]; ok {
+			return nil, fmt.Errorf("type %s is defined more than once", schema.name)
+		}
+		// registered before the fields are parsed, so records can refer to themselves
+		p.named[schema.name] = schema
+		if i := strings.LastIndex(schema.name, "."); i >= 0 {
+			namespace = schema.name[:i]
+		} else {
+			namespace = ""
+		}
+		return schema, p.parseNamed(schema, raw, namespace)
+	case "array":
+		items, err := p.parse(raw["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: kind, items: items}, nil
+	case "map":
+		values, err := p.parse(raw["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: kind, values: values}, nil
+	default:
+		if avroPrimitives[kind] {
+			// a primitive with attributes, such as a logical type, which are ignored
+			return &avroSchema{kind: kind}, nil
+		}
+		if raw["type"] != nil {
+			// the type of the schema is itself a schema
+			return p.parse(raw["type"], namespace)
+		}
+		return nil, fmt.Errorf("unknown type %v", raw["type"])
+	}
+}
+
+func (p *avroParser) parseNamed(schema *avroSchema, raw map[string]interface{}, namespace string) error {
+	switch schema.kind {
+	case "record":
+		fields, ok := raw["fields"].([]interface{})
+		if !ok {
+			return fmt.Errorf("record %s must have fields", schema.name)
+		}
+		for _, f := range fields {
+			rawField, ok := f.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("record %s has an invalid field %v", schema.name, f)
+			}
+			name, _ := rawField["name"].(string)
+			if name == "" {
+				return fmt.Errorf("record %s has a field without a name", schema.name)
+			}
+			fieldSchema, err := p.parse(rawField["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("field %s.%s: %v", schema.name, name, err)
+			}
+			def, hasDefault := rawField["default"]
+			schema.fields = append(schema.fields, &avroField{
+				name:       name,
+				schema:     fieldSchema,
+				def:        def,
+				hasDefault: hasDefault,
+			})
+		}
+	case "enum":
+		symbols, ok := raw["symbols"].([]interface{})
+		if !ok || len(symbols) == 0 {
+			return fmt.Errorf("enum %s must have symbols", schema.name)
+		}
+		for _, symbol := range symbols {
+			s, ok := symbol.(string)
+			if !ok {
+				return fmt.Errorf("enum %s has an invalid symbol %v", schema.name, symbol)
+			}
+			schema.symbols = append(schema.symbols, s)
+		}
+	case "fixed":
+		size, ok := raw["size"].(float64)
+		if !ok || size < 0 || size != math.Trunc(size) {
+			return fmt.Errorf("fixed %s must have a size", schema.name)
+		}
+		schema.size = int(size)
+	}
+	return nil
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// avroCanonicalForm returns the Parsing Canonical Form of the schema, which two
+// schemas share if they encode data the same way
+func avroCanonicalForm(schema *avroSchema) string {
+	var buf strings.Builder
+	writeAvroCanonical(&buf, schema, make(map[string]bool))
+	return buf.String()
+}
+
+func writeAvroCanonical(buf *strings.Builder, schema *avroSchema, written map[string]bool) {
+	quote := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+	switch schema.kind {
+	case "record", "enum", "fixed":
+		if written[schema.name] {
+			buf.WriteString(quote(schema.name))
+			return
+		}
+		written[schema.name] = true
+		buf.WriteString(`{"name":` + quote(schema.name) + `,"type":` + quote(schema.kind))
+		switch schema.kind {
+		case "record":
+			buf.WriteString(`,"fields":[`)
+			for i, field := range schema.fields {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(`{"name":` + quote(field.name) + `,"type":`)
+				writeAvroCanonical(buf, field.schema, written)
+				buf.WriteByte('}')
+			}
+			buf.WriteByte(']')
+		case "enum":
+			buf.WriteString(`,"symbols":[`)
+			for i, symbol := range schema.symbols {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(quote(symbol))
+			}
+			buf.WriteByte(']')
+		case "fixed":
+			buf.WriteString(`,"size":` + strconv.Itoa(schema.size))
+		}
+		buf.WriteByte('}')
+	case "array":
+		buf.WriteString(`{"type":"array","items":`)
+		writeAvroCanonical(buf, schema.items, written)
+		buf.WriteByte('}')
+	case "map":
+		buf.WriteString(`{"type":"map","values":`)
+		writeAvroCanonical(buf, schema.values, written)
+		buf.WriteByte('}')
+	case "union":
+		buf.WriteByte('[')
+		for i, branch := range schema.branches {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeAvroCanonical(buf, branch, written)
+		}
+		buf.WriteByte(']')
+	default:
+		buf.WriteString(quote(schema.kind))
+	}
+}
+
+// avroFingerprint returns the CRC-64-AVRO (Rabin) fingerprint of the data
+func avroFingerprint(data []byte) uint64 {
+	fp := avroEmptyFingerprint
+	for _, b := range data {
+		fp = (fp >> 8) ^ avroFingerprintTable[byte(fp)^b]
+	}
+	return fp
+}
+
+// encode returns the record as Avro binary, after the single object encoding header
+func (c *avroCodec) encode(record map[interface{}]interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	buf.Write(c.header)
+	if err := c.encodeValue(buf, c.schema, record, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *avroCodec) encodeValue(buf *bytes.Buffer, schema *avroSchema, value interface{}, path string) error {
+	switch schema.kind {
+	case "null":
+		if value != nil {
+			return avroTypeError(path, schema, value)
+		}
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case "int", "long":
+		n, ok := avroInteger(value)
+		if !ok || (schema.kind == "int" && (n < math.MinInt32 || n > math.MaxInt32)) {
+			return avroTypeError(path, schema, value)
+		}
+		writeAvroLong(buf, n)
+	case "float":
+		f, ok := avroFloat(value)
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+		buf.Write(b[:])
+	case "double":
+		f, ok := avroFloat(value)
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		buf.Write(b[:])
+	case "string", "bytes":
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			return avroTypeError(path, schema, value)
+		}
+		writeAvroLong(buf, int64(len(s)))
+		buf.WriteString(s)
+	case "fixed":
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			return avroTypeError(path, schema, value)
+		}
+		if len(s) != schema.size {
+			return fmt.Errorf("field %s has %d bytes, fixed %s must have %d", avroPath(path), len(s), schema.name, schema.size)
+		}
+		buf.WriteString(s)
+	case "enum":
+		s, _ := value.(string)
+		for i, symbol := range schema.symbols {
+			if s == symbol {
+				writeAvroLong(buf, int64(i))
+				return nil
+			}
+		}
+		return fmt.Errorf("field %s value %v is not a symbol of enum %s", avroPath(path), value, schema.name)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		if len(items) > 0 {
+			writeAvroLong(buf, int64(len(items)))
+			for i, item := range items {
+				if err := c.encodeValue(buf, schema.items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+		writeAvroLong(buf, 0)
+	case "map":
+		entries, ok := avroMapEntries(value)
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		if len(entries) > 0 {
+			keys := make([]string, 0, len(entries))
+			for key := range entries {
+				keys = append(keys, key)
+			}
+			// sorted, so the same record always has the same encoding
+			sort.Strings(keys)
+			writeAvroLong(buf, int64(len(keys)))
+			for _, key := range keys {
+				writeAvroLong(buf, int64(len(key)))
+				buf.WriteString(key)
+				if err := c.encodeValue(buf, schema.values, entries[key], avroJoin(path, key)); err != nil {
+					return err
+				}
+			}
+		}
+		writeAvroLong(buf, 0)
+	case "record":
+		entries, ok := avroMapEntries(value)
+		if !ok {
+			return avroTypeError(path, schema, value)
+		}
+		return c.encodeRecord(buf, schema, entries, path)
+	case "union":
+		// the value is written as the first branch which can encode it
+		var branchBuf bytes.Buffer
+		for i, branch := range schema.branches {
+			branchBuf.Reset()
+			if c.encodeValue(&branchBuf, branch, value, path) == nil {
+				writeAvroLong(buf, int64(i))
+				buf.Write(branchBuf.Bytes())
+				return nil
+			}
+		}
+		return avroTypeError(path, schema, value)
+	}
+	return nil
+}
+
+func (c *avroCodec) encodeRecord(buf *bytes.Buffer, schema *avroSchema, entries map[string]interface{}, path string) error {
+	if c.unknownFields == AvroUnknownFieldsError {
+		known := make(map[string]bool, len(schema.fields))
+		for _, field := range schema.fields {
+			known[field.name] = true
+		}
+		for key := range entries {
+			if !known[key] {
+				return fmt.Errorf("field %s is not in the Avro schema", avroJoin(path, key))
+			}
+		}
+	}
+
+	for _, field := range schema.fields {
+		fieldPath := avroJoin(path, field.name)
+		value, ok := entries[field.name]
+		if !ok {
+			if !field.hasDefault {
+				// optional fields may be left out of the record
+				if err := c.encodeValue(buf, field.schema, nil, fieldPath); err != nil {
+					return fmt.Errorf("field %s is missing and has no default", fieldPath)
+				}
+				continue
+			}
+			value = field.def
+			if field.schema.kind == "union" {
+				// defaults of unions are always of the first type
+				writeAvroLong(buf, 0)
+				if err := c.encodeValue(buf, field.schema.branches[0], value, fieldPath); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := c.encodeValue(buf, field.schema, value, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// avroMapEntries returns the entries of a decoded record or a schema default by their string keys
+func avroMapEntries(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		entries := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			entries[stringOrByteArray(key)] = value
+		}
+		return entries, true
+	case map[string]interface{}:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// avroInteger converts any integer, or a float without a fraction, to an int64
+func avroInteger(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	default:
+		return 0, false
+	}
+}
+
+func avroFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// writeAvroLong writes an int or long as a zig-zag encoded varint
+func writeAvroLong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func avroTypeError(path string, schema *avroSchema, value interface{}) error {
+	kind := schema.kind
+	if schema.name != "" {
+		kind += " " + schema.name
+	}
+	return fmt.Errorf("field %s of type %T can't be encoded as Avro %s", avroPath(path), value, kind)
+}
+
+func avroJoin(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func avroPath(path string) string {
+	if path == "" {
+		return "(record)"
+	}
+	return path
+}
@@ -0,0 +1,303 @@
+package kinesis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "Event",
+	"namespace": "com.example",
+	"doc": "stripped from the canonical form",
+	"fields": [
+		{"name": "log", "type": "string"},
+		{"name": "count", "type": "long"},
+		{"name": "code", "type": "int"},
+		{"name": "ratio", "type": "double"},
+		{"name": "weight", "type": "float"},
+		{"name": "ok", "type": "boolean"},
+		{"name": "user", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": []},
+		{"name": "labels", "type": {"type": "map", "values": "long"}, "default": {}},
+		{"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["DEBUG", "INFO", "ERROR"]}, "default": "INFO"},
+		{"name": "host", "type": {"type": "record", "name": "Host", "fields": [
+			{"name": "name", "type": "string"},
+			{"name": "id", "type": {"type": "fixed", "name": "HostID", "size": 4}}
+		]}},
+		{"name": "parent", "type": ["null", "Host"], "default": null}
+	]
+}`
+
+func TestAvroFingerprint(t *testing.T) {
+	// test vectors from the Avro specification's test suite
+	assert.Equal(t, int64(8247732601305521295), int64(avroFingerprint([]byte(`"int"`))))
+	assert.Equal(t, int64(7195948357588979594), int64(avroFingerprint([]byte(`"null"`))))
+	assert.Equal(t, int64(-6970731678124411036), int64(avroFingerprint([]byte(`"boolean"`))))
+}
+
+func TestAvroCanonicalForm(t *testing.T) {
+	codec, err := newAvroCodec([]byte(testAvroSchema), AvroUnknownFieldsDrop)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"com.example.Event","type":"record","fields":[`+
+		`{"name":"log","type":"string"},{"name":"count","type":"long"},{"name":"code","type":"int"},`+
+		`{"name":"ratio","type":"double"},{"name":"weight","type":"float"},{"name":"ok","type":"boolean"},`+
+		`{"name":"user","type":["null","string"]},{"name":"tags","type":{"type":"array","items":"string"}},`+
+		`{"name":"labels","type":{"type":"map","values":"long"}},`+
+		`{"name":"level","type":{"name":"com.example.Level","type":"enum","symbols":["DEBUG","INFO","ERROR"]}},`+
+		`{"name":"host","type":{"name":"com.example.Host","type":"record","fields":[{"name":"name","type":"string"},`+
+		`{"name":"id","type":{"name":"com.example.HostID","type":"fixed","size":4}}]}},`+
+		`{"name":"parent","type":["null","com.example.Host"]}]}`, avroCanonicalForm(codec.schema))
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	codec, err := newAvroCodec([]byte(testAvroSchema), AvroUnknownFieldsDrop)
+	assert.NoError(t, err)
+
+	// values as they are decoded from Fluent Bit's msgpack
+	data, err := codec.encode(map[interface{}]interface{}{
+		"log":    "hello",
+		"count":  int64(-42),
+		"code":   uint64(200),
+		"ratio":  0.25,
+		"weight": int64(3),
+		"ok":     true,
+		"user":   "alice",
+		"tags":   []interface{}{"a", "b"},
+		"labels": map[interface{}]interface{}{"x": int64(1), "y": uint64(2)},
+		"level":  "ERROR",
+		"host": map[interface{}]interface{}{
+			"name": "web-1",
+			"id":   "\x00\x01\x02\x03",
+		},
+		"extra": "not in the schema",
+	})
+	assert.NoError(t, err)
+
+	reader := bytes.NewReader(data)
+	fingerprint, err := readAvroHeader(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, codec.fingerprint, fingerprint)
+	decoded, err := decodeAvro(reader, codec.schema)
+	assert.NoError(t, err)
+	assert.Zero(t, reader.Len(), "Expected the whole record to be decoded")
+	assert.Equal(t, map[string]interface{}{
+		"log":    "hello",
+		"count":  int64(-42),
+		"code":   int64(200),
+		"ratio":  0.25,
+		"weight": float32(3),
+		"ok":     true,
+		"user":   "alice",
+		"tags":   []interface{}{"a", "b"},
+		"labels": map[string]interface{}{"x": int64(1), "y": int64(2)},
+		"level":  "ERROR",
+		"host":   map[string]interface{}{"name": "web-1", "id": "\x00\x01\x02\x03"},
+		"parent": nil,
+	}, decoded)
+}
+
+func TestAvroDefaultsAndErrors(t *testing.T) {
+	codec, err := newAvroCodec([]byte(testAvroSchema), AvroUnknownFieldsError)
+	assert.NoError(t, err)
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"log": "hello", "count": int64(1), "code": int64(2), "ratio": 1.5, "weight": 2.5, "ok": false,
+			"host": map[interface{}]interface{}{"name": "web-1", "id": "abcd"},
+		}
+	}
+
+	data, err := codec.encode(record())
+	assert.NoError(t, err)
+	reader := bytes.NewReader(data)
+	_, err = readAvroHeader(reader)
+	assert.NoError(t, err)
+	decoded, err := decodeAvro(reader, codec.schema)
+	assert.NoError(t, err)
+	assert.Equal(t, nil, decoded.(map[string]interface{})["user"])
+	assert.Equal(t, []interface{}{}, decoded.(map[string]interface{})["tags"])
+	assert.Equal(t, map[string]interface{}{}, decoded.(map[string]interface{})["labels"])
+	assert.Equal(t, "INFO", decoded.(map[string]interface{})["level"], "Expected the default to be used")
+
+	tests := []struct {
+		name   string
+		modify func(map[interface{}]interface{})
+		err    string
+	}{
+		{"unknown field", func(r map[interface{}]interface{}) { r["extra"] = "x" }, "field extra is not in the Avro schema"},
+		{"missing field", func(r map[interface{}]interface{}) { delete(r, "log") }, "field log is missing and has no default"},
+		{"wrong type", func(r map[interface{}]interface{}) { r["count"] = "many" }, "field count of type string can't be encoded as Avro long"},
+		{"int overflow", func(r map[interface{}]interface{}) { r["code"] = int64(math.MaxInt32 + 1) }, "field code of type int64 can't be encoded as Avro int"},
+		{"enum symbol", func(r map[interface{}]interface{}) { r["level"] = "TRACE" }, "field level value TRACE is not a symbol of enum com.example.Level"},
+		{"fixed size", func(r map[interface{}]interface{}) {
+			r["host"] = map[interface{}]interface{}{"name": "web-1", "id": "abc"}
+		}, "field host.id has 3 bytes, fixed com.example.HostID must have 4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := record()
+			test.modify(r)
+			_, err := codec.encode(r)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestNewAvroCodecInvalidSchema(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`"string"`,
+		`{"type": "record", "name": "A", "fields": [{"name": "b", "type": "Missing"}]}`,
+		`{"type": "record", "fields": []}`,
+		`{"type": "record", "name": "A", "fields": [{"name": "b", "type": [["null"]]}]}`,
+	} {
+		_, err := newAvroCodec([]byte(schema), AvroUnknownFieldsDrop)
+		assert.Error(t, err, "Expected schema %s to be rejected", schema)
+	}
+}
+
+func TestAddRecordAvro(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.avsc")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+		"type": "record", "name": "Log", "fields": [
+			{"name": "log", "type": "string"},
+			{"name": "level", "type": ["null", "string"], "default": null}
+		]
+	}`), 0644))
+	codec, err := loadAvroCodec(path, AvroUnknownFieldsDrop)
+	assert.NoError(t, err)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.avro = codec
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log":    []byte("hello"),
+		"stream": []byte("stdout"),
+	}, &timeStamp)
+
+	if assert.Len(t, records, 1) {
+		reader := bytes.NewReader(records[0].Data)
+		fingerprint, err := readAvroHeader(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, codec.fingerprint, fingerprint)
+		decoded, err := decodeAvro(reader, codec.schema)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"log": "hello", "level": nil}, decoded)
+	}
+}
+
+// readAvroHeader reads the single object encoding header and returns the schema fingerprint
+func readAvroHeader(reader *bytes.Reader) (uint64, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[:2], avroSingleObjectMagic) {
+		return 0, fmt.Errorf("missing single object marker")
+	}
+	return binary.LittleEndian.Uint64(header[2:]), nil
+}
+
+// decodeAvro decodes a value written with the schema
+func decodeAvro(reader *bytes.Reader, schema *avroSchema) (interface{}, error) {
+	readString := func() (string, error) {
+		n, err := binary.ReadVarint(reader)
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(reader, b)
+		return string(b), err
+	}
+
+	switch schema.kind {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := reader.ReadByte()
+		return b == 1, err
+	case "int", "long":
+		return binary.ReadVarint(reader)
+	case "float":
+		var b [4]byte
+		_, err := io.ReadFull(reader, b[:])
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[:])), err
+	case "double":
+		var b [8]byte
+		_, err := io.ReadFull(reader, b[:])
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), err
+	case "string", "bytes":
+		return readString()
+	case "fixed":
+		b := make([]byte, schema.size)
+		_, err := io.ReadFull(reader, b)
+		return string(b), err
+	case "enum":
+		i, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		return schema.symbols[i], nil
+	case "array":
+		items := []interface{}{}
+		for {
+			n, err := binary.ReadVarint(reader)
+			if err != nil || n == 0 {
+				return items, err
+			}
+			for ; n > 0; n-- {
+				item, err := decodeAvro(reader, schema.items)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+		}
+	case "map":
+		entries := map[string]interface{}{}
+		for {
+			n, err := binary.ReadVarint(reader)
+			if err != nil || n == 0 {
+				return entries, err
+			}
+			for ; n > 0; n-- {
+				key, err := readString()
+				if err != nil {
+					return nil, err
+				}
+				if entries[key], err = decodeAvro(reader, schema.values); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case "record":
+		record := map[string]interface{}{}
+		for _, field := range schema.fields {
+			value, err := decodeAvro(reader, field.schema)
+			if err != nil {
+				return nil, err
+			}
+			record[field.name] = value
+		}
+		return record, nil
+	case "union":
+		i, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decodeAvro(reader, schema.branches[i])
+	}
+	return nil, fmt.Errorf("unknown type %s", schema.kind)
+}
@@ -0,0 +1,105 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// the key trailer records carry their stats under, which tells them apart from data records
+	batchTrailerKey = "batch_trailer"
+	// the room left in each batch for the trailer, more than its data and partition key take
+	batchTrailerReserve = 256
+)
+
+// batchTrailer builds the trailer record appended to each PutRecords batch for the main
+// stream, which summarizes the data records of the batch
+type batchTrailer struct {
+	// batch ids are the prefix, which is random for each plugin instance, and a counter
+	prefix string
+	// next is a pointer so it stays 64 bit aligned for atomic access
+	next *uint64
+}
+
+type batchTrailerStats struct {
+	BatchID string `json:"batch_id"`
+	Records int    `json:"records"`
+	Bytes   int    `json:"bytes"`
+}
+
+func newBatchTrailer(prefix string) *batchTrailer {
+	return &batchTrailer{prefix: prefix, next: new(uint64)}
+}
+
+// entry returns the trailer of a batch of records. Its partition key is the batch id, which
+// is different for every batch, so trailers are spread over the shards.
+func (t *batchTrailer) entry(records []*kinesis.PutRecordsRequestEntry) (*kinesis.PutRecordsRequestEntry, error) {
+	stats := batchTrailerStats{
+		BatchID: fmt.Sprintf("%s-%d", t.prefix, atomic.AddUint64(t.next, 1)),
+		Records: len(records),
+	}
+	for _, record := range records {
+		stats.Bytes += len(record.Data) + len(aws.StringValue(record.PartitionKey))
+	}
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(map[string]batchTrailerStats{batchTrailerKey: stats})
+	if err != nil {
+		return nil, err
+	}
+	return &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(stats.BatchID),
+	}, nil
+}
+
+// withTrailer returns the records of a batch followed by its trailer, leaving records as they are
+func (outputPlugin *OutputPlugin) withTrailer(records []*kinesis.PutRecordsRequestEntry) []*kinesis.PutRecordsRequestEntry {
+	trailer, err := outputPlugin.batchTrailer.entry(records)
+	if err != nil {
+		outputPlugin.sampledErrorf("Failed to marshal the batch trailer, sending the batch without it: %v\n", err)
+		return records
+	}
+	batch := make([]*kinesis.PutRecordsRequestEntry, 0, len(records)+1)
+	batch = append(batch, records...)
+	return append(batch, trailer)
+}
+
+// stripTrailer removes the result of the trailer from a response, so the records of the
+// batch are retried as they would be without it. A trailer which failed is not retried.
+func (outputPlugin *OutputPlugin) stripTrailer(response *kinesis.PutRecordsOutput, records int) *kinesis.PutRecordsOutput {
+	if response == nil || len(response.Records) != records+1 {
+		return response
+	}
+	result := response.Records[records]
+	if result.ErrorCode != nil {
+		outputPlugin.sampledWarnf("The batch trailer failed to be delivered with %s, it is not retried\n", aws.StringValue(result.ErrorCode))
+		response.FailedRecordCount = aws.Int64(aws.Int64Value(response.FailedRecordCount) - 1)
+	}
+	response.Records = response.Records[:records]
+	return response
+}
+
+// batchRecordsLimit returns the most data records a batch holds, leaving room for the trailer
+func (outputPlugin *OutputPlugin) batchRecordsLimit() int {
+	if outputPlugin.batchTrailer != nil {
+		return maximumRecordsPerPut - 1
+	}
+	return maximumRecordsPerPut
+}
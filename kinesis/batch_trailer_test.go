@@ -0,0 +1,139 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitTrailer returns the data records of a request and the stats of its trailer, failing
+// the test unless the request holds exactly one trailer, as its last record
+func splitTrailer(t *testing.T, records []*kinesis.PutRecordsRequestEntry) ([]*kinesis.PutRecordsRequestEntry, batchTrailerStats) {
+	var trailers int
+	for _, record := range records {
+		if strings.Contains(string(record.Data), batchTrailerKey) {
+			trailers++
+		}
+	}
+	require.Equal(t, 1, trailers, "Expected exactly one trailer per batch")
+	last := records[len(records)-1]
+	var trailer map[string]batchTrailerStats
+	require.NoError(t, json.Unmarshal(last.Data, &trailer))
+	stats, ok := trailer[batchTrailerKey]
+	require.True(t, ok, "Expected the trailer to be the last record")
+	assert.Equal(t, stats.BatchID, aws.StringValue(last.PartitionKey))
+	return records[:len(records)-1], stats
+}
+
+func TestBatchTrailer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	batchIDs := make(map[string]bool)
+	var sent int
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		assert.LessOrEqual(t, len(input.Records), maximumRecordsPerPut, "Expected the trailer to count toward the record limit")
+		data, stats := splitTrailer(t, input.Records)
+		bytes := 0
+		for _, record := range data {
+			bytes += len(record.Data) + len(aws.StringValue(record.PartitionKey))
+		}
+		assert.Equal(t, len(data), stats.Records)
+		assert.Equal(t, bytes, stats.Bytes)
+		assert.False(t, batchIDs[stats.BatchID], "Expected batch ids to be unique")
+		batchIDs[stats.BatchID] = true
+		sent += len(data)
+		results := make([]*kinesis.PutRecordsResultEntry, 0, len(input.Records))
+		for range input.Records {
+			results = append(results, &kinesis.PutRecordsResultEntry{})
+		}
+		return &kinesis.PutRecordsOutput{
+			FailedRecordCount: aws.Int64(0),
+			Records:           results,
+		}, nil
+	}).Times(3)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.batchTrailer = newBatchTrailer("test")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		records = append(records, &kinesis.PutRecordsRequestEntry{
+			Data:         []byte(fmt.Sprintf("record %d", i)),
+			PartitionKey: aws.String("key"),
+		})
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Equal(t, 1000, sent)
+	assert.Len(t, batchIDs, 3)
+}
+
+func TestBatchTrailerPartialFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	throttled := &kinesis.PutRecordsResultEntry{
+		ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+		ErrorMessage: aws.String("Rate exceeded"),
+	}
+	gomock.InOrder(
+		// the second record and the trailer are throttled
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			_, stats := splitTrailer(t, input.Records)
+			assert.Equal(t, 2, stats.Records)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(2),
+				Records:           []*kinesis.PutRecordsResultEntry{{}, throttled, throttled},
+			}, nil
+		}),
+		// the retry only resends the data record, with a trailer of its own
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			data, stats := splitTrailer(t, input.Records)
+			require.Len(t, data, 1)
+			assert.Equal(t, "1", string(data[0].Data))
+			assert.Equal(t, 1, stats.Records)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+				Records:           []*kinesis.PutRecordsResultEntry{{}, {}},
+			}, nil
+		}),
+	)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.batchTrailer = newBatchTrailer("test")
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("0"), PartitionKey: aws.String("key")},
+		{Data: []byte("1"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+	require.Len(t, records, 1, "Expected the failed trailer not to be retried")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+}
+
+func TestBatchTrailerOnlyForStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		assert.Len(t, input.Records, 1, "Expected no trailer for other streams")
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	})
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.batchTrailer = newBatchTrailer("test")
+	records := []*kinesis.PutRecordsRequestEntry{{Data: []byte("0"), PartitionKey: aws.String("key")}}
+	retCode, _ := outputPlugin.flushStream("dlq", &records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+}
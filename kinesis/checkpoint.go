@@ -0,0 +1,238 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+const checkpointFileSuffix = ".checkpoint"
+
+var (
+	// the checkpoint directories opened in this process, since plugin instances sharing
+	// one would replay and remove each other's checkpoints
+	checkpointDirsMutex sync.Mutex
+	checkpointDirs      = make(map[string]bool)
+)
+
+// checkpoints persists the records of each flush in progress until they have been sent,
+// in the format of the spill queue. Checkpoints left behind by a crash are replayed
+// when the plugin starts, and before any later flush, so records are sent at least once.
+type checkpoints struct {
+	dir   string
+	mutex sync.Mutex
+	// sequence number of the next checkpoint, 0 is never used
+	nextSeq uint64
+	// checkpoints left over from a previous run, oldest first
+	replay []uint64
+	// serializes replays, so each checkpoint is replayed once
+	replayMutex sync.Mutex
+}
+
+// newCheckpoints opens the checkpoints in dir, picking up those left over from a previous
+// run. It returns an error if another plugin instance has dir open.
+func newCheckpoints(dir string) (*checkpoints, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := claimCheckpointDir(dir); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		releaseCheckpointDir(dir)
+		return nil, err
+	}
+
+	c := &checkpoints{
+		dir:     dir,
+		nextSeq: 1,
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, spillTmpFileSuffix) {
+			// left behind by a crash while writing, the flush had not started yet
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, checkpointFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, checkpointFileSuffix), 10, 64)
+		if err != nil || seq == 0 {
+			continue
+		}
+		c.replay = append(c.replay, seq)
+	}
+	sort.Slice(c.replay, func(i, j int) bool { return c.replay[i] < c.replay[j] })
+	if len(c.replay) > 0 {
+		c.nextSeq = c.replay[len(c.replay)-1] + 1
+	}
+	return c, nil
+}
+
+func claimCheckpointDir(dir string) error {
+	checkpointDirsMutex.Lock()
+	defer checkpointDirsMutex.Unlock()
+	if checkpointDirs[dir] {
+		return fmt.Errorf("%s is already used by another output, each output needs its own checkpoint_dir", dir)
+	}
+	checkpointDirs[dir] = true
+	return nil
+}
+
+func releaseCheckpointDir(dir string) {
+	checkpointDirsMutex.Lock()
+	defer checkpointDirsMutex.Unlock()
+	delete(checkpointDirs, dir)
+}
+
+// close releases the directory, so another plugin instance may open it. The checkpoints
+// left in it are kept for that instance to replay.
+func (c *checkpoints) close() {
+	releaseCheckpointDir(c.dir)
+}
+
+func (c *checkpoints) path(seq uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d%s", seq, checkpointFileSuffix))
+}
+
+// save persists the records of a flush, returning the sequence number of the checkpoint
+func (c *checkpoints) save(records []*kinesis.PutRecordsRequestEntry) (uint64, error) {
+	c.mutex.Lock()
+	seq := c.nextSeq
+	c.nextSeq++
+	c.mutex.Unlock()
+
+	if _, err := writeRecordsFile(c.dir, c.path(seq), records); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// update persists the records of a flush which remain unsent, removing the checkpoint once none are left
+func (c *checkpoints) update(seq uint64, records []*kinesis.PutRecordsRequestEntry) error {
+	if len(records) == 0 {
+		return c.remove(seq)
+	}
+	_, err := writeRecordsFile(c.dir, c.path(seq), records)
+	return err
+}
+
+// remove deletes a checkpoint once its records have been sent, or handed over
+func (c *checkpoints) remove(seq uint64) error {
+	if err := os.Remove(c.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// pending returns whether checkpoints from a previous run are left to be replayed
+func (c *checkpoints) pending() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.replay) > 0
+}
+
+// ReplayCheckpoints sends the records of the checkpoints a previous run left behind,
+// oldest first. It returns FLB_RETRY if some could not be sent yet, in which case
+// the records they still hold are replayed again before the next flush.
+func (outputPlugin *OutputPlugin) ReplayCheckpoints() int {
+	c := outputPlugin.checkpoints
+	if c == nil || !c.pending() {
+		return output.FLB_OK
+	}
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+
+	for c.pending() {
+		c.mutex.Lock()
+		seq := c.replay[0]
+		c.mutex.Unlock()
+
+		records, err := readSpillFile(c.path(seq))
+		if err != nil {
+			outputPlugin.logger.Errorf("Failed to read checkpoint %d, dropping it: %v\n", seq, err)
+		} else {
+			count := len(records)
+			retCode, _ := outputPlugin.flush(&records)
+			switch retCode {
+			case output.FLB_OK:
+				outputPlugin.logger.Infof("Replayed %d records left unsent by the previous run from checkpoint %d\n", count, seq)
+			case output.FLB_ERROR:
+				outputPlugin.logger.Errorf("Failed to replay (%d) records with error, dropping checkpoint %d\n", count, seq)
+			default:
+				if err := c.update(seq, records); err != nil {
+					outputPlugin.logger.Errorf("Failed to update checkpoint %d: %v\n", seq, err)
+				}
+				outputPlugin.flushInfof("flush returning retry, %d records of checkpoint %d are left to be replayed\n", len(records), seq)
+				return output.FLB_RETRY
+			}
+		}
+		if err := c.remove(seq); err != nil {
+			outputPlugin.logger.Errorf("Failed to remove checkpoint %d: %v\n", seq, err)
+		}
+		c.mutex.Lock()
+		c.replay = c.replay[1:]
+		c.mutex.Unlock()
+	}
+	return output.FLB_OK
+}
+
+// saveCheckpoint persists the records of a flush about to start. It returns 0 without
+// checkpoint_dir, and FLB_RETRY if the records could not be persisted.
+func (outputPlugin *OutputPlugin) saveCheckpoint(records []*kinesis.PutRecordsRequestEntry) (uint64, int) {
+	if outputPlugin.checkpoints == nil || len(records) == 0 {
+		return 0, output.FLB_OK
+	}
+	seq, err := outputPlugin.checkpoints.save(records)
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to save checkpoint, returning retry: %v\n", err)
+		return 0, output.FLB_RETRY
+	}
+	return seq, output.FLB_OK
+}
+
+// updateCheckpoint persists the records of a flush which remain unsent
+func (outputPlugin *OutputPlugin) updateCheckpoint(seq uint64, records []*kinesis.PutRecordsRequestEntry) {
+	if seq == 0 {
+		return
+	}
+	if err := outputPlugin.checkpoints.update(seq, records); err != nil {
+		outputPlugin.logger.Errorf("Failed to update checkpoint %d: %v\n", seq, err)
+	}
+}
+
+// removeCheckpoint deletes the checkpoint of a flush once it is done with its records
+func (outputPlugin *OutputPlugin) removeCheckpoint(seq uint64) {
+	if seq == 0 {
+		return
+	}
+	if err := outputPlugin.checkpoints.remove(seq); err != nil {
+		outputPlugin.logger.Errorf("Failed to remove checkpoint %d: %v\n", seq, err)
+	}
+}
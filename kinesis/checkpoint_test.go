@@ -0,0 +1,179 @@
+package kinesis
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func sentData(input *kinesis.PutRecordsInput) []string {
+	var data []string
+	for _, record := range input.Records {
+		data = append(data, string(record.Data))
+	}
+	return data
+}
+
+func TestCheckpointReplayedAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the first run crashes while PutRecords is in flight
+	crashingKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	crashingKinesis.EXPECT().PutRecords(gomock.Any()).Do(func(input *kinesis.PutRecordsInput) {
+		runtime.Goexit()
+	})
+	crashed, _ := newMockOutputPlugin(crashingKinesis, false)
+	crashed.checkpoints, _ = newCheckpoints(dir)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		records := newTestEntries("first", "second")
+		crashed.Flush(&records)
+	}()
+	<-done
+	// the directory is no longer in use once the process has crashed
+	crashed.checkpoints.close()
+
+	// the next run replays the records before it sends new ones
+	var sent [][]string
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		sent = append(sent, sentData(input))
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	}).Times(2)
+	restarted, _ := newMockOutputPlugin(mockKinesis, false)
+	var err error
+	restarted.checkpoints, err = newCheckpoints(dir)
+	assert.NoError(t, err)
+	assert.True(t, restarted.checkpoints.pending())
+
+	assert.Equal(t, fluentbit.FLB_OK, restarted.ReplayCheckpoints())
+	records := newTestEntries("third")
+	assert.Equal(t, fluentbit.FLB_OK, restarted.Flush(&records))
+	assert.Equal(t, [][]string{{"first", "second"}, {"third"}}, sent)
+
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries, "Expected no checkpoint once every record was sent")
+}
+
+func TestCheckpointReplayBlocksNewRecords(t *testing.T) {
+	dir := t.TempDir()
+	previous, _ := newCheckpoints(dir)
+	_, err := previous.save(newTestEntries("unsent"))
+	assert.NoError(t, err)
+	previous.close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var sent [][]string
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			sent = append(sent, sentData(input))
+			return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+		}).Times(2),
+	)
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.checkpoints, _ = newCheckpoints(dir)
+
+	records := newTestEntries("new")
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected new records to be retried until the checkpoint is replayed")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Equal(t, [][]string{{"unsent"}, {"new"}}, sent)
+}
+
+func TestCheckpointKeepsUnsentRecordsBetweenRetries(t *testing.T) {
+	dir := t.TempDir()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.checkpoints, _ = newCheckpoints(dir)
+
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+			FailedRecordCount: aws.Int64(1),
+			Records: []*kinesis.PutRecordsResultEntry{
+				{SequenceNumber: aws.String("1")},
+				{ErrorCode: aws.String(kinesis.ErrCodeProvisionedThroughputExceededException), ErrorMessage: aws.String("Rate exceeded")},
+			},
+		}, nil),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			// the checkpoint only holds the record left unsent by the first attempt
+			entries, _ := os.ReadDir(dir)
+			assert.Len(t, entries, 1)
+			records, err := readSpillFile(filepath.Join(dir, entries[0].Name()))
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"second"}, sentData(&kinesis.PutRecordsInput{Records: records}))
+			return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+		}),
+	)
+	outputPlugin.client = mockKinesis
+	retrySleep = func(time.Duration) {}
+	defer func() { retrySleep = time.Sleep }()
+
+	records := newTestEntries("first", "second")
+	seq, retCode := outputPlugin.saveCheckpoint(records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	outputPlugin.flushWithRetries(len(records), records, seq)
+
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries, "Expected the checkpoint to be removed once the flush is done")
+}
+
+func TestCheckpointDirSharedByInstances(t *testing.T) {
+	dir := t.TempDir()
+	config := &OutputPluginConfig{
+		Region:                    "us-east-1",
+		Stream:                    "stream",
+		PartitionKeyHistogramTopN: 5,
+		CheckpointDir:             dir,
+	}
+	first, err := NewOutputPlugin(config)
+	assert.NoError(t, err)
+
+	// a second instance would replay and remove the checkpoints of the first
+	second := *config
+	second.PluginID = 1
+	second.CheckpointDir = filepath.Join(dir, ".")
+	_, err = NewOutputPlugin(&second)
+	assert.Error(t, err, "Expected a checkpoint_dir in use by another instance to be rejected")
+
+	// the directory can be opened again once the first instance is closed, as on a reload
+	assert.NoError(t, first.Close())
+	restarted, err := NewOutputPlugin(&second)
+	assert.NoError(t, err, "Expected the directory to be released once the instance using it is closed")
+	assert.NoError(t, restarted.Close())
+}
+
+func TestCheckpointDirReleasedOnFailedStart(t *testing.T) {
+	dir := t.TempDir()
+	config := &OutputPluginConfig{
+		Region:                    "us-east-1",
+		Stream:                    "stream",
+		PartitionKeyHistogramTopN: 5,
+		CheckpointDir:             dir,
+		// fails to start after the checkpoints are opened
+		StatusFile: filepath.Join(dir, "missing", "status.json"),
+	}
+	_, err := NewOutputPlugin(config)
+	assert.Error(t, err)
+
+	config.StatusFile = ""
+	outputPlugin, err := NewOutputPlugin(config)
+	assert.NoError(t, err, "Expected the checkpoint_dir of an instance which failed to start to be released")
+	assert.NoError(t, outputPlugin.Close())
+}
@@ -0,0 +1,46 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+)
+
+// ChecksumAlgo is the algorithm of the checksum added under checksum_key
+type ChecksumAlgo string
+
+const (
+	// ChecksumCRC32 is the CRC-32 (IEEE) of the record, as 8 hex digits
+	ChecksumCRC32 ChecksumAlgo = "crc32"
+	// ChecksumSHA256 is the SHA-256 of the record, as 64 hex digits
+	ChecksumSHA256 ChecksumAlgo = "sha256"
+)
+
+// checksum returns the lowercase hex encoded checksum of data
+func checksum(algo ChecksumAlgo, data []byte) string {
+	if algo == ChecksumSHA256 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// injectChecksum adds the checksum of a record marshaled to JSON as its last field, so
+// consumers can verify it by removing the field again and hashing what is left
+func injectChecksum(data []byte, key string, algo ChecksumAlgo) ([]byte, error) {
+	return injectField(data, key, []byte(`"`+checksum(algo, data)+`"`))
+}
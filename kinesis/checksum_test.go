@@ -0,0 +1,70 @@
+package kinesis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyChecksum checks a record the way a consumer would, by removing the checksum
+// field from the end of the record and hashing the bytes which are left
+func verifyChecksum(t *testing.T, data []byte, key string, sum func([]byte) string) {
+	var fields map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(data, &fields)) {
+		return
+	}
+	value, _ := fields[key].(string)
+	field := fmt.Sprintf(`"%s":"%s"}`, key, value)
+	if !assert.True(t, strings.HasSuffix(string(data), field), "Expected the checksum to be the last field of %s", data) {
+		return
+	}
+	covered := strings.TrimSuffix(strings.TrimSuffix(string(data), field), ",") + "}"
+	assert.Equal(t, sum([]byte(covered)), value)
+}
+
+func TestChecksumKey(t *testing.T) {
+	sums := map[ChecksumAlgo]func([]byte) string{
+		ChecksumCRC32: func(data []byte) string {
+			return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+		},
+		ChecksumSHA256: func(data []byte) string {
+			sum := sha256.Sum256(data)
+			return hex.EncodeToString(sum[:])
+		},
+	}
+	for algo, sum := range sums {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.checksumKey = "checksum"
+		outputPlugin.checksumAlgo = algo
+
+		data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+			"log":   []byte("request served"),
+			"level": []byte("info"),
+		}, 0)
+		if assert.NoError(t, err, algo) {
+			verifyChecksum(t, data, "checksum", sum)
+		}
+
+		// the size field is added first, so the checksum covers it too
+		outputPlugin.sizeKey = "size"
+		data, err = outputPlugin.processRecord(map[interface{}]interface{}{
+			"log": []byte("request served"),
+		}, 0)
+		if assert.NoError(t, err, algo) {
+			assert.Contains(t, string(data), `"size":`)
+			verifyChecksum(t, data, "checksum", sum)
+		}
+
+		outputPlugin.sizeKey = ""
+		data, err = outputPlugin.processRecord(map[interface{}]interface{}{}, 0)
+		if assert.NoError(t, err, algo) {
+			assert.Equal(t, fmt.Sprintf(`{"checksum":"%s"}`, sum([]byte("{}"))), string(data))
+		}
+	}
+}
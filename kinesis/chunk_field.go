@@ -0,0 +1,109 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"unicode/utf8"
+)
+
+const (
+	// DefaultChunkSize is the most bytes of chunk_field each record carries if chunk_size is not set
+	DefaultChunkSize = 512 * 1024
+	// the key chunked records carry their reassembly metadata under
+	chunkMetadataKey = "_chunk"
+	// the length of the random id shared by the chunks of a record
+	chunkIDLength = 16
+)
+
+// splitChunks splits a record whose chunk_field value is longer than chunk_size into a
+// record for each chunk of the value. Each chunk record is a copy of the record with
+// the chunk as the field value, and the reassembly metadata under _chunk: the id shared
+// by every chunk of the record, the index of the chunk from 0, and the total number of
+// chunks. It returns nil if the record doesn't need to be split.
+func (outputPlugin *OutputPlugin) splitChunks(record map[interface{}]interface{}) []map[interface{}]interface{} {
+	var fieldKey interface{}
+	var value []byte
+	for k, v := range record {
+		if stringOrByteArray(k) != outputPlugin.chunkField {
+			continue
+		}
+		switch t := v.(type) {
+		case []byte:
+			fieldKey, value = k, t
+		case string:
+			fieldKey, value = k, []byte(t)
+		}
+		break
+	}
+	if len(value) <= outputPlugin.chunkSize {
+		return nil
+	}
+
+	var parts [][]byte
+	for len(value) > 0 {
+		end := outputPlugin.chunkSize
+		if end >= len(value) {
+			end = len(value)
+		} else if utf8.Valid(value) {
+			// split on a character boundary, so each chunk stays valid UTF-8
+			for end > 0 && !utf8.RuneStart(value[end]) {
+				end--
+			}
+			if end == 0 {
+				end = outputPlugin.chunkSize
+			}
+		}
+		parts = append(parts, value[:end])
+		value = value[end:]
+	}
+
+	id := outputPlugin.chunkIDs.RandomString()
+	chunks := make([]map[interface{}]interface{}, 0, len(parts))
+	for i, part := range parts {
+		chunk := deepCopyMap(record)
+		chunk[fieldKey] = part
+		chunk[chunkMetadataKey] = map[interface{}]interface{}{
+			"id":    id,
+			"index": i,
+			"total": len(parts),
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// deepCopyMap copies the record along with its nested maps and arrays, so the chunks
+// of a record can be processed independently
+func deepCopyMap(record map[interface{}]interface{}) map[interface{}]interface{} {
+	copied := make(map[interface{}]interface{}, len(record)+1)
+	for k, v := range record {
+		copied[k] = deepCopyValue(v)
+	}
+	return copied
+}
+
+func deepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return deepCopyMap(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopyValue(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,69 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/util"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkFieldSplitsLargeValue(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.chunkField = "stack"
+	outputPlugin.chunkSize = 10
+	outputPlugin.chunkIDs = util.NewRandomStringGenerator(chunkIDLength)
+
+	// 25 bytes with multi-byte characters around the chunk boundaries
+	stack := "abcdefghé" + "jklmnopqrs" + "tuvwx"
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log":   []byte("error"),
+		"stack": []byte(stack),
+	}, &timeStamp)
+	assert.Equal(t, output.FLB_OK, retCode)
+	assert.Len(t, records, 3)
+
+	var id string
+	var reassembled strings.Builder
+	for i, record := range records {
+		var decoded map[string]interface{}
+		assert.NoError(t, json.Unmarshal(record.Data, &decoded))
+		assert.Equal(t, "error", decoded["log"], "Expected each chunk to keep the other fields")
+		meta, ok := decoded[chunkMetadataKey].(map[string]interface{})
+		if !assert.True(t, ok, "Expected reassembly metadata on each chunk") {
+			continue
+		}
+		if i == 0 {
+			id, _ = meta["id"].(string)
+			assert.Len(t, id, chunkIDLength)
+		}
+		assert.Equal(t, id, meta["id"], "Expected every chunk to share the record id")
+		assert.Equal(t, float64(i), meta["index"])
+		assert.Equal(t, float64(3), meta["total"])
+		chunk := decoded["stack"].(string)
+		assert.LessOrEqual(t, len(chunk), outputPlugin.chunkSize)
+		reassembled.WriteString(chunk)
+	}
+	assert.Equal(t, stack, reassembled.String())
+}
+
+func TestChunkFieldLeavesSmallValue(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.chunkField = "stack"
+	outputPlugin.chunkSize = 10
+	outputPlugin.chunkIDs = util.NewRandomStringGenerator(chunkIDLength)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"stack": []byte("short"),
+	}, &timeStamp)
+	assert.Len(t, records, 1)
+	assert.NotContains(t, string(records[0].Data), chunkMetadataKey)
+}
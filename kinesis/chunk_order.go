@@ -0,0 +1,37 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// errChunkOrder stops a flush with preserve_chunk_order once records of a batch failed,
+// so the records after them are not sent first
+var errChunkOrder = errors.New("records failed to be delivered, holding back the rest of the chunk to preserve its order")
+
+// dispatchChunk queues a whole chunk on the worker of its first record, so its batches
+// are sent in order by one worker. It returns false if that worker's queue is full.
+// dispatchChunk must not be called concurrently.
+func (w *flushWorkers) dispatchChunk(records []*kinesis.PutRecordsRequestEntry) bool {
+	worker := w.route(aws.StringValue(records[0].PartitionKey))
+	if len(w.queues[worker]) == cap(w.queues[worker]) {
+		return false
+	}
+	w.queues[worker] <- records
+	return true
+}
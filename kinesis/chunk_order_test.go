@@ -0,0 +1,90 @@
+package kinesis
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreserveChunkOrderWithWorkers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var mutex sync.Mutex
+	var sent []string
+	var done sync.WaitGroup
+	const count = 1200
+	done.Add(count)
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, record := range input.Records {
+			sent = append(sent, string(record.Data))
+			done.Done()
+		}
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.preserveChunkOrder = true
+	hash, _ := newKeyHashFunc(WorkerHashFNV)
+	outputPlugin.workers = newFlushWorkers(4, hash, func(worker int, records []*kinesis.PutRecordsRequestEntry) {
+		outputPlugin.FlushWithRetries(len(records), records)
+	})
+
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.DispatchToWorkers(count, newTestEntries(numberedTestData(count)...)))
+	done.Wait()
+	outputPlugin.workers.close(closeTimeout)
+
+	for i, data := range sent {
+		assert.Equal(t, strconv.Itoa(i), data, "Expected records to be sent in the order of the chunk")
+	}
+}
+
+func TestPreserveChunkOrderStopsAtFailedRecords(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var sent []string
+	calls := 0
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		calls++
+		output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+		for i, record := range input.Records {
+			// the last record of the first request fails once
+			if calls == 1 && i == len(input.Records)-1 {
+				output.FailedRecordCount = aws.Int64(1)
+				output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+					ErrorCode:    aws.String(kinesis.ErrCodeInternalFailureException),
+					ErrorMessage: aws.String("Internal service failure."),
+				})
+				continue
+			}
+			sent = append(sent, string(record.Data))
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{})
+		}
+		return output, nil
+	}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.preserveChunkOrder = true
+
+	records := newTestEntries(numberedTestData(maximumRecordsPerPut + 100)...)
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected the flush to stop at the failed record")
+	assert.Equal(t, 1, calls, "Expected no later batch to be sent before the failed record")
+	assert.Len(t, records, 101)
+	assert.Equal(t, strconv.Itoa(maximumRecordsPerPut-1), string(records[0].Data))
+
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Len(t, sent, maximumRecordsPerPut+100)
+	for i, data := range sent {
+		assert.Equal(t, strconv.Itoa(i), data, "Expected records to be sent in the order of the chunk")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// DefaultClientRebuildCooldown is the minimum time between two rebuilds of the client
+const DefaultClientRebuildCooldown = time.Minute
+
+// authErrorCodes are the error codes of requests signed with expired or unusable credentials
+var authErrorCodes = map[string]bool{
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidSignatureException":   true,
+	"UnrecognizedClientException": true,
+}
+
+// isAuthError returns true if the request failed because of its credentials
+func isAuthError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && authErrorCodes[aerr.Code()]
+}
+
+// clientRebuild recreates the client, assuming the role again, when its credentials
+// have expired, at most once per cooldown
+type clientRebuild struct {
+	mutex       sync.RWMutex
+	build       func() (PutRecordsClient, error)
+	cooldown    time.Duration
+	lastRebuild time.Time
+}
+
+// currentClient returns the client, which may be replaced by a rebuild concurrently
+func (outputPlugin *OutputPlugin) currentClient() PutRecordsClient {
+	if outputPlugin.clientRebuild == nil {
+		return outputPlugin.client
+	}
+	outputPlugin.clientRebuild.mutex.RLock()
+	defer outputPlugin.clientRebuild.mutex.RUnlock()
+	return outputPlugin.client
+}
+
+// rebuildClient replaces the stale client after an auth error, and returns the client to retry
+// with. It returns false if the client was rebuilt within the cooldown, or could not be rebuilt.
+func (outputPlugin *OutputPlugin) rebuildClient(stale PutRecordsClient) (PutRecordsClient, bool) {
+	rebuild := outputPlugin.clientRebuild
+	rebuild.mutex.Lock()
+	defer rebuild.mutex.Unlock()
+	if outputPlugin.client != stale {
+		// another flush rebuilt the client while this one was in flight
+		return outputPlugin.client, true
+	}
+	if !rebuild.lastRebuild.IsZero() && time.Since(rebuild.lastRebuild) < rebuild.cooldown {
+		return nil, false
+	}
+	rebuild.lastRebuild = time.Now()
+
+	client, err := rebuild.build()
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to rebuild Kinesis client after an authentication error: %v\n", err)
+		return nil, false
+	}
+	outputPlugin.client = client
+	outputPlugin.logger.Infof("Rebuilt Kinesis client after an authentication error\n")
+	return client, true
+}
@@ -0,0 +1,105 @@
+package kinesis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAuthError(t *testing.T) {
+	assert.True(t, isAuthError(awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)))
+	assert.True(t, isAuthError(awserr.New("UnrecognizedClientException", "The security token included in the request is invalid", nil)))
+	assert.False(t, isAuthError(awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)))
+	assert.False(t, isAuthError(errors.New("ExpiredTokenException")))
+}
+
+func TestClientRebuiltOnExpiredToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	staleClient := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	staleClient.EXPECT().PutRecords(gomock.Any()).Return(nil, awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil))
+	rebuiltClient := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	rebuiltClient.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+	}, nil).Times(2)
+
+	builds := 0
+	outputPlugin, _ := newMockOutputPlugin(staleClient, false)
+	outputPlugin.clientRebuild = &clientRebuild{
+		build: func() (PutRecordsClient, error) {
+			builds++
+			return rebuiltClient, nil
+		},
+		cooldown: time.Minute,
+	}
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected the flush to succeed with the rebuilt client")
+	assert.Equal(t, 1, builds)
+	assert.Equal(t, rebuiltClient, outputPlugin.client)
+
+	records = []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Equal(t, 1, builds, "Expected later flushes to use the rebuilt client")
+}
+
+func TestClientRebuildCooldown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	expired := awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)
+	staleClient := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	staleClient.EXPECT().PutRecords(gomock.Any()).Return(nil, expired)
+	rebuiltClient := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	rebuiltClient.EXPECT().PutRecords(gomock.Any()).Return(nil, expired).Times(2)
+
+	builds := 0
+	outputPlugin, _ := newMockOutputPlugin(staleClient, false)
+	outputPlugin.clientRebuild = &clientRebuild{
+		build: func() (PutRecordsClient, error) {
+			builds++
+			return rebuiltClient, nil
+		},
+		cooldown: time.Minute,
+	}
+
+	for i := 0; i < 2; i++ {
+		records := []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("data"), PartitionKey: aws.String("key")},
+		}
+		assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+	}
+	assert.Equal(t, 1, builds, "Expected no second rebuild within the cooldown")
+}
+
+func TestClientNotRebuiltOnOtherErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil))
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.clientRebuild = &clientRebuild{
+		build: func() (PutRecordsClient, error) {
+			t.Fatal("Expected the client not to be rebuilt")
+			return nil, nil
+		},
+		cooldown: time.Minute,
+	}
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+}
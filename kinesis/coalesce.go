@@ -0,0 +1,167 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+)
+
+type coalesceResult struct {
+	// the records of the request which were not sent
+	unsent  []*kinesis.PutRecordsRequestEntry
+	retCode int
+	err     error
+}
+
+type coalesceRequest struct {
+	records []*kinesis.PutRecordsRequestEntry
+	done    chan coalesceResult
+}
+
+// coalescer combines the records concurrent flushes send into shared batches, so
+// under high concurrency PutRecords is called with fuller batches. A batch is sent
+// once it has enough records or bytes to fill a PutRecords call, or linger after
+// the first request joined it. The request which fills a batch sends it, and the
+// others wait for their share of the result. Each flush keeps its own retries.
+type coalescer struct {
+	linger     time.Duration
+	maxRecords int
+	maxBytes   int64
+	// if positive, a batch is sent as soon as it has this many records, even though
+	// it could hold more, so bursts are sent without waiting for the linger
+	flushOnRecords int
+	send           func(records *[]*kinesis.PutRecordsRequestEntry) (int, error)
+
+	mutex   sync.Mutex
+	pending []*coalesceRequest
+	records int
+	bytes   int64
+	timer   *time.Timer
+	// once closed, requests are sent without lingering
+	closed bool
+}
+
+func newCoalescer(linger time.Duration, maxRecords int, maxBytes int64, send func(records *[]*kinesis.PutRecordsRequestEntry) (int, error)) *coalescer {
+	return &coalescer{
+		linger:     linger,
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		send:       send,
+	}
+}
+
+// flush sends the records as part of a shared batch, leaving the records it failed
+// to send in the buffer as flush does
+func (c *coalescer) flush(records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+	request := &coalesceRequest{
+		records: *records,
+		done:    make(chan coalesceResult, 1),
+	}
+
+	c.mutex.Lock()
+	c.pending = append(c.pending, request)
+	c.records += len(*records)
+	c.bytes += recordsSize(*records)
+	var batch []*coalesceRequest
+	if c.closed || c.records >= c.maxRecords || c.bytes >= c.maxBytes || c.reachedFlushOnRecords() {
+		batch = c.take()
+	} else if len(c.pending) == 1 {
+		c.timer = time.AfterFunc(c.linger, c.sendPending)
+	}
+	c.mutex.Unlock()
+
+	if batch != nil {
+		c.sendBatch(batch)
+	}
+	result := <-request.done
+	*records = result.unsent
+	return result.retCode, result.err
+}
+
+// reachedFlushOnRecords reports whether the pending batch has enough records to be sent
+// early, it must be called with the mutex held
+func (c *coalescer) reachedFlushOnRecords() bool {
+	return c.flushOnRecords > 0 && c.records >= c.flushOnRecords
+}
+
+// close sends the pending requests without waiting for them to linger, and has later
+// requests sent straight away, so no flush is left waiting on the timer when the plugin closes
+func (c *coalescer) close() {
+	c.mutex.Lock()
+	c.closed = true
+	batch := c.take()
+	c.mutex.Unlock()
+
+	if len(batch) > 0 {
+		c.sendBatch(batch)
+	}
+}
+
+// take removes the pending requests, it must be called with the mutex held
+func (c *coalescer) take() []*coalesceRequest {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	batch := c.pending
+	c.pending = nil
+	c.records = 0
+	c.bytes = 0
+	return batch
+}
+
+// sendPending sends the pending requests once they have lingered
+func (c *coalescer) sendPending() {
+	c.mutex.Lock()
+	batch := c.take()
+	c.mutex.Unlock()
+
+	// the batch may already have been filled and sent
+	if len(batch) > 0 {
+		c.sendBatch(batch)
+	}
+}
+
+// sendBatch sends the records of every request together, and gives each request
+// the records of its own which were not sent
+func (c *coalescer) sendBatch(batch []*coalesceRequest) {
+	var combined []*kinesis.PutRecordsRequestEntry
+	for _, request := range batch {
+		combined = append(combined, request.records...)
+	}
+
+	retCode, err := c.send(&combined)
+
+	unsent := make(map[*kinesis.PutRecordsRequestEntry]bool, len(combined))
+	for _, record := range combined {
+		unsent[record] = true
+	}
+	for _, request := range batch {
+		result := coalesceResult{retCode: fluentbit.FLB_OK}
+		for _, record := range request.records {
+			if unsent[record] {
+				result.unsent = append(result.unsent, record)
+			}
+		}
+		if len(result.unsent) > 0 {
+			result.retCode = retCode
+			result.err = err
+		}
+		request.done <- result
+	}
+}
@@ -0,0 +1,188 @@
+package kinesis
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescerFillsBatches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var mutex sync.Mutex
+	var batchSizes []int
+	sent := make(map[string]int)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			batchSizes = append(batchSizes, len(input.Records))
+			for _, record := range input.Records {
+				sent[string(record.Data)]++
+			}
+			return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+		}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.concurrencyRetryLimit = 1
+	outputPlugin.coalescer = newCoalescer(200*time.Millisecond, maximumRecordsPerPut, int64(maximumPutRecordBatchSize), outputPlugin.flush)
+
+	// 70 flushes of 10 records, which fill one batch of 500 and leave 200 to linger
+	const flushes, recordsPerFlush = 70, 10
+	var wg sync.WaitGroup
+	for i := 0; i < flushes; i++ {
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, recordsPerFlush)
+		for j := 0; j < recordsPerFlush; j++ {
+			records = append(records, &kinesis.PutRecordsRequestEntry{
+				Data:         []byte(strconv.Itoa(i*recordsPerFlush + j)),
+				PartitionKey: aws.String("key"),
+			})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputPlugin.FlushWithRetries(len(records), records)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, sent, flushes*recordsPerFlush)
+	for data, count := range sent {
+		assert.Equal(t, 1, count, "Expected record %s to be sent once", data)
+	}
+	assert.Less(t, len(batchSizes), flushes/10, "Expected far fewer PutRecords calls than flushes, got batches %v", batchSizes)
+	assert.Contains(t, batchSizes, maximumRecordsPerPut, "Expected a full batch")
+}
+
+func TestCoalescerReturnsUnsentRecords(t *testing.T) {
+	errThrottled := errors.New("throttled")
+	c := newCoalescer(time.Hour, 4, int64(maximumPutRecordBatchSize), func(records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+		// only records with data "fail" are left unsent
+		unsent := (*records)[:0]
+		for _, record := range *records {
+			if string(record.Data) == "fail" {
+				unsent = append(unsent, record)
+			}
+		}
+		*records = unsent
+		return fluentbit.FLB_RETRY, errThrottled
+	})
+
+	first := []*kinesis.PutRecordsRequestEntry{{Data: []byte("ok")}, {Data: []byte("fail")}}
+	second := []*kinesis.PutRecordsRequestEntry{{Data: []byte("ok")}, {Data: []byte("ok")}}
+	firstFailed := first[1]
+
+	var wg sync.WaitGroup
+	var firstCode, secondCode int
+	var firstErr, secondErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		firstCode, firstErr = c.flush(&first)
+	}()
+	go func() {
+		defer wg.Done()
+		secondCode, secondErr = c.flush(&second)
+	}()
+	wg.Wait()
+
+	assert.Equal(t, fluentbit.FLB_RETRY, firstCode)
+	assert.Equal(t, errThrottled, firstErr)
+	assert.Equal(t, []*kinesis.PutRecordsRequestEntry{firstFailed}, first)
+	assert.Equal(t, fluentbit.FLB_OK, secondCode, "Expected a flush whose records were all sent to succeed")
+	assert.NoError(t, secondErr)
+	assert.Empty(t, second)
+}
+
+func TestCoalescerFlushOnRecords(t *testing.T) {
+	sent := make(chan int, 2)
+	c := newCoalescer(time.Hour, maximumRecordsPerPut, int64(maximumPutRecordBatchSize), func(records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+		sent <- len(*records)
+		*records = (*records)[:0]
+		return fluentbit.FLB_OK, nil
+	})
+	c.flushOnRecords = 3
+
+	var wg sync.WaitGroup
+	flush := func(data string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records := newTestEntries(data)
+			retCode, _ := c.flush(&records)
+			assert.Equal(t, fluentbit.FLB_OK, retCode)
+		}()
+	}
+
+	flush("first")
+	waitForCoalescedRecords(c, 1)
+	flush("second")
+	waitForCoalescedRecords(c, 2)
+	select {
+	case size := <-sent:
+		t.Fatalf("Expected no batch below the threshold, got one of %d records", size)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the request which reaches the threshold sends the batch, without waiting for the linger
+	flush("third")
+	select {
+	case size := <-sent:
+		assert.Equal(t, 3, size)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the batch to be sent once it reached flush_on_records")
+	}
+	wg.Wait()
+}
+
+// waitForCoalescedRecords waits until the pending batch has count records
+func waitForCoalescedRecords(c *coalescer, count int) {
+	for {
+		c.mutex.Lock()
+		records := c.records
+		c.mutex.Unlock()
+		if records == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescerCloseSendsPending(t *testing.T) {
+	sent := make(chan int, 2)
+	c := newCoalescer(time.Hour, maximumRecordsPerPut, int64(maximumPutRecordBatchSize), func(records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+		sent <- len(*records)
+		*records = (*records)[:0]
+		return fluentbit.FLB_OK, nil
+	})
+
+	done := make(chan int)
+	go func() {
+		records := newTestEntries("lingering")
+		retCode, _ := c.flush(&records)
+		done <- retCode
+	}()
+	waitForCoalescedRecords(c, 1)
+
+	c.close()
+	assert.Equal(t, fluentbit.FLB_OK, <-done, "Expected the pending request to be sent without waiting for the linger")
+	assert.Equal(t, 1, <-sent)
+	assert.Nil(t, c.timer, "Expected the timer to be stopped")
+
+	// requests after the close are sent straight away
+	records := newTestEntries("late")
+	retCode, _ := c.flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.Equal(t, 1, <-sent)
+}
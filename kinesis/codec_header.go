@@ -0,0 +1,41 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+// Codec header values, the first byte of each event when codec_header is enabled.
+// Consumers read it to decide how to decompress the rest of the event.
+const (
+	// CodecHeaderNone marks an uncompressed event
+	CodecHeaderNone byte = 0
+	// CodecHeaderGzip marks a gzip compressed event
+	CodecHeaderGzip byte = 1
+	// CodecHeaderZstd is reserved for zstd compressed events
+	CodecHeaderZstd byte = 2
+	// CodecHeaderZlib marks a zlib compressed event
+	CodecHeaderZlib byte = 3
+
+	codecHeaderSize = 1
+)
+
+// codecHeader returns the header byte for the configured compression
+func (outputPlugin *OutputPlugin) codecHeader() byte {
+	switch outputPlugin.compression {
+	case CompressionGzip:
+		return CodecHeaderGzip
+	case CompressionZlib:
+		return CodecHeaderZlib
+	default:
+		return CodecHeaderNone
+	}
+}
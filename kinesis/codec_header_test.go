@@ -0,0 +1,76 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeWithCodecHeader decompresses an event according to its header byte, as a consumer would
+func decodeWithCodecHeader(data []byte) ([]byte, error) {
+	header, payload := data[0], data[1:]
+	var reader io.Reader
+	var err error
+	switch header {
+	case CodecHeaderGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case CodecHeaderZlib:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestCodecHeaderRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		compression CompressionType
+		header      byte
+	}{
+		{CompressionNone, CodecHeaderNone},
+		{CompressionGzip, CodecHeaderGzip},
+		{CompressionZlib, CodecHeaderZlib},
+	} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.compression = tc.compression
+		outputPlugin.codecHeaderEnabled = true
+
+		data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+			"log": []byte("event"),
+		}, 0)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, tc.header, data[0], "Unexpected header for compression %s", tc.compression)
+		decoded, err := decodeWithCodecHeader(data)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"log":"event"}`, string(decoded))
+	}
+}
+
+func TestCodecHeaderWithFraming(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.compression = CompressionGzip
+	outputPlugin.codecHeaderEnabled = true
+	outputPlugin.framing = FramingLengthPrefixed
+	outputPlugin.logKey = "log"
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": []byte("event"),
+	}, 0)
+	assert.NoError(t, err)
+	events, err := splitLengthPrefixed(data)
+	if assert.NoError(t, err) && assert.Len(t, events, 1) {
+		assert.Equal(t, CodecHeaderGzip, events[0][0], "Expected the header to be inside the frame")
+		decoded, err := decodeWithCodecHeader(events[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "event", string(decoded))
+	}
+}
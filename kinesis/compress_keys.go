@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"encoding/base64"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// compressFields replaces the value of each compress_keys field of a decoded record with
+// its gzip compressed, base64 encoded form. Strings are compressed as is and every other
+// value as JSON, so consumers can restore a field by decoding and decompressing it.
+func (outputPlugin *OutputPlugin) compressFields(record map[interface{}]interface{}) error {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	for _, key := range outputPlugin.compressKeys {
+		value, ok := record[key]
+		if !ok || value == nil {
+			continue
+		}
+
+		var data []byte
+		switch v := value.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			var err error
+			data, err = json.Marshal(v)
+			if err != nil {
+				return err
+			}
+		}
+
+		compressed, err := gzipCompress(data)
+		if err != nil {
+			return err
+		}
+		record[key] = base64.StdEncoding.EncodeToString(compressed)
+	}
+	return nil
+}
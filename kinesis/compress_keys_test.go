@@ -0,0 +1,58 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func decompressField(t *testing.T, value interface{}) string {
+	compressed, err := base64.StdEncoding.DecodeString(value.(string))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCompressKeys(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.compressKeys = []string{"stacktrace", "context", "missing"}
+
+	stacktrace := strings.Repeat("at com.example.Handler.handle(Handler.java:42)\n", 1000)
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"level":      []byte("error"),
+		"message":    []byte("request failed"),
+		"stacktrace": []byte(stacktrace),
+		"context": map[interface{}]interface{}{
+			"request_id": []byte("abc"),
+		},
+	}, &timeStamp)
+	if !assert.Len(t, records, 1) {
+		return
+	}
+	assert.Less(t, len(records[0].Data), len(stacktrace)/10, "Expected the large field to be compressed")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(records[0].Data, &record))
+	assert.Equal(t, "error", record["level"], "Expected other fields to be left readable")
+	assert.Equal(t, "request failed", record["message"])
+	assert.NotContains(t, record, "missing")
+	assert.Equal(t, stacktrace, decompressField(t, record["stacktrace"]))
+	assert.JSONEq(t, `{"request_id":"abc"}`, decompressField(t, record["context"]))
+}
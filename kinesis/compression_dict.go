@@ -0,0 +1,67 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"os"
+)
+
+// maximumDictSize is the deflate window size, only the last 32KiB of a dictionary are used
+const maximumDictSize = 32 * 1024
+
+// loadCompressionDict reads a preset dictionary for zlib compression
+func loadCompressionDict(path string) ([]byte, error) {
+	dict, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(dict) == 0 {
+		return nil, fmt.Errorf("dictionary %s is empty", path)
+	}
+	return dict, nil
+}
+
+// newZlibDictCompressor returns a compressor which primes the flate writer with a preset
+// dictionary. Small records which share content with the dictionary, such as field names,
+// compress far better than they do on their own. The zlib header records the Adler-32
+// checksum of the dictionary, so consumers can check they decompress with the same one.
+func newZlibDictCompressor(dict []byte) CompressorFunc {
+	return func(data []byte) ([]byte, error) {
+		var b bytes.Buffer
+
+		if data == nil {
+			return nil, fmt.Errorf("No data to compress.  'nil' value passed as data")
+		}
+
+		// lower levels make little use of the dictionary for small inputs, and records are small
+		zw, err := zlib.NewWriterLevelDict(&b, flate.BestCompression, dict)
+		if err != nil {
+			return data, err
+		}
+		_, err = zw.Write(data)
+		if err != nil {
+			return data, err
+		}
+		err = zw.Close()
+		if err != nil {
+			return data, err
+		}
+
+		return b.Bytes(), nil
+	}
+}
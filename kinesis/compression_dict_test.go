@@ -0,0 +1,74 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDict = `{"level":"info","service":"checkout","message":"request completed","status":200,"duration_ms":`
+
+func zlibDecompress(t *testing.T, data, dict []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	if dict != nil {
+		reader, err = zlib.NewReaderDict(bytes.NewReader(data), dict)
+	} else {
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestZlibDictRoundTrip(t *testing.T) {
+	record := []byte(`{"level":"info","service":"checkout","message":"request completed","status":200,"duration_ms":12}`)
+
+	withDict, err := newZlibDictCompressor([]byte(testDict))(record)
+	assert.NoError(t, err)
+	withoutDict, err := zlibCompress(record)
+	assert.NoError(t, err)
+	assert.Less(t, len(withDict), len(withoutDict)/2, "Expected the dictionary to improve the compression ratio")
+
+	decompressed, err := zlibDecompress(t, withDict, []byte(testDict))
+	assert.NoError(t, err)
+	assert.Equal(t, record, decompressed)
+
+	_, err = zlibDecompress(t, withDict, nil)
+	assert.Equal(t, zlib.ErrDictionary, err, "Expected consumers without the dictionary to fail")
+
+	decompressed, err = zlibDecompress(t, withoutDict, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, record, decompressed)
+}
+
+func TestProcessRecordWithCompressionDict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dict")
+	assert.NoError(t, os.WriteFile(path, []byte(testDict), 0600))
+	dict, err := loadCompressionDict(path)
+	assert.NoError(t, err)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.compression = CompressionZlib
+	outputPlugin.zlibDictCompressor = newZlibDictCompressor(dict)
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"level":   []byte("info"),
+		"service": []byte("checkout"),
+	}, 0)
+	assert.NoError(t, err)
+
+	decompressed, err := zlibDecompress(t, data, dict)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"level":"info","service":"checkout"}`, string(decompressed))
+
+	_, err = loadCompressionDict(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
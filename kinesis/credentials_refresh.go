@@ -0,0 +1,53 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+)
+
+const (
+	// DefaultCredentialsRefreshBefore is how long before they expire assumed role credentials are refreshed
+	DefaultCredentialsRefreshBefore = time.Minute
+	// MaxCredentialsRefreshBefore keeps the lead time below the 15 minute assumed role session
+	// (stscreds.DefaultDuration), beyond which the credentials would be refreshed on every request
+	MaxCredentialsRefreshBefore = 14 * time.Minute
+)
+
+// refreshBefore makes assumed role credentials expire early, so they are refreshed
+// by the request made the given time before the session expires rather than after
+func refreshBefore(leadTime time.Duration) func(*stscreds.AssumeRoleProvider) {
+	return func(provider *stscreds.AssumeRoleProvider) {
+		provider.ExpiryWindow = leadTime
+	}
+}
+
+// withExternalID sets the external ID required by the trust policy of some roles
+func withExternalID(externalID string) func(*stscreds.AssumeRoleProvider) {
+	return func(provider *stscreds.AssumeRoleProvider) {
+		if externalID != "" {
+			provider.ExternalID = aws.String(externalID)
+		}
+	}
+}
+
+// newAssumeRoleCredentials creates the credentials for a role, replaced in tests to avoid calling STS
+var newAssumeRoleCredentials = func(c client.ConfigProvider, roleARN string, options ...func(*stscreds.AssumeRoleProvider)) *credentials.Credentials {
+	return stscreds.NewCredentials(c, roleARN, options...)
+}
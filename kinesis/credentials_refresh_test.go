@@ -0,0 +1,55 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAssumeRoler issues sessions which expire after duration
+type mockAssumeRoler struct {
+	duration time.Duration
+	calls    int
+}
+
+func (m *mockAssumeRoler) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	m.calls++
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String("AKID"),
+			SecretAccessKey: aws.String("SECRET"),
+			SessionToken:    aws.String("TOKEN"),
+			Expiration:      aws.Time(time.Now().Add(m.duration)),
+		},
+	}, nil
+}
+
+func TestCredentialsRefreshBefore(t *testing.T) {
+	// the session outlives the lead time, so the credentials are reused
+	client := &mockAssumeRoler{duration: 10 * time.Minute}
+	creds := stscreds.NewCredentialsWithClient(client, "arn:aws:iam::123456789012:role/test", refreshBefore(5*time.Minute))
+	_, err := creds.Get()
+	assert.NoError(t, err)
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.calls)
+
+	expiresAt, err := creds.ExpiresAt()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiresAt, 5*time.Second,
+		"Expected the credentials to expire the lead time before the session")
+
+	// the session is within the lead time of expiring, so it is refreshed before it expires
+	client = &mockAssumeRoler{duration: 4 * time.Minute}
+	creds = stscreds.NewCredentialsWithClient(client, "arn:aws:iam::123456789012:role/test", refreshBefore(5*time.Minute))
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.True(t, creds.IsExpired())
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, client.calls)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DataKeysOutput indicates how the fields selected by data_keys are encoded
+type DataKeysOutput string
+
+const (
+	// DataKeysOutputJSON encodes the selected fields as a JSON object
+	DataKeysOutputJSON DataKeysOutput = "json"
+	// DataKeysOutputValues joins the values of the selected fields with a delimiter, in data_keys order
+	DataKeysOutputValues DataKeysOutput = "values"
+
+	defaultDataKeysDelimiter = ","
+)
+
+// joinDataKeyValues joins the values of the data_keys fields of a decoded record with
+// the delimiter, in the order the keys are configured. Missing fields are skipped,
+// strings are used as is and every other value is encoded as JSON.
+func (outputPlugin *OutputPlugin) joinDataKeyValues(record map[interface{}]interface{}) ([]byte, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	delimiter := outputPlugin.dataKeysDelimiter
+	if delimiter == "" {
+		delimiter = defaultDataKeysDelimiter
+	}
+
+	values := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		values[stringOrByteArray(k)] = v
+	}
+
+	var data []byte
+	first := true
+	for _, key := range strings.Split(outputPlugin.dataKeys, ",") {
+		if outputPlugin.replaceDots != "" {
+			// the record keys have already had their dots replaced
+			key = strings.ReplaceAll(key, ".", outputPlugin.replaceDots)
+		}
+		value, ok := values[key]
+		if key == "" || !ok {
+			continue
+		}
+
+		if !first {
+			data = append(data, delimiter...)
+		}
+		first = false
+
+		switch v := value.(type) {
+		case string:
+			data = append(data, v...)
+		case nil:
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, encoded...)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataKeysOutputJSON(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.dataKeys = "level,message"
+	outputPlugin.dataKeysOutput = DataKeysOutputJSON
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"level":   []byte("info"),
+		"message": []byte("hello"),
+		"other":   []byte("dropped"),
+	}, 0)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[string]interface{}{"level": "info", "message": "hello"}, decoded)
+}
+
+func TestDataKeysOutputValues(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.dataKeys = "time,level,missing,status,tags,message"
+	outputPlugin.dataKeysOutput = DataKeysOutputValues
+
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"time":    []byte("2023-04-05T06:07:08"),
+			"level":   []byte("info"),
+			"status":  200,
+			"tags":    []interface{}{[]byte("a"), []byte("b")},
+			"message": "hello world",
+			"other":   []byte("dropped"),
+		}
+	}
+
+	// values follow the data_keys order, and missing fields are skipped
+	data, err := outputPlugin.processRecord(record(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, `2023-04-05T06:07:08,info,200,["a","b"],hello world`, string(data))
+
+	outputPlugin.dataKeysDelimiter = "\t"
+	outputPlugin.appendNewline = true
+	data, err = outputPlugin.processRecord(record(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "2023-04-05T06:07:08\tinfo\t200\t[\"a\",\"b\"]\thello world\n", string(data))
+}
+
+func TestDataKeysOutputValuesReplaceDots(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.dataKeys = "host.name,level"
+	outputPlugin.dataKeysOutput = DataKeysOutputValues
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"host.name": []byte("web-1"),
+		"level":     []byte("warn"),
+	}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "web-1,warn", string(data))
+}
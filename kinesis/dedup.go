@@ -0,0 +1,191 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+const (
+	// at most this many dedup keys are remembered, the oldest are forgotten first
+	dedupMaxKeys = 100000
+)
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// pendingDedupKey is the key of a record which has been added but not sent yet
+type pendingDedupKey struct {
+	record *kinesis.PutRecordsRequestEntry
+	dedupEntry
+}
+
+// dedupCache remembers the dedup keys of records sent within the window, and of records
+// waiting to be sent, so records with the same key are dropped instead of sent again.
+// Keys are only remembered past the flush once Kinesis has accepted their record, so
+// records which fail are still retried.
+type dedupCache struct {
+	keys   []string
+	window time.Duration
+	now    func() time.Time
+
+	mutex sync.Mutex
+	// when each sent key expires
+	sent map[string]time.Time
+	// the sent keys in the order they expire
+	expiry []dedupEntry
+	// the keys of records which have been added but not sent yet, in the order they were added
+	pending      map[*kinesis.PutRecordsRequestEntry]*list.Element
+	pendingOrder *list.List
+	// how many records waiting to be sent have each key
+	pendingKeys map[string]int
+}
+
+func newDedupCache(key string, window time.Duration) *dedupCache {
+	return &dedupCache{
+		keys:         strings.Split(key, "->"),
+		window:       window,
+		now:          time.Now,
+		sent:         make(map[string]time.Time),
+		pending:      make(map[*kinesis.PutRecordsRequestEntry]*list.Element),
+		pendingOrder: list.New(),
+		pendingKeys:  make(map[string]int),
+	}
+}
+
+// key returns the dedup key of the record. Records without one are never deduplicated.
+func (d *dedupCache) key(record map[interface{}]interface{}) (string, bool) {
+	var value interface{} = record
+	for _, key := range d.keys {
+		nested, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return "", false
+		}
+		value = getFromMap(key, nested)
+	}
+
+	switch v := value.(type) {
+	case nil, map[interface{}]interface{}, []interface{}:
+		return "", false
+	case []byte, string:
+		str := stringOrByteArray(v)
+		return str, str != ""
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// seen reports whether a record with the key was sent within the window, or is waiting
+// to be sent, in the same chunk or another
+func (d *dedupCache) seen(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+	if d.pendingKeys[key] > 0 {
+		return true
+	}
+	_, ok := d.sent[key]
+	return ok
+}
+
+// track remembers the key of a record until it is sent. Records which are never sent are
+// forgotten when they are released, or once the window passes. Beyond dedupMaxKeys the
+// oldest record is forgotten, so repeats of it won't be dropped.
+func (d *dedupCache) track(record *kinesis.PutRecordsRequestEntry, key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	now := d.now()
+	for oldest := d.pendingOrder.Front(); oldest != nil; oldest = d.pendingOrder.Front() {
+		if oldest.Value.(*pendingDedupKey).expires.After(now) && len(d.pending) < dedupMaxKeys {
+			break
+		}
+		d.removePending(oldest)
+	}
+	d.pending[record] = d.pendingOrder.PushBack(&pendingDedupKey{
+		record:     record,
+		dedupEntry: dedupEntry{key: key, expires: now.Add(d.window)},
+	})
+	d.pendingKeys[key]++
+}
+
+// release forgets the keys of records which won't be sent, because they were dropped
+// or left for Fluent Bit to deliver again
+func (d *dedupCache) release(records []*kinesis.PutRecordsRequestEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, record := range records {
+		if elem, ok := d.pending[record]; ok {
+			d.removePending(elem)
+		}
+	}
+}
+
+func (d *dedupCache) removePending(elem *list.Element) {
+	pending := elem.Value.(*pendingDedupKey)
+	delete(d.pending, pending.record)
+	d.pendingOrder.Remove(elem)
+	if d.pendingKeys[pending.key]--; d.pendingKeys[pending.key] <= 0 {
+		delete(d.pendingKeys, pending.key)
+	}
+}
+
+// markSent remembers the keys of the records Kinesis accepted
+func (d *dedupCache) markSent(records []*kinesis.PutRecordsRequestEntry, response *kinesis.PutRecordsOutput) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	allSent := aws.Int64Value(response.FailedRecordCount) == 0
+	for i, record := range records {
+		elem, ok := d.pending[record]
+		if !ok {
+			continue
+		}
+		if !allSent && (i >= len(response.Records) || response.Records[i].ErrorCode != nil) {
+			continue
+		}
+		key := elem.Value.(*pendingDedupKey).key
+		d.removePending(elem)
+		expires := d.now().Add(d.window)
+		d.sent[key] = expires
+		d.expiry = append(d.expiry, dedupEntry{key: key, expires: expires})
+	}
+	for len(d.expiry) > dedupMaxKeys {
+		d.forget(d.expiry[0])
+		d.expiry = d.expiry[1:]
+	}
+}
+
+// expire forgets the keys whose window has passed
+func (d *dedupCache) expire() {
+	now := d.now()
+	for len(d.expiry) > 0 && !d.expiry[0].expires.After(now) {
+		d.forget(d.expiry[0])
+		d.expiry = d.expiry[1:]
+	}
+}
+
+// forget removes a sent key, unless it was sent again since
+func (d *dedupCache) forget(entry dedupEntry) {
+	if d.sent[entry.key].Equal(entry.expires) {
+		delete(d.sent, entry.key)
+	}
+}
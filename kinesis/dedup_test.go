@@ -0,0 +1,167 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupWindow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var sent []string
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			for _, record := range input.Records {
+				sent = append(sent, string(record.Data))
+			}
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.logKey = "log"
+	outputPlugin.dedup = newDedupCache("id", time.Minute)
+	now := time.Now()
+	outputPlugin.dedup.now = func() time.Time { return now }
+
+	flush := func(logs ...string) {
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, len(logs))
+		timeStamp := time.Now()
+		for _, log := range logs {
+			outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+				"id":  []byte(log[:1]),
+				"log": []byte(log),
+			}, &timeStamp)
+		}
+		assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	}
+
+	flush("a1", "b1")
+	// repeats within the window are dropped in later flushes
+	now = now.Add(30 * time.Second)
+	flush("a2", "c1")
+	// and are sent again once the window has passed
+	now = now.Add(31 * time.Second)
+	flush("a3", "b2", "c2")
+
+	assert.Equal(t, []string{"a1", "b1", "c1", "a3", "b2"}, sent)
+}
+
+func TestDedupDropsRepeatsInSameChunk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var sent []string
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			sent = append(sent, sentData(input)...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.logKey = "log"
+	outputPlugin.dedup = newDedupCache("id", time.Minute)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	timeStamp := time.Now()
+	for _, log := range []string{"a1", "b1", "a2"} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"id":  []byte(log[:1]),
+			"log": []byte(log),
+		}, &timeStamp)
+	}
+	assert.Len(t, records, 2, "Expected a repeat of a record waiting to be sent to be dropped")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Equal(t, []string{"a1", "b1"}, sent)
+	assert.Empty(t, outputPlugin.dedup.pendingKeys)
+}
+
+func TestDedupOnlyRemembersSentRecords(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+			FailedRecordCount: aws.Int64(1),
+			Records: []*kinesis.PutRecordsResultEntry{
+				{SequenceNumber: aws.String("1")},
+				{ErrorCode: aws.String(kinesis.ErrCodeProvisionedThroughputExceededException), ErrorMessage: aws.String("Rate exceeded")},
+			},
+		}, nil),
+	)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.dedup = newDedupCache("id", time.Minute)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Now()
+	for _, id := range []string{"sent", "throttled"} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"id": []byte(id),
+		}, &timeStamp)
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+
+	assert.True(t, outputPlugin.dedup.seen("sent"))
+	// the failed record is released once Fluent Bit is left to deliver the chunk again
+	outputPlugin.DiscardRecords(records)
+	assert.False(t, outputPlugin.dedup.seen("throttled"), "Expected a record which failed to be retried")
+}
+
+func TestDedupForgetsUnsentRecords(t *testing.T) {
+	dedup := newDedupCache("id", time.Minute)
+	start := time.Now()
+	now := start
+	dedup.now = func() time.Time { return now }
+
+	records := newTestEntries("first", "second", "third")
+	for _, record := range records {
+		dedup.track(record, string(record.Data))
+		now = now.Add(time.Second)
+	}
+
+	assert.True(t, dedup.seen("second"), "Expected a record waiting to be sent to be seen")
+
+	// a record left for Fluent Bit to deliver again is released
+	dedup.release(records[1:2])
+	assert.False(t, dedup.seen("second"))
+	assert.Len(t, dedup.pending, 2)
+	assert.Equal(t, 2, dedup.pendingOrder.Len())
+
+	// records never sent are forgotten in the order they were added
+	now = start.Add(time.Minute + time.Second/2)
+	dedup.track(newTestEntries("fourth")[0], "fourth")
+	assert.NotContains(t, dedup.pending, records[0])
+	assert.Contains(t, dedup.pending, records[2])
+	assert.Equal(t, 2, dedup.pendingOrder.Len())
+}
+
+func TestDedupEvictsOldestPendingKey(t *testing.T) {
+	dedup := newDedupCache("id", time.Minute)
+	first := newTestEntries("first")[0]
+	dedup.track(first, "first")
+	for i := 1; i < dedupMaxKeys; i++ {
+		dedup.track(&kinesis.PutRecordsRequestEntry{}, "key")
+	}
+	assert.Contains(t, dedup.pending, first)
+
+	last := newTestEntries("last")[0]
+	dedup.track(last, "last")
+	assert.Len(t, dedup.pending, dedupMaxKeys)
+	assert.NotContains(t, dedup.pending, first, "Expected the oldest record to be forgotten")
+	assert.Contains(t, dedup.pending, last)
+}
@@ -0,0 +1,103 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// deadLetter wraps a record which could not be sent, for the dead letter stream.
+// Data is the record exactly as it would have been sent, base64 encoded in JSON.
+type deadLetter struct {
+	OriginalStream string `json:"original_stream"`
+	PartitionKey   string `json:"partition_key"`
+	Error          string `json:"error"`
+	Data           []byte `json:"data"`
+}
+
+// DeadLetter sends records which could not be delivered to dlq_stream, wrapped with
+// the original stream and the error. It returns false if no dead letter stream is
+// configured or the records could not be sent to it, in which case the caller
+// should log them as dropped.
+func (outputPlugin *OutputPlugin) DeadLetter(records []*kinesis.PutRecordsRequestEntry, reason error) bool {
+	if outputPlugin.dlqStream == "" || len(records) == 0 {
+		return false
+	}
+
+	entries := make([]*kinesis.PutRecordsRequestEntry, 0, len(records))
+	for _, record := range records {
+		if entry := outputPlugin.deadLetterEntry(record, reason); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	count := len(entries)
+	retCode, err := outputPlugin.flushStream(outputPlugin.dlqStream, &entries)
+	if retCode != fluentbit.FLB_OK || len(entries) > 0 {
+		if err == nil {
+			err = fmt.Errorf("%d records were rejected", len(entries))
+		}
+		outputPlugin.logger.Errorf("Failed to send records to dlq_stream %s: %v\n", outputPlugin.dlqStream, err)
+		return false
+	}
+	outputPlugin.logger.Warnf("Sent (%d) records which failed with '%v' to dlq_stream %s\n", count, reason, outputPlugin.dlqStream)
+	return true
+}
+
+// queueDeadLetter queues a record rejected as it was added for dlq_stream. Rejected
+// records are sent in batches with the next flush, like the mirror stream, rather than
+// in a request of their own, and those still queued when the plugin closes are sent by
+// Close. It returns false if no dead letter stream is configured
+// or the record could not be wrapped, in which case the caller should log it as dropped.
+func (outputPlugin *OutputPlugin) queueDeadLetter(record *kinesis.PutRecordsRequestEntry, reason error) bool {
+	if outputPlugin.deadLetters == nil {
+		return false
+	}
+	entry := outputPlugin.deadLetterEntry(record, reason)
+	if entry == nil {
+		return false
+	}
+	if dropped := outputPlugin.deadLetters.add(entry); dropped > 0 {
+		outputPlugin.logger.Errorf("Dropped %d records queued for dlq_stream %s\n", dropped, outputPlugin.deadLetters.stream)
+	}
+	return true
+}
+
+// deadLetterEntry wraps a record for dlq_stream, returning nil if it can't be wrapped
+func (outputPlugin *OutputPlugin) deadLetterEntry(record *kinesis.PutRecordsRequestEntry, reason error) *kinesis.PutRecordsRequestEntry {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(&deadLetter{
+		OriginalStream: outputPlugin.stream,
+		PartitionKey:   aws.StringValue(record.PartitionKey),
+		Error:          reason.Error(),
+		Data:           record.Data,
+	})
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to marshal record for dlq_stream %s, dropping it: %v\n", outputPlugin.dlqStream, err)
+		return nil
+	}
+	if len(data)+len(aws.StringValue(record.PartitionKey)) > outputPlugin.recordSizeLimit() {
+		outputPlugin.logger.Errorf("Record is too large for dlq_stream %s once wrapped (%d bytes), dropping it\n", outputPlugin.dlqStream, len(data))
+		return nil
+	}
+	return &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: record.PartitionKey,
+	}
+}
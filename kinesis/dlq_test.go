@@ -0,0 +1,88 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/golang/mock/gomock"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterAfterRetries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var dlqRecords []*kinesis.PutRecordsRequestEntry
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			if aws.StringValue(input.StreamName) != "dlq" {
+				return nil, errors.New("connection refused")
+			}
+			dlqRecords = append(dlqRecords, input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.concurrencyRetryLimit = 0
+	outputPlugin.dlqStream = "dlq"
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte(`{"log":"first"}`), PartitionKey: aws.String("a")},
+		{Data: []byte(`{"log":"second"}`), PartitionKey: aws.String("b")},
+	}
+	outputPlugin.FlushWithRetries(len(records), records)
+
+	if !assert.Len(t, dlqRecords, 2) {
+		return
+	}
+	for i, record := range dlqRecords {
+		var letter deadLetter
+		assert.NoError(t, json.Unmarshal(record.Data, &letter))
+		assert.Equal(t, "stream", letter.OriginalStream)
+		assert.Equal(t, "connection refused", letter.Error)
+		assert.Equal(t, records[i].Data, letter.Data)
+		assert.Equal(t, aws.StringValue(records[i].PartitionKey), letter.PartitionKey)
+		assert.Equal(t, records[i].PartitionKey, record.PartitionKey)
+	}
+}
+
+func TestDeadLetterFailureIsLogged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused")).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.concurrencyRetryLimit = 0
+	outputPlugin.dlqStream = "dlq"
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte(`{"log":"first"}`), PartitionKey: aws.String("a")},
+	}
+	outputPlugin.FlushWithRetries(len(records), records)
+
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "Failed to send records to dlq_stream dlq: connection refused\n")
+	assert.Contains(t, messages, "Failed to send (1) records after retries 0")
+}
+
+func TestDeadLetterDisabled(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	assert.False(t, outputPlugin.DeadLetter([]*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("a")},
+	}, errors.New("failed")))
+}
@@ -0,0 +1,157 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+)
+
+// fieldComparison is the drop_where condition, it matches records whose value at a key
+// compares to a value with an operator. Values which look like numbers are compared as
+// numbers, anything else as strings. Unlike the recordCondition of mirror_condition and
+// route_flag_value, = compares numbers by value, so 007 equals 7.
+type fieldComparison struct {
+	keys  []string
+	op    string
+	value string
+	// the value as a number, if it is one and not quoted
+	number   float64
+	isNumber bool
+}
+
+// comparison operators, two character operators first so they are matched before their prefixes
+var comparisonOperators = []string{"==", "!=", "<=", ">=", "=", "<", ">"}
+
+// parseFieldComparison parses a condition of the form key op value, where op is one of =
+// (or ==), !=, <, <=, > and >=. Nested keys are separated by '->', as for partition_key.
+// A value in double or single quotes is always compared as a string.
+func parseFieldComparison(condition string) (*fieldComparison, error) {
+	index, op := findComparisonOperator(condition)
+	if op == "" || strings.TrimSpace(condition[:index]) == "" {
+		return nil, fmt.Errorf("invalid condition '%s', must be of the form key=value, key!=value, key<value, key<=value, key>value or key>=value", condition)
+	}
+
+	keys := strings.Split(strings.TrimSpace(condition[:index]), "->")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid condition '%s', key must not be empty", condition)
+		}
+	}
+	c := &fieldComparison{
+		keys:  keys,
+		op:    op,
+		value: strings.TrimSpace(condition[index+len(op):]),
+	}
+	if op == "==" {
+		c.op = "="
+	}
+	if len(c.value) >= 2 && (c.value[0] == '"' || c.value[0] == '\'') && c.value[len(c.value)-1] == c.value[0] {
+		c.value = c.value[1 : len(c.value)-1]
+	} else if number, err := strconv.ParseFloat(c.value, 64); err == nil {
+		c.number, c.isNumber = number, true
+	}
+	return c, nil
+}
+
+// findComparisonOperator returns the position and text of the first operator in a condition,
+// skipping the '->' separating nested keys
+func findComparisonOperator(condition string) (int, string) {
+	for i := 0; i < len(condition); i++ {
+		if condition[i] == '>' && i > 0 && condition[i-1] == '-' {
+			continue
+		}
+		for _, op := range comparisonOperators {
+			if strings.HasPrefix(condition[i:], op) {
+				return i, op
+			}
+		}
+	}
+	return 0, ""
+}
+
+// matches reports whether the record satisfies the condition. A missing key, or a nested
+// map or array, never compares to the value, so it only matches a != condition. For
+// conditions on numbers, string values which don't parse as a number never compare either.
+func (c *fieldComparison) matches(record map[interface{}]interface{}) bool {
+	var value interface{} = record
+	for _, key := range c.keys {
+		nested, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return c.op == "!="
+		}
+		value = getFromMap(key, nested)
+	}
+
+	var str string
+	switch v := value.(type) {
+	case nil:
+		return c.op == "!="
+	case []byte, string:
+		str = stringOrByteArray(v)
+	case map[interface{}]interface{}, []interface{}:
+		return c.op == "!="
+	default:
+		str = fmt.Sprint(v)
+	}
+
+	var cmp int
+	if c.isNumber {
+		number, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return c.op == "!="
+		}
+		switch {
+		case number < c.number:
+			cmp = -1
+		case number > c.number:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(str, c.value)
+	}
+
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func newDropWhereCounter(pluginID int) *metrics.Counter {
+	return metrics.NewCounter("kinesis_drop_where_dropped_total", "Records dropped for matching drop_where.",
+		metrics.Labels{"plugin_id": strconv.Itoa(pluginID)})
+}
+
+// droppedWhere counts and drops a record matching drop_where. It returns false if the
+// record is kept.
+func (outputPlugin *OutputPlugin) droppedWhere(record map[interface{}]interface{}) bool {
+	if outputPlugin.dropWhere == nil || !outputPlugin.dropWhere.matches(record) {
+		return false
+	}
+	outputPlugin.dropWhereDropped.Inc()
+	return true
+}
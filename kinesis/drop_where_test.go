@@ -0,0 +1,96 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldComparisonOperators(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"status_code": int64(404),
+		"latency":     2.5,
+		"retries":     uint64(0),
+		"code_text":   []byte("503"),
+		"level":       []byte("warn"),
+		"request": map[interface{}]interface{}{
+			"method": []byte("POST"),
+		},
+	}
+
+	for condition, expected := range map[string]bool{
+		// integers
+		"status_code < 400":  false,
+		"status_code <= 404": true,
+		"status_code > 399":  true,
+		"status_code >= 405": false,
+		"status_code = 404":  true,
+		"status_code == 404": true,
+		"status_code != 404": false,
+		"retries < 1":        true,
+		// floats
+		"latency > 2":     true,
+		"latency < 2.5":   false,
+		"latency <= 2.50": true,
+		// numbers sent as strings
+		"code_text >= 500": true,
+		"code_text < 500":  false,
+		// strings
+		"level = warn":   true,
+		"level != warn":  false,
+		"level < x":      true,
+		"level > warn":   false,
+		"level >= warn":  true,
+		"level <= error": false,
+		// quoted values are compared as strings, "404" sorts after "1000"
+		`status_code > "1000"`: true,
+		`status_code = '404'`:  true,
+		// nested keys
+		"request->method = POST":  true,
+		"request->method != POST": false,
+		// strings never compare to numbers, and missing keys only match !=
+		"level < 400":        false,
+		"level != 400":       true,
+		"missing < 400":      false,
+		"missing != 400":     true,
+		"request < 400":      false,
+		"request->path = /x": false,
+	} {
+		c, err := parseFieldComparison(condition)
+		if assert.NoError(t, err, condition) {
+			assert.Equal(t, expected, c.matches(record), condition)
+		}
+	}
+
+	for _, condition := range []string{"status_code", "< 400", "status_code ! 400", "request-> < 400"} {
+		_, err := parseFieldComparison(condition)
+		assert.Error(t, err, condition)
+	}
+}
+
+func TestDropWhere(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.dropWhere, _ = parseFieldComparison("status_code < 400")
+	before := outputPlugin.dropWhereDropped.Value()
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	for _, status := range []interface{}{int64(200), int64(302), int64(500), []byte("404"), nil} {
+		record := map[interface{}]interface{}{"log": []byte("request")}
+		if status != nil {
+			record["status_code"] = status
+		}
+		retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected the batch to continue")
+	}
+
+	if assert.Len(t, records, 3, "Expected only the records with an error status, or none, to be kept") {
+		assert.Equal(t, `{"log":"request","status_code":500}`, string(records[0].Data))
+		assert.Equal(t, `{"log":"request","status_code":"404"}`, string(records[1].Data))
+		assert.Equal(t, `{"log":"request"}`, string(records[2].Data))
+	}
+	assert.Equal(t, before+2, outputPlugin.dropWhereDropped.Value())
+}
@@ -0,0 +1,193 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEMFNamespace is the CloudWatch namespace of EMF metrics if emf_namespace is not set
+	DefaultEMFNamespace = "FluentBit/Kinesis"
+	// DefaultEMFInterval is how often EMF metrics are emitted if emf_interval is not set
+	DefaultEMFInterval = time.Minute
+
+	// bits of the linear counting bitmap which estimates partition key cardinality,
+	// accurate to a few percent up to tens of thousands of distinct keys per interval
+	emfCardinalityBits = 1 << 16
+)
+
+// emfMetrics counts records and the distribution of their partition keys over an interval,
+// and emits them as CloudWatch Embedded Metric Format lines, which CloudWatch Logs
+// extracts as metrics from the plugin's own logs
+type emfMetrics struct {
+	namespace string
+	pluginID  int
+	stream    string
+	out       io.Writer
+
+	mutex sync.Mutex
+	// the hottest key, and the count-min sketch estimating its count
+	keys *partitionKeyHistogram
+	// linear counting bitmap of the partition keys seen
+	seen   [emfCardinalityBits / 64]uint64
+	sent   uint64
+	failed uint64
+}
+
+// emfLine is an EMF log line, whose _aws metadata declares which of its members are metrics
+type emfLine map[string]interface{}
+
+type emfMetadata struct {
+	Timestamp         int64                 `json:"Timestamp"`
+	CloudWatchMetrics []emfMetricDirectives `json:"CloudWatchMetrics"`
+}
+
+type emfMetricDirectives struct {
+	Namespace  string                `json:"Namespace"`
+	Dimensions [][]string            `json:"Dimensions"`
+	Metrics    []emfMetricDefinition `json:"Metrics"`
+}
+
+type emfMetricDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+func newEMFMetrics(namespace string, pluginID int, stream string, out io.Writer) *emfMetrics {
+	if namespace == "" {
+		namespace = DefaultEMFNamespace
+	}
+	return &emfMetrics{
+		namespace: namespace,
+		pluginID:  pluginID,
+		stream:    stream,
+		out:       out,
+		keys:      newPartitionKeyHistogram(1),
+	}
+}
+
+// add counts a record and its partition key, if it has one
+func (m *emfMetrics) add(partitionKey string, hasPartitionKey bool) {
+	m.keys.add(partitionKey, hasPartitionKey)
+	if !hasPartitionKey {
+		return
+	}
+	hasher := fnv.New64a()
+	hasher.Write([]byte(partitionKey))
+	bit := hasher.Sum64() % emfCardinalityBits
+	m.mutex.Lock()
+	m.seen[bit/64] |= 1 << (bit % 64)
+	m.mutex.Unlock()
+}
+
+// addSent counts the records a PutRecords call sent, and those it failed to send
+func (m *emfMetrics) addSent(sent, failed int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sent += uint64(sent)
+	m.failed += uint64(failed)
+}
+
+// rollup returns the EMF line for the interval, and resets the counts for the next one
+func (m *emfMetrics) rollup(now time.Time) emfLine {
+	top, total := m.keys.rollup()
+
+	m.mutex.Lock()
+	set := 0
+	for _, word := range m.seen {
+		set += bits.OnesCount64(word)
+	}
+	m.seen = [emfCardinalityBits / 64]uint64{}
+	sent, failed := m.sent, m.failed
+	m.sent, m.failed = 0, 0
+	m.mutex.Unlock()
+
+	var cardinality float64
+	if set == emfCardinalityBits {
+		// the bitmap is saturated, so this is only a lower bound
+		cardinality = emfCardinalityBits
+	} else if set > 0 {
+		cardinality = math.Round(-emfCardinalityBits * math.Log(float64(emfCardinalityBits-set)/emfCardinalityBits))
+	}
+	var topShare float64
+	if len(top) > 0 && total > 0 {
+		topShare = float64(top[0].count) * 100 / float64(total)
+	}
+
+	metrics := []emfMetricDefinition{
+		{Name: "Records", Unit: "Count"},
+		{Name: "RecordsSent", Unit: "Count"},
+		{Name: "RecordsFailed", Unit: "Count"},
+		{Name: "PartitionKeyCardinality", Unit: "Count"},
+		{Name: "TopPartitionKeyShare", Unit: "Percent"},
+	}
+	return emfLine{
+		"_aws": emfMetadata{
+			Timestamp: now.UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []emfMetricDirectives{{
+				Namespace:  m.namespace,
+				Dimensions: [][]string{{"PluginId", "Stream"}},
+				Metrics:    metrics,
+			}},
+		},
+		"PluginId":                strconv.Itoa(m.pluginID),
+		"Stream":                  m.stream,
+		"Records":                 total,
+		"RecordsSent":             sent,
+		"RecordsFailed":           failed,
+		"PartitionKeyCardinality": cardinality,
+		"TopPartitionKeyShare":    topShare,
+	}
+}
+
+// emit writes the EMF line for the interval as a single line of JSON
+func (m *emfMetrics) emit(now time.Time) error {
+	data, err := json.Marshal(m.rollup(now))
+	if err != nil {
+		return err
+	}
+	_, err = m.out.Write(append(data, '\n'))
+	return err
+}
+
+// emitPeriodically emits the metrics every interval, until the returned channel is closed
+func (m *emfMetrics) emitPeriodically(interval time.Duration, onError func(error)) chan struct{} {
+	if interval <= 0 {
+		interval = DefaultEMFInterval
+	}
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := m.emit(now); err != nil {
+					onError(err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,125 @@
+package kinesis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+// validateEMF checks a line follows the Embedded Metric Format specification, and returns it decoded
+func validateEMF(t *testing.T, line string) map[string]interface{} {
+	var decoded map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal([]byte(line), &decoded)) {
+		return nil
+	}
+	metadata, ok := decoded["_aws"].(map[string]interface{})
+	if !assert.True(t, ok, "Expected the _aws metadata object") {
+		return decoded
+	}
+	timestamp, ok := metadata["Timestamp"].(float64)
+	assert.True(t, ok && timestamp > 0, "Expected a Timestamp in milliseconds")
+
+	directives, ok := metadata["CloudWatchMetrics"].([]interface{})
+	if !assert.True(t, ok && len(directives) > 0, "Expected CloudWatchMetrics directives") {
+		return decoded
+	}
+	for _, d := range directives {
+		directive := d.(map[string]interface{})
+		assert.NotEmpty(t, directive["Namespace"])
+		for _, dimensionSet := range directive["Dimensions"].([]interface{}) {
+			for _, dimension := range dimensionSet.([]interface{}) {
+				_, isString := decoded[dimension.(string)].(string)
+				assert.True(t, isString, "Expected dimension %s to be a string member", dimension)
+			}
+		}
+		metrics := directive["Metrics"].([]interface{})
+		assert.NotEmpty(t, metrics)
+		for _, m := range metrics {
+			metric := m.(map[string]interface{})
+			name := metric["Name"].(string)
+			_, isNumber := decoded[name].(float64)
+			assert.True(t, isNumber, "Expected metric %s to be a number member", name)
+			assert.Contains(t, []string{"Count", "Percent"}, metric["Unit"])
+		}
+	}
+	return decoded
+}
+
+func TestEMFMetrics(t *testing.T) {
+	out := new(bytes.Buffer)
+	emf := newEMFMetrics("", 3, "stream", out)
+
+	for i := 0; i < 60; i++ {
+		emf.add("hot", true)
+	}
+	for i := 0; i < 40; i++ {
+		emf.add(fmt.Sprintf("key-%d", i%20), true)
+	}
+	emf.add("", false)
+	emf.addSent(90, 11)
+
+	now := time.Unix(1700000000, 0)
+	assert.NoError(t, emf.emit(now))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+	decoded := validateEMF(t, lines[0])
+	assert.Equal(t, float64(now.UnixNano()/int64(time.Millisecond)), decoded["_aws"].(map[string]interface{})["Timestamp"])
+	assert.Equal(t, DefaultEMFNamespace, decoded["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})["Namespace"])
+	assert.Equal(t, "3", decoded["PluginId"])
+	assert.Equal(t, "stream", decoded["Stream"])
+	assert.Equal(t, float64(101), decoded["Records"])
+	assert.Equal(t, float64(90), decoded["RecordsSent"])
+	assert.Equal(t, float64(11), decoded["RecordsFailed"])
+	assert.Equal(t, float64(21), decoded["PartitionKeyCardinality"])
+	assert.InDelta(t, 60*100/101.0, decoded["TopPartitionKeyShare"], 0.01)
+
+	// the counts are reset for the next interval
+	out.Reset()
+	assert.NoError(t, emf.emit(now.Add(time.Minute)))
+	decoded = validateEMF(t, strings.TrimSuffix(out.String(), "\n"))
+	assert.Equal(t, float64(0), decoded["Records"])
+	assert.Equal(t, float64(0), decoded["PartitionKeyCardinality"])
+	assert.Equal(t, float64(0), decoded["TopPartitionKeyShare"])
+}
+
+func TestEMFMetricsCardinalityEstimate(t *testing.T) {
+	emf := newEMFMetrics("Custom", 0, "stream", new(bytes.Buffer))
+	for i := 0; i < 20000; i++ {
+		emf.add(fmt.Sprintf("key-%d", i), true)
+	}
+	line := emf.rollup(time.Now())
+	assert.InEpsilon(t, 20000, line["PartitionKeyCardinality"], 0.05)
+}
+
+func TestAddRecordEMFMetrics(t *testing.T) {
+	out := new(bytes.Buffer)
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "tenant"
+	outputPlugin.emf = newEMFMetrics("", 0, "stream", out)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	timeStamp := time.Now()
+	for _, tenant := range []string{"a", "a", "b"} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"tenant": []byte(tenant),
+		}, &timeStamp)
+	}
+	assert.Equal(t, "a", aws.StringValue(records[0].PartitionKey))
+	outputPlugin.recordStatus("stream", 3, 0)
+	outputPlugin.recordStatus("mirror", 3, 0)
+
+	assert.NoError(t, outputPlugin.emf.emit(time.Now()))
+	decoded := validateEMF(t, strings.TrimSuffix(out.String(), "\n"))
+	assert.Equal(t, float64(3), decoded["Records"])
+	assert.Equal(t, float64(3), decoded["RecordsSent"], "Expected only records sent to the main stream to count")
+	assert.Equal(t, float64(2), decoded["PartitionKeyCardinality"])
+}
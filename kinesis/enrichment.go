@@ -0,0 +1,179 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+)
+
+// enrichmentTable maps values of the enrichment key to the fields added to matching records
+type enrichmentTable map[string]map[string]interface{}
+
+// loadEnrichmentTable reads a lookup table from a JSON or CSV file, chosen by extension.
+// A JSON file holds an object mapping each key value to an object of fields.
+// A CSV file has a header row, the first column holds the key value and every
+// other column is added as a field named after its header.
+func loadEnrichmentTable(path string) (enrichmentTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var json = jsoniter.ConfigCompatibleWithStandardLibrary
+		table := make(enrichmentTable)
+		if err := json.NewDecoder(file).Decode(&table); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return table, nil
+	case ".csv":
+		return readEnrichmentCSV(file)
+	default:
+		return nil, fmt.Errorf("unsupported enrichment file %s, must be .json or .csv", path)
+	}
+}
+
+func readEnrichmentCSV(r io.Reader) (enrichmentTable, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("header must have a key column and at least one field column")
+	}
+
+	table := make(enrichmentTable)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return table, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]interface{}, len(row)-1)
+		for i, name := range header[1:] {
+			fields[name] = row[i+1]
+		}
+		table[row[0]] = fields
+	}
+}
+
+// enricher merges the fields from a lookup table into records, keyed by the value of a record field
+type enricher struct {
+	path  string
+	keys  []string
+	mutex sync.RWMutex
+	table enrichmentTable
+}
+
+// newEnricher loads the table at path, for records keyed by key. Nested keys are
+// separated by '->', as for partition_key.
+func newEnricher(path, key string) (*enricher, error) {
+	table, err := loadEnrichmentTable(path)
+	if err != nil {
+		return nil, err
+	}
+	return &enricher{
+		path:  path,
+		keys:  strings.Split(key, "->"),
+		table: table,
+	}, nil
+}
+
+// reload replaces the table with the current contents of the file.
+// The previous table is kept if the file can not be loaded.
+func (e *enricher) reload() error {
+	table, err := loadEnrichmentTable(e.path)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.table = table
+	return nil
+}
+
+// reloadPeriodically reloads the table every interval, until the returned channel is closed
+func (e *enricher) reloadPeriodically(interval time.Duration, logger *logrus.Entry) chan struct{} {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.reload(); err != nil {
+					logger.Warnf("Failed to reload enrichment file, keeping the previous table: %v\n", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// enrich adds the fields for the record's key value. Fields already in the record
+// are not overwritten, and records whose key value is missing or not in the table
+// are left unmodified.
+func (e *enricher) enrich(record map[interface{}]interface{}) {
+	var value interface{} = record
+	for _, key := range e.keys {
+		nested, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		value = getFromMap(key, nested)
+	}
+
+	var lookup string
+	switch v := value.(type) {
+	case []byte, string:
+		lookup = stringOrByteArray(v)
+	case map[interface{}]interface{}, []interface{}, nil:
+		return
+	default:
+		lookup = fmt.Sprint(v)
+	}
+
+	e.mutex.RLock()
+	fields, ok := e.table[lookup]
+	e.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	existing := make(map[string]bool, len(record))
+	for k := range record {
+		existing[stringOrByteArray(k)] = true
+	}
+	for name, fieldValue := range fields {
+		if !existing[name] {
+			record[name] = fieldValue
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEnrichmentFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoadEnrichmentTable(t *testing.T) {
+	jsonPath := writeEnrichmentFile(t, "services.json", `{"svc-1": {"team": "payments", "tier": 1}}`)
+	table, err := loadEnrichmentTable(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, enrichmentTable{"svc-1": {"team": "payments", "tier": float64(1)}}, table)
+
+	csvPath := writeEnrichmentFile(t, "services.csv", "service_id,team,tier\nsvc-1,payments,1\nsvc-2,search,2\n")
+	table, err = loadEnrichmentTable(csvPath)
+	assert.NoError(t, err)
+	assert.Equal(t, enrichmentTable{
+		"svc-1": {"team": "payments", "tier": "1"},
+		"svc-2": {"team": "search", "tier": "2"},
+	}, table)
+
+	_, err = loadEnrichmentTable(writeEnrichmentFile(t, "services.txt", "svc-1=payments"))
+	assert.Error(t, err)
+	_, err = loadEnrichmentTable(writeEnrichmentFile(t, "bad.csv", "service_id\nsvc-1\n"))
+	assert.Error(t, err)
+}
+
+func TestEnrich(t *testing.T) {
+	path := writeEnrichmentFile(t, "services.csv", "service_id,team,tier\nsvc-1,payments,1\n")
+	e, err := newEnricher(path, "service_id")
+	assert.NoError(t, err)
+
+	// a hit merges the fields, without overwriting those already in the record
+	record := map[interface{}]interface{}{
+		"service_id": []byte("svc-1"),
+		"tier":       []byte("0"),
+	}
+	e.enrich(record)
+	assert.Equal(t, map[interface{}]interface{}{
+		"service_id": []byte("svc-1"),
+		"team":       "payments",
+		"tier":       []byte("0"),
+	}, record)
+
+	// misses and records without the key pass through unmodified
+	miss := map[interface{}]interface{}{"service_id": []byte("svc-9")}
+	e.enrich(miss)
+	assert.Equal(t, map[interface{}]interface{}{"service_id": []byte("svc-9")}, miss)
+	missing := map[interface{}]interface{}{"log": []byte("hello")}
+	e.enrich(missing)
+	assert.Equal(t, map[interface{}]interface{}{"log": []byte("hello")}, missing)
+
+	// nested keys
+	nested, err := newEnricher(path, "kubernetes->labels->service_id")
+	assert.NoError(t, err)
+	record = map[interface{}]interface{}{
+		"kubernetes": map[interface{}]interface{}{
+			"labels": map[interface{}]interface{}{"service_id": "svc-1"},
+		},
+	}
+	nested.enrich(record)
+	assert.Equal(t, "payments", record["team"])
+}
+
+func TestEnrichmentReload(t *testing.T) {
+	path := writeEnrichmentFile(t, "services.json", `{"svc-1": {"team": "payments"}}`)
+	e, err := newEnricher(path, "service_id")
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"svc-1": {"team": "billing"}}`), 0600))
+	assert.NoError(t, e.reload())
+	record := map[interface{}]interface{}{"service_id": "svc-1"}
+	e.enrich(record)
+	assert.Equal(t, "billing", record["team"])
+
+	// a broken file keeps the previous table
+	assert.NoError(t, os.WriteFile(path, []byte(`{`), 0600))
+	assert.Error(t, e.reload())
+	record = map[interface{}]interface{}{"service_id": "svc-1"}
+	e.enrich(record)
+	assert.Equal(t, "billing", record["team"])
+}
+
+func TestAddRecordEnrichment(t *testing.T) {
+	path := writeEnrichmentFile(t, "services.json", `{"svc-1": {"team": "payments"}}`)
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.enricher, _ = newEnricher(path, "service_id")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"service_id": []byte("svc-1"),
+	}, &timeStamp)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(records[0].Data, &decoded))
+	assert.Equal(t, map[string]interface{}{"service_id": "svc-1", "team": "payments"}, decoded)
+}
@@ -0,0 +1,67 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"math"
+)
+
+const (
+	// at most this many bytes of a record are looked at to estimate its entropy
+	entropySampleSize = 4096
+	// the sample is taken as this many evenly spaced runs, so repetition across the record is seen
+	entropySampleRuns = 16
+)
+
+// estimateEntropy returns the Shannon entropy of the bytes of data, in bits per byte,
+// between 0 for a run of one byte value and 8 for uniformly random bytes. Records
+// longer than entropySampleSize are estimated from evenly spaced runs of their bytes.
+func estimateEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	sampled := 0
+	if len(data) <= entropySampleSize {
+		for _, b := range data {
+			counts[b]++
+		}
+		sampled = len(data)
+	} else {
+		runLen := entropySampleSize / entropySampleRuns
+		stride := len(data) / entropySampleRuns
+		for run := 0; run < entropySampleRuns; run++ {
+			for _, b := range data[run*stride : run*stride+runLen] {
+				counts[b]++
+			}
+		}
+		sampled = runLen * entropySampleRuns
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(sampled)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// skipCompression reports whether data looks too random to shrink when compressed,
+// judged by compression_entropy_threshold
+func (outputPlugin *OutputPlugin) skipCompression(data []byte) bool {
+	return outputPlugin.entropyThreshold > 0 && estimateEntropy(data) > outputPlugin.entropyThreshold
+}
@@ -0,0 +1,51 @@
+package kinesis
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateEntropy(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	assert.Zero(t, estimateEntropy(nil))
+	assert.Zero(t, estimateEntropy(bytes.Repeat([]byte("a"), 10000)))
+	assert.InDelta(t, 1.0, estimateEntropy(bytes.Repeat([]byte("ab"), 10000)), 0.001)
+	assert.InDelta(t, 8.0, estimateEntropy(random), 0.1, "Expected random bytes to be close to 8 bits per byte")
+	assert.Less(t, estimateEntropy(bytes.Repeat([]byte(`{"level":"info","log":"GET /health 200"}`), 1000)), 5.0)
+}
+
+func TestCompressionEntropyThreshold(t *testing.T) {
+	random := make([]byte, 3000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, tc := range []struct {
+		name   string
+		log    []byte
+		header byte
+	}{
+		{"high entropy", random, CodecHeaderNone},
+		{"low entropy", bytes.Repeat([]byte("GET /health 200 "), 200), CodecHeaderGzip},
+	} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.compression = CompressionGzip
+		outputPlugin.codecHeaderEnabled = true
+		outputPlugin.entropyThreshold = 7.5
+		outputPlugin.logKey = "log"
+
+		data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+			"log": tc.log,
+		}, 0)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		assert.Equal(t, tc.header, data[0], "Unexpected header for the %s record", tc.name)
+		decoded, err := decodeWithCodecHeader(data)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.log, decoded, tc.name)
+	}
+}
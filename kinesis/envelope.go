@@ -0,0 +1,95 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// EnvelopeField is a field of the meta object records are wrapped with
+type EnvelopeField string
+
+const (
+	// EnvelopeFieldTag is the tag the record was flushed with
+	EnvelopeFieldTag EnvelopeField = "tag"
+	// EnvelopeFieldTimestamp is the record's timestamp, in RFC 3339 format in UTC
+	EnvelopeFieldTimestamp EnvelopeField = "timestamp"
+	// EnvelopeFieldPartitionKey is the partition key the record is sent with
+	EnvelopeFieldPartitionKey EnvelopeField = "partition_key"
+	// EnvelopeFieldStream is the stream the record is sent to
+	EnvelopeFieldStream EnvelopeField = "stream"
+)
+
+// the keys of the envelope
+const (
+	envelopeMetaKey = "meta"
+	envelopeDataKey = "data"
+)
+
+// envelope wraps each marshaled record as {"meta": {...}, "data": <record>}, where the
+// meta object holds the configured fields
+type envelope struct {
+	fields []EnvelopeField
+}
+
+func newEnvelope(fields []EnvelopeField) *envelope {
+	return &envelope{fields: fields}
+}
+
+// hasField reports whether the meta object holds the field
+func (e *envelope) hasField(field EnvelopeField) bool {
+	for _, f := range e.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// meta marshals the meta object of a record. The tag and partition key are left out when
+// they are empty, which they are for records added without a tag, and for aggregated
+// records without a partition key, whose key is only chosen by the aggregator.
+func (e *envelope) meta(stream, tag string, timeStamp time.Time, partitionKey string) ([]byte, error) {
+	meta := make(map[string]interface{}, len(e.fields))
+	for _, field := range e.fields {
+		switch field {
+		case EnvelopeFieldTag:
+			if tag != "" {
+				meta[string(field)] = tag
+			}
+		case EnvelopeFieldTimestamp:
+			meta[string(field)] = timeStamp.UTC().Format(time.RFC3339Nano)
+		case EnvelopeFieldPartitionKey:
+			if partitionKey != "" {
+				meta[string(field)] = partitionKey
+			}
+		case EnvelopeFieldStream:
+			meta[string(field)] = stream
+		}
+	}
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Marshal(meta)
+}
+
+// wrapEnvelope wraps a record marshaled to JSON in the envelope
+func wrapEnvelope(meta []byte, data []byte) []byte {
+	wrapped := make([]byte, 0, len(meta)+len(data)+len(envelopeMetaKey)+len(envelopeDataKey)+10)
+	wrapped = append(wrapped, `{"`+envelopeMetaKey+`":`...)
+	wrapped = append(wrapped, meta...)
+	wrapped = append(wrapped, `,"`+envelopeDataKey+`":`...)
+	wrapped = append(wrapped, data...)
+	return append(wrapped, '}')
+}
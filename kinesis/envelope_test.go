@@ -0,0 +1,65 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "user"
+	outputPlugin.envelope = newEnvelope([]EnvelopeField{EnvelopeFieldTag, EnvelopeFieldTimestamp, EnvelopeFieldPartitionKey, EnvelopeFieldStream})
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Date(2024, 3, 1, 12, 30, 0, 500, time.FixedZone("CET", 3600))
+	retCode := outputPlugin.AddTaggedRecord(&records, map[interface{}]interface{}{
+		"user":    []byte("alice"),
+		"message": []byte("hello"),
+		"nested":  map[interface{}]interface{}{"level": int64(3)},
+	}, nil, "app.web", &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	require.Len(t, records, 1)
+	assert.Equal(t, "alice", aws.StringValue(records[0].PartitionKey))
+
+	assert.JSONEq(t, `{
+		"meta": {
+			"tag": "app.web",
+			"timestamp": "2024-03-01T11:30:00.0000005Z",
+			"partition_key": "alice",
+			"stream": "stream"
+		},
+		"data": {"user": "alice", "message": "hello", "nested": {"level": 3}}
+	}`, string(records[0].Data))
+}
+
+func TestEnvelopeRandomPartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.dataKeys = "message"
+	outputPlugin.envelope = newEnvelope([]EnvelopeField{EnvelopeFieldPartitionKey, EnvelopeFieldTag})
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"message": []byte("hello"),
+		"dropped": []byte("by data_keys"),
+	}, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	require.Len(t, records, 1)
+
+	var enveloped struct {
+		Meta map[string]string      `json:"meta"`
+		Data map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(records[0].Data, &enveloped))
+	assert.Equal(t, aws.StringValue(records[0].PartitionKey), enveloped.Meta["partition_key"], "Expected the envelope to name the random key the record is sent with")
+	_, hasTag := enveloped.Meta["tag"]
+	assert.False(t, hasTag, "Expected an empty tag to be left out")
+	assert.Equal(t, map[string]interface{}{"message": "hello"}, enveloped.Data, "Expected data_keys to apply to the wrapped record")
+}
@@ -0,0 +1,167 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sirupsen/logrus"
+)
+
+type sampledLog struct {
+	level logrus.Level
+	// the latest message logged from this call site
+	message string
+	count   int
+}
+
+// errorLogSampler collapses the errors and warnings logged on the retry and failure
+// paths, so an outage doesn't log a line for every failed flush. Messages logged
+// with the same format string and errors are counted together, and logged once with
+// the number of occurrences and the latest message, when rate occurrences have been
+// counted or at the end of each interval, whichever comes first.
+type errorLogSampler struct {
+	logger   *logrus.Entry
+	interval time.Duration
+	rate     int
+
+	mutex   sync.Mutex
+	pending map[string]*sampledLog
+	// the keys of pending in the order they were first logged
+	order []string
+}
+
+func newErrorLogSampler(logger *logrus.Entry, interval time.Duration, rate int) *errorLogSampler {
+	return &errorLogSampler{
+		logger:   logger,
+		interval: interval,
+		rate:     rate,
+		pending:  make(map[string]*sampledLog),
+	}
+}
+
+// logf counts the message, and logs it if it has now occurred rate times
+func (sampler *errorLogSampler) logf(level logrus.Level, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	key := sampleKey(level, format, args)
+
+	sampler.mutex.Lock()
+	entry, ok := sampler.pending[key]
+	if !ok {
+		entry = &sampledLog{level: level}
+		sampler.pending[key] = entry
+		sampler.order = append(sampler.order, key)
+	}
+	entry.message = message
+	entry.count++
+	if sampler.rate <= 0 || entry.count < sampler.rate {
+		sampler.mutex.Unlock()
+		return
+	}
+	delete(sampler.pending, key)
+	for i, k := range sampler.order {
+		if k == key {
+			sampler.order = append(sampler.order[:i], sampler.order[i+1:]...)
+			break
+		}
+	}
+	sampler.mutex.Unlock()
+
+	sampler.write(entry)
+}
+
+// sampleKey identifies the messages counted together: the format string, and the kind of
+// each error argument, so a "%v" format doesn't collapse unrelated errors into one count.
+// AWS errors are told apart by their code, as their messages carry request IDs.
+func sampleKey(level logrus.Level, format string, args []interface{}) string {
+	var key strings.Builder
+	key.WriteString(level.String())
+	key.WriteString(format)
+	for _, arg := range args {
+		err, ok := arg.(error)
+		if !ok {
+			continue
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			fmt.Fprintf(&key, "\x00%T:%s", err, aerr.Code())
+		} else {
+			fmt.Fprintf(&key, "\x00%T:%s", err, err.Error())
+		}
+	}
+	return key.String()
+}
+
+// flush logs every message counted since it was last logged
+func (sampler *errorLogSampler) flush() {
+	sampler.mutex.Lock()
+	entries := make([]*sampledLog, 0, len(sampler.order))
+	for _, key := range sampler.order {
+		entries = append(entries, sampler.pending[key])
+	}
+	sampler.pending = make(map[string]*sampledLog)
+	sampler.order = nil
+	sampler.mutex.Unlock()
+
+	for _, entry := range entries {
+		sampler.write(entry)
+	}
+}
+
+func (sampler *errorLogSampler) write(entry *sampledLog) {
+	message := strings.TrimSuffix(entry.message, "\n")
+	if entry.count > 1 {
+		message = fmt.Sprintf("%s (occurred %d times)", message, entry.count)
+	}
+	sampler.logger.Logf(entry.level, "%s\n", message)
+}
+
+// flushPeriodically flushes the sampler every interval, until the returned channel is closed
+func (sampler *errorLogSampler) flushPeriodically() chan struct{} {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(sampler.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sampler.flush()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// sampledErrorf logs an error on the retry and failure paths, through the sampler if one is configured
+func (outputPlugin *OutputPlugin) sampledErrorf(format string, args ...interface{}) {
+	if outputPlugin.errorLogSampler == nil {
+		outputPlugin.logger.Errorf(format, args...)
+		return
+	}
+	outputPlugin.errorLogSampler.logf(logrus.ErrorLevel, format, args...)
+}
+
+// sampledWarnf logs a warning on the retry and failure paths, through the sampler if one is configured
+func (outputPlugin *OutputPlugin) sampledWarnf(format string, args ...interface{}) {
+	if outputPlugin.errorLogSampler == nil {
+		outputPlugin.logger.Warnf(format, args...)
+		return
+	}
+	outputPlugin.errorLogSampler.logf(logrus.WarnLevel, format, args...)
+}
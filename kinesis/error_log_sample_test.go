@@ -0,0 +1,100 @@
+package kinesis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorLogSamplerCountsIdenticalErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("service unavailable")).Times(5)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	// the interval is long enough that the sample is only logged by the flush below
+	outputPlugin.errorLogSampler = newErrorLogSampler(outputPlugin.logger, time.Hour, 0)
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	for i := 0; i < 5; i++ {
+		records := []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("data"), PartitionKey: aws.String("key")},
+		}
+		assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+	}
+	assert.Empty(t, errorEntries(hook), "Expected errors to be held until the interval ends")
+
+	outputPlugin.errorLogSampler.flush()
+	assert.Equal(t, []string{
+		"PutRecords failed with service unavailable (occurred 5 times)\n",
+		"service unavailable (occurred 5 times)\n",
+	}, errorEntries(hook))
+}
+
+func TestErrorLogSamplerRate(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	sampler := newErrorLogSampler(logrus.WithField("plugin_id", 0), 0, 3)
+	for i := 1; i <= 7; i++ {
+		sampler.logf(logrus.ErrorLevel, "Failed to send (%d) records\n", i)
+	}
+	// a single occurrence is logged as it is
+	sampler.logf(logrus.WarnLevel, "Throughput limits for the stream may have been exceeded.")
+	assert.Equal(t, []string{
+		"Failed to send (3) records (occurred 3 times)\n",
+		"Failed to send (6) records (occurred 3 times)\n",
+	}, errorEntries(hook))
+
+	sampler.flush()
+	assert.Equal(t, "Failed to send (7) records\n", hook.AllEntries()[2].Message)
+	assert.Equal(t, "Throughput limits for the stream may have been exceeded.\n", hook.LastEntry().Message)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+
+	sampler.flush()
+	assert.Len(t, hook.AllEntries(), 4, "Expected nothing more to log")
+}
+
+func TestErrorLogSamplerSeparatesErrors(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	sampler := newErrorLogSampler(logrus.WithField("plugin_id", 0), time.Hour, 0)
+	sampler.logf(logrus.ErrorLevel, "%v\n", errors.New("connection reset"))
+	sampler.logf(logrus.ErrorLevel, "%v\n", errors.New("no such host"))
+	// AWS errors with the same code are counted together, whatever their request ID
+	sampler.logf(logrus.ErrorLevel, "%v\n", awserr.NewRequestFailure(awserr.New("InternalFailure", "failed", nil), 500, "request-1"))
+	sampler.logf(logrus.ErrorLevel, "%v\n", awserr.NewRequestFailure(awserr.New("InternalFailure", "failed", nil), 500, "request-2"))
+
+	sampler.flush()
+	messages := errorEntries(hook)
+	if assert.Len(t, messages, 3) {
+		assert.Equal(t, "connection reset\n", messages[0])
+		assert.Equal(t, "no such host\n", messages[1])
+		assert.Contains(t, messages[2], "request-2")
+		assert.Contains(t, messages[2], "(occurred 2 times)")
+	}
+}
+
+func errorEntries(hook *logrustest.Hook) []string {
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level <= logrus.WarnLevel {
+			messages = append(messages, entry.Message)
+		}
+	}
+	return messages
+}
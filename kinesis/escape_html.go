@@ -0,0 +1,35 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+// jsonWithoutHTMLEscape behaves like jsoniter.ConfigCompatibleWithStandardLibrary, except
+// that '<', '>' and '&' in strings are written as they are instead of as \u003c, \u003e
+// and \u0026
+var jsonWithoutHTMLEscape = jsoniter.Config{
+	EscapeHTML:             false,
+	SortMapKeys:            true,
+	ValidateJsonRawMessage: true,
+}.Froze()
+
+// newRecordJSON returns the config records are marshalled to JSON with
+func newRecordJSON(escapeHTML bool) jsoniter.API {
+	if escapeHTML {
+		return jsoniter.ConfigCompatibleWithStandardLibrary
+	}
+	return jsonWithoutHTMLEscape
+}
@@ -0,0 +1,30 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeHTML(t *testing.T) {
+	for escapeHTML, expected := range map[bool]string{
+		true:  `{"message":"\u003cscript\u003ealert(1)\u003c/script\u003e \u0026 more"}`,
+		false: `{"message":"<script>alert(1)</script> & more"}`,
+	} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.recordJSON = newRecordJSON(escapeHTML)
+
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+		timeStamp := time.Now()
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"message": []byte("<script>alert(1)</script> & more"),
+		}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+		if assert.Len(t, records, 1) {
+			assert.Equal(t, expected, string(records[0].Data), "escape_html %v", escapeHTML)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	clientRetryInitialInterval = time.Second
+	clientRetryMaxInterval     = time.Minute
+)
+
+var errClientUnavailable = errors.New("Kinesis client is not available, it could not be created at startup")
+
+// retryClient keeps trying to build the client until it succeeds or stop is closed.
+// Until then the plugin runs degraded and every flush is retried.
+func (outputPlugin *OutputPlugin) retryClient(build func() (PutRecordsClient, error), interval time.Duration, stop chan struct{}) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+
+		client, err := build()
+		if err != nil {
+			outputPlugin.logger.Errorf("Failed to create Kinesis client, will try again in %s: %v\n", interval, err)
+			interval *= 2
+			if interval > clientRetryMaxInterval {
+				interval = clientRetryMaxInterval
+			}
+			continue
+		}
+
+		// the store to clientPending publishes the client to flushing goroutines
+		outputPlugin.client = client
+		atomic.StoreInt32(&outputPlugin.clientPending, 0)
+		outputPlugin.logger.Infof("Created Kinesis client, leaving degraded mode\n")
+		return
+	}
+}
+
+// clientAvailable indicates if the client has been created, it is only false with fail_open
+func (outputPlugin *OutputPlugin) clientAvailable() bool {
+	return atomic.LoadInt32(&outputPlugin.clientPending) == 0
+}
@@ -0,0 +1,79 @@
+package kinesis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOutputPluginFailOpen(t *testing.T) {
+	// an invalid setting makes creating the AWS session fail
+	t.Setenv("AWS_STS_REGIONAL_ENDPOINTS", "invalid")
+
+	_, err := NewOutputPlugin(&OutputPluginConfig{
+		Region: "us-east-1",
+		Stream: "stream",
+	})
+	assert.Error(t, err, "Expected init to fail fast by default")
+
+	outputPlugin, err := NewOutputPlugin(&OutputPluginConfig{
+		Region:   "us-east-1",
+		Stream:   "stream",
+		FailOpen: true,
+	})
+	assert.NoError(t, err)
+	defer outputPlugin.Close()
+	assert.False(t, outputPlugin.clientAvailable())
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected flushes to be retried while degraded")
+	assert.Len(t, records, 1)
+}
+
+func TestRetryClientRecovers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+	}, nil)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.clientPending = 1
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+
+	attempts := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		outputPlugin.retryClient(func() (PutRecordsClient, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("no credentials")
+			}
+			return mockKinesis, nil
+		}, time.Millisecond, make(chan struct{}))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to be created")
+	}
+	assert.Equal(t, 3, attempts)
+	assert.True(t, outputPlugin.clientAvailable())
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+}
@@ -0,0 +1,151 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/sirupsen/logrus"
+)
+
+// fanoutTarget is a stream, in its own region, which every batch is also sent to
+type fanoutTarget struct {
+	region string
+	stream string
+	client PutRecordsClient
+}
+
+// fanoutClient sends each PutRecords request for the main stream to the primary client
+// and to every fan-out target at once. A record counts as sent once quorum of the
+// destinations, the primary included, accepted it. Records which fall short are failed
+// in the merged response and retried to every destination, so destinations which had
+// accepted them receive them again. Requests for other streams, such as the mirror or
+// metadata streams, only go to the primary client.
+type fanoutClient struct {
+	primary PutRecordsClient
+	stream  string
+	targets []fanoutTarget
+	quorum  int
+	logger  *logrus.Entry
+}
+
+func newFanoutClient(primary PutRecordsClient, stream string, targets []fanoutTarget, quorum int, logger *logrus.Entry) *fanoutClient {
+	destinations := len(targets) + 1
+	if quorum <= 0 || quorum > destinations {
+		quorum = destinations
+	}
+	return &fanoutClient{
+		primary: primary,
+		stream:  stream,
+		targets: targets,
+		quorum:  quorum,
+		logger:  logger,
+	}
+}
+
+// fanoutResult is the response of a single destination
+type fanoutResult struct {
+	name     string
+	response *kinesis.PutRecordsOutput
+	err      error
+}
+
+// accepted reports whether the destination accepted the i-th record
+func (r fanoutResult) accepted(i int) bool {
+	if r.err != nil || r.response == nil || i >= len(r.response.Records) {
+		return false
+	}
+	return r.response.Records[i].ErrorCode == nil
+}
+
+// PutRecords sends the request to every destination concurrently and merges their responses
+func (c *fanoutClient) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	if aws.StringValue(input.StreamName) != c.stream {
+		return c.primary.PutRecords(input)
+	}
+
+	results := make([]fanoutResult, len(c.targets)+1)
+	var wg sync.WaitGroup
+	send := func(i int, name string, client PutRecordsClient, stream string) {
+		defer wg.Done()
+		request := *input
+		request.StreamName = aws.String(stream)
+		response, err := client.PutRecords(&request)
+		results[i] = fanoutResult{name: name, response: response, err: err}
+	}
+	wg.Add(len(results))
+	go send(0, c.stream, c.primary, c.stream)
+	for i, target := range c.targets {
+		go send(i+1, fmt.Sprintf("%s in %s", target.stream, target.region), target.client, target.stream)
+	}
+	wg.Wait()
+
+	return c.merge(input.Records, results)
+}
+
+// merge returns a response in which each record succeeded if a quorum of the destinations
+// accepted it, or an error if no destination could be reached, so the whole batch is retried
+func (c *fanoutClient) merge(records []*kinesis.PutRecordsRequestEntry, results []fanoutResult) (*kinesis.PutRecordsOutput, error) {
+	var firstErr error
+	failedDestinations := 0
+	for _, result := range results {
+		if result.err != nil {
+			failedDestinations++
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			c.logger.Warnf("Fan-out PutRecords to %s failed: %v\n", result.name, result.err)
+		}
+	}
+	if len(results)-failedDestinations < c.quorum {
+		// fewer destinations than the quorum answered, the first error keeps its retry hints
+		return nil, firstErr
+	}
+
+	merged := &kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+		Records:           make([]*kinesis.PutRecordsResultEntry, len(records)),
+	}
+	for i := range records {
+		var accepted []*kinesis.PutRecordsResultEntry
+		var rejected *kinesis.PutRecordsResultEntry
+		for _, result := range results {
+			if result.accepted(i) {
+				accepted = append(accepted, result.response.Records[i])
+			} else if rejected == nil && result.err == nil && i < len(result.response.Records) {
+				rejected = result.response.Records[i]
+			}
+		}
+		if len(accepted) >= c.quorum {
+			// the primary's shard and sequence number, if it accepted the record
+			merged.Records[i] = accepted[0]
+			continue
+		}
+		if rejected == nil {
+			rejected = &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String(kinesis.ErrCodeInternalFailureException),
+				ErrorMessage: aws.String("missing from the fan-out response"),
+			}
+		}
+		merged.Records[i] = &kinesis.PutRecordsResultEntry{
+			ErrorCode:    rejected.ErrorCode,
+			ErrorMessage: aws.String(fmt.Sprintf("accepted by %d of the %d destinations needed: %s", len(accepted), c.quorum, aws.StringValue(rejected.ErrorMessage))),
+		}
+		merged.FailedRecordCount = aws.Int64(aws.Int64Value(merged.FailedRecordCount) + 1)
+	}
+	return merged, nil
+}
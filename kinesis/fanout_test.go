@@ -0,0 +1,156 @@
+package kinesis
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fanoutResponse accepts every record except the ones at the failed indexes
+func fanoutResponse(input *kinesis.PutRecordsInput, failed ...int) *kinesis.PutRecordsOutput {
+	output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(int64(len(failed)))}
+	for range input.Records {
+		output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+			ShardId:        aws.String("shardId-000000000000"),
+			SequenceNumber: aws.String("1"),
+		})
+	}
+	for _, i := range failed {
+		output.Records[i] = &kinesis.PutRecordsResultEntry{
+			ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+			ErrorMessage: aws.String("Rate exceeded"),
+		}
+	}
+	return output
+}
+
+func TestFanoutSendsToEveryDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	secondary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	// the destinations are sent to in parallel
+	var mutex sync.Mutex
+	sent := make(map[string][]string)
+	record := func(name string) func(*kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		return func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, entry := range input.Records {
+				sent[name] = append(sent[name], aws.StringValue(input.StreamName)+":"+string(entry.Data))
+			}
+			return fanoutResponse(input), nil
+		}
+	}
+	primary.EXPECT().PutRecords(gomock.Any()).DoAndReturn(record("primary"))
+	secondary.EXPECT().PutRecords(gomock.Any()).DoAndReturn(record("secondary"))
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.logKey = "log"
+	outputPlugin.client = newFanoutClient(primary, "stream", []fanoutTarget{
+		{region: "eu-west-1", stream: "replica", client: secondary},
+	}, 0, outputPlugin.logger)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	for _, log := range []string{"first", "second"} {
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": []byte(log)}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+	}
+
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected Flush return code to be FLB_OK")
+	assert.Equal(t, []string{"stream:first", "stream:second"}, sent["primary"])
+	assert.Equal(t, []string{"replica:first", "replica:second"}, sent["secondary"])
+}
+
+func TestFanoutQuorum(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	secondary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	input := &kinesis.PutRecordsInput{
+		StreamName: aws.String("stream"),
+		Records: []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("first"), PartitionKey: aws.String("a")},
+			{Data: []byte("second"), PartitionKey: aws.String("b")},
+		},
+	}
+	primary.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			return fanoutResponse(input), nil
+		}).Times(2)
+	secondary.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			return fanoutResponse(input, 1), nil
+		}).Times(2)
+	targets := []fanoutTarget{{region: "eu-west-1", stream: "stream", client: secondary}}
+	logger := newPluginLogger(0, "stream", "us-east-1")
+
+	// every destination has to accept a record by default
+	response, err := newFanoutClient(primary, "stream", targets, 0, logger).PutRecords(input)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), aws.Int64Value(response.FailedRecordCount))
+	assert.Nil(t, response.Records[0].ErrorCode)
+	assert.Equal(t, kinesis.ErrCodeProvisionedThroughputExceededException, aws.StringValue(response.Records[1].ErrorCode))
+	assert.Contains(t, aws.StringValue(response.Records[1].ErrorMessage), "accepted by 1 of the 2 destinations needed")
+
+	response, err = newFanoutClient(primary, "stream", targets, 1, logger).PutRecords(input)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), aws.Int64Value(response.FailedRecordCount))
+	assert.Equal(t, "shardId-000000000000", aws.StringValue(response.Records[1].ShardId))
+}
+
+func TestFanoutDestinationErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	secondary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	input := &kinesis.PutRecordsInput{
+		StreamName: aws.String("stream"),
+		Records:    []*kinesis.PutRecordsRequestEntry{{Data: []byte("first"), PartitionKey: aws.String("a")}},
+	}
+	primary.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			return fanoutResponse(input), nil
+		}).Times(2)
+	secondary.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("region unavailable")).Times(2)
+	targets := []fanoutTarget{{region: "eu-west-1", stream: "stream", client: secondary}}
+	logger := newPluginLogger(0, "stream", "us-east-1")
+
+	_, err := newFanoutClient(primary, "stream", targets, 2, logger).PutRecords(input)
+	assert.EqualError(t, err, "region unavailable")
+
+	response, err := newFanoutClient(primary, "stream", targets, 1, logger).PutRecords(input)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), aws.Int64Value(response.FailedRecordCount))
+}
+
+func TestFanoutOtherStreamsUsePrimary(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	primary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	secondary := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	input := &kinesis.PutRecordsInput{
+		StreamName: aws.String("errors"),
+		Records:    []*kinesis.PutRecordsRequestEntry{{Data: []byte("first"), PartitionKey: aws.String("a")}},
+	}
+	primary.EXPECT().PutRecords(input).Return(fanoutResponse(input), nil)
+	secondary.EXPECT().PutRecords(gomock.Any()).Times(0)
+
+	client := newFanoutClient(primary, "stream", []fanoutTarget{{region: "eu-west-1", stream: "stream", client: secondary}}, 0, newPluginLogger(0, "stream", "us-east-1"))
+	_, err := client.PutRecords(input)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,129 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/amazon-kinesis-firehose-for-fluent-bit/plugins"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/sirupsen/logrus"
+)
+
+// Sink indicates the kind of stream records are sent to
+type Sink string
+
+const (
+	// SinkKinesis sends records to a Kinesis Data Stream with PutRecords
+	SinkKinesis Sink = "kinesis"
+	// SinkFirehose sends records to a Kinesis Data Firehose delivery stream with PutRecordBatch
+	SinkFirehose Sink = "firehose"
+)
+
+const (
+	// Firehose accepts less data than Kinesis in each request and record
+	firehoseMaximumPutRecordBatchSize = 1024 * 1024 * 4 // 4 MB
+	firehoseMaximumRecordSize         = 1000 * 1024     // 1000 KB
+)
+
+// PutRecordBatchClient contains the firehose PutRecordBatch method call
+type PutRecordBatchClient interface {
+	PutRecordBatch(input *firehose.PutRecordBatchInput) (*firehose.PutRecordBatchOutput, error)
+}
+
+// firehoseClient sends records to a delivery stream as if it were a Kinesis stream, so
+// the rest of the plugin is unchanged. Delivery streams have no shards, so partition
+// keys and explicit hash keys are dropped.
+type firehoseClient struct {
+	client PutRecordBatchClient
+}
+
+// PutRecords sends the records with PutRecordBatch, translating the per record results
+func (c *firehoseClient) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	records := make([]*firehose.Record, 0, len(input.Records))
+	for _, record := range input.Records {
+		records = append(records, &firehose.Record{
+			Data: record.Data,
+		})
+	}
+	response, err := c.client.PutRecordBatch(&firehose.PutRecordBatchInput{
+		DeliveryStreamName: input.StreamName,
+		Records:            records,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := &kinesis.PutRecordsOutput{
+		FailedRecordCount: response.FailedPutCount,
+		Records:           make([]*kinesis.PutRecordsResultEntry, 0, len(response.RequestResponses)),
+	}
+	for _, result := range response.RequestResponses {
+		errorCode := result.ErrorCode
+		if aws.StringValue(errorCode) == firehose.ErrCodeServiceUnavailableException {
+			// the delivery stream's throughput limits were exceeded, retried like a throttled Kinesis record
+			errorCode = aws.String(kinesis.ErrCodeProvisionedThroughputExceededException)
+		}
+		output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+			ErrorCode:      errorCode,
+			ErrorMessage:   result.ErrorMessage,
+			SequenceNumber: result.RecordId,
+		})
+	}
+	return output, nil
+}
+
+// newFirehoseClient creates the client for sending records to a delivery stream
+func newFirehoseClient(roleARN string, externalID string, awsRegion string, endpoint string, signingRegion string, stsEndpoint string, useFIPSEndpoint bool, sdkMaxRetries int, credentialsRefreshBefore time.Duration, logger *logrus.Entry, httpClient *http.Client) (*firehoseClient, error) {
+	svcSess, svcConfig, err := newClientSession(roleARN, externalID, awsRegion, endpoint, signingRegion, stsEndpoint, useFIPSEndpoint, sdkMaxRetries, credentialsRefreshBefore, logger, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	client := firehose.New(svcSess, svcConfig)
+	client.Handlers.Build.PushBackNamed(plugins.CustomUserAgentHandler())
+	client.Handlers.Complete.PushBackNamed(captureRetryAfterHandler)
+	return &firehoseClient{client: client}, nil
+}
+
+// batchSizeLimit returns the most bytes of records a single request may send
+func (outputPlugin *OutputPlugin) batchSizeLimit() int {
+	if outputPlugin.sink == SinkFirehose {
+		return firehoseMaximumPutRecordBatchSize
+	}
+	return maximumPutRecordBatchSize
+}
+
+// batchBytesTarget returns the bytes of records batches are filled to, targetBatchBytes
+// if it is set and within the limit of a single request, less the room for the batch trailer
+func (outputPlugin *OutputPlugin) batchBytesTarget() int {
+	limit := outputPlugin.batchSizeLimit()
+	if outputPlugin.batchTrailer != nil {
+		limit -= batchTrailerReserve
+	}
+	if outputPlugin.targetBatchBytes > 0 && outputPlugin.targetBatchBytes < limit {
+		return outputPlugin.targetBatchBytes
+	}
+	return limit
+}
+
+// recordSizeLimit returns the most bytes a single record may have, including its partition key
+func (outputPlugin *OutputPlugin) recordSizeLimit() int {
+	if outputPlugin.sink == SinkFirehose {
+		return firehoseMaximumRecordSize
+	}
+	return maximumRecordSize
+}
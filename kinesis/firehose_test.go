@@ -0,0 +1,107 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockPutRecordBatcher records each batch, failing the records whose data is in fail
+type mockPutRecordBatcher struct {
+	batches []*firehose.PutRecordBatchInput
+	fail    map[string]bool
+}
+
+func (m *mockPutRecordBatcher) PutRecordBatch(input *firehose.PutRecordBatchInput) (*firehose.PutRecordBatchOutput, error) {
+	m.batches = append(m.batches, input)
+	output := &firehose.PutRecordBatchOutput{
+		FailedPutCount: aws.Int64(0),
+	}
+	for i, record := range input.Records {
+		if m.fail[string(record.Data)] {
+			*output.FailedPutCount++
+			output.RequestResponses = append(output.RequestResponses, &firehose.PutRecordBatchResponseEntry{
+				ErrorCode:    aws.String(firehose.ErrCodeServiceUnavailableException),
+				ErrorMessage: aws.String("Slow down."),
+			})
+			continue
+		}
+		output.RequestResponses = append(output.RequestResponses, &firehose.PutRecordBatchResponseEntry{
+			RecordId: aws.String(string(rune('a' + i))),
+		})
+	}
+	return output, nil
+}
+
+func newFirehoseOutputPlugin(client *mockPutRecordBatcher) *OutputPlugin {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.client = &firehoseClient{client: client}
+	outputPlugin.sink = SinkFirehose
+	outputPlugin.stream = "delivery-stream"
+	outputPlugin.logKey = "log"
+	return outputPlugin
+}
+
+func TestFirehoseFlush(t *testing.T) {
+	client := &mockPutRecordBatcher{}
+	outputPlugin := newFirehoseOutputPlugin(client)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Now()
+	for _, log := range []string{"first", "second"} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte(log),
+		}, &timeStamp)
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+
+	if assert.Len(t, client.batches, 1) {
+		assert.Equal(t, "delivery-stream", aws.StringValue(client.batches[0].DeliveryStreamName))
+		if assert.Len(t, client.batches[0].Records, 2) {
+			assert.Equal(t, "first", string(client.batches[0].Records[0].Data))
+			assert.Equal(t, "second", string(client.batches[0].Records[1].Data))
+		}
+	}
+}
+
+func TestFirehoseFlushRetriesFailedRecords(t *testing.T) {
+	client := &mockPutRecordBatcher{fail: map[string]bool{"throttled": true}}
+	outputPlugin := newFirehoseOutputPlugin(client)
+
+	records := newTestEntries("sent", "throttled")
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected throttled records to be retried")
+	if assert.Len(t, records, 1, "Expected only the failed record to be left") {
+		assert.Equal(t, "throttled", string(records[0].Data))
+	}
+}
+
+func TestFirehoseBatchSizeLimit(t *testing.T) {
+	client := &mockPutRecordBatcher{}
+	outputPlugin := newFirehoseOutputPlugin(client)
+
+	// five records of just under 1000 KB fit a Kinesis request, but not a Firehose one
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 5)
+	for i := 0; i < 5; i++ {
+		records = append(records, &kinesis.PutRecordsRequestEntry{
+			Data:         make([]byte, firehoseMaximumRecordSize-100),
+			PartitionKey: aws.String("key"),
+		})
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	if assert.Len(t, client.batches, 2) {
+		assert.Len(t, client.batches[0].Records, 4)
+		assert.Len(t, client.batches[1].Records, 1)
+	}
+
+	// records are truncated to the Firehose record limit
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": make([]byte, firehoseMaximumRecordSize),
+	}, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, firehoseMaximumRecordSize-8, len(data))
+}
@@ -0,0 +1,110 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+const (
+	// at most this many partially sent chunks are remembered, the oldest are forgotten first
+	maxPartialChunks = 256
+)
+
+var errFlushDeadline = errors.New("flush deadline passed")
+
+func deadlinePassed(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// partialChunks remembers the records of chunks which were only partially sent, so when
+// Fluent Bit retries a chunk only the records which were not sent are sent again
+type partialChunks struct {
+	mutex   sync.Mutex
+	unsent  map[string][]*kinesis.PutRecordsRequestEntry
+	ordered []string
+}
+
+func newPartialChunks() *partialChunks {
+	return &partialChunks{
+		unsent: make(map[string][]*kinesis.PutRecordsRequestEntry),
+	}
+}
+
+// take returns and forgets the unsent records of a chunk
+func (p *partialChunks) take(key string) ([]*kinesis.PutRecordsRequestEntry, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	records, ok := p.unsent[key]
+	if !ok {
+		return nil, false
+	}
+	delete(p.unsent, key)
+	for i, k := range p.ordered {
+		if k == key {
+			p.ordered = append(p.ordered[:i], p.ordered[i+1:]...)
+			break
+		}
+	}
+	return records, true
+}
+
+// keep remembers the unsent records of a chunk, forgetting the oldest chunk if there are too many.
+// A forgotten chunk is sent in full if Fluent Bit retries it. It returns the forgotten records.
+func (p *partialChunks) keep(key string, records []*kinesis.PutRecordsRequestEntry) []*kinesis.PutRecordsRequestEntry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.unsent[key]; !ok {
+		p.ordered = append(p.ordered, key)
+	}
+	p.unsent[key] = records
+	var forgotten []*kinesis.PutRecordsRequestEntry
+	for len(p.ordered) > maxPartialChunks {
+		forgotten = append(forgotten, p.unsent[p.ordered[0]]...)
+		delete(p.unsent, p.ordered[0])
+		p.ordered = p.ordered[1:]
+	}
+	return forgotten
+}
+
+// ChunkKey identifies a chunk by its tag and contents, which are the same each time Fluent Bit retries it
+func ChunkKey(tag string, data []byte) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(tag))
+	hasher.Write([]byte{0})
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// ResumesChunks reports whether partially sent chunks are resumed, which is the case
+// when flush_deadline or partial_ack is set and records are flushed one chunk at a time
+func (outputPlugin *OutputPlugin) ResumesChunks() bool {
+	return (outputPlugin.flushDeadline > 0 || outputPlugin.partialAck) && outputPlugin.workers == nil && outputPlugin.Concurrency == 0
+}
+
+// ResumeChunk returns the records of a chunk which an earlier flush did not send, if any
+func (outputPlugin *OutputPlugin) ResumeChunk(key string) ([]*kinesis.PutRecordsRequestEntry, bool) {
+	return outputPlugin.partialChunks.take(key)
+}
+
+// KeepUnsent remembers the records of a chunk which were not sent, for when Fluent Bit retries it
+func (outputPlugin *OutputPlugin) KeepUnsent(key string, records []*kinesis.PutRecordsRequestEntry) {
+	outputPlugin.DiscardRecords(outputPlugin.partialChunks.keep(key, records))
+}
@@ -0,0 +1,121 @@
+package kinesis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	sent := make(map[string]int)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			// a slow request, which uses up the deadline
+			time.Sleep(50 * time.Millisecond)
+			for _, record := range input.Records {
+				sent[string(record.Data)]++
+			}
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(3)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.flushDeadline = 10 * time.Millisecond
+	outputPlugin.partialChunks = newPartialChunks()
+	outputPlugin.logKey = "log"
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3*maximumRecordsPerPut)
+	timeStamp := time.Now()
+	for i := 0; i < 3*maximumRecordsPerPut; i++ {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte(strconv.Itoa(i)),
+		}, &timeStamp)
+	}
+	chunkKey := ChunkKey("tag", []byte("chunk"))
+
+	// the first batch is sent, then the deadline stops the flush
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+	assert.Len(t, records, 2*maximumRecordsPerPut, "Expected the records which weren't sent to be left for the retry")
+	outputPlugin.KeepUnsent(chunkKey, records)
+
+	// the retry resumes the chunk, sending one more batch per flush
+	resumed, ok := outputPlugin.ResumeChunk(chunkKey)
+	assert.True(t, ok)
+	retCode = outputPlugin.Flush(&resumed)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+	assert.Len(t, resumed, maximumRecordsPerPut)
+	outputPlugin.KeepUnsent(chunkKey, resumed)
+
+	resumed, ok = outputPlugin.ResumeChunk(chunkKey)
+	assert.True(t, ok)
+	retCode = outputPlugin.Flush(&resumed)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.Empty(t, resumed)
+	_, ok = outputPlugin.ResumeChunk(chunkKey)
+	assert.False(t, ok, "Expected the chunk to be forgotten once resumed")
+
+	assert.Len(t, sent, 3*maximumRecordsPerPut)
+	for data, count := range sent {
+		assert.Equal(t, 1, count, "Expected %s to be sent once", data)
+	}
+}
+
+func TestPartialChunksLimit(t *testing.T) {
+	chunks := newPartialChunks()
+	for i := 0; i <= maxPartialChunks; i++ {
+		chunks.keep(ChunkKey("tag", []byte{byte(i), byte(i >> 8)}), nil)
+	}
+	_, ok := chunks.take(ChunkKey("tag", []byte{0, 0}))
+	assert.False(t, ok, "Expected the oldest chunk to be forgotten")
+	_, ok = chunks.take(ChunkKey("tag", []byte{1, 0}))
+	assert.True(t, ok)
+}
+
+func TestFlushDeadlineStartsAfterReplay(t *testing.T) {
+	dir := t.TempDir()
+	previous, _ := newCheckpoints(dir)
+	_, err := previous.save(newTestEntries("unsent"))
+	assert.NoError(t, err)
+	previous.close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			if string(input.Records[0].Data) == "unsent" {
+				// a slow replay, which would use up the deadline
+				time.Sleep(50 * time.Millisecond)
+			}
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(3)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.flushDeadline = 20 * time.Millisecond
+	outputPlugin.partialChunks = newPartialChunks()
+	outputPlugin.checkpoints, err = newCheckpoints(dir)
+	assert.NoError(t, err)
+
+	data := make([]string, 2*maximumRecordsPerPut)
+	for i := range data {
+		data[i] = strconv.Itoa(i)
+	}
+	records := newTestEntries(data...)
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected both batches of the chunk to be sent within its deadline")
+	assert.Empty(t, records)
+}
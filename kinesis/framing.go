@@ -0,0 +1,65 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"encoding/binary"
+)
+
+// FramingType indicates how each event is framed within the data sent to Kinesis
+type FramingType string
+
+const (
+	// FramingNone sends each event as is
+	FramingNone FramingType = "none"
+	// FramingLengthPrefixed prefixes each event with its length as a 4 byte big-endian unsigned integer
+	FramingLengthPrefixed FramingType = "length_prefixed"
+
+	lengthPrefixSize = 4
+	// record_prefix and record_suffix together may add at most this many bytes to each event
+	maxRecordWrapperSize = 64 * 1024
+)
+
+// frameOverhead returns the number of bytes framing, the codec header, record_prefix and record_suffix add to each event
+func (outputPlugin *OutputPlugin) frameOverhead() int {
+	overhead := len(outputPlugin.recordPrefix) + len(outputPlugin.recordSuffix)
+	if outputPlugin.codecHeaderEnabled {
+		overhead += codecHeaderSize
+	}
+	if outputPlugin.framing == FramingLengthPrefixed {
+		overhead += lengthPrefixSize
+	}
+	return overhead
+}
+
+// frame wraps the final bytes of an event in the record_prefix and record_suffix,
+// preceded by the codec header byte if enabled, then frames the result according to the configured framing
+func (outputPlugin *OutputPlugin) frame(data []byte, codec byte) []byte {
+	if outputPlugin.codecHeaderEnabled || len(outputPlugin.recordPrefix) > 0 || len(outputPlugin.recordSuffix) > 0 {
+		wrapped := make([]byte, 0, codecHeaderSize+len(outputPlugin.recordPrefix)+len(data)+len(outputPlugin.recordSuffix))
+		if outputPlugin.codecHeaderEnabled {
+			wrapped = append(wrapped, codec)
+		}
+		wrapped = append(wrapped, outputPlugin.recordPrefix...)
+		wrapped = append(wrapped, data...)
+		data = append(wrapped, outputPlugin.recordSuffix...)
+	}
+	if outputPlugin.framing != FramingLengthPrefixed {
+		return data
+	}
+	framed := make([]byte, lengthPrefixSize+len(data))
+	binary.BigEndian.PutUint32(framed, uint32(len(data)))
+	copy(framed[lengthPrefixSize:], data)
+	return framed
+}
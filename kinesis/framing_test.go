@@ -0,0 +1,133 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// splitLengthPrefixed splits concatenated length prefixed events, as a consumer would
+func splitLengthPrefixed(data []byte) ([][]byte, error) {
+	var events [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthPrefixSize {
+			return nil, errors.New("truncated length prefix")
+		}
+		length := binary.BigEndian.Uint32(data)
+		data = data[lengthPrefixSize:]
+		if uint32(len(data)) < length {
+			return nil, errors.New("truncated event")
+		}
+		events = append(events, data[:length])
+		data = data[length:]
+	}
+	return events, nil
+}
+
+func TestLengthPrefixedFramingRoundTrip(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.framing = FramingLengthPrefixed
+	outputPlugin.logKey = "log"
+
+	// events containing newlines can't be split on a separator
+	logs := []string{"first\nevent", "", "third event\n\n"}
+	var packed []byte
+	for _, log := range logs {
+		data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+			"log": []byte(log),
+		}, 0)
+		assert.NoError(t, err)
+		packed = append(packed, data...)
+	}
+
+	events, err := splitLengthPrefixed(packed)
+	assert.NoError(t, err)
+	assert.Len(t, events, len(logs))
+	for i, log := range logs {
+		assert.Equal(t, log, string(events[i]))
+	}
+}
+
+func TestLengthPrefixedFramingWithCompression(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.framing = FramingLengthPrefixed
+	outputPlugin.compression = CompressionGzip
+	outputPlugin.logKey = "log"
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": []byte("compressed event"),
+	}, 0)
+	assert.NoError(t, err)
+
+	events, err := splitLengthPrefixed(data)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	// the frame wraps the compressed payload
+	reader, err := gzip.NewReader(bytes.NewReader(events[0]))
+	assert.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "compressed event", string(decompressed))
+}
+
+func TestLengthPrefixedFramingTruncation(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.framing = FramingLengthPrefixed
+	outputPlugin.logKey = "log"
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": bytes.Repeat([]byte("a"), maximumRecordSize),
+	}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, maximumRecordSize-10, len(data), "Expected the framed record to fit the record size limit")
+
+	events, err := splitLengthPrefixed(data)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.True(t, bytes.HasSuffix(events[0], []byte(truncatedSuffix)))
+}
+
+func TestRecordPrefixAndSuffix(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.recordPrefix = []byte{0x01, 'S', 'R', 'C'}
+	outputPlugin.recordSuffix = []byte{0x00, 0xff}
+	outputPlugin.logKey = "log"
+	outputPlugin.appendNewline = true
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": []byte("event"),
+	}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x01SRCevent\n\x00\xff"), data)
+
+	// the length prefix covers the wrapped event
+	outputPlugin.framing = FramingLengthPrefixed
+	data, err = outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": []byte("event"),
+	}, 0)
+	assert.NoError(t, err)
+	events, err := splitLengthPrefixed(data)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("\x01SRCevent\n\x00\xff")}, events)
+}
+
+func TestRecordPrefixAndSuffixTruncation(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.recordPrefix = []byte("<")
+	outputPlugin.recordSuffix = []byte(">")
+	outputPlugin.logKey = "log"
+
+	data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+		"log": bytes.Repeat([]byte("a"), maximumRecordSize),
+	}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, maximumRecordSize-10, len(data), "Expected the wrapped record to fit the record size limit")
+	assert.True(t, bytes.HasPrefix(data, []byte("<")))
+	assert.True(t, bytes.HasSuffix(data, []byte(truncatedSuffix+">")))
+}
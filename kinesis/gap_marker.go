@@ -0,0 +1,54 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Reasons a gap marker gives for the record it replaces
+const (
+	gapMaxFields     = "max_fields"
+	gapDropWhere     = "drop_where"
+	gapDedup         = "dedup"
+	gapMarshalError  = "marshal_error"
+	gapNoProjection  = "projection_no_match"
+	gapProcessError  = "process_error"
+	gapAggregateFail = "aggregation_error"
+)
+
+// markGap sends a marker record in place of a record which took a sequence number, but
+// was skipped. The marker carries the skipped record's sequence number rather than
+// taking one of its own, so the sequence stays contiguous with the marker in the gap.
+func (outputPlugin *OutputPlugin) markGap(records *[]*kinesis.PutRecordsRequestEntry, sequence uint64, reason string) {
+	if outputPlugin.gapMarker == "" || outputPlugin.sequenceKey == "" {
+		return
+	}
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(map[string]interface{}{
+		outputPlugin.sequenceKey: sequence,
+		outputPlugin.gapMarker:   reason,
+	})
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to marshal gap marker for sequence number %d: %v\n", sequence, err)
+		return
+	}
+	// markers are never aggregated, compressed or framed, so consumers can always read them
+	*records = append(*records, &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(outputPlugin.stringGen.RandomString()),
+	})
+}
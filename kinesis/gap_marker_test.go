@@ -0,0 +1,60 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGapMarker(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.sequenceKey = "seq"
+	outputPlugin.sequence = new(uint64)
+	outputPlugin.gapMarker = "gap"
+	outputPlugin.dropWhere, _ = parseFieldComparison("level=debug")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	for _, record := range []map[interface{}]interface{}{
+		{"log": []byte("first")},
+		// skipped, since NaN can't be marshaled
+		{"log": math.NaN()},
+		{"log": []byte("third"), "level": "debug"},
+		{"log": []byte("fourth")},
+	} {
+		outputPlugin.AddRecord(&records, record, &timeStamp)
+	}
+
+	type decodedRecord struct {
+		Seq uint64 `json:"seq"`
+		Gap string `json:"gap"`
+		Log string `json:"log"`
+	}
+	var decoded []decodedRecord
+	for _, record := range records {
+		var d decodedRecord
+		assert.NoError(t, json.Unmarshal(record.Data, &d))
+		decoded = append(decoded, d)
+	}
+	assert.Equal(t, []decodedRecord{
+		{Seq: 1, Log: "first"},
+		{Seq: 2, Gap: gapMarshalError},
+		{Seq: 3, Gap: gapDropWhere},
+		{Seq: 4, Log: "fourth"},
+	}, decoded, "Expected a marker with the sequence number of each skipped record")
+	assert.NotEmpty(t, *records[1].PartitionKey)
+}
+
+func TestGapMarkerNeedsSequenceKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.gapMarker = "gap"
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": math.NaN()}, &timeStamp)
+	assert.Empty(t, records, "Expected no marker without sequence numbers")
+}
@@ -0,0 +1,98 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// keyedRecord is a processed record waiting to be aggregated
+type keyedRecord struct {
+	partitionKey    string
+	hasPartitionKey bool
+	data            []byte
+}
+
+// partitionKeyGroups buffers the records of a chunk by partition key, so that each
+// key is aggregated on its own. Records without a partition key form one group.
+type partitionKeyGroups struct {
+	// keys in the order they were first seen, so output is deterministic
+	order   []string
+	groups  map[string][]keyedRecord
+	unkeyed []keyedRecord
+}
+
+func newPartitionKeyGroups() *partitionKeyGroups {
+	return &partitionKeyGroups{
+		groups: make(map[string][]keyedRecord),
+	}
+}
+
+func (g *partitionKeyGroups) add(partitionKey string, hasPartitionKey bool, data []byte) {
+	record := keyedRecord{
+		partitionKey:    partitionKey,
+		hasPartitionKey: hasPartitionKey,
+		data:            data,
+	}
+	if !hasPartitionKey {
+		g.unkeyed = append(g.unkeyed, record)
+		return
+	}
+	if _, ok := g.groups[partitionKey]; !ok {
+		g.order = append(g.order, partitionKey)
+	}
+	g.groups[partitionKey] = append(g.groups[partitionKey], record)
+}
+
+// take removes every buffered group, in the order their keys were first seen
+func (g *partitionKeyGroups) take() [][]keyedRecord {
+	groups := make([][]keyedRecord, 0, len(g.order)+1)
+	for _, key := range g.order {
+		groups = append(groups, g.groups[key])
+	}
+	if len(g.unkeyed) > 0 {
+		groups = append(groups, g.unkeyed)
+	}
+	g.order = nil
+	g.groups = make(map[string][]keyedRecord)
+	g.unkeyed = nil
+	return groups
+}
+
+// aggregateGroups aggregates each buffered group separately, so every aggregated
+// record holds a single partition key and is routed to the shard for that key
+func (outputPlugin *OutputPlugin) aggregateGroups(records *[]*kinesis.PutRecordsRequestEntry) error {
+	for _, group := range outputPlugin.groups.take() {
+		for _, record := range group {
+			aggRecord, err := outputPlugin.aggregator.AddRecord(record.partitionKey, record.hasPartitionKey, record.data)
+			if err != nil {
+				outputPlugin.logger.Errorf("Failed to aggregate record %v\n", err)
+				// discard this single bad record instead and let the batch continue
+				continue
+			}
+			if aggRecord != nil {
+				*records = append(*records, aggRecord)
+			}
+		}
+
+		aggRecord, err := outputPlugin.aggregator.AggregateRecords()
+		if err != nil {
+			return err
+		}
+		if aggRecord != nil {
+			*records = append(*records, aggRecord)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/aggregate"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// deaggregate decodes a KCL aggregated record, returning its partition key table and records
+func deaggregate(t *testing.T, entry *kinesis.PutRecordsRequestEntry) ([]string, []*aggregate.Record) {
+	// strip the 4 byte magic number and the 16 byte MD5 checksum
+	data := entry.Data[4 : len(entry.Data)-16]
+	agg := &aggregate.AggregatedRecord{}
+	assert.NoError(t, proto.Unmarshal(data, agg))
+	return agg.PartitionKeyTable, agg.Records
+}
+
+func TestGroupByPartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, true)
+	outputPlugin.partitionKey = "key"
+	outputPlugin.groups = newPartitionKeyGroups()
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	for _, key := range []string{"a", "b", "a", "b", "a"} {
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"key": []byte(key),
+		}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+	}
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"other": []byte("no key"),
+	}, &timeStamp)
+	assert.Empty(t, records, "Expected records to be buffered until the chunk is complete")
+
+	retCode := outputPlugin.FlushAggregatedRecords(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected FlushAggregatedRecords return code to be FLB_OK")
+	if !assert.Len(t, records, 3, "Expected an aggregated record per partition key") {
+		return
+	}
+
+	for i, expected := range map[int]string{0: "a", 1: "b"} {
+		keys, aggregated := deaggregate(t, records[i])
+		assert.Equal(t, []string{expected}, keys)
+		assert.Equal(t, expected, aws.StringValue(records[i].PartitionKey))
+		if expected == "a" {
+			assert.Len(t, aggregated, 3)
+		} else {
+			assert.Len(t, aggregated, 2)
+		}
+	}
+	_, aggregated := deaggregate(t, records[2])
+	assert.Len(t, aggregated, 1, "Expected records without a partition key to be aggregated together")
+
+	// the groups are emptied once aggregated
+	records = records[:0]
+	outputPlugin.FlushAggregatedRecords(&records)
+	assert.Empty(t, records)
+}
+
+func TestAggregationPartitionStrategy(t *testing.T) {
+	for _, strategy := range []AggregationPartitionStrategy{AggregationPartitionRandom, AggregationPartitionRoundRobin} {
+		outputPlugin, _ := newMockOutputPlugin(nil, true)
+		// every record has the same key, which would otherwise make its shard hot
+		outputPlugin.partitionKey = "key"
+		outputPlugin.roundRobinKeys, _ = generateRoundRobinKeys(4)
+		if strategy == AggregationPartitionRandom {
+			outputPlugin.aggregator.SetRoutingKey(outputPlugin.stringGen.RandomString)
+		} else {
+			outputPlugin.aggregator.SetRoutingKey(outputPlugin.nextRoundRobinKey)
+		}
+
+		shardCounts := make(map[int]int)
+		timeStamp := time.Now()
+		for chunk := 0; chunk < 400; chunk++ {
+			records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+			outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+				"key": []byte("hot"),
+			}, &timeStamp)
+			outputPlugin.FlushAggregatedRecords(&records)
+			if !assert.Len(t, records, 1) {
+				return
+			}
+			keys, _ := deaggregate(t, records[0])
+			assert.Equal(t, []string{"hot"}, keys, "Expected user records to keep their partition key")
+			shardCounts[kinesisShard(aws.StringValue(records[0].PartitionKey), 4)]++
+		}
+
+		assert.Len(t, shardCounts, 4, "Expected %s aggregates to reach every shard", strategy)
+		for shard, count := range shardCounts {
+			if strategy == AggregationPartitionRoundRobin {
+				assert.Equal(t, 100, count, "shard %d", shard)
+			} else {
+				assert.InDelta(t, 100, count, 50, "shard %d", shard)
+			}
+		}
+	}
+}
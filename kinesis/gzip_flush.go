@@ -0,0 +1,62 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// GzipFlushMode decides where gzip compressed records can be decompressed up to
+type GzipFlushMode string
+
+const (
+	// GzipFlushFull compresses each record in one go, so it only decompresses once complete
+	GzipFlushFull GzipFlushMode = "full"
+	// GzipFlushSync ends a deflate block with a sync flush after every gzipSyncFlushSize bytes
+	// of input, so consumers can decompress a record incrementally, boundary by boundary
+	GzipFlushSync GzipFlushMode = "sync"
+
+	// the bytes of input between sync flush boundaries
+	gzipSyncFlushSize = 16 * 1024
+)
+
+// gzipSyncCompress is gzipCompress, with a sync flush (Z_SYNC_FLUSH) after each
+// gzipSyncFlushSize bytes of input. Everything written before a boundary can be
+// decompressed from the bytes up to it, at the cost of a few bytes per boundary.
+func gzipSyncCompress(data []byte) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("No data to compress.  'nil' value passed as data")
+	}
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	for start := 0; start < len(data); start += gzipSyncFlushSize {
+		end := start + gzipSyncFlushSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := zw.Write(data[start:end]); err != nil {
+			return data, err
+		}
+		if err := zw.Flush(); err != nil {
+			return data, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return data, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncFlushMarker ends the empty stored block a sync flush writes
+var syncFlushMarker = []byte{0x00, 0x00, 0xff, 0xff}
+
+func TestGzipSyncFlushDecompressesIncrementally(t *testing.T) {
+	var data []byte
+	for i := 0; len(data) < 5*gzipSyncFlushSize+100; i++ {
+		data = append(data, fmt.Sprintf(`{"log":"GET /orders/%d 200","latency_ms":%d}`+"\n", i, i%97)...)
+	}
+	compressed, err := gzipSyncCompress(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// split the compressed record after each boundary
+	var segments [][]byte
+	for rest := compressed; len(rest) > 0; {
+		end := bytes.Index(rest, syncFlushMarker)
+		if end < 0 {
+			segments = append(segments, rest)
+			break
+		}
+		end += len(syncFlushMarker)
+		segments = append(segments, rest[:end])
+		rest = rest[end:]
+	}
+	blocks := (len(data) + gzipSyncFlushSize - 1) / gzipSyncFlushSize
+	// one segment per boundary, and the trailer
+	if !assert.Len(t, segments, blocks+1) {
+		return
+	}
+
+	// a segment is only written once the data before it has been decompressed
+	reader, writer := io.Pipe()
+	allow := make(chan struct{}, len(segments))
+	go func() {
+		for _, segment := range segments {
+			<-allow
+			writer.Write(segment)
+		}
+		writer.Close()
+	}()
+
+	allow <- struct{}{}
+	decompressed := make(chan []byte, 1)
+	go func() {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			close(decompressed)
+			return
+		}
+		for start := 0; start < len(data); start += gzipSyncFlushSize {
+			end := start + gzipSyncFlushSize
+			if end > len(data) {
+				end = len(data)
+			}
+			block := make([]byte, end-start)
+			if _, err := io.ReadFull(zr, block); err != nil {
+				close(decompressed)
+				return
+			}
+			decompressed <- block
+		}
+		close(decompressed)
+	}()
+
+	for start := 0; start < len(data); start += gzipSyncFlushSize {
+		select {
+		case block, ok := <-decompressed:
+			if !assert.True(t, ok, "Expected the block at %d to decompress", start) {
+				return
+			}
+			assert.Equal(t, data[start:start+len(block)], block)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the block at %d to decompress without the rest of the record", start)
+		}
+		allow <- struct{}{}
+	}
+}
+
+func TestGzipFlushModes(t *testing.T) {
+	for _, syncFlush := range []bool{false, true} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.compression = CompressionGzip
+		outputPlugin.gzipSyncFlush = syncFlush
+		outputPlugin.logKey = "log"
+
+		log := bytes.Repeat([]byte("GET /health 200\n"), 3*gzipSyncFlushSize/16)
+		data, err := outputPlugin.processRecord(map[interface{}]interface{}{
+			"log": log,
+		}, 0)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			decoded, err := io.ReadAll(zr)
+			assert.NoError(t, err)
+			assert.Equal(t, log, decoded, "sync flush %v", syncFlush)
+		}
+		if syncFlush {
+			assert.Equal(t, 3, bytes.Count(data, syncFlushMarker))
+		}
+	}
+}
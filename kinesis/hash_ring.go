@@ -0,0 +1,94 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+const (
+	// the sum of the consistent_hash_ring weights may be at most this many virtual nodes
+	maxHashRingNodes = 10000
+)
+
+type hashRingNode struct {
+	// the position of the virtual node in the hash key space
+	point [md5.Size]byte
+	// point as the decimal explicit hash key Kinesis expects
+	explicitHashKey string
+}
+
+// hashRing maps partition keys to the explicit hash key of a virtual node on a
+// consistent hash ring. Member i of the ring has weights[i] virtual nodes, placed
+// at the MD5 hashes of "member-i-j", so a member's nodes don't depend on the
+// other members. A record is sent with the explicit hash key of the first node
+// at or after the MD5 hash of its partition key, the same hash Kinesis uses.
+//
+// Explicit hash keys are points in the hash key space rather than shard IDs, so
+// a tenant keeps its key across reshards and lands on whichever shard owns it.
+// Adding a member, or changing the weight of one, only moves the tenants whose
+// nearest node is added or removed. Members are identified by their position,
+// so a member is removed by setting its weight to 0 rather than deleting it.
+type hashRing struct {
+	nodes []hashRingNode
+}
+
+func newHashRing(weights []int) (*hashRing, error) {
+	total := 0
+	for _, weight := range weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("consistent_hash_ring weights must not be negative")
+		}
+		total += weight
+		if total > maxHashRingNodes {
+			return nil, fmt.Errorf("consistent_hash_ring weights must add up to at most %d virtual nodes", maxHashRingNodes)
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("consistent_hash_ring must have at least one member with a positive weight")
+	}
+
+	nodes := make([]hashRingNode, 0, total)
+	for member, weight := range weights {
+		for i := 0; i < weight; i++ {
+			point := md5.Sum([]byte("member-" + strconv.Itoa(member) + "-" + strconv.Itoa(i)))
+			nodes = append(nodes, hashRingNode{
+				point:           point,
+				explicitHashKey: new(big.Int).SetBytes(point[:]).String(),
+			})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].point[:], nodes[j].point[:]) < 0
+	})
+	return &hashRing{nodes: nodes}, nil
+}
+
+// explicitHashKey returns the explicit hash key records with this partition key are sent with
+func (ring *hashRing) explicitHashKey(partitionKey string) string {
+	sum := md5.Sum([]byte(partitionKey))
+	i := sort.Search(len(ring.nodes), func(i int) bool {
+		return bytes.Compare(ring.nodes[i].point[:], sum[:]) >= 0
+	})
+	if i == len(ring.nodes) {
+		// past the last node, so wrap around to the first
+		i = 0
+	}
+	return ring.nodes[i].explicitHashKey
+}
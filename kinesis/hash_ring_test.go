@@ -0,0 +1,73 @@
+package kinesis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRingStableAsMembersChange(t *testing.T) {
+	ring, err := newHashRing([]int{50, 50, 50})
+	assert.NoError(t, err)
+	// a fourth member is added, and the weight of the second is lowered
+	changed, err := newHashRing([]int{50, 25, 50, 50})
+	assert.NoError(t, err)
+
+	tenants := 2000
+	moved := 0
+	for i := 0; i < tenants; i++ {
+		tenant := "tenant-" + strconv.Itoa(i)
+		key := ring.explicitHashKey(tenant)
+		assert.Equal(t, key, ring.explicitHashKey(tenant), "Expected the same tenant to always get the same key")
+		if changed.explicitHashKey(tenant) != key {
+			moved++
+		}
+	}
+	// about a quarter move to the new member, and a twelfth off the second member
+	assert.Less(t, moved, tenants/2, "Expected most tenants to keep their explicit hash key")
+	assert.Greater(t, moved, 0)
+}
+
+func TestHashRingRemovedMember(t *testing.T) {
+	ring, _ := newHashRing([]int{10, 10, 10})
+	removed, _ := newHashRing([]int{10, 0, 10})
+
+	kept := make(map[string]bool)
+	for _, node := range removed.nodes {
+		kept[node.explicitHashKey] = true
+	}
+	for i := 0; i < 1000; i++ {
+		tenant := "tenant-" + strconv.Itoa(i)
+		if key := ring.explicitHashKey(tenant); kept[key] {
+			assert.Equal(t, key, removed.explicitHashKey(tenant), "Expected only tenants of the removed member to move")
+		}
+	}
+}
+
+func TestNewHashRingInvalidWeights(t *testing.T) {
+	_, err := newHashRing([]int{0, 0})
+	assert.Error(t, err)
+	_, err = newHashRing([]int{maxHashRingNodes, 1})
+	assert.Error(t, err)
+}
+
+func TestAddRecordConsistentHashRing(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "tenant"
+	outputPlugin.hashRing, _ = newHashRing([]int{10, 10})
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"tenant": []byte("tenant-a")}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"tenant": []byte("tenant-a")}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": []byte("no tenant")}, &timeStamp)
+
+	assert.Len(t, records, 3)
+	assert.Equal(t, outputPlugin.hashRing.explicitHashKey("tenant-a"), aws.StringValue(records[0].ExplicitHashKey))
+	assert.Equal(t, aws.StringValue(records[0].ExplicitHashKey), aws.StringValue(records[1].ExplicitHashKey))
+	assert.Nil(t, records[2].ExplicitHashKey, "Expected records without a partition key to keep their random key")
+}
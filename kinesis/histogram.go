@@ -0,0 +1,166 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Dimensions of the count-min sketch, these give an error of roughly
+	// e/sketchWidth of the total count with probability 1 - e^-sketchDepth
+	sketchWidth = 2048
+	sketchDepth = 4
+
+	defaultHistogramInterval = time.Minute
+)
+
+// partitionKeyCount is the estimated number of records seen for a partition key
+type partitionKeyCount struct {
+	key   string
+	count uint64
+}
+
+// partitionKeyHistogram tracks the hottest partition keys using bounded memory.
+// Per key counts are estimated with a count-min sketch, and only the top N keys
+// by estimated count are remembered.
+type partitionKeyHistogram struct {
+	mutex  sync.Mutex
+	sketch [sketchDepth][sketchWidth]uint64
+	top    map[string]uint64
+	topN   int
+	// total number of records, including those without a resolved partition key
+	total uint64
+}
+
+func newPartitionKeyHistogram(topN int) *partitionKeyHistogram {
+	return &partitionKeyHistogram{
+		top:  make(map[string]uint64, topN),
+		topN: topN,
+	}
+}
+
+// add records a single record for the given partition key. Records which did not
+// resolve a partition key only count towards the total.
+func (h *partitionKeyHistogram) add(partitionKey string, hasPartitionKey bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.total++
+	if !hasPartitionKey {
+		return
+	}
+
+	estimate := h.increment(partitionKey)
+
+	if _, ok := h.top[partitionKey]; ok || len(h.top) < h.topN {
+		h.top[partitionKey] = estimate
+		return
+	}
+
+	// Evict the coldest tracked key if this one is now hotter
+	coldestKey, coldestCount := "", uint64(0)
+	for k, c := range h.top {
+		if coldestKey == "" || c < coldestCount {
+			coldestKey, coldestCount = k, c
+		}
+	}
+	if estimate > coldestCount {
+		delete(h.top, coldestKey)
+		h.top[partitionKey] = estimate
+	}
+}
+
+// increment adds one to each row of the sketch and returns the new estimated count
+func (h *partitionKeyHistogram) increment(partitionKey string) uint64 {
+	var estimate uint64
+	for row := 0; row < sketchDepth; row++ {
+		hasher := fnv.New64a()
+		hasher.Write([]byte{byte(row)})
+		hasher.Write([]byte(partitionKey))
+		col := hasher.Sum64() % sketchWidth
+
+		h.sketch[row][col]++
+		if row == 0 || h.sketch[row][col] < estimate {
+			estimate = h.sketch[row][col]
+		}
+	}
+	return estimate
+}
+
+// rollup returns the hottest partition keys in descending order of volume along with
+// the total number of records seen, and resets the histogram for the next interval.
+func (h *partitionKeyHistogram) rollup() ([]partitionKeyCount, uint64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	counts := make([]partitionKeyCount, 0, len(h.top))
+	for k, c := range h.top {
+		counts = append(counts, partitionKeyCount{key: k, count: c})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count == counts[j].count {
+			return counts[i].key < counts[j].key
+		}
+		return counts[i].count > counts[j].count
+	})
+	total := h.total
+
+	h.sketch = [sketchDepth][sketchWidth]uint64{}
+	h.top = make(map[string]uint64, h.topN)
+	h.total = 0
+
+	return counts, total
+}
+
+// logPeriodically logs a rollup of the hottest partition keys every interval,
+// until the returned channel is closed.
+func (h *partitionKeyHistogram) logPeriodically(interval time.Duration, logger *logrus.Entry) chan struct{} {
+	if interval <= 0 {
+		interval = defaultHistogramInterval
+	}
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				counts, total := h.rollup()
+				if total > 0 {
+					logger.Infof("Partition key histogram over the last %s: %s\n", interval, formatRollup(counts, total))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// formatRollup renders the hottest keys with their estimated share of the total records
+func formatRollup(counts []partitionKeyCount, total uint64) string {
+	parts := make([]string, 0, len(counts))
+	for _, c := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d (%.1f%%)", c.key, c.count, float64(c.count)*100/float64(total)))
+	}
+	return fmt.Sprintf("%d records, top keys: [%s]", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,63 @@
+package kinesis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionKeyHistogramRollup(t *testing.T) {
+	histogram := newPartitionKeyHistogram(3)
+
+	// one hot key, a couple of warm keys and a long tail of cold keys
+	for i := 0; i < 1000; i++ {
+		histogram.add("hot", true)
+	}
+	for i := 0; i < 200; i++ {
+		histogram.add("warm-1", true)
+		histogram.add("warm-2", true)
+	}
+	for i := 0; i < 500; i++ {
+		histogram.add(fmt.Sprintf("cold-%d", i), true)
+	}
+	histogram.add("", false)
+
+	counts, total := histogram.rollup()
+	assert.Equal(t, uint64(1901), total, "Expected total to include every record")
+	assert.Len(t, counts, 3, "Expected only the top N keys to be tracked")
+	assert.Equal(t, "hot", counts[0].key, "Expected hottest key to be first in the rollup")
+	assert.Equal(t, uint64(1000), counts[0].count)
+	assert.ElementsMatch(t, []string{"warm-1", "warm-2"}, []string{counts[1].key, counts[2].key})
+
+	counts, total = histogram.rollup()
+	assert.Equal(t, uint64(0), total, "Expected rollup to reset the histogram")
+	assert.Len(t, counts, 0)
+}
+
+func TestAddRecordPartitionKeyHistogram(t *testing.T) {
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "key"
+	outputPlugin.histogram = newPartitionKeyHistogram(2)
+
+	timeStamp := time.Now()
+	for i := 0; i < 10; i++ {
+		key := "skewed"
+		if i%5 == 0 {
+			key = fmt.Sprintf("other-%d", i)
+		}
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"key": []byte(key),
+		}, &timeStamp)
+	}
+
+	counts, total := outputPlugin.histogram.rollup()
+	assert.Equal(t, uint64(10), total)
+	assert.Equal(t, "skewed", counts[0].key, "Expected hottest key to be first in the rollup")
+	assert.Equal(t, uint64(8), counts[0].count)
+	assert.Contains(t, formatRollup(counts, total), "skewed=8 (80.0%)")
+}
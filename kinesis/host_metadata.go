@@ -0,0 +1,90 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"net"
+	"os"
+)
+
+const (
+	defaultHostnameKey = "hostname"
+	defaultHostIPKey   = "host_ip"
+)
+
+// hostMetadata is added to every record when add_host_metadata is enabled.
+// It is resolved once when the plugin is created.
+type hostMetadata struct {
+	hostnameKey string
+	hostname    string
+	ipKey       string
+	ip          string
+}
+
+// resolveHostMetadata looks up the hostname and primary IP of the host. The primary IP is
+// the first IPv4 address, in interface order, which is not loopback or link-local. If there
+// is none, the first such IPv6 address is used instead. Lookup failures leave the value empty.
+func resolveHostMetadata(hostnameKey, ipKey string, hostname func() (string, error), interfaceAddrs func() ([]net.Addr, error)) *hostMetadata {
+	if hostnameKey == "" {
+		hostnameKey = defaultHostnameKey
+	}
+	if ipKey == "" {
+		ipKey = defaultHostIPKey
+	}
+
+	metadata := &hostMetadata{
+		hostnameKey: hostnameKey,
+		ipKey:       ipKey,
+	}
+	if name, err := hostname(); err == nil {
+		metadata.hostname = name
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return metadata
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		if ip.To4() != nil {
+			metadata.ip = ip.String()
+			return metadata
+		}
+		if ipv6 == nil {
+			ipv6 = ip
+		}
+	}
+	if ipv6 != nil {
+		metadata.ip = ipv6.String()
+	}
+	return metadata
+}
+
+func newHostMetadata(hostnameKey, ipKey string) *hostMetadata {
+	return resolveHostMetadata(hostnameKey, ipKey, os.Hostname, net.InterfaceAddrs)
+}
+
+// addTo sets the host metadata on a record
+func (m *hostMetadata) addTo(record map[interface{}]interface{}) {
+	record[m.hostnameKey] = m.hostname
+	record[m.ipKey] = m.ip
+}
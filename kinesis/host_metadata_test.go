@@ -0,0 +1,62 @@
+package kinesis
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func stubHostname(name string) func() (string, error) {
+	return func() (string, error) {
+		return name, nil
+	}
+}
+
+func stubInterfaceAddrs(cidrs ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		addrs := make([]net.Addr, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			ip, ipNet, _ := net.ParseCIDR(cidr)
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+}
+
+func TestResolveHostMetadata(t *testing.T) {
+	metadata := resolveHostMetadata("", "", stubHostname("web-1"), stubInterfaceAddrs(
+		"127.0.0.1/8", "::1/128", "fe80::1/64", "2001:db8::10/64", "10.0.0.5/24", "192.168.1.5/24"))
+	assert.Equal(t, "hostname", metadata.hostnameKey)
+	assert.Equal(t, "web-1", metadata.hostname)
+	assert.Equal(t, "host_ip", metadata.ipKey)
+	assert.Equal(t, "10.0.0.5", metadata.ip, "Expected the first non-loopback IPv4 address to be preferred")
+
+	metadata = resolveHostMetadata("host", "ip", stubHostname("web-2"), stubInterfaceAddrs(
+		"127.0.0.1/8", "fe80::1/64", "2001:db8::10/64"))
+	assert.Equal(t, "2001:db8::10", metadata.ip, "Expected a global IPv6 address when there is no IPv4 address")
+
+	metadata = resolveHostMetadata("", "", func() (string, error) {
+		return "", errors.New("no hostname")
+	}, stubInterfaceAddrs("127.0.0.1/8"))
+	assert.Equal(t, "", metadata.hostname)
+	assert.Equal(t, "", metadata.ip)
+}
+
+func TestAddRecordWithHostMetadata(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.hostMetadata = resolveHostMetadata("host", "ip", stubHostname("web-1"), stubInterfaceAddrs("10.0.0.5/24"))
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log": []byte("message"),
+	}, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.JSONEq(t, `{"log":"message","host":"web-1","ip":"10.0.0.5"}`, string(records[0].Data))
+}
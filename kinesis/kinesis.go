@@ -20,21 +20,26 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-kinesis-firehose-for-fluent-bit/plugins"
 	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/aggregate"
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
 	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/util"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
@@ -46,11 +51,16 @@ import (
 )
 
 const (
-	truncatedSuffix = "[Truncated...]"
-	truncationReductionPercent = 90
+	truncatedSuffix                  = "[Truncated...]"
+	truncationReductionPercent       = 90
 	truncationCompressionMaxAttempts = 10
+	// AddRecord waits at most this long for max_ingest_records_per_sec before returning a retry
+	maxIngestWait = time.Second
 )
 
+// Close waits this long for in-flight flushes to finish
+var closeTimeout = 30 * time.Second
+
 const (
 	// Kinesis API Limit https://docs.aws.amazon.com/sdk-for-go/api/service/kinesis/#Kinesis.PutRecords
 	maximumRecordsPerPut      = 500
@@ -92,92 +102,1170 @@ type OutputPlugin struct {
 	// Otherwise a random string will be used.
 	// Partition key decides in which shard of your stream the data belongs to
 	partitionKey string
+	// Decides whether the partition key comes from partitionKey or is derived from the record
+	partitionKeySource PartitionKeySource
+	recordHasher       func() hash.Hash
+	// If non-nil, partition key field values are replaced with their hash
+	partitionKeyHash func(value string) string
+	// If non-nil, the partition keys derived from partition key field values are cached
+	partitionKeyCache *partitionKeyCache
+	// The pool of partition keys for PartitionKeySourceRoundRobin, and the index of the next one
+	roundRobinKeys []string
+	roundRobinNext uint32
+	// The partition keys PartitionKeySourceWeighted picks from
+	weightedKeys *weightedKeys
+	// The bucket duration for PartitionKeySourceTimeBucket
+	timeBucket time.Duration
+	// If true, each event starts with a byte identifying its compression codec
+	codecHeaderEnabled bool
+	// If positive, Flush stops sending new batches after this long, and the records
+	// it did not send are kept for when Fluent Bit retries the chunk
+	flushDeadline time.Duration
+	partialChunks *partialChunks
+	// If true, a flush which leaves failed records unsent returns FLB_RETRY, and only
+	// those records are sent again
+	partialAck bool
+	// If true, batches and compression buffers are taken from pools shared by every flush
+	recordPool bool
+	// If non-nil, the metadata of each record is also sent to a separate stream
+	metadata *mirror
+	// If non-nil, the record's timestamp field is normalized to a single key and format
+	timestampNormalizer *timestampNormalizer
+	// Chunks are retried while the spill queue is above the high watermark, until it
+	// drains below the low watermark
+	spillHighWatermark int64
+	spillLowWatermark  int64
+	spillThrottled     int32
+	// The kind of stream records are sent to, a Kinesis Data Stream if empty
+	sink Sink
+	// If set, each record is given the next value of sequence under this key.
+	// sequence is a pointer so it stays 64 bit aligned for atomic access.
+	sequenceKey string
+	sequence    *uint64
+	// If set, a marker record is sent in place of each record skipped after it took a
+	// sequence number, with the reason under this key
+	gapMarker string
+	// If non-nil, the string values of records are transcoded to UTF-8
+	transcoder *transcoder
+	// If non-nil, the records of each flush are persisted until they have been sent
+	checkpoints *checkpoints
+	// If non-nil, the records each shard received are counted and logged periodically
+	shardLoad     *shardLoad
+	shardLoadStop chan struct{}
+	// If non-nil, records whose dedup key was sent within the window, or is waiting to be sent, are dropped
+	dedup *dedupCache
+	// If non-nil, records with a partition key are sent with an explicit hash key from the ring
+	hashRing *hashRing
+	// If non-nil, errors on the retry and failure paths are counted and logged in samples
+	errorLogSampler     *errorLogSampler
+	errorLogSamplerStop chan struct{}
+	// The paths of the fields removed from each record before it is marshaled
+	stripFields [][]string
+	// If non-nil, the counters are written to the status file every interval
+	status     *pluginStatus
+	statusStop chan struct{}
+	// If non-nil, partition key field values must match the pattern, or are handled by the action
+	partitionKeyPattern *keyPattern
+	invalidKeyAction    PartitionKeyInvalidAction
+	// If non-nil, concurrent flushes send their records in shared batches
+	coalescer *coalescer
+	// If non-nil, records are encoded as Avro binary instead of JSON
+	avro *avroCodec
+	// If positive, the longest FlushWithRetries waits between attempts
+	retryMaxDelay time.Duration
+	// If set, records whose chunkField value is longer than chunkSize are split into a record per chunk
+	chunkField string
+	chunkSize  int
+	chunkIDs   *util.RandomStringGenerator
+	// If non-nil, records are encoded as length delimited protobuf messages instead of JSON
+	protobuf *protobufCodec
+	// If set, records are given the PluginVersion under this key
+	versionKey string
+	// If non-nil, bounds the number of concurrent PutRecords calls with a limit adapted to their latency
+	adaptive *adaptiveLimiter
+	// If true, the partition key is taken from the record metadata rather than its body
+	keyFromMetadata        bool
+	metadataFallbackLogged int32
+	// If positive, batches are filled to about this many bytes rather than to the request limit
+	targetBatchBytes int
+	// If non-nil, record counts and partition key distribution are emitted as EMF log lines
+	emf     *emfMetrics
+	emfStop chan struct{}
+	// If non-nil, each record a stream accepted is logged with its shard and sequence number
+	audit *auditLog
+	// If true, partition keys from records are trimmed of surrounding whitespace, or lowercased
+	keyTrim      bool
+	keyLowercase bool
+	// Marshals records to JSON, escaping HTML characters in strings or not. If nil, they are escaped.
+	recordJSON jsoniter.API
+	// If non-nil, each record is reshaped by a JMESPath expression before it is sent
+	projection *recordProjection
+	// If set, each secondary stream is flushed by a pipeline of its own
+	pipelines []*streamPipeline
+	// If positive, records whose estimated entropy in bits per byte is higher are not compressed
+	entropyThreshold float64
+	// If set, a checksum of each record marshaled to JSON is added under this key
+	checksumKey  string
+	checksumAlgo ChecksumAlgo
 	// Decides whether to append a newline after each data record
-	appendNewline         bool
-	timeKey               string
-	fmtStrftime           *strftime.Strftime
-	logKey                string
-	client                PutRecordsClient
-	timer                 *plugins.Timeout
-	PluginID              int
+	appendNewline bool
+	timeKey       string
+	fmtStrftime   *strftime.Strftime
+	// If true, the time_key is an integer count of nanoseconds instead of using fmtStrftime
+	timeKeyEpochNanos bool
+	// If non-nil, the time_key is formatted in this location instead of the local time zone
+	timeKeyLocation *time.Location
+	logKey          string
+	client          PutRecordsClient
+	timer           *sendFailureTimer
+	PluginID        int
+	// Attaches the plugin_id, stream and region fields to every log line
+	logger                *logrus.Entry
 	stringGen             *util.RandomStringGenerator
 	Concurrency           int
 	concurrencyRetryLimit int
 	// Concurrency is the limit, goroutineCount represents the running goroutines
-	goroutineCount        int32
+	goroutineCount int32
 	// Used to implement backoff for concurrent flushes
-	concurrentRetries     uint32
-	isAggregate           bool
-	aggregator            *aggregate.Aggregator
-	compression           CompressionType
+	concurrentRetries uint32
+	isAggregate       bool
+	aggregator        *aggregate.Aggregator
+	compression       CompressionType
+	// If true, gzip compressed records have a sync flush boundary every gzipSyncFlushSize bytes
+	gzipSyncFlush bool
+	framing       FramingType
 	// If specified, dots in key names should be replaced with other symbols
-	replaceDots           string
+	replaceDots string
+	// If non-nil, tracks the records sent per partition key to help diagnose shard imbalance
+	histogram     *partitionKeyHistogram
+	histogramStop chan struct{}
+	// Bytes held in memory by concurrent flushes, bounded by bufferMaxBytes if non-zero
+	inflightBytes  int64
+	bufferMaxBytes int64
+	// If non-nil, batches which would exceed bufferMaxBytes are queued on disk instead
+	spill     *spillQueue
+	spillStop chan struct{}
+	spillDone chan struct{}
+	// If non-nil, bounds the number of retries across all flushes
+	retryBudget          *util.TokenBucket
+	retryBudgetPerMinute int
+	retryBudgetExhausted int32
+	// Set once a record without the configured partition key has been logged
+	missingPartitionKeyLogged int32
+	// If true, informational logs emitted on every flush are demoted to debug
+	quiet bool
+	// If non-nil, outgoing records are also written to stdout
+	tee *recordTee
+	// If non-nil, records are flushed by workers chosen by partition key
+	workers *flushWorkers
+	// If non-nil, the hostname and IP of the host are added to every record
+	hostMetadata *hostMetadata
+	closed       int32
+	// If non-nil, zlib compression uses a preset dictionary
+	zlibDictCompressor CompressorFunc
+	// Set while the client could not be created at startup with fail_open
+	clientPending int32
+	clientStop    chan struct{}
+	// If non-nil, the client is built on the first flush
+	lazyClient *lazyClient
+	// If non-nil, the client is rebuilt when a request fails with expired credentials
+	clientRebuild *clientRebuild
+	// If true, the records of a chunk are sent in their original order
+	preserveChunkOrder bool
+	// If non-nil, the path of the file each record was read from is added to it
+	sourcePath *sourcePath
+	// If non-nil, failed records are retried or dropped depending on their error code
+	errorRetry *errorRetryStrategies
+	// If set, the size of each marshaled record is added under this key
+	sizeKey string
+	// If non-nil, records matching its condition are also sent to a secondary stream
+	mirror *mirror
+	// If non-nil, AddRecord is paced so records are never processed faster than its rate
+	ingestPacer   *util.Pacer
+	ingestMaxWait time.Duration
+	// If non-nil, fields from a lookup table are merged into each record
+	enricher       *enricher
+	enrichmentStop chan struct{}
+	// If set, records dropped after retries are sent to this stream instead
+	dlqStream string
+	// If non-nil, records rejected as they are added are queued for dlqStream, and sent
+	// with the next flush
+	deadLetters *mirror
+	// If non-nil, aggregated records are built per partition key
+	groups *partitionKeyGroups
+	// Bytes added before and after each event, inside any framing
+	recordPrefix []byte
+	recordSuffix []byte
+	// How the data_keys fields are encoded, and the delimiter between values
+	dataKeysOutput    DataKeysOutput
+	dataKeysDelimiter string
+	// How integer record timestamps are converted to times
+	timestampUnit TimestampUnit
+	// Counts records which could not be marshaled, which are dropped or dead-lettered
+	marshalErrors  *metrics.Counter
+	onMarshalError OnMarshalError
+	// The values of these top level fields are compressed in place
+	compressKeys []string
+	// Measures the time between the event time of records and when they are sent
+	latency *recordLatency
+	// If true, the non-string keys of records are converted to strings
+	stringifyKeys bool
+	// If non-nil, records still in flight when Close times out are written to the sink it opens
+	inflight         *inflightRecords
+	shutdownDumpSink func() (io.WriteCloser, error)
+	// If positive, records with more top level fields are rejected and counted
+	maxFields         int
+	maxFieldsExceeded *metrics.Counter
+	// If non-nil, records matching the condition are dropped, and counted by dropWhereDropped
+	dropWhere        *fieldComparison
+	dropWhereDropped *metrics.Counter
+	// If non-nil, only records whose flag field matches are forwarded, the others are dropped
+	routeFlag *routeFlag
+	// If non-nil, each record is wrapped in an envelope with a meta object
+	envelope *envelope
+	// If non-nil, a trailer record summarizing each PutRecords batch for the stream is appended to it
+	batchTrailer *batchTrailer
+}
+
+// OutputPluginConfig contains the configuration used to create an OutputPlugin
+type OutputPluginConfig struct {
+	Region             string
+	Stream             string
+	DataKeys           string
+	PartitionKey       string
+	RoleARN            string
+	KinesisEndpoint    string
+	STSEndpoint        string
+	TimeKey            string
+	TimeFmt            string
+	LogKey             string
+	ReplaceDots        string
+	Concurrency        int
+	RetryLimit         int
+	IsAggregate        bool
+	AppendNewline      bool
+	Compression        CompressionType
+	PluginID           int
+	HTTPRequestTimeout time.Duration
+	// If greater than zero, the top N partition keys by volume are tracked
+	// and logged every PartitionKeyHistogramInterval
+	PartitionKeyHistogramTopN     int
+	PartitionKeyHistogramInterval time.Duration
+	// Proxies used by the Kinesis and STS clients, these default to the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// Bounds the bytes held in memory by concurrent flushes; when exceeded and
+	// SpillDir is set, batches are queued on disk until Kinesis catches up
+	BufferMaxBytes int64
+	SpillDir       string
+	// How the partition key of each record is chosen, and the hash algorithm
+	// used for PartitionKeySourceRecordHash
+	PartitionKeySource  PartitionKeySource
+	RecordHashAlgorithm string
+	// If set, the partition key field value is hashed with crc32, fnv or md5
+	PartitionKeyHash string
+	// The partition keys for PartitionKeySourceRoundRobin, or RoundRobinKeyCount generated keys if empty
+	RoundRobinKeys     []string
+	RoundRobinKeyCount int
+	// If greater than zero, limits the number of retries per minute across all flushes
+	RetryBudgetPerMinute int
+	Framing              FramingType
+	Quiet                bool
+	// Resolve FIPS endpoints for Kinesis and STS, unless custom endpoints are set
+	UseFIPSEndpoint bool
+	// Retries made by the AWS SDK within a single flush, use aws.UseServiceDefaultRetries for the SDK default
+	SDKMaxRetries int
+	// If greater than zero, overrides the idle connection pool size and the
+	// connect, TLS handshake and response header timeouts of the HTTP transport
+	MaxIdleConns int
+	HTTPTimeout  time.Duration
+	// Write outgoing records to stdout as well, at most TeeStdoutRecordsPerSecond
+	TeeStdout                 bool
+	TeeStdoutRecordsPerSecond int
+	// If greater than zero, records are flushed by this many workers, with every
+	// record for a partition key handled by the same worker to preserve ordering
+	Workers    int
+	WorkerHash string
+	// Add the hostname and primary IP of the host to every record, under the given keys
+	AddHostMetadata bool
+	HostnameKey     string
+	HostIPKey       string
+	// Path to a preset dictionary for zlib compression
+	CompressionDictFile string
+	// If true, failing to create the client does not fail startup, instead it is
+	// retried in the background while every flush is retried
+	FailOpen bool
+	// Add the size in bytes of each marshaled record under this key
+	SizeKey string
+	// Records matching MirrorCondition (key=value or key!=value) are also sent to MirrorStream
+	MirrorStream    string
+	MirrorCondition string
+	// If greater than zero, AddRecord processes at most this many records per second
+	MaxIngestRecordsPerSec int
+	// Fields from the table in EnrichmentFile are merged into records by the value of
+	// EnrichmentKey, reloading the file every EnrichmentReloadInterval if non-zero
+	EnrichmentFile           string
+	EnrichmentKey            string
+	EnrichmentReloadInterval time.Duration
+	// Records which could not be delivered are sent to this stream, if set
+	DLQStream string
+	// If true with IsAggregate, the records of each chunk are aggregated per partition key
+	GroupByPartitionKey bool
+	// Bytes added before and after each event, counted towards the record size limit
+	RecordPrefix []byte
+	RecordSuffix []byte
+	// Encodes the data_keys fields as a JSON object, or as their values joined by DataKeysDelimiter
+	DataKeysOutput    DataKeysOutput
+	DataKeysDelimiter string
+	// If true, the client is built on the first flush instead of at startup
+	LazyClientInit bool
+	// The unit of integer record timestamps, seconds if empty
+	TimestampUnit TimestampUnit
+	// Assumed role credentials are refreshed this long before they expire
+	CredentialsRefreshBefore time.Duration
+	// Records which can't be marshaled are dropped, or sent to DLQStream
+	OnMarshalError OnMarshalError
+	// Top level fields whose values are gzip compressed and base64 encoded
+	CompressKeys []string
+	// The time zone the time_key is formatted in, the local time zone if nil
+	TimeKeyLocation *time.Location
+	// Chooses the partition key of aggregated records, the key of their first record if empty
+	AggregationPartitionStrategy AggregationPartitionStrategy
+	// If non-zero, a summary of record latency is logged at most this often
+	LatencyLogInterval time.Duration
+	// Converts the non-string keys of records, and the maps nested in them, to strings
+	StringifyKeys bool
+	// If non-zero, the most user records packed into each aggregated record
+	AggregationMaxRecords int
+	// Where records still in flight when the plugin is closed are written, and the file for ShutdownDumpFile
+	ShutdownDump     ShutdownDump
+	ShutdownDumpPath string
+	// If positive, records with more top level fields are dropped, or sent to DLQStream
+	MaxFields int
+	// ExternalID is passed when assuming RoleARN
+	ExternalID string
+	// The bucket duration for PartitionKeySourceTimeBucket, DefaultTimeBucket if zero
+	TimeBucket time.Duration
+	// If true, each event starts with a byte identifying its compression codec
+	CodecHeader bool
+	// If positive, Flush stops sending new batches after this long and returns FLB_RETRY
+	FlushDeadline time.Duration
+	// If set, the metadata of each record is also sent to this stream
+	MetadataStream string
+	// If set, the first of TimestampSourceKeys a record has is parsed with one of TimestampFormats,
+	// or DefaultTimestampFormats, and replaced by TimestampTargetKey in TimestampTargetFormat
+	TimestampSourceKeys   []string
+	TimestampTargetKey    string
+	TimestampFormats      []string
+	TimestampTargetFormat string
+	// If SpillHighWatermark is positive, chunks are retried once SpillDir holds more
+	// bytes, until it drains below SpillLowWatermark, or half the high watermark if zero
+	SpillHighWatermark int64
+	SpillLowWatermark  int64
+	// Sink is the kind of stream Stream names, SinkKinesis if empty
+	Sink Sink
+	// If set, each record is given a sequence number under this key, which increases by
+	// one for each record the instance receives
+	SequenceKey string
+	// If DedupWindow is positive, records whose DedupKey value was sent within the window are dropped
+	DedupKey    string
+	DedupWindow time.Duration
+	// If set, records with a partition key are sent with the explicit hash key of a
+	// consistent hash ring with one member of each weight
+	ConsistentHashRing []int
+	// If either is positive, errors on the retry and failure paths logged with the same
+	// format and error are logged once every ErrorLogDedupInterval, or every
+	// ErrorLogSampleRate occurrences, with the number of occurrences
+	ErrorLogDedupInterval time.Duration
+	ErrorLogSampleRate    int
+	// Fields removed from each record before it is marshaled, nested fields are separated by ->
+	StripInternalFields []string
+	// If set, counts of the records sent, failed and buffered are written to this file as
+	// JSON every StatusInterval, or DefaultStatusInterval if zero
+	StatusFile     string
+	StatusInterval time.Duration
+	// If set, partition key field values which don't match the pattern are sanitized,
+	// or with PartitionKeyInvalidFallback, replaced by a random key
+	PartitionKeyPattern       string
+	PartitionKeyInvalidAction PartitionKeyInvalidAction
+	// If positive, concurrent flushes and flush workers send their records in shared
+	// batches, which are sent once full or this long after the first records joined
+	CoalesceLinger time.Duration
+	// If positive with CoalesceLinger, a shared batch is sent as soon as it has this many
+	// records, rather than once it is full or has lingered
+	FlushOnRecords int
+	// With RecordFormatAvro, records are encoded against the schema in AvroSchemaFile,
+	// and fields which are not in the schema are handled by AvroUnknownFields
+	RecordFormat      RecordFormat
+	AvroSchemaFile    string
+	AvroUnknownFields AvroUnknownFields
+	// If positive, caps the delay between retries, including delays asked for by throttling responses
+	RetryMaxDelay time.Duration
+	// If set, records whose ChunkField value is longer than ChunkSize, or DefaultChunkSize
+	// if zero, are split into a record for each chunk with reassembly metadata
+	ChunkField string
+	ChunkSize  int
+	// If set, Kinesis or Firehose requests are signed for SigningRegion instead of Region,
+	// for custom endpoints that accept requests signed for another region
+	SigningRegion string
+	// With RecordFormatProtobuf, records are encoded as the message named ProtobufMessage
+	// in the descriptor set in ProtobufDescriptorFile, and fields which are not in the
+	// message are handled by ProtobufUnknownFields
+	ProtobufDescriptorFile string
+	ProtobufMessage        string
+	ProtobufUnknownFields  ProtobufUnknownFields
+	// If set, records are given the PluginVersion under this key
+	VersionKey string
+	// If AdaptiveConcurrencyMax is positive, concurrent PutRecords calls are limited to
+	// between AdaptiveConcurrencyMin and AdaptiveConcurrencyMax, increasing while calls
+	// succeed within AdaptiveConcurrencyLatency and backing off when they don't
+	AdaptiveConcurrencyMin     int
+	AdaptiveConcurrencyMax     int
+	AdaptiveConcurrencyLatency time.Duration
+	// If true, PartitionKey is looked up in the record metadata, falling back to the record
+	// body if the running Fluent Bit does not support metadata
+	PartitionKeyFromMetadata bool
+	// If positive, each PutRecords request is filled with records up to about TargetBatchBytes
+	// rather than up to the size limit of a request, for more even request latency
+	TargetBatchBytes int
+	// If true, record counts and partition key distribution stats are written to the logs
+	// every EMFInterval as CloudWatch Embedded Metric Format lines in EMFNamespace
+	EMFMetrics   bool
+	EMFNamespace string
+	EMFInterval  time.Duration
+	// If set, the partition key, shard id and sequence number of every record sent are
+	// appended to AuditLog, which is rotated at AuditLogMaxBytes keeping AuditLogMaxFiles
+	AuditLog         string
+	AuditLogMaxBytes int64
+	AuditLogMaxFiles int
+	// If true, partition keys taken from records have surrounding whitespace trimmed, and with
+	// PartitionKeyLowercase are lowercased, so variants of the same key go to the same shard
+	PartitionKeyTrim      bool
+	PartitionKeyLowercase bool
+	// If set, batches for Stream are also sent to the stream in each of FanoutRegions at once,
+	// named by the matching entry of FanoutStreams, or Stream if there is none. Records count as
+	// sent once FanoutQuorum destinations accepted them, all of them if zero.
+	FanoutRegions []string
+	FanoutStreams []string
+	FanoutQuorum  int
+	// If true, '<', '>' and '&' in the strings of JSON records are escaped as \u003c, \u003e and \u0026
+	EscapeHTML bool
+	// If set, a JMESPath expression whose result is sent in place of each record
+	Projection string
+	// What happens to records which the Projection finds nothing in
+	ProjectionNoMatch ProjectionNoMatch
+	// If non-zero, the lowest TLS version, such as tls.VersionTLS12, used to connect to AWS
+	TLSMinVersion uint16
+	// If set, the only cipher suites used for TLS 1.2 and lower connections to AWS
+	TLSCipherSuites []uint16
+	// If true, the secondary streams, such as the mirror, metadata and dead letter streams,
+	// are flushed independently of the main stream, so none of them waits for another which
+	// is slow or throttled. The main stream is still flushed by Flush.
+	IndependentSecondaryFlush bool
+	// If positive, records whose estimated entropy is higher, in bits per byte from 0 to 8,
+	// are sent uncompressed and marked so by the codec header, since they would barely shrink
+	CompressionEntropyThreshold float64
+	// If set, the checksum of each record marshaled to JSON, computed with ChecksumAlgo, is added as its last field
+	ChecksumKey  string
+	ChecksumAlgo ChecksumAlgo
+	// The partition keys for PartitionKeySourceWeighted, each picked in proportion to its weight
+	WeightedPartitionKeys []WeightedPartitionKey
+	// How gzip compressed records are flushed, GzipFlushSync lets consumers decompress them incrementally
+	GzipFlushMode GzipFlushMode
+	// If set, records matching this condition, such as status_code < 400, are dropped
+	DropWhere string
+	// If true, the client is rebuilt once, assuming the role again, when PutRecords fails with
+	// expired or invalid credentials, and the request is retried with the new client
+	RebuildClientOnAuthError bool
+	// The minimum time between two rebuilds of the client
+	ClientRebuildCooldown time.Duration
+	// If true, the records of a chunk are sent in their original order: flush workers send a
+	// chunk as a whole, and a flush stops at a batch with failed records until they are sent
+	PreserveChunkOrder bool
+	// If set, the path of the file a record was read from is added under this key
+	SourcePathKey string
+	// The field of the record metadata or body holding the source path
+	SourcePathField string
+	// A regex extracting the source path from the tag, when SourcePathField is not found
+	SourcePathTagRegex string
+	// How the path is built from the groups of SourcePathTagRegex, DefaultSourcePathTagFormat if empty
+	SourcePathTagFormat string
+	// The retry strategy for each error code, records failing with other codes are retried with backoff
+	ErrorRetryStrategies map[string]RetryStrategy
+	// The delay of the fixed retry strategy, DefaultErrorRetryFixedDelay if 0
+	ErrorRetryFixedDelay time.Duration
+	// How often the fixed and immediate strategies resend records, DefaultErrorRetryAttempts if 0
+	ErrorRetryAttempts int
+	// If set with SequenceKey, a marker record with the sequence number and the reason under
+	// this key is sent in place of each record skipped after it was given a sequence number
+	GapMarker string
+	// If set, the string values of records are transcoded from this encoding to UTF-8
+	SourceEncoding SourceEncoding
+	// What is done with sequences which are invalid in the source encoding
+	SourceEncodingInvalid InvalidEncoding
+	// If set, the records of each flush are persisted in this directory until they have
+	// been sent, and records left behind by a crash are replayed
+	CheckpointDir string
+	// If non-zero, the load of each shard of the stream is counted from the shards PutRecords
+	// reports the records were written to, and logged at this interval
+	ShardLoadLogInterval time.Duration
+	// If true, a flush which leaves failed records unsent returns FLB_RETRY, and only those
+	// records are sent again, rather than the records failing with codes other than
+	// ProvisionedThroughputExceededException being dropped at the end of the flush
+	PartialAck bool
+	// If true, the batches PutRecords is called with and the buffers and writers records are
+	// compressed with are reused across flushes, rather than allocated for each one
+	RecordPool bool
+	// If set, only records whose RouteFlagKey field is RouteFlagValue are forwarded, the
+	// others are dropped and counted
+	RouteFlagKey   string
+	RouteFlagValue string
+	// If set, each record marshaled to JSON is sent as {"meta": {...}, "data": <record>},
+	// where the meta object holds these fields
+	Envelope []EnvelopeField
+	// If true, the stream is checked with DescribeStreamSummary when the plugin starts,
+	// retrying transient failures for up to ValidateStreamTimeout, DefaultValidateStreamTimeout
+	// if 0. A stream which can't be validated fails startup, unless FailOpen is set.
+	ValidateStream        bool
+	ValidateStreamTimeout time.Duration
+	// If true, each PutRecords batch for the stream ends with a trailer record holding the
+	// batch id and the number and size of the data records of the batch
+	BatchTrailer bool
+	// If positive, the partition keys derived from this many of the most recently seen
+	// partition key field values are cached
+	PartitionKeyCacheSize int
 }
 
 // NewOutputPlugin creates an OutputPlugin object
-func NewOutputPlugin(region, stream, dataKeys, partitionKey, roleARN, kinesisEndpoint, stsEndpoint, timeKey, timeFmt, logKey, replaceDots string, concurrency, retryLimit int, isAggregate, appendNewline bool, compression CompressionType, pluginID int, httpRequestTimeout time.Duration) (*OutputPlugin, error) {
-	client, err := newPutRecordsClient(roleARN, region, kinesisEndpoint, stsEndpoint, pluginID, httpRequestTimeout)
-	if err != nil {
-		return nil, err
+func NewOutputPlugin(config *OutputPluginConfig) (*OutputPlugin, error) {
+	pluginID := config.PluginID
+	logger := newPluginLogger(pluginID, config.Stream, config.Region)
+	buildRegionClient := func(region, endpoint, signingRegion string) (PutRecordsClient, error) {
+		if config.Sink == SinkFirehose {
+			client, err := newFirehoseClient(config.RoleARN, config.ExternalID, region, endpoint, signingRegion, config.STSEndpoint, config.UseFIPSEndpoint, config.SDKMaxRetries, config.CredentialsRefreshBefore, logger, newHTTPClient(config))
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+		client, err := newPutRecordsClient(config.RoleARN, config.ExternalID, region, endpoint, signingRegion, config.STSEndpoint, config.UseFIPSEndpoint, config.SDKMaxRetries, config.CredentialsRefreshBefore, logger, newHTTPClient(config))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	buildClient := func() (PutRecordsClient, error) {
+		client, err := buildRegionClient(config.Region, config.KinesisEndpoint, config.SigningRegion)
+		if err != nil || len(config.FanoutRegions) == 0 {
+			return client, err
+		}
+		// the endpoint and signing region overrides are for the primary region only
+		targets := make([]fanoutTarget, 0, len(config.FanoutRegions))
+		for i, region := range config.FanoutRegions {
+			target := fanoutTarget{region: region, stream: config.Stream}
+			if i < len(config.FanoutStreams) && config.FanoutStreams[i] != "" {
+				target.stream = config.FanoutStreams[i]
+			}
+			if target.client, err = buildRegionClient(region, "", ""); err != nil {
+				return nil, err
+			}
+			targets = append(targets, target)
+		}
+		return newFanoutClient(client, config.Stream, targets, config.FanoutQuorum, logger), nil
+	}
+	var client PutRecordsClient
+	var err error
+	if !config.LazyClientInit {
+		client, err = buildClient()
+		if err != nil {
+			if !config.FailOpen {
+				return nil, err
+			}
+			logger.Errorf("Failed to create Kinesis client, starting in degraded mode where every flush is retried until it can be created: %v\n", err)
+		}
+	}
+	if client != nil && config.ValidateStream {
+		validateTimeout := config.ValidateStreamTimeout
+		if validateTimeout <= 0 {
+			validateTimeout = DefaultValidateStreamTimeout
+		}
+		if describer, ok := streamDescriberOf(client); !ok {
+			logger.Warnf("Skipping stream validation, the client can't describe streams\n")
+		} else if err := validateStream(describer, config.Stream, validateTimeout, logger); err != nil {
+			if !config.FailOpen {
+				return nil, fmt.Errorf("[kinesis %d] Failed to validate stream %s: %v", pluginID, config.Stream, err)
+			}
+			logger.Errorf("Failed to validate stream, starting anyway since fail_open is set: %v\n", err)
+		}
 	}
 
-	timer, err := plugins.NewTimeout(func(d time.Duration) {
-		logrus.Errorf("[kinesis %d] timeout threshold reached: Failed to send logs for %s\n", pluginID, d.String())
-		logrus.Errorf("[kinesis %d] Quitting Fluent Bit", pluginID)
+	timeout, err := plugins.NewTimeout(func(d time.Duration) {
+		logger.Errorf("timeout threshold reached: Failed to send logs for %s\n", d.String())
+		logger.Errorf("Quitting Fluent Bit")
 		os.Exit(1)
 	})
 
 	if err != nil {
 		return nil, err
 	}
+	timer := newSendFailureTimer(timeout)
 
 	stringGen := util.NewRandomStringGenerator(8)
 
+	timeFmt := config.TimeFmt
 	var timeFormatter *strftime.Strftime
-	if timeKey != "" {
+	if config.TimeKey != "" && timeFmt != TimeFmtEpochNanos {
 		if timeFmt == "" {
 			timeFmt = defaultTimeFmt
 		}
-		timeFormatter, err = strftime.New(timeFmt, strftime.WithMilliseconds('L'), strftime.WithMicroseconds('f'))
+		timeFormatter, err = newTimeFormatter(timeFmt)
 		if err != nil {
-			logrus.Errorf("[kinesis %d] Issue with strftime format in 'time_key_format'", pluginID)
+			logger.Errorf("Issue with strftime format in 'time_key_format'")
 			return nil, err
 		}
 	}
 
 	var aggregator *aggregate.Aggregator
-	if isAggregate {
+	if config.IsAggregate {
 		aggregator = aggregate.NewAggregator(stringGen)
+		aggregator.SetMaxRecords(config.AggregationMaxRecords)
 	}
 
-	return &OutputPlugin{
-		stream:                stream,
+	var recordHasher func() hash.Hash
+	if config.PartitionKeySource == PartitionKeySourceRecordHash {
+		recordHasher, err = newHasher(config.RecordHashAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'record_hash_algorithm': %v", pluginID, err)
+		}
+	}
+
+	if len(config.RecordPrefix)+len(config.RecordSuffix) > maxRecordWrapperSize {
+		return nil, fmt.Errorf("[kinesis %d] 'record_prefix' and 'record_suffix' must add up to at most %d bytes", pluginID, maxRecordWrapperSize)
+	}
+
+	roundRobinKeys := config.RoundRobinKeys
+	usesRoundRobinKeys := config.PartitionKeySource == PartitionKeySourceRoundRobin ||
+		(config.IsAggregate && config.AggregationPartitionStrategy == AggregationPartitionRoundRobin)
+	if usesRoundRobinKeys && len(roundRobinKeys) == 0 {
+		roundRobinKeys, err = generateRoundRobinKeys(config.RoundRobinKeyCount)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'round_robin_keys': %v", pluginID, err)
+		}
+	}
+
+	var dropWhere *fieldComparison
+	if config.DropWhere != "" {
+		dropWhere, err = parseFieldComparison(config.DropWhere)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'drop_where': %v", pluginID, err)
+		}
+	}
+
+	var recordRouteFlag *routeFlag
+	if config.RouteFlagKey != "" {
+		recordRouteFlag = newRouteFlag(pluginID, config.RouteFlagKey, config.RouteFlagValue)
+	}
+
+	var recordEnvelope *envelope
+	if len(config.Envelope) > 0 {
+		recordEnvelope = newEnvelope(config.Envelope)
+	}
+
+	var recordBatchTrailer *batchTrailer
+	if config.BatchTrailer {
+		recordBatchTrailer = newBatchTrailer(stringGen.RandomString())
+	}
+
+	var keyCache *partitionKeyCache
+	if config.PartitionKeyCacheSize > 0 {
+		keyCache = newPartitionKeyCache(config.PartitionKeyCacheSize)
+	}
+
+	var recordSourcePath *sourcePath
+	if config.SourcePathKey != "" {
+		recordSourcePath, err = newSourcePath(config.SourcePathKey, config.SourcePathField, config.SourcePathTagRegex, config.SourcePathTagFormat)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'source_path_tag_regex': %v", pluginID, err)
+		}
+	}
+
+	var errorRetry *errorRetryStrategies
+	if len(config.ErrorRetryStrategies) > 0 {
+		errorRetry = newErrorRetryStrategies(config.ErrorRetryStrategies, config.ErrorRetryFixedDelay, config.ErrorRetryAttempts)
+	}
+
+	var recordTranscoder *transcoder
+	if config.SourceEncoding != "" {
+		recordTranscoder = newTranscoder(config.SourceEncoding, config.SourceEncodingInvalid)
+	}
+
+	// set once the plugin has started, the checkpoint_dir is released if it fails to start
+	started := false
+	var recordCheckpoints *checkpoints
+	if config.CheckpointDir != "" {
+		recordCheckpoints, err = newCheckpoints(config.CheckpointDir)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to open checkpoint_dir %s: %v", pluginID, config.CheckpointDir, err)
+		}
+		// released if the plugin fails to start, so a corrected configuration can open it
+		defer func() {
+			if !started {
+				recordCheckpoints.close()
+			}
+		}()
+	}
+
+	var weighted *weightedKeys
+	if config.PartitionKeySource == PartitionKeySourceWeighted {
+		weighted, err = newWeightedKeys(config.WeightedPartitionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'weighted_partition_keys': %v", pluginID, err)
+		}
+	}
+
+	timeBucket := config.TimeBucket
+	if timeBucket <= 0 {
+		timeBucket = DefaultTimeBucket
+	}
+
+	var partitionKeyHash func(string) string
+	if config.PartitionKeyHash != "" {
+		partitionKeyHash, err = newPartitionKeyHash(config.PartitionKeyHash)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'partition_key_hash': %v", pluginID, err)
+		}
+	}
+
+	var zlibDictCompressor CompressorFunc
+	if config.CompressionDictFile != "" {
+		if config.Compression != CompressionZlib {
+			return nil, fmt.Errorf("[kinesis %d] 'compression_dict_file' requires 'compression' zlib, the gzip format does not support preset dictionaries", pluginID)
+		}
+		dict, err := loadCompressionDict(config.CompressionDictFile)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to load 'compression_dict_file': %v", pluginID, err)
+		}
+		if len(dict) > maximumDictSize {
+			logger.Warnf("Compression dictionary is %d bytes, only the last %d bytes will be used", len(dict), maximumDictSize)
+		}
+		zlibDictCompressor = newZlibDictCompressor(dict)
+	}
+
+	var workerHash keyHashFunc
+	if config.Workers > 0 {
+		workerHash, err = newKeyHashFunc(config.WorkerHash)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'worker_hash': %v", pluginID, err)
+		}
+	}
+
+	var recordEnricher *enricher
+	if config.EnrichmentFile != "" {
+		recordEnricher, err = newEnricher(config.EnrichmentFile, config.EnrichmentKey)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to load 'enrichment_file': %v", pluginID, err)
+		}
+	}
+
+	var normalizer *timestampNormalizer
+	if len(config.TimestampSourceKeys) > 0 {
+		normalizer, err = newTimestampNormalizer(config.TimestampSourceKeys, config.TimestampTargetKey, config.TimestampFormats, config.TimestampTargetFormat)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid timestamp normalization: %v", pluginID, err)
+		}
+	}
+
+	spillLowWatermark := config.SpillLowWatermark
+	if spillLowWatermark == 0 {
+		spillLowWatermark = config.SpillHighWatermark / 2
+	}
+	if config.SpillHighWatermark > 0 && spillLowWatermark > config.SpillHighWatermark {
+		return nil, fmt.Errorf("[kinesis %d] 'spill_low_watermark' must not be greater than 'spill_high_watermark'", pluginID)
+	}
+
+	var recordDedup *dedupCache
+	if config.DedupKey != "" && config.DedupWindow > 0 {
+		if config.IsAggregate {
+			return nil, fmt.Errorf("[kinesis %d] 'dedup_key' can't be used with 'aggregation'", pluginID)
+		}
+		recordDedup = newDedupCache(config.DedupKey, config.DedupWindow)
+	}
+
+	var ring *hashRing
+	if len(config.ConsistentHashRing) > 0 {
+		if config.IsAggregate {
+			return nil, fmt.Errorf("[kinesis %d] 'consistent_hash_ring' can't be used with 'aggregation'", pluginID)
+		}
+		ring, err = newHashRing(config.ConsistentHashRing)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] %v", pluginID, err)
+		}
+	}
+
+	var sampler *errorLogSampler
+	if config.ErrorLogDedupInterval > 0 || config.ErrorLogSampleRate > 0 {
+		sampler = newErrorLogSampler(logger, config.ErrorLogDedupInterval, config.ErrorLogSampleRate)
+	}
+
+	var partitionKeyPattern *keyPattern
+	if config.PartitionKeyPattern != "" {
+		partitionKeyPattern, err = newKeyPattern(config.PartitionKeyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'partition_key_pattern': %v", pluginID, err)
+		}
+	}
+
+	var recordAvro *avroCodec
+	if config.RecordFormat == RecordFormatAvro {
+		recordAvro, err = loadAvroCodec(config.AvroSchemaFile, config.AvroUnknownFields)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to load 'avro_schema_file': %v", pluginID, err)
+		}
+		logger.Infof("Encoding records as Avro with schema fingerprint %016x\n", recordAvro.fingerprint)
+	}
+
+	var adaptive *adaptiveLimiter
+	if config.AdaptiveConcurrencyMax > 0 {
+		adaptive = newAdaptiveLimiter(config.AdaptiveConcurrencyMin, config.AdaptiveConcurrencyMax, config.AdaptiveConcurrencyLatency)
+	}
+
+	var recordProtobuf *protobufCodec
+	if config.RecordFormat == RecordFormatProtobuf {
+		recordProtobuf, err = loadProtobufCodec(config.ProtobufDescriptorFile, config.ProtobufMessage, config.ProtobufUnknownFields)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to load 'protobuf_descriptor_file': %v", pluginID, err)
+		}
+	}
+
+	var projection *recordProjection
+	if config.Projection != "" {
+		projection, err = newRecordProjection(config.Projection, config.ProjectionNoMatch)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'projection' value (%s) specified: %v", pluginID, config.Projection, err)
+		}
+	}
+
+	chunkSize := config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	var status *pluginStatus
+	if config.StatusFile != "" {
+		status = &pluginStatus{path: config.StatusFile}
+	}
+
+	var metadataStream *mirror
+	if config.MetadataStream != "" {
+		metadataStream = &mirror{stream: config.MetadataStream}
+	}
+
+	var deadLetters *mirror
+	if config.DLQStream != "" {
+		deadLetters = &mirror{stream: config.DLQStream}
+	}
+
+	var recordMirror *mirror
+	if config.MirrorStream != "" {
+		recordMirror, err = newMirror(config.MirrorStream, config.MirrorCondition)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Invalid 'mirror_condition': %v", pluginID, err)
+		}
+	}
+
+	var spill *spillQueue
+	if config.SpillDir != "" {
+		spill, err = newSpillQueue(config.SpillDir)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to open spill_dir %s: %v", pluginID, config.SpillDir, err)
+		}
+	}
+
+	var histogram *partitionKeyHistogram
+	if config.PartitionKeyHistogramTopN > 0 {
+		histogram = newPartitionKeyHistogram(config.PartitionKeyHistogramTopN)
+	}
+
+	outputPlugin := &OutputPlugin{
+		stream:                config.Stream,
 		client:                client,
-		dataKeys:              dataKeys,
-		partitionKey:          partitionKey,
-		appendNewline:         appendNewline,
-		timeKey:               timeKey,
+		dataKeys:              config.DataKeys,
+		partitionKey:          config.PartitionKey,
+		partitionKeySource:    config.PartitionKeySource,
+		recordHasher:          recordHasher,
+		partitionKeyHash:      partitionKeyHash,
+		partitionKeyCache:     keyCache,
+		roundRobinKeys:        roundRobinKeys,
+		weightedKeys:          weighted,
+		timeBucket:            timeBucket,
+		codecHeaderEnabled:    config.CodecHeader,
+		flushDeadline:         config.FlushDeadline,
+		partialAck:            config.PartialAck,
+		recordPool:            config.RecordPool,
+		partialChunks:         newPartialChunks(),
+		metadata:              metadataStream,
+		timestampNormalizer:   normalizer,
+		spillHighWatermark:    config.SpillHighWatermark,
+		spillLowWatermark:     spillLowWatermark,
+		sink:                  config.Sink,
+		sequenceKey:           config.SequenceKey,
+		gapMarker:             config.GapMarker,
+		transcoder:            recordTranscoder,
+		checkpoints:           recordCheckpoints,
+		sequence:              new(uint64),
+		dedup:                 recordDedup,
+		hashRing:              ring,
+		errorLogSampler:       sampler,
+		stripFields:           parseStripFields(config.StripInternalFields),
+		status:                status,
+		partitionKeyPattern:   partitionKeyPattern,
+		invalidKeyAction:      config.PartitionKeyInvalidAction,
+		avro:                  recordAvro,
+		retryMaxDelay:         config.RetryMaxDelay,
+		chunkField:            config.ChunkField,
+		chunkSize:             chunkSize,
+		chunkIDs:              util.NewRandomStringGenerator(chunkIDLength),
+		protobuf:              recordProtobuf,
+		versionKey:            config.VersionKey,
+		adaptive:              adaptive,
+		keyFromMetadata:       config.PartitionKeyFromMetadata,
+		targetBatchBytes:      config.TargetBatchBytes,
+		keyTrim:               config.PartitionKeyTrim,
+		keyLowercase:          config.PartitionKeyLowercase,
+		recordJSON:            newRecordJSON(config.EscapeHTML),
+		projection:            projection,
+		entropyThreshold:      config.CompressionEntropyThreshold,
+		checksumKey:           config.ChecksumKey,
+		checksumAlgo:          config.ChecksumAlgo,
+		appendNewline:         config.AppendNewline,
+		timeKey:               config.TimeKey,
 		fmtStrftime:           timeFormatter,
-		logKey:                logKey,
+		timeKeyEpochNanos:     timeFmt == TimeFmtEpochNanos,
+		timeKeyLocation:       config.TimeKeyLocation,
+		logKey:                config.LogKey,
 		timer:                 timer,
 		PluginID:              pluginID,
+		logger:                logger,
 		stringGen:             stringGen,
-		Concurrency:           concurrency,
-		concurrencyRetryLimit: retryLimit,
-		isAggregate:           isAggregate,
+		Concurrency:           config.Concurrency,
+		concurrencyRetryLimit: config.RetryLimit,
+		isAggregate:           config.IsAggregate,
 		aggregator:            aggregator,
-		compression:           compression,
-		replaceDots:           replaceDots,
-	}, nil
+		compression:           config.Compression,
+		gzipSyncFlush:         config.GzipFlushMode == GzipFlushSync,
+		framing:               config.Framing,
+		quiet:                 config.Quiet,
+		replaceDots:           config.ReplaceDots,
+		histogram:             histogram,
+		bufferMaxBytes:        config.BufferMaxBytes,
+		spill:                 spill,
+		retryBudgetPerMinute:  config.RetryBudgetPerMinute,
+		zlibDictCompressor:    zlibDictCompressor,
+		sizeKey:               config.SizeKey,
+		mirror:                recordMirror,
+		enricher:              recordEnricher,
+		dlqStream:             config.DLQStream,
+		deadLetters:           deadLetters,
+		recordPrefix:          config.RecordPrefix,
+		recordSuffix:          config.RecordSuffix,
+		dataKeysOutput:        config.DataKeysOutput,
+		dataKeysDelimiter:     config.DataKeysDelimiter,
+		timestampUnit:         config.TimestampUnit,
+		marshalErrors:         newMarshalErrorCounter(pluginID),
+		onMarshalError:        config.OnMarshalError,
+		compressKeys:          config.CompressKeys,
+		latency:               newRecordLatency(pluginID, config.LatencyLogInterval),
+		stringifyKeys:         config.StringifyKeys,
+		maxFields:             config.MaxFields,
+		maxFieldsExceeded:     newMaxFieldsCounter(pluginID),
+		dropWhere:             dropWhere,
+		dropWhereDropped:      newDropWhereCounter(pluginID),
+		routeFlag:             recordRouteFlag,
+		envelope:              recordEnvelope,
+		batchTrailer:          recordBatchTrailer,
+		preserveChunkOrder:    config.PreserveChunkOrder,
+		sourcePath:            recordSourcePath,
+		errorRetry:            errorRetry,
+	}
+
+	// the steps which can fail come before any goroutine is started, so a plugin which
+	// fails to start leaves nothing running
+	if status != nil {
+		// written once up front, so a sidecar can tell the plugin has started
+		if err := outputPlugin.writeStatus(); err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to write status file %s: %v", pluginID, config.StatusFile, err)
+		}
+	}
+
+	if config.AuditLog != "" {
+		// the last step which can fail, as it starts the audit log writer
+		outputPlugin.audit, err = newAuditLog(logger, config.AuditLog, config.AuditLogMaxBytes, config.AuditLogMaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("[kinesis %d] Failed to open audit log %s: %v", pluginID, config.AuditLog, err)
+		}
+	}
+
+	if config.Workers > 0 {
+		outputPlugin.workers = newFlushWorkers(config.Workers, workerHash, func(worker int, records []*kinesis.PutRecordsRequestEntry) {
+			outputPlugin.FlushWithRetries(len(records), records)
+		})
+	}
+
+	if config.CoalesceLinger > 0 {
+		outputPlugin.coalescer = newCoalescer(config.CoalesceLinger, outputPlugin.batchRecordsLimit(), int64(outputPlugin.batchBytesTarget()), outputPlugin.flush)
+		outputPlugin.coalescer.flushOnRecords = config.FlushOnRecords
+	}
+
+	if config.LazyClientInit {
+		outputPlugin.clientPending = 1
+		outputPlugin.lazyClient = &lazyClient{build: buildClient}
+	} else if client == nil {
+		outputPlugin.clientPending = 1
+		outputPlugin.clientStop = make(chan struct{})
+		go outputPlugin.retryClient(buildClient, clientRetryInitialInterval, outputPlugin.clientStop)
+	}
+
+	if config.RebuildClientOnAuthError {
+		cooldown := config.ClientRebuildCooldown
+		if cooldown == 0 {
+			cooldown = DefaultClientRebuildCooldown
+		}
+		outputPlugin.clientRebuild = &clientRebuild{
+			build: func() (PutRecordsClient, error) {
+				// forget the shared credentials, so the role is assumed again instead of reusing them
+				for _, region := range append([]string{config.Region}, config.FanoutRegions...) {
+					forgetSharedCredentials(newCredentialsKey(config.RoleARN, config.ExternalID, region, config.STSEndpoint, config.UseFIPSEndpoint, config.CredentialsRefreshBefore))
+				}
+				return buildClient()
+			},
+			cooldown: cooldown,
+		}
+	}
+
+	if config.AddHostMetadata {
+		outputPlugin.hostMetadata = newHostMetadata(config.HostnameKey, config.HostIPKey)
+		logger.Infof("Adding host metadata to records: %s=%s, %s=%s", outputPlugin.hostMetadata.hostnameKey, outputPlugin.hostMetadata.hostname, outputPlugin.hostMetadata.ipKey, outputPlugin.hostMetadata.ip)
+	}
+
+	if config.TeeStdout {
+		outputPlugin.tee = newRecordTee(os.Stdout, config.TeeStdoutRecordsPerSecond)
+	}
+
+	if recordEnricher != nil && config.EnrichmentReloadInterval > 0 {
+		outputPlugin.enrichmentStop = recordEnricher.reloadPeriodically(config.EnrichmentReloadInterval, logger)
+	}
+
+	if config.IsAggregate && config.GroupByPartitionKey {
+		outputPlugin.groups = newPartitionKeyGroups()
+	}
+
+	if sink := newShutdownDumpSink(config.ShutdownDump, config.ShutdownDumpPath); sink != nil {
+		outputPlugin.inflight = newInflightRecords()
+		outputPlugin.shutdownDumpSink = sink
+	}
+
+	if config.IsAggregate {
+		switch config.AggregationPartitionStrategy {
+		case AggregationPartitionRandom:
+			aggregator.SetRoutingKey(stringGen.RandomString)
+		case AggregationPartitionRoundRobin:
+			aggregator.SetRoutingKey(outputPlugin.nextRoundRobinKey)
+		}
+	}
+
+	if config.MaxIngestRecordsPerSec > 0 {
+		outputPlugin.ingestPacer = util.NewPacer(config.MaxIngestRecordsPerSec)
+		outputPlugin.ingestMaxWait = maxIngestWait
+	}
+
+	if config.RetryBudgetPerMinute > 0 {
+		outputPlugin.retryBudget = util.NewTokenBucket(config.RetryBudgetPerMinute, time.Minute)
+	}
+
+	if histogram != nil {
+		outputPlugin.histogramStop = histogram.logPeriodically(config.PartitionKeyHistogramInterval, logger)
+	}
+
+	if config.ShardLoadLogInterval > 0 {
+		outputPlugin.shardLoad = newShardLoad()
+		outputPlugin.shardLoadStop = outputPlugin.shardLoad.logPeriodically(config.ShardLoadLogInterval, logger)
+	}
+
+	if config.EMFMetrics {
+		// EMF lines are written as they are, without the log prefix, so CloudWatch Logs can parse them
+		outputPlugin.emf = newEMFMetrics(config.EMFNamespace, pluginID, config.Stream, logrus.StandardLogger().Out)
+		outputPlugin.emfStop = outputPlugin.emf.emitPeriodically(config.EMFInterval, func(err error) {
+			outputPlugin.logger.Warnf("Failed to write EMF metrics: %v\n", err)
+		})
+	}
+
+	if sampler != nil && config.ErrorLogDedupInterval > 0 {
+		outputPlugin.errorLogSamplerStop = sampler.flushPeriodically()
+	}
+
+	if status != nil {
+		outputPlugin.statusStop = outputPlugin.writeStatusPeriodically(config.StatusInterval)
+	}
+
+	if config.IndependentSecondaryFlush {
+		outputPlugin.pipelines = outputPlugin.startStreamPipelines(outputPlugin.secondaryQueues()...)
+	}
+
+	if spill != nil {
+		outputPlugin.spillStop = make(chan struct{})
+		outputPlugin.spillDone = make(chan struct{})
+		go func() {
+			defer close(outputPlugin.spillDone)
+			outputPlugin.drainSpillQueue(outputPlugin.spillStop)
+		}()
+	}
+
+	started = true
+	return outputPlugin, nil
+}
+
+// newPluginLogger creates the logger for an OutputPlugin, with fields identifying the plugin instance
+func newPluginLogger(pluginID int, stream, region string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"plugin_id": pluginID,
+		"stream":    stream,
+		"region":    region,
+	})
 }
 
 // newPutRecordsClient creates the Kinesis client for calling the PutRecords method
-func newPutRecordsClient(roleARN string, awsRegion string, kinesisEndpoint string, stsEndpoint string, pluginID int, httpRequestTimeout time.Duration) (*kinesis.Kinesis, error) {
+func newPutRecordsClient(roleARN string, externalID string, awsRegion string, kinesisEndpoint string, signingRegion string, stsEndpoint string, useFIPSEndpoint bool, sdkMaxRetries int, credentialsRefreshBefore time.Duration, logger *logrus.Entry, httpClient *http.Client) (*kinesis.Kinesis, error) {
+	svcSess, svcConfig, err := newClientSession(roleARN, externalID, awsRegion, kinesisEndpoint, signingRegion, stsEndpoint, useFIPSEndpoint, sdkMaxRetries, credentialsRefreshBefore, logger, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	client := kinesis.New(svcSess, svcConfig)
+	client.Handlers.Build.PushBackNamed(plugins.CustomUserAgentHandler())
+	client.Handlers.Complete.PushBackNamed(captureRetryAfterHandler)
+	return client, nil
+}
+
+// newClientSession creates the session and config for the client of the sink, with the
+// credentials of the role, if any. kinesisEndpoint overrides the Kinesis or Firehose endpoint,
+// and signingRegion the region their requests are signed for.
+func newClientSession(roleARN string, externalID string, awsRegion string, kinesisEndpoint string, signingRegion string, stsEndpoint string, useFIPSEndpoint bool, sdkMaxRetries int, credentialsRefreshBefore time.Duration, logger *logrus.Entry, httpClient *http.Client) (*session.Session, *aws.Config, error) {
 	customResolverFn := func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
-		if service == endpoints.KinesisServiceID && kinesisEndpoint != "" {
-			return endpoints.ResolvedEndpoint{
-				URL: kinesisEndpoint,
-			}, nil
+		if service == endpoints.KinesisServiceID || service == endpoints.FirehoseServiceID {
+			var resolved endpoints.ResolvedEndpoint
+			if kinesisEndpoint != "" {
+				resolved.URL = kinesisEndpoint
+			} else {
+				var err error
+				if resolved, err = endpoints.DefaultResolver().EndpointFor(service, region, optFns...); err != nil {
+					return resolved, err
+				}
+			}
+			if signingRegion != "" {
+				resolved.SigningRegion = signingRegion
+			}
+			return resolved, nil
 		} else if service == endpoints.StsServiceID && stsEndpoint != "" {
 			return endpoints.ResolvedEndpoint{
 				URL: stsEndpoint,
@@ -185,109 +1273,375 @@ func newPutRecordsClient(roleARN string, awsRegion string, kinesisEndpoint strin
 		}
 		return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
 	}
-	httpClient := &http.Client{
-		Timeout: httpRequestTimeout,
+
+	// Custom endpoints always win, otherwise the default resolver is asked for FIPS endpoints
+	fipsEndpointState := endpoints.FIPSEndpointStateUnset
+	if useFIPSEndpoint {
+		fipsEndpointState = endpoints.FIPSEndpointStateEnabled
 	}
 
 	// Fetch base credentials
 	baseConfig := &aws.Config{
 		Region:                        aws.String(awsRegion),
 		EndpointResolver:              endpoints.ResolverFunc(customResolverFn),
+		UseFIPSEndpoint:               fipsEndpointState,
+		MaxRetries:                    aws.Int(sdkMaxRetries),
 		CredentialsChainVerboseErrors: aws.Bool(true),
 		HTTPClient:                    httpClient,
 	}
 
+	credsKey := newCredentialsKey(roleARN, externalID, awsRegion, stsEndpoint, useFIPSEndpoint, credentialsRefreshBefore)
+	eksRole := credsKey.eksRole
+	if creds := loadSharedCredentials(credsKey); creds != nil {
+		logger.Debugf("Using credentials shared with another instance for the same region and role\n")
+		svcConfig := baseConfig.Copy()
+		svcConfig.Credentials = creds
+		svcSess, err := session.NewSession(svcConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return svcSess, svcConfig, nil
+	}
+
 	sess, err := session.NewSession(baseConfig)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var svcSess = sess
 	var svcConfig = baseConfig
-	eksRole := os.Getenv("EKS_POD_EXECUTION_ROLE")
 	if eksRole != "" {
-		logrus.Debugf("[kinesis %d] Fetching EKS pod credentials.\n", pluginID)
+		logger.Debugf("Fetching EKS pod credentials.\n")
 		eksConfig := &aws.Config{}
-		creds := stscreds.NewCredentials(svcSess, eksRole)
+		creds := newAssumeRoleCredentials(svcSess, eksRole, refreshBefore(credentialsRefreshBefore))
 		eksConfig.Credentials = creds
 		eksConfig.Region = aws.String(awsRegion)
 		eksConfig.HTTPClient = httpClient
+		eksConfig.EndpointResolver = endpoints.ResolverFunc(customResolverFn)
+		eksConfig.UseFIPSEndpoint = fipsEndpointState
+		eksConfig.MaxRetries = aws.Int(sdkMaxRetries)
 		svcConfig = eksConfig
 
 		svcSess, err = session.NewSession(svcConfig)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 	if roleARN != "" {
-		logrus.Debugf("[kinesis %d] Fetching credentials for %s\n", pluginID, roleARN)
+		logger.Debugf("Fetching credentials for %s\n", roleARN)
 		stsConfig := &aws.Config{}
-		creds := stscreds.NewCredentials(svcSess, roleARN)
+		creds := newAssumeRoleCredentials(svcSess, roleARN, refreshBefore(credentialsRefreshBefore), withExternalID(externalID))
 		stsConfig.Credentials = creds
 		stsConfig.Region = aws.String(awsRegion)
 		stsConfig.HTTPClient = httpClient
+		stsConfig.EndpointResolver = endpoints.ResolverFunc(customResolverFn)
+		stsConfig.UseFIPSEndpoint = fipsEndpointState
+		stsConfig.MaxRetries = aws.Int(sdkMaxRetries)
 		svcConfig = stsConfig
 
 		svcSess, err = session.NewSession(svcConfig)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	client := kinesis.New(svcSess, svcConfig)
-	client.Handlers.Build.PushBackNamed(plugins.CustomUserAgentHandler())
-	return client, nil
+	storeSharedCredentials(credsKey, svcSess.Config.Credentials)
+
+	return svcSess, svcConfig, nil
+}
+
+// sendFailureTimer guards the timeout which exits Fluent Bit once sends have failed for
+// SEND_FAILURE_TIMEOUT. plugins.Timeout is written for a single goroutine, while
+// concurrent flushes, flush workers and the spill drain all start and reset it.
+type sendFailureTimer struct {
+	mutex   sync.Mutex
+	timeout *plugins.Timeout
+}
+
+func newSendFailureTimer(timeout *plugins.Timeout) *sendFailureTimer {
+	return &sendFailureTimer{timeout: timeout}
+}
+
+// Start starts the timer, unless it is already running
+func (t *sendFailureTimer) Start() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timeout.Start()
+}
+
+// Reset stops the timer
+func (t *sendFailureTimer) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timeout.Reset()
+}
+
+// Check exits Fluent Bit if the timer has run for longer than the timeout
+func (t *sendFailureTimer) Check() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timeout.Check()
 }
 
 // AddRecord accepts a record and adds it to the buffer
 // the return value is one of: FLB_OK FLB_RETRY FLB_ERROR
 func (outputPlugin *OutputPlugin) AddRecord(records *[]*kinesis.PutRecordsRequestEntry, record map[interface{}]interface{}, timeStamp *time.Time) int {
-	if outputPlugin.timeKey != "" {
+	return outputPlugin.AddRecordWithMetadata(records, record, nil, timeStamp)
+}
+
+// AddRecordWithMetadata is AddRecord for a record with the metadata Fluent Bit 2.1 and later
+// keep apart from the record body. metadata is nil if Fluent Bit does not support it.
+func (outputPlugin *OutputPlugin) AddRecordWithMetadata(records *[]*kinesis.PutRecordsRequestEntry, record map[interface{}]interface{}, metadata map[interface{}]interface{}, timeStamp *time.Time) int {
+	return outputPlugin.AddTaggedRecord(records, record, metadata, "", timeStamp)
+}
+
+// AddTaggedRecord is AddRecordWithMetadata for a record flushed with the tag, which
+// source_path_tag_regex extracts the source path from
+func (outputPlugin *OutputPlugin) AddTaggedRecord(records *[]*kinesis.PutRecordsRequestEntry, record map[interface{}]interface{}, metadata map[interface{}]interface{}, tag string, timeStamp *time.Time) int {
+	if outputPlugin.chunkField != "" {
+		if chunks := outputPlugin.splitChunks(record); chunks != nil {
+			for _, chunk := range chunks {
+				if retCode := outputPlugin.AddTaggedRecord(records, chunk, metadata, tag, timeStamp); retCode != fluentbit.FLB_OK {
+					return retCode
+				}
+			}
+			return fluentbit.FLB_OK
+		}
+	}
+
+	if outputPlugin.ingestPacer != nil {
+		if retCode := outputPlugin.paceIngest(); retCode != fluentbit.FLB_OK {
+			return retCode
+		}
+	}
+
+	if outputPlugin.stringifyKeys {
+		stringifyKeys(record)
+	}
+
+	if outputPlugin.transcoder != nil {
+		outputPlugin.transcoder.transcode(record)
+	}
+
+	// records not routed to the stream are not part of its sequence
+	if outputPlugin.notRouted(record) {
+		return fluentbit.FLB_OK
+	}
+
+	// taken before records can be dropped, so consumers see a gap for each dropped record
+	var sequence uint64
+	if outputPlugin.sequenceKey != "" {
+		sequence = outputPlugin.nextSequence()
+	}
+
+	if outputPlugin.exceedsMaxFields(record) {
+		outputPlugin.markGap(records, sequence, gapMaxFields)
+		// the batch continues with the remaining records
+		return fluentbit.FLB_OK
+	}
+
+	if outputPlugin.droppedWhere(record) {
+		outputPlugin.markGap(records, sequence, gapDropWhere)
+		return fluentbit.FLB_OK
+	}
+
+	var dedupKey string
+	var hasDedupKey bool
+	if outputPlugin.dedup != nil {
+		dedupKey, hasDedupKey = outputPlugin.dedup.key(record)
+		if hasDedupKey && outputPlugin.dedup.seen(dedupKey) {
+			outputPlugin.logger.Debugf("Dropping record with dedup key %s, which was sent within the dedup window or is waiting to be sent\n", dedupKey)
+			outputPlugin.markGap(records, sequence, gapDedup)
+			return fluentbit.FLB_OK
+		}
+	}
+
+	if outputPlugin.timestampNormalizer != nil {
+		if err := outputPlugin.normalizeTimestamp(record, *timeStamp); err != nil {
+			outputPlugin.logger.Errorf("Could not create timestamp %v\n", err)
+			return fluentbit.FLB_ERROR
+		}
+	}
+
+	if outputPlugin.timeKey != "" && outputPlugin.timeKeyEpochNanos {
+		record[outputPlugin.timeKey] = timeStamp.UnixNano()
+	} else if outputPlugin.timeKey != "" {
 		buf := new(bytes.Buffer)
-		err := outputPlugin.fmtStrftime.Format(buf, *timeStamp)
+		t := *timeStamp
+		if outputPlugin.timeKeyLocation != nil {
+			t = t.In(outputPlugin.timeKeyLocation)
+		}
+		err := outputPlugin.fmtStrftime.Format(buf, t)
 		if err != nil {
-			logrus.Errorf("[kinesis %d] Could not create timestamp %v\n", outputPlugin.PluginID, err)
+			outputPlugin.logger.Errorf("Could not create timestamp %v\n", err)
 			return fluentbit.FLB_ERROR
 		}
 		record[outputPlugin.timeKey] = buf.String()
 	}
 
-	partitionKey, hasPartitionKey := outputPlugin.getPartitionKey(record)
-	var partitionKeyLen = len(partitionKey)
-	if !hasPartitionKey {
-		partitionKeyLen = outputPlugin.stringGen.Size
+	if outputPlugin.sequenceKey != "" {
+		record[outputPlugin.sequenceKey] = sequence
 	}
-	data, err := outputPlugin.processRecord(record, partitionKeyLen)
-	if err != nil {
-		logrus.Errorf("[kinesis %d] %v\n", outputPlugin.PluginID, err)
+
+	if outputPlugin.versionKey != "" {
+		record[outputPlugin.versionKey] = PluginVersion
+	}
+
+	if outputPlugin.hostMetadata != nil {
+		outputPlugin.hostMetadata.addTo(record)
+	}
+
+	if outputPlugin.enricher != nil {
+		outputPlugin.enricher.enrich(record)
+	}
+
+	if outputPlugin.sourcePath != nil {
+		outputPlugin.sourcePath.addTo(record, metadata, tag)
+	}
+
+	var partitionKey string
+	var hasPartitionKey bool
+	var partitionKeyLen int
+	switch outputPlugin.partitionKeySource {
+	case PartitionKeySourceRecordHash:
+		// the key is a hash of the processed record, so only its length is known up front
+		partitionKeyLen = hex.EncodedLen(outputPlugin.recordHasher().Size())
+	case PartitionKeySourceRoundRobin:
+		partitionKey, hasPartitionKey = outputPlugin.nextRoundRobinKey(), true
+		partitionKeyLen = len(partitionKey)
+	case PartitionKeySourceTimeBucket:
+		partitionKey, hasPartitionKey = outputPlugin.timeBucketKey(*timeStamp), true
+		partitionKeyLen = len(partitionKey)
+	case PartitionKeySourceWeighted:
+		partitionKey, hasPartitionKey = outputPlugin.weightedKeys.pick(), true
+		partitionKeyLen = len(partitionKey)
+	default:
+		partitionKey, hasPartitionKey = outputPlugin.getPartitionKey(outputPlugin.partitionKeyRecord(record, metadata))
+		partitionKeyLen = len(partitionKey)
+		if !hasPartitionKey {
+			partitionKeyLen = outputPlugin.stringGen.Size
+		}
+	}
+	mirrored := outputPlugin.mirror != nil && outputPlugin.mirror.condition.matches(record)
+	// after the partition key and mirror condition are taken, so they can use the stripped fields
+	if len(outputPlugin.stripFields) > 0 {
+		stripFields(record, outputPlugin.stripFields)
+	}
+	// with the partition key in the envelope, a record without one has its random key picked
+	// before it is marshaled, so the envelope names the key the record is sent with
+	var randomKey string
+	var meta []byte
+	if outputPlugin.envelope != nil {
+		if outputPlugin.envelope.hasField(EnvelopeFieldPartitionKey) && !hasPartitionKey && !outputPlugin.isAggregate {
+			randomKey = outputPlugin.stringGen.RandomString()
+		}
+		envelopeKey := partitionKey
+		if !hasPartitionKey {
+			envelopeKey = randomKey
+		}
+		var err error
+		meta, err = outputPlugin.envelope.meta(outputPlugin.stream, tag, *timeStamp, envelopeKey)
+		if err != nil {
+			outputPlugin.logger.Errorf("Failed to marshal the envelope of a record: %v\n", err)
+			outputPlugin.markGap(records, sequence, gapProcessError)
+			return fluentbit.FLB_OK
+		}
+	}
+	data, err := outputPlugin.processEnvelopedRecord(record, partitionKeyLen, meta)
+	if mErr, ok := err.(*marshalError); ok {
+		outputPlugin.handleMarshalError(mErr, partitionKey, hasPartitionKey)
+		outputPlugin.markGap(records, sequence, gapMarshalError)
+		// the batch continues with the remaining records
+		return fluentbit.FLB_OK
+	} else if err == errProjectionNoMatch {
+		outputPlugin.logger.Debugf("Dropping record which the projection found nothing in\n")
+		outputPlugin.markGap(records, sequence, gapNoProjection)
+		return fluentbit.FLB_OK
+	} else if err != nil {
+		outputPlugin.logger.Errorf("%v\n", err)
+		outputPlugin.markGap(records, sequence, gapProcessError)
 		// discard this single bad record instead and let the batch continue
 		return fluentbit.FLB_OK
 	}
 
+	if outputPlugin.partitionKeySource == PartitionKeySourceRecordHash {
+		partitionKey, hasPartitionKey = outputPlugin.recordHash(data), true
+	}
+
+	if outputPlugin.histogram != nil {
+		outputPlugin.histogram.add(partitionKey, hasPartitionKey)
+	}
+	if outputPlugin.emf != nil {
+		outputPlugin.emf.add(partitionKey, hasPartitionKey)
+	}
+
+	// records without a partition key are spread over the shards by their random key
+	var explicitHashKey *string
+	if outputPlugin.hashRing != nil && hasPartitionKey {
+		explicitHashKey = aws.String(outputPlugin.hashRing.explicitHashKey(partitionKey))
+	}
+
+	if randomKey != "" {
+		partitionKey, hasPartitionKey = randomKey, true
+	}
+
+	if mirrored {
+		// records for the mirror stream are never aggregated
+		mirrorKey := partitionKey
+		if !hasPartitionKey {
+			mirrorKey = outputPlugin.stringGen.RandomString()
+		}
+		if dropped := outputPlugin.mirror.add(&kinesis.PutRecordsRequestEntry{
+			Data:         data,
+			PartitionKey: aws.String(mirrorKey),
+		}); dropped > 0 {
+			outputPlugin.logger.Errorf("Dropped %d records queued for mirror stream %s\n", dropped, outputPlugin.mirror.stream)
+		}
+	}
+
 	if !outputPlugin.isAggregate {
 		if !hasPartitionKey {
 			partitionKey = outputPlugin.stringGen.RandomString()
 		}
-		logrus.Debugf("[kinesis %d] Got value: %s for a given partition key.\n", outputPlugin.PluginID, partitionKey)
-		*records = append(*records, &kinesis.PutRecordsRequestEntry{
-			Data:         data,
-			PartitionKey: aws.String(partitionKey),
-		})
+		outputPlugin.logger.Debugf("Got value: %s for a given partition key.\n", partitionKey)
+		entry := &kinesis.PutRecordsRequestEntry{
+			Data:            data,
+			PartitionKey:    aws.String(partitionKey),
+			ExplicitHashKey: explicitHashKey,
+		}
+		if hasDedupKey {
+			outputPlugin.dedup.track(entry, dedupKey)
+		}
+		outputPlugin.latency.add(entry, *timeStamp)
+		*records = append(*records, entry)
+	} else if outputPlugin.groups != nil {
+		// aggregated per partition key once the chunk is complete, see FlushAggregatedRecords
+		outputPlugin.groups.add(partitionKey, hasPartitionKey, data)
+		outputPlugin.latency.add(nil, *timeStamp)
 	} else {
 		// Use the KPL aggregator to buffer records isAggregate is true
 		aggRecord, err := outputPlugin.aggregator.AddRecord(partitionKey, hasPartitionKey, data)
 		if err != nil {
-			logrus.Errorf("[kinesis %d] Failed to aggregate record %v\n", outputPlugin.PluginID, err)
+			outputPlugin.logger.Errorf("Failed to aggregate record %v\n", err)
+			outputPlugin.markGap(records, sequence, gapAggregateFail)
 			// discard this single bad record instead and let the batch continue
 			return fluentbit.FLB_OK
 		}
+		outputPlugin.latency.add(nil, *timeStamp)
 
 		// If aggRecord isn't nil, then a full kinesis record has been aggregated
 		if aggRecord != nil {
+			outputPlugin.latency.assign(aggRecord)
 			*records = append(*records, aggRecord)
 		}
 	}
 
+	// only once the record is accepted, so dropped and rejected records have no metadata.
+	// The metadata names the key the record is sent with.
+	if outputPlugin.metadata != nil {
+		outputPlugin.addMetadata(record, len(data), partitionKey, *timeStamp)
+	}
+
 	return fluentbit.FLB_OK
 }
 
@@ -295,40 +1649,171 @@ func (outputPlugin *OutputPlugin) AddRecord(records *[]*kinesis.PutRecordsReques
 // Returns FLB_OK, FLB_RETRY, FLB_ERROR
 func (outputPlugin *OutputPlugin) FlushAggregatedRecords(records *[]*kinesis.PutRecordsRequestEntry) int {
 
+	if outputPlugin.groups != nil {
+		if err := outputPlugin.aggregateGroups(records); err != nil {
+			outputPlugin.logger.Errorf("Failed to aggregate record %v\n", err)
+			return fluentbit.FLB_ERROR
+		}
+	}
+
 	aggRecord, err := outputPlugin.aggregator.AggregateRecords()
 	if err != nil {
-		logrus.Errorf("[kinesis %d] Failed to aggregate record %v\n", outputPlugin.PluginID, err)
+		outputPlugin.logger.Errorf("Failed to aggregate record %v\n", err)
 		return fluentbit.FLB_ERROR
 	}
 
 	if aggRecord != nil {
 		*records = append(*records, aggRecord)
 	}
+	if len(*records) > 0 {
+		// the records of a chunk are flushed together, so the rest are counted with its last entry
+		outputPlugin.latency.assign((*records)[len(*records)-1])
+	}
 
 	return fluentbit.FLB_OK
 }
 
+// DiscardRecords forgets the records this plugin won't send, because their chunk was
+// abandoned or left for Fluent Bit to deliver again, or they were dropped
+func (outputPlugin *OutputPlugin) DiscardRecords(records []*kinesis.PutRecordsRequestEntry) {
+	outputPlugin.latency.forget(records)
+	if outputPlugin.dedup != nil {
+		outputPlugin.dedup.release(records)
+	}
+}
+
 // Flush sends the current buffer of log records
 // Returns FLB_OK, FLB_RETRY, FLB_ERROR
 func (outputPlugin *OutputPlugin) Flush(records *[]*kinesis.PutRecordsRequestEntry) int {
+	if outputPlugin.nothingToFlush(*records) {
+		outputPlugin.logger.Debugf("No records to flush\n")
+		return fluentbit.FLB_OK
+	}
+	// taken up front, so the records a resumed chunk sends later are not counted twice
+	eventTimes := outputPlugin.latency.take(*records)
+	if retCode := outputPlugin.ReplayCheckpoints(); retCode != fluentbit.FLB_OK {
+		return retCode
+	}
+	// started after the replay, so a slow replay doesn't use up the chunk's deadline
+	var deadline time.Time
+	if outputPlugin.flushDeadline > 0 {
+		deadline = time.Now().Add(outputPlugin.flushDeadline)
+	}
+	checkpoint, retCode := outputPlugin.saveCheckpoint(*records)
+	if retCode != fluentbit.FLB_OK {
+		return retCode
+	}
+	outputPlugin.addBuffered(len(*records))
+	defer outputPlugin.addBuffered(-len(*records))
+	if outputPlugin.spill != nil {
+		// the spill drain waits for the flush to finish before it sends
+		outputPlugin.spill.flushMutex.Lock()
+	}
+	retCode, _ = outputPlugin.flushUntil(records, deadline)
+	if outputPlugin.spill != nil {
+		outputPlugin.spill.flushMutex.Unlock()
+	}
+	// Fluent Bit holds on to a chunk it retries, so the checkpoint is only needed while the flush runs
+	outputPlugin.removeCheckpoint(checkpoint)
+	outputPlugin.observeLatency(eventTimes, retCode)
+	return retCode
+}
+
+// nothingToFlush reports whether a flush of records would send nothing, when every
+// record of the chunk was dropped or filtered and no records are queued for the
+// secondary streams, so the flush can return without sending a request
+func (outputPlugin *OutputPlugin) nothingToFlush(records []*kinesis.PutRecordsRequestEntry) bool {
+	if len(records) > 0 {
+		return false
+	}
+	for _, q := range outputPlugin.secondaryQueues() {
+		if !q.queue.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// flush sends the current buffer of log records, returning the error which stopped it, if any
+func (outputPlugin *OutputPlugin) flush(records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+	return outputPlugin.flushUntil(records, time.Time{})
+}
+
+// flushUntil is flush, but stops sending new batches once the deadline passes, if it is set
+func (outputPlugin *OutputPlugin) flushUntil(records *[]*kinesis.PutRecordsRequestEntry, deadline time.Time) (int, error) {
+	for _, pipeline := range outputPlugin.pipelines {
+		pipeline.notify()
+	}
+	retCode := fluentbit.FLB_OK
+	var err error
+	if len(*records) > 0 {
+		retCode, err = outputPlugin.flushStreamUntil(outputPlugin.stream, records, deadline)
+	}
+	if len(outputPlugin.pipelines) > 0 {
+		return retCode, err
+	}
+	for _, q := range outputPlugin.secondaryQueues() {
+		outputPlugin.flushMirror(q.queue, q.name)
+	}
+	return retCode, err
+}
+
+// secondaryQueues returns the queues of records for streams other than the main stream
+func (outputPlugin *OutputPlugin) secondaryQueues() []namedQueue {
+	var queues []namedQueue
+	if outputPlugin.mirror != nil {
+		queues = append(queues, namedQueue{queue: outputPlugin.mirror, name: "mirror"})
+	}
+	if outputPlugin.metadata != nil {
+		queues = append(queues, namedQueue{queue: outputPlugin.metadata, name: "metadata"})
+	}
+	if outputPlugin.deadLetters != nil {
+		queues = append(queues, namedQueue{queue: outputPlugin.deadLetters, name: "dlq"})
+	}
+	return queues
+}
+
+// flushStream sends records to a stream, leaving the records it failed to send in the buffer
+func (outputPlugin *OutputPlugin) flushStream(stream string, records *[]*kinesis.PutRecordsRequestEntry) (int, error) {
+	return outputPlugin.flushStreamUntil(stream, records, time.Time{})
+}
+
+// flushStreamUntil is flushStream, but once a batch has been sent and the deadline has
+// passed it stops, leaving the records it did not send in the buffer and returning FLB_RETRY
+func (outputPlugin *OutputPlugin) flushStreamUntil(stream string, records *[]*kinesis.PutRecordsRequestEntry, deadline time.Time) (int, error) {
 	// Use a different buffer to batch the logs
-	requestBuf := make([]*kinesis.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
+	requestBuf := outputPlugin.newRequestBuf()
+	defer func() { outputPlugin.releaseRequestBuf(requestBuf) }()
 	dataLength := 0
+	sentBatch := false
 
 	for i, record := range *records {
 		newRecordSize := len(record.Data) + len(aws.StringValue(record.PartitionKey))
 
-		if len(requestBuf) == maximumRecordsPerPut || (dataLength+newRecordSize) > maximumPutRecordBatchSize {
-			retCode, err := outputPlugin.sendCurrentBatch(&requestBuf, &dataLength)
+		// the batch is only sent once it holds a record, so every record is sent, if need be in
+		// a batch of its own, and a record larger than the batch target never stalls the flush
+		if len(requestBuf) == outputPlugin.batchRecordsLimit() || (len(requestBuf) > 0 && dataLength+newRecordSize > outputPlugin.batchBytesTarget()) {
+			if sentBatch && deadlinePassed(deadline) {
+				*records = outputPlugin.unsentRecords(requestBuf, (*records)[i:])
+				outputPlugin.sampledWarnf("Flush deadline passed, leaving %d records to be retried\n", len(*records))
+				return fluentbit.FLB_RETRY, errFlushDeadline
+			}
+			sentBatch = true
+			retCode, err := outputPlugin.sendCurrentBatch(stream, &requestBuf, &dataLength)
 			if err != nil {
-				logrus.Errorf("[kinesis %d] %v\n", outputPlugin.PluginID, err)
+				outputPlugin.sampledErrorf("%v\n", err)
 			}
 			if retCode != fluentbit.FLB_OK {
-				unsent := (*records)[i:]
 				// requestBuf will contain records sendCurrentBatch failed to send,
 				// combine those with the records yet to be sent/batched
-				*records = append(requestBuf, unsent...)
-				return retCode
+				*records = outputPlugin.unsentRecords(requestBuf, (*records)[i:])
+				return retCode, err
+			}
+			if outputPlugin.preserveChunkOrder && len(requestBuf) > 0 {
+				// the failed records are retried before any later record is sent
+				*records = outputPlugin.unsentRecords(requestBuf, (*records)[i:])
+				outputPlugin.sampledWarnf("%v\n", errChunkOrder)
+				return fluentbit.FLB_RETRY, errChunkOrder
 			}
 		}
 
@@ -336,85 +1821,241 @@ func (outputPlugin *OutputPlugin) Flush(records *[]*kinesis.PutRecordsRequestEnt
 		dataLength += newRecordSize
 	}
 
+	if sentBatch && len(requestBuf) > 0 && deadlinePassed(deadline) {
+		*records = outputPlugin.unsentRecords(requestBuf, nil)
+		outputPlugin.sampledWarnf("Flush deadline passed, leaving %d records to be retried\n", len(*records))
+		return fluentbit.FLB_RETRY, errFlushDeadline
+	}
+
 	// send any remaining records
-	retCode, err := outputPlugin.sendCurrentBatch(&requestBuf, &dataLength)
+	retCode, err := outputPlugin.sendCurrentBatch(stream, &requestBuf, &dataLength)
 	if err != nil {
-		logrus.Errorf("[kinesis %d] %v\n", outputPlugin.PluginID, err)
+		outputPlugin.sampledErrorf("%v\n", err)
+	}
+
+	if retCode == output.FLB_OK && outputPlugin.partialAck && len(requestBuf) > 0 {
+		outputPlugin.sampledWarnf("%d records failed to be delivered, returning a retry for them\n", len(requestBuf))
+		retCode, err = fluentbit.FLB_RETRY, errPartialFailure
 	}
 
 	if retCode == output.FLB_OK {
-		logrus.Debugf("[kinesis %d] Flushed %d logs\n", outputPlugin.PluginID, len(*records))
+		outputPlugin.logger.Debugf("Flushed %d logs\n", len(*records))
 	}
 
 	// requestBuf will contain records sendCurrentBatch failed to send
-	*records = requestBuf
-	return retCode
+	*records = outputPlugin.unsentRecords(requestBuf, nil)
+	return retCode, err
 }
 
 // FlushWithRetries sends the current buffer of log records, with retries
 func (outputPlugin *OutputPlugin) FlushWithRetries(count int, records []*kinesis.PutRecordsRequestEntry) {
+	outputPlugin.flushWithRetries(count, records, 0)
+}
+
+// flushWithRetries is FlushWithRetries for records persisted in the checkpoint, which is
+// kept up to date with the records left unsent, and removed once the flush is done
+func (outputPlugin *OutputPlugin) flushWithRetries(count int, records []*kinesis.PutRecordsRequestEntry, checkpoint uint64) {
 	var retCode, tries int
+	var err error
+	var budgetExhausted bool
+	var retryAfter time.Duration
+	size := recordsSize(records)
 
 	currentRetries := outputPlugin.getConcurrentRetries()
 	outputPlugin.addGoroutineCount(1)
+	outputPlugin.addBuffered(len(records))
+	defer outputPlugin.addBuffered(-len(records))
+	var inflightID int
+	if outputPlugin.inflight != nil {
+		inflightID = outputPlugin.inflight.add(records)
+		defer outputPlugin.inflight.remove(inflightID)
+	}
 
 	for tries = 0; tries <= outputPlugin.concurrencyRetryLimit; tries++ {
 		if currentRetries > 0 {
 			// Wait if other goroutines are retrying, as well as implement a progressive backoff
-			if currentRetries > uint32(outputPlugin.concurrencyRetryLimit) {
-				time.Sleep(time.Duration((1<<uint32(outputPlugin.concurrencyRetryLimit))*100) * time.Millisecond)
-			} else {
-				time.Sleep(time.Duration((1<<currentRetries)*100) * time.Millisecond)
-			}
+			retrySleep(outputPlugin.retryDelay(currentRetries, retryAfter))
 		}
 
-		logrus.Debugf("[kinesis %d] Sending (%d) records, currentRetries=(%d)", outputPlugin.PluginID, len(records), currentRetries)
-		retCode = outputPlugin.Flush(&records)
+		outputPlugin.logger.Debugf("Sending (%d) records, currentRetries=(%d)", len(records), currentRetries)
+		if outputPlugin.coalescer != nil {
+			retCode, err = outputPlugin.coalescer.flush(&records)
+		} else {
+			retCode, err = outputPlugin.flush(&records)
+		}
+		if outputPlugin.inflight != nil {
+			// records only holds those still unsent
+			outputPlugin.inflight.update(inflightID, records)
+		}
+		if retCode == output.FLB_RETRY {
+			outputPlugin.updateCheckpoint(checkpoint, records)
+		}
+		retryAfter = retryAfterHint(err)
 		if retCode != output.FLB_RETRY {
 			break
 		}
+		if tries < outputPlugin.concurrencyRetryLimit && !outputPlugin.AllowRetry() {
+			budgetExhausted = true
+			break
+		}
 		currentRetries = outputPlugin.addConcurrentRetries(1)
-		logrus.Infof("[kinesis %d] Going to retry with (%d) records, currentRetries=(%d)", outputPlugin.PluginID, len(records), currentRetries)
+		outputPlugin.flushInfof("Going to retry with (%d) records, currentRetries=(%d)", len(records), currentRetries)
 	}
 
 	outputPlugin.addGoroutineCount(-1)
 	if tries > 0 {
 		outputPlugin.addConcurrentRetries(-tries)
 	}
+	outputPlugin.addInflightBytes(-size)
 
 	switch retCode {
 	case output.FLB_ERROR:
-		logrus.Errorf("[kinesis %d] Failed to send (%d) records with error", outputPlugin.PluginID, len(records))
+		outputPlugin.sampledErrorf("Failed to send (%d) records with error", len(records))
 	case output.FLB_RETRY:
-		logrus.Errorf("[kinesis %d] Failed to send (%d) records after retries %d", outputPlugin.PluginID, len(records), outputPlugin.concurrencyRetryLimit)
+		if err == nil {
+			err = fmt.Errorf("failed after %d retries", outputPlugin.concurrencyRetryLimit)
+		}
+		if budgetExhausted {
+			if outputPlugin.DeadLetter(records, fmt.Errorf("%v, retry budget exhausted", err)) {
+				break
+			}
+			outputPlugin.sampledErrorf("Failed to send (%d) records, dropping them since the retry budget is exhausted", len(records))
+			break
+		}
+		if outputPlugin.spill != nil && outputPlugin.spillRecords(records) == output.FLB_OK {
+			outputPlugin.sampledWarnf("Spilled (%d) records to disk after retries %d", len(records), outputPlugin.concurrencyRetryLimit)
+			break
+		}
+		if outputPlugin.DeadLetter(records, err) {
+			break
+		}
+		outputPlugin.sampledErrorf("Failed to send (%d) records after retries %d", len(records), outputPlugin.concurrencyRetryLimit)
 	case output.FLB_OK:
-		logrus.Debugf("[kinesis %d] Flushed %d records\n", outputPlugin.PluginID, count)
+		outputPlugin.logger.Debugf("Flushed %d records\n", count)
 	}
+	// records only holds those which were not sent
+	outputPlugin.DiscardRecords(records)
+	// the records were sent, spilled, dead-lettered or dropped
+	outputPlugin.removeCheckpoint(checkpoint)
 }
 
 // FlushConcurrent sends the current buffer of log records in a goroutine with retries
 // Returns FLB_OK, FLB_RETRY
 // Will return FLB_RETRY if the limit of concurrency has been reached
-func (outputPlugin *OutputPlugin) FlushConcurrent(count int, records []*kinesis.PutRecordsRequestEntry) int {
+func (outputPlugin *OutputPlugin) FlushConcurrent(count int, records []*kinesis.PutRecordsRequestEntry) (retCode int) {
+	if outputPlugin.nothingToFlush(records) {
+		outputPlugin.logger.Debugf("No records to flush\n")
+		return output.FLB_OK
+	}
+	eventTimes := outputPlugin.latency.take(records)
+	defer func() { outputPlugin.observeLatency(eventTimes, retCode) }()
+
+	if retCode := outputPlugin.ReplayCheckpoints(); retCode != output.FLB_OK {
+		return retCode
+	}
+
+	if outputPlugin.spill != nil && outputPlugin.spill.pending() {
+		// once records have been spilled, keep spilling until the queue drains to preserve ordering
+		return outputPlugin.spillRecords(records)
+	}
 
 	runningGoRoutines := outputPlugin.getGoroutineCount()
 	if runningGoRoutines+1 > int32(outputPlugin.Concurrency) {
-		logrus.Infof("[kinesis %d] flush returning retry, concurrency limit reached (%d)\n", outputPlugin.PluginID, runningGoRoutines)
+		if outputPlugin.spill != nil {
+			return outputPlugin.spillRecords(records)
+		}
+		outputPlugin.flushInfof("flush returning retry, concurrency limit reached (%d)\n", runningGoRoutines)
 		return output.FLB_RETRY
 	}
 
 	curRetries := outputPlugin.getConcurrentRetries()
 	if curRetries > 0 {
-		logrus.Infof("[kinesis %d] flush returning retry, kinesis retries in progress (%d)\n", outputPlugin.PluginID, curRetries)
+		if outputPlugin.spill != nil {
+			return outputPlugin.spillRecords(records)
+		}
+		outputPlugin.flushInfof("flush returning retry, kinesis retries in progress (%d)\n", curRetries)
+		return output.FLB_RETRY
+	}
+
+	size := recordsSize(records)
+	if outputPlugin.bufferMaxBytes > 0 && outputPlugin.getInflightBytes()+size > outputPlugin.bufferMaxBytes {
+		if outputPlugin.spill != nil {
+			return outputPlugin.spillRecords(records)
+		}
+		outputPlugin.flushInfof("flush returning retry, buffer limit reached (%d bytes)\n", outputPlugin.getInflightBytes())
 		return output.FLB_RETRY
 	}
 
-	go outputPlugin.FlushWithRetries(count, records)
+	checkpoint, retCode := outputPlugin.saveCheckpoint(records)
+	if retCode != output.FLB_OK {
+		return retCode
+	}
+	outputPlugin.addInflightBytes(size)
+	go outputPlugin.flushWithRetries(count, records, checkpoint)
 
 	return output.FLB_OK
 
 }
 
+// flushInfof logs informational messages emitted on every flush, which are
+// demoted to debug level when the plugin is configured to be quiet
+func (outputPlugin *OutputPlugin) flushInfof(format string, args ...interface{}) {
+	if outputPlugin.quiet {
+		outputPlugin.logger.Debugf(format, args...)
+		return
+	}
+	outputPlugin.logger.Infof(format, args...)
+}
+
+// AllowRetry consumes a retry from the retry budget, if one is configured.
+// It returns false if the budget has been exhausted, in which case failing records
+// should be dropped rather than retried until the budget refills.
+func (outputPlugin *OutputPlugin) AllowRetry() bool {
+	if outputPlugin.retryBudget == nil {
+		return true
+	}
+
+	if outputPlugin.retryBudget.Take() {
+		if atomic.CompareAndSwapInt32(&outputPlugin.retryBudgetExhausted, 1, 0) {
+			outputPlugin.logger.Infof("Retry budget has refilled, failed records will be retried again")
+		}
+		return true
+	}
+
+	if atomic.CompareAndSwapInt32(&outputPlugin.retryBudgetExhausted, 0, 1) {
+		outputPlugin.logger.Warnf("Retry budget of %d retries per minute exhausted, failed records will be dropped until it refills", outputPlugin.retryBudgetPerMinute)
+	}
+	return false
+}
+
+// paceIngest blocks until the record may be processed under max_ingest_records_per_sec.
+// If that would take longer than ingestMaxWait, for example because several chunks
+// are flushed at once, it returns FLB_RETRY so Fluent Bit holds the records instead.
+func (outputPlugin *OutputPlugin) paceIngest() int {
+	wait, ok := outputPlugin.ingestPacer.Reserve(outputPlugin.ingestMaxWait)
+	if !ok {
+		outputPlugin.flushInfof("Ingest rate limit reached, returning retry instead of waiting %v\n", wait)
+		return fluentbit.FLB_RETRY
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return fluentbit.FLB_OK
+}
+
+// spillRecords queues records on disk to be sent once Kinesis catches up
+// Returns FLB_OK, FLB_RETRY
+func (outputPlugin *OutputPlugin) spillRecords(records []*kinesis.PutRecordsRequestEntry) int {
+	if err := outputPlugin.spill.push(records); err != nil {
+		outputPlugin.sampledErrorf("Failed to spill (%d) records to disk: %v\n", len(records), err)
+		return output.FLB_RETRY
+	}
+	outputPlugin.logger.Debugf("Spilled (%d) records to disk\n", len(records))
+	// the spilled records are sent as new entries once they are read back
+	outputPlugin.DiscardRecords(records)
+	return output.FLB_OK
+}
+
 func replaceDots(obj map[interface{}]interface{}, replacement string) map[interface{}]interface{} {
 	for k, v := range obj {
 		var curK = k
@@ -435,6 +2076,12 @@ func replaceDots(obj map[interface{}]interface{}, replacement string) map[interf
 }
 
 func (outputPlugin *OutputPlugin) processRecord(record map[interface{}]interface{}, partitionKeyLen int) ([]byte, error) {
+	return outputPlugin.processEnvelopedRecord(record, partitionKeyLen, nil)
+}
+
+// processEnvelopedRecord is processRecord, wrapping the record marshaled to JSON in the
+// envelope with the meta object, if it is non-nil
+func (outputPlugin *OutputPlugin) processEnvelopedRecord(record map[interface{}]interface{}, partitionKeyLen int, meta []byte) ([]byte, error) {
 	if outputPlugin.dataKeys != "" {
 		record = plugins.DataKeys(outputPlugin.dataKeys, record)
 	}
@@ -442,31 +2089,61 @@ func (outputPlugin *OutputPlugin) processRecord(record map[interface{}]interface
 	var err error
 	record, err = plugins.DecodeMap(record)
 	if err != nil {
-		logrus.Debugf("[kinesis %d] Failed to decode record: %v\n", outputPlugin.PluginID, record)
+		outputPlugin.logger.Debugf("Failed to decode record: %v\n", record)
 		return nil, err
 	}
 
+	if len(outputPlugin.compressKeys) > 0 {
+		if err := outputPlugin.compressFields(record); err != nil {
+			return nil, &marshalError{err: err, record: record}
+		}
+	}
+
 	if outputPlugin.replaceDots != "" {
 		record = replaceDots(record, outputPlugin.replaceDots)
 	}
 
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	var json = outputPlugin.recordJSON
+	if json == nil {
+		json = jsoniter.ConfigCompatibleWithStandardLibrary
+	}
 	var data []byte
 
 	if outputPlugin.logKey != "" {
-		log, err := plugins.LogKey(record, outputPlugin.logKey)
+		var log *interface{}
+		log, err = plugins.LogKey(record, outputPlugin.logKey)
 		if err != nil {
 			return nil, err
 		}
 
 		data, err = plugins.EncodeLogKey(log)
+	} else if outputPlugin.dataKeysOutput == DataKeysOutputValues && outputPlugin.dataKeys != "" {
+		data, err = outputPlugin.joinDataKeyValues(record)
+	} else if outputPlugin.avro != nil {
+		data, err = outputPlugin.avro.encode(record)
+	} else if outputPlugin.protobuf != nil {
+		data, err = outputPlugin.protobuf.encode(record)
+	} else if outputPlugin.projection != nil {
+		data, err = outputPlugin.projection.apply(record, json)
+		if err == errProjectionNoMatch {
+			return nil, err
+		}
 	} else {
 		data, err = json.Marshal(record)
+		if err == nil && outputPlugin.sizeKey != "" {
+			data, err = injectSize(data, outputPlugin.sizeKey)
+		}
+		if err == nil && outputPlugin.checksumKey != "" {
+			data, err = injectChecksum(data, outputPlugin.checksumKey, outputPlugin.checksumAlgo)
+		}
 	}
 
 	if err != nil {
-		logrus.Debugf("[kinesis %d] Failed to marshal record: %v\n", outputPlugin.PluginID, record)
-		return nil, err
+		return nil, &marshalError{err: err, record: record}
+	}
+
+	if meta != nil {
+		data = wrapEnvelope(meta, data)
 	}
 
 	// append a newline after each log record
@@ -475,72 +2152,191 @@ func (outputPlugin *OutputPlugin) processRecord(record map[interface{}]interface
 	}
 
 	// max truncation size
-	maxDataSize := maximumRecordSize-partitionKeyLen
+	maxDataSize := outputPlugin.recordSizeLimit() - partitionKeyLen - outputPlugin.frameOverhead()
+
+	codec := outputPlugin.codecHeader()
+	compression := outputPlugin.compression
+	if compression != CompressionNone && outputPlugin.skipCompression(data) {
+		outputPlugin.logger.Debugf("Sending record uncompressed, its estimated entropy is above the compression threshold\n")
+		compression = CompressionNone
+		codec = CodecHeaderNone
+	}
 
-	switch outputPlugin.compression {
+	switch compression {
 	case CompressionZlib:
-		data, err = compressThenTruncate(zlibCompress, data, maxDataSize, []byte(truncatedSuffix), *outputPlugin)
+		compressor := zlibCompress
+		if outputPlugin.recordPool {
+			compressor = pooledZlibCompress
+		}
+		if outputPlugin.zlibDictCompressor != nil {
+			compressor = outputPlugin.zlibDictCompressor
+		}
+		data, err = compressThenTruncate(compressor, data, maxDataSize, []byte(truncatedSuffix), *outputPlugin)
 	case CompressionGzip:
-		data, err = compressThenTruncate(gzipCompress, data, maxDataSize, []byte(truncatedSuffix), *outputPlugin)
+		compressor := gzipCompress
+		if outputPlugin.gzipSyncFlush {
+			compressor = gzipSyncCompress
+		} else if outputPlugin.recordPool {
+			compressor = pooledGzipCompress
+		}
+		data, err = compressThenTruncate(compressor, data, maxDataSize, []byte(truncatedSuffix), *outputPlugin)
 	default:
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	if len(data)+partitionKeyLen > maximumRecordSize {
-		logrus.Warnf("[kinesis %d] Found record with %d bytes, truncating to 1MB, stream=%s\n", outputPlugin.PluginID, len(data)+partitionKeyLen, outputPlugin.stream)
+	if len(data) > maxDataSize && outputPlugin.avro != nil {
+		// a truncated record could not be decoded
+		return nil, &marshalError{err: fmt.Errorf("Avro record is %d bytes, more than the limit of %d", len(data), maxDataSize), record: record}
+	}
+	if len(data) > maxDataSize && outputPlugin.protobuf != nil {
+		return nil, &marshalError{err: fmt.Errorf("protobuf record is %d bytes, more than the limit of %d", len(data), maxDataSize), record: record}
+	}
+	if len(data) > maxDataSize {
+		outputPlugin.logger.Warnf("Found record with %d bytes, truncating to 1MB\n", len(data)+partitionKeyLen)
 		data = data[:maxDataSize-len(truncatedSuffix)]
 		data = append(data, []byte(truncatedSuffix)...)
 	}
 
-	return data, nil
+	return outputPlugin.frame(data, codec), nil
+}
+
+func (outputPlugin *OutputPlugin) sendCurrentBatch(stream string, records *[]*kinesis.PutRecordsRequestEntry, dataLength *int) (int, error) {
+	retCode, strategy, err := outputPlugin.putBatch(stream, records, dataLength)
+	if outputPlugin.errorRetry == nil {
+		return retCode, err
+	}
+	// records failing with codes retried with the fixed or immediate strategy are resent right away
+	for attempt := 0; attempt < outputPlugin.errorRetry.attempts && len(*records) > 0; attempt++ {
+		switch strategy {
+		case RetryStrategyFixed:
+			retrySleep(outputPlugin.errorRetry.fixedDelay)
+		case RetryStrategyImmediate:
+		default:
+			return retCode, err
+		}
+		outputPlugin.logger.Debugf("Resending %d failed records with the %s retry strategy\n", len(*records), strategy)
+		retCode, strategy, err = outputPlugin.putBatch(stream, records, dataLength)
+	}
+	return retCode, err
 }
 
-func (outputPlugin *OutputPlugin) sendCurrentBatch(records *[]*kinesis.PutRecordsRequestEntry, dataLength *int) (int, error) {
+// putBatch sends the batch once, returning the retry strategy for the records it failed to send
+func (outputPlugin *OutputPlugin) putBatch(stream string, records *[]*kinesis.PutRecordsRequestEntry, dataLength *int) (int, RetryStrategy, error) {
 	if len(*records) == 0 {
-		return fluentbit.FLB_OK, nil
+		return fluentbit.FLB_OK, "", nil
+	}
+	if err := outputPlugin.ensureClient(); err != nil {
+		return fluentbit.FLB_RETRY, "", err
+	}
+	// secondary streams, such as the mirror, metadata and dead letter streams, never touch
+	// the send failure timer, so only failures of the stream itself exit Fluent Bit
+	mainStream := stream == outputPlugin.stream
+	if mainStream {
+		outputPlugin.timer.Check()
 	}
-	outputPlugin.timer.Check()
-	response, err := outputPlugin.client.PutRecords(&kinesis.PutRecordsInput{
+	if outputPlugin.tee != nil {
+		outputPlugin.tee.write(stream, *records)
+	}
+	if outputPlugin.adaptive != nil {
+		outputPlugin.adaptive.acquire()
+	}
+	start := time.Now()
+	input := &kinesis.PutRecordsInput{
 		Records:    *records,
-		StreamName: aws.String(outputPlugin.stream),
-	})
+		StreamName: aws.String(stream),
+	}
+	trailed := outputPlugin.batchTrailer != nil && stream == outputPlugin.stream
+	if trailed {
+		input.Records = outputPlugin.withTrailer(*records)
+	}
+	client := outputPlugin.currentClient()
+	response, err := client.PutRecords(input)
+	if err != nil && outputPlugin.clientRebuild != nil && isAuthError(err) {
+		if rebuilt, ok := outputPlugin.rebuildClient(client); ok {
+			outputPlugin.logger.Warnf("PutRecords failed with %v, retrying with a rebuilt client\n", err)
+			response, err = rebuilt.PutRecords(input)
+		}
+	}
+	if trailed && err == nil {
+		response = outputPlugin.stripTrailer(response, len(*records))
+	}
+	if outputPlugin.adaptive != nil {
+		outputPlugin.adaptive.release(time.Since(start), err != nil || aws.Int64Value(response.FailedRecordCount) > 0)
+	}
 	if err != nil {
-		logrus.Errorf("[kinesis %d] PutRecords failed with %v\n", outputPlugin.PluginID, err)
-		outputPlugin.timer.Start()
+		outputPlugin.sampledErrorf("PutRecords failed with %v\n", err)
+		outputPlugin.recordStatus(stream, 0, len(*records))
+		if mainStream {
+			outputPlugin.timer.Start()
+		}
+		var strategy RetryStrategy
 		if aerr, ok := err.(awserr.Error); ok {
 			if aerr.Code() == kinesis.ErrCodeProvisionedThroughputExceededException {
-				logrus.Warnf("[kinesis %d] Throughput limits for the stream may have been exceeded.", outputPlugin.PluginID)
+				outputPlugin.sampledWarnf("Throughput limits for the stream may have been exceeded.")
+			}
+			if outputPlugin.errorRetry != nil {
+				strategy = outputPlugin.errorRetry.forCode(aerr.Code())
 			}
 		}
-		return fluentbit.FLB_RETRY, err
+		if strategy == RetryStrategyDrop {
+			outputPlugin.dropRecords(*records, err.(awserr.Error).Code())
+			*records = (*records)[:0]
+			*dataLength = 0
+			return fluentbit.FLB_OK, "", nil
+		}
+		return fluentbit.FLB_RETRY, strategy, err
+	}
+	outputPlugin.logger.Debugf("Sent %d events to Kinesis\n", len(*records))
+	failed := int(aws.Int64Value(response.FailedRecordCount))
+	outputPlugin.recordStatus(stream, len(*records)-failed, failed)
+	if outputPlugin.shardLoad != nil && stream == outputPlugin.stream {
+		outputPlugin.shardLoad.add(*records, response)
+	}
+	if outputPlugin.audit != nil {
+		if dropped := outputPlugin.audit.record(stream, *records, response); dropped > 0 {
+			outputPlugin.sampledWarnf("The audit log writer is behind, dropped %d entries\n", dropped)
+		}
 	}
-	logrus.Debugf("[kinesis %d] Sent %d events to Kinesis\n", outputPlugin.PluginID, len(*records))
 
-	return outputPlugin.processAPIResponse(records, dataLength, response)
+	if outputPlugin.errorRetry == nil || failed == 0 {
+		retCode, err := outputPlugin.processAPIResponse(mainStream, records, dataLength, response)
+		return retCode, "", err
+	}
+	response = outputPlugin.dropFailedRecords(records, dataLength, response)
+	strategy := outputPlugin.errorRetry.slowest(response)
+	retCode, err := outputPlugin.processAPIResponse(mainStream, records, dataLength, response)
+	return retCode, strategy, err
 }
 
 // processAPIResponse processes the successful and failed records
 // it returns an error iff no records succeeded (i.e.) no progress has been made
-func (outputPlugin *OutputPlugin) processAPIResponse(records *[]*kinesis.PutRecordsRequestEntry, dataLength *int, response *kinesis.PutRecordsOutput) (int, error) {
+// the send failure timer is only started and reset for responses of the main stream
+func (outputPlugin *OutputPlugin) processAPIResponse(mainStream bool, records *[]*kinesis.PutRecordsRequestEntry, dataLength *int, response *kinesis.PutRecordsOutput) (int, error) {
 
 	var retCode int = fluentbit.FLB_OK
 	var limitsExceeded bool
 
+	if outputPlugin.dedup != nil {
+		outputPlugin.dedup.markSent(*records, response)
+	}
+
 	if aws.Int64Value(response.FailedRecordCount) > 0 {
 		// start timer if all records failed (no progress has been made)
 		if aws.Int64Value(response.FailedRecordCount) == int64(len(*records)) {
-			outputPlugin.timer.Start()
+			if mainStream {
+				outputPlugin.timer.Start()
+			}
 			return fluentbit.FLB_RETRY, fmt.Errorf("PutRecords request returned with no records successfully recieved")
 		}
 
-		logrus.Warnf("[kinesis %d] %d/%d records failed to be delivered. Will retry.\n", outputPlugin.PluginID, aws.Int64Value(response.FailedRecordCount), len(*records))
+		outputPlugin.sampledWarnf("%d/%d records failed to be delivered. Will retry.\n", aws.Int64Value(response.FailedRecordCount), len(*records))
 		failedRecords := make([]*kinesis.PutRecordsRequestEntry, 0, aws.Int64Value(response.FailedRecordCount))
 		// try to resend failed records
 		for i, record := range response.Records {
 			if record.ErrorMessage != nil {
-				logrus.Debugf("[kinesis %d] Record failed to send with error: %s\n", outputPlugin.PluginID, aws.StringValue(record.ErrorMessage))
+				outputPlugin.logger.Debugf("Record failed to send with error: %s\n", aws.StringValue(record.ErrorMessage))
 				failedRecords = append(failedRecords, (*records)[i])
 			}
 
@@ -551,18 +2347,21 @@ func (outputPlugin *OutputPlugin) processAPIResponse(records *[]*kinesis.PutReco
 		}
 
 		if limitsExceeded {
-			logrus.Warnf("[kinesis %d] Throughput limits for the stream may have been exceeded.", outputPlugin.PluginID)
+			outputPlugin.sampledWarnf("Throughput limits for the stream may have been exceeded.")
 		}
 
 		*records = (*records)[:0]
 		*records = append(*records, failedRecords...)
+		// counted the way flushStreamUntil does, so batches with retried records stay within the limits
 		*dataLength = 0
 		for _, record := range *records {
-			*dataLength += len(record.Data)
+			*dataLength += len(record.Data) + len(aws.StringValue(record.PartitionKey))
 		}
 	} else {
 		// request fully succeeded
-		outputPlugin.timer.Reset()
+		if mainStream {
+			outputPlugin.timer.Reset()
+		}
 		*records = (*records)[:0]
 		*dataLength = 0
 	}
@@ -580,6 +2379,33 @@ func getFromMap(dataKey string, record map[interface{}]interface{}) interface{}
 	return ""
 }
 
+// partitionKeyRecord returns the map the partition key is taken from, the record metadata
+// with keyFromMetadata, unless Fluent Bit does not support metadata
+func (outputPlugin *OutputPlugin) partitionKeyRecord(record map[interface{}]interface{}, metadata map[interface{}]interface{}) map[interface{}]interface{} {
+	if !outputPlugin.keyFromMetadata {
+		return record
+	}
+	if metadata == nil {
+		if atomic.CompareAndSwapInt32(&outputPlugin.metadataFallbackLogged, 0, 1) {
+			outputPlugin.logger.Warnf("Records carry no metadata, which needs Fluent Bit 2.1 or later, so partition keys are taken from the record body instead\n")
+		}
+		return record
+	}
+	return metadata
+}
+
+// normalizePartitionKey applies partition_key_trim and partition_key_lowercase to a key,
+// before it is validated, hashed or truncated
+func (outputPlugin *OutputPlugin) normalizePartitionKey(value string) string {
+	if outputPlugin.keyTrim {
+		value = strings.TrimSpace(value)
+	}
+	if outputPlugin.keyLowercase {
+		value = strings.ToLower(value)
+	}
+	return value
+}
+
 // getPartitionKey returns the value for a given valid key
 // if the given key is empty or invalid, it returns empty
 // second return value indicates whether a partition key was found or not
@@ -591,19 +2417,25 @@ func (outputPlugin *OutputPlugin) getPartitionKey(record map[interface{}]interfa
 		for count, dataKey := range partitionKeys {
 			newRecord := getFromMap(dataKey, record)
 			if count == num-1 {
-				value := stringOrByteArray(newRecord)
-				if value != "" {
-					if len(value) > partitionKeyMaxLength {
-						value = value[0:partitionKeyMaxLength]
-					}
-					return value, true
+				var derived derivedPartitionKey
+				if outputPlugin.partitionKeyCache != nil {
+					derived = outputPlugin.partitionKeyCache.get(stringOrByteArray(newRecord), outputPlugin.derivePartitionKey)
+				} else {
+					derived = outputPlugin.derivePartitionKey(stringOrByteArray(newRecord))
+				}
+				if derived.found {
+					return derived.key, derived.valid
 				}
 			}
 			_, ok := newRecord.(map[interface{}]interface{})
 			if ok {
 				record = newRecord.(map[interface{}]interface{})
 			} else {
-				logrus.Errorf("[kinesis %d] The partition key could not be found in the record, using a random string instead", outputPlugin.PluginID)
+				if atomic.CompareAndSwapInt32(&outputPlugin.missingPartitionKeyLogged, 0, 1) {
+					outputPlugin.logger.Errorf("The partition key could not be found in the record, using a random string instead. Further records without the partition key are only logged at debug level")
+				} else {
+					outputPlugin.logger.Debugf("The partition key could not be found in the record, using a random string instead")
+				}
 				return "", false
 			}
 		}
@@ -611,6 +2443,58 @@ func (outputPlugin *OutputPlugin) getPartitionKey(record map[interface{}]interfa
 	return "", false
 }
 
+// derivePartitionKey derives the partition key from a value of the partition key field:
+// normalized, checked against partition_key_pattern, then hashed or truncated
+func (outputPlugin *OutputPlugin) derivePartitionKey(value string) derivedPartitionKey {
+	derived := derivedPartitionKey{value: value}
+	key := outputPlugin.normalizePartitionKey(value)
+	if key == "" {
+		return derived
+	}
+	derived.found = true
+	if outputPlugin.partitionKeyPattern != nil {
+		if key, derived.valid = outputPlugin.validPartitionKey(key); !derived.valid {
+			return derived
+		}
+	}
+	derived.valid = true
+	if outputPlugin.partitionKeyHash != nil {
+		derived.key = outputPlugin.partitionKeyHash(key)
+		return derived
+	}
+	if len(key) > partitionKeyMaxLength {
+		key = key[0:partitionKeyMaxLength]
+	}
+	derived.key = key
+	return derived
+}
+
+// injectSize adds the length of a marshaled JSON object to the object under key.
+// The size is that of the object before the key was added.
+func injectSize(data []byte, key string) ([]byte, error) {
+	return injectField(data, key, strconv.AppendInt(nil, int64(len(data)), 10))
+}
+
+// injectField appends a field with an already encoded JSON value to a JSON object
+func injectField(data []byte, key string, value []byte) ([]byte, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	size := len(data)
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	injected := make([]byte, 0, size+len(encodedKey)+len(value)+2)
+	injected = append(injected, data[:size-1]...)
+	if size > 2 {
+		injected = append(injected, ',')
+	}
+	injected = append(injected, encodedKey...)
+	injected = append(injected, ':')
+	injected = append(injected, value...)
+	return append(injected, '}'), nil
+}
+
 // CompressorFunc is a function that compresses a byte slice
 type CompressorFunc func([]byte) ([]byte, error)
 
@@ -672,7 +2556,7 @@ func compressThenTruncate(compressorFunc CompressorFunc, data []byte, maxOutLen
 	truncatedInLen := len(data)
 	truncationBuffer = data
 	truncationCompressionAttempts := 0
-	for (compressedLen > maxOutLen) {
+	for compressedLen > maxOutLen {
 		compressedData, err = compressorFunc(truncationBuffer)
 		if err != nil {
 			return nil, err
@@ -680,59 +2564,55 @@ func compressThenTruncate(compressorFunc CompressorFunc, data []byte, maxOutLen
 		compressedLen = len(compressedData)
 
 		/* Truncation needed */
-		if (compressedLen > maxOutLen) {
+		if compressedLen > maxOutLen {
 			truncationCompressionAttempts++
-			logrus.Debugf("[kinesis %d] iterative truncation round stream=%s\n",
-						 outputPlugin.PluginID, outputPlugin.stream)
+			outputPlugin.logger.Debugf("iterative truncation round\n")
 
 			/* Base case: input compressed empty string, output still too large */
-			if (truncatedInLen == 0) {
-				logrus.Errorf("[kinesis %d] truncation failed, compressed empty input too " +
-							 "large stream=%s\n", outputPlugin.PluginID, outputPlugin.stream)
-				return nil, errors.New("compressed empty to large");
+			if truncatedInLen == 0 {
+				outputPlugin.logger.Errorf("truncation failed, compressed empty input too large\n")
+				return nil, errors.New("compressed empty to large")
 			}
 
 			/* Base case: too many attempts - just to be extra safe */
-			if (truncationCompressionAttempts > truncationCompressionMaxAttempts) {
-				logrus.Errorf("[kinesis %d] truncation failed, too many compression attempts " +
-							 "stream=%s\n", outputPlugin.PluginID, outputPlugin.stream)
-				return nil, errors.New("too many compression attempts");
+			if truncationCompressionAttempts > truncationCompressionMaxAttempts {
+				outputPlugin.logger.Errorf("truncation failed, too many compression attempts\n")
+				return nil, errors.New("too many compression attempts")
 			}
 
 			/* Calculate corrected input size */
-			truncatedInLenPrev := truncatedInLen;
-			truncatedInLen = (maxOutLen * truncatedInLen) / compressedLen;
-			truncatedInLen = (truncatedInLen * truncationReductionPercent) / 100;
+			truncatedInLenPrev := truncatedInLen
+			truncatedInLen = (maxOutLen * truncatedInLen) / compressedLen
+			truncatedInLen = (truncatedInLen * truncationReductionPercent) / 100
 
 			/* Ensure working down */
-			if (truncatedInLen >= truncatedInLenPrev) {
-				truncatedInLen = truncatedInLenPrev - 1;
+			if truncatedInLen >= truncatedInLenPrev {
+				truncatedInLen = truncatedInLenPrev - 1
 			}
 
 			/* Allocate truncation buffer */
-			if (!isTruncated) {
-				isTruncated = true;
+			if !isTruncated {
+				isTruncated = true
 				originalCompressedLen = compressedLen
 				truncationBuffer = make([]byte, truncatedInLen)
 				copy(truncationBuffer, data[:truncatedInLen])
 			}
 
 			/* Slap on truncation suffix */
-			if (truncatedInLen < len(truncatedSuffix)) {
+			if truncatedInLen < len(truncatedSuffix) {
 				/* No room for the truncation suffix. Terminal error */
-				logrus.Errorf("[kinesis %d] truncation failed, no room for suffix " +
-							 "stream=%s\n", outputPlugin.PluginID, outputPlugin.stream)
-				return nil, errors.New("no room for suffix");
+				outputPlugin.logger.Errorf("truncation failed, no room for suffix\n")
+				return nil, errors.New("no room for suffix")
 			}
 			truncationBuffer = truncationBuffer[:truncatedInLen]
 			copy(truncationBuffer[len(truncationBuffer)-len(truncatedSuffix):], truncatedSuffix)
 		}
 	}
 
-	if (isTruncated) {
-		logrus.Warnf("[kinesis %d] Found compressed record with %d bytes, " +
-					 "truncating to %d bytes after compression, stream=%s\n",
-					 outputPlugin.PluginID, originalCompressedLen, len(compressedData), outputPlugin.stream)
+	if isTruncated {
+		outputPlugin.logger.Warnf("Found compressed record with %d bytes, "+
+			"truncating to %d bytes after compression\n",
+			originalCompressedLen, len(compressedData))
 	}
 
 	return compressedData, nil
@@ -770,6 +2650,115 @@ func (outputPlugin *OutputPlugin) addGoroutineCount(val int) int32 {
 	return atomic.AddInt32(&outputPlugin.goroutineCount, int32(val))
 }
 
+// getInflightBytes value (goroutine safe)
+func (outputPlugin *OutputPlugin) getInflightBytes() int64 {
+	return atomic.LoadInt64(&outputPlugin.inflightBytes)
+}
+
+// addInflightBytes will update the value (goroutine safe)
+func (outputPlugin *OutputPlugin) addInflightBytes(val int64) int64 {
+	return atomic.AddInt64(&outputPlugin.inflightBytes, val)
+}
+
+// Logger returns the logger for this instance of the plugin
+func (outputPlugin *OutputPlugin) Logger() *logrus.Entry {
+	return outputPlugin.logger
+}
+
+// Close releases the resources held by the plugin: in-flight flushes are given up to
+// closeTimeout to finish, the records queued for the secondary streams are sent once
+// within the same timeout, and background goroutines are stopped. Records spilled to
+// disk are kept for the next instance to send. It is safe to call Close more than
+// once, and the plugin must not be used afterwards.
+func (outputPlugin *OutputPlugin) Close() error {
+	if !atomic.CompareAndSwapInt32(&outputPlugin.closed, 0, 1) {
+		return nil
+	}
+
+	var err error
+	deadline := time.Now().Add(closeTimeout)
+	if outputPlugin.coalescer != nil {
+		// the in-flight flushes waiting for a shared batch don't wait out the linger
+		outputPlugin.coalescer.close()
+	}
+	// the workers share the close timeout with the in-flight flushes
+	var queued []*kinesis.PutRecordsRequestEntry
+	timedOut := false
+	if outputPlugin.workers != nil {
+		var drained bool
+		queued, drained = outputPlugin.workers.close(closeTimeout)
+		timedOut = !drained
+	}
+	for !timedOut && outputPlugin.getGoroutineCount() > 0 {
+		if time.Now().After(deadline) {
+			timedOut = true
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if timedOut {
+		err = fmt.Errorf("timed out waiting for %d in-flight flushes", outputPlugin.getGoroutineCount())
+		if outputPlugin.inflight != nil {
+			outputPlugin.dumpInflight(err, queued)
+		} else if len(queued) > 0 {
+			outputPlugin.logger.Errorf("Dropping (%d) records still queued on the flush workers: %v\n", len(queued), err)
+		}
+	}
+
+	for _, pipeline := range outputPlugin.pipelines {
+		pipeline.close()
+	}
+	outputPlugin.drainSecondaryQueues(deadline)
+	if outputPlugin.spillStop != nil {
+		close(outputPlugin.spillStop)
+		<-outputPlugin.spillDone
+	}
+	if outputPlugin.histogramStop != nil {
+		close(outputPlugin.histogramStop)
+	}
+	if outputPlugin.shardLoadStop != nil {
+		close(outputPlugin.shardLoadStop)
+	}
+	if outputPlugin.emfStop != nil {
+		close(outputPlugin.emfStop)
+		// emit the counts since the last interval
+		if emfErr := outputPlugin.emf.emit(time.Now()); emfErr != nil {
+			outputPlugin.logger.Warnf("Failed to write EMF metrics: %v\n", emfErr)
+		}
+	}
+	if outputPlugin.enrichmentStop != nil {
+		close(outputPlugin.enrichmentStop)
+	}
+	if outputPlugin.errorLogSamplerStop != nil {
+		close(outputPlugin.errorLogSamplerStop)
+	}
+	if outputPlugin.errorLogSampler != nil {
+		// log the errors counted since the last sample
+		outputPlugin.errorLogSampler.flush()
+	}
+	if outputPlugin.statusStop != nil {
+		close(outputPlugin.statusStop)
+		if statusErr := outputPlugin.writeStatus(); statusErr != nil {
+			outputPlugin.logger.Warnf("Failed to write status file %s: %v\n", outputPlugin.status.path, statusErr)
+		}
+	}
+	if outputPlugin.audit != nil {
+		if auditErr := outputPlugin.audit.close(); auditErr != nil {
+			outputPlugin.logger.Warnf("Failed to write audit log %s: %v\n", outputPlugin.audit.path, auditErr)
+		}
+	}
+	if outputPlugin.clientStop != nil {
+		close(outputPlugin.clientStop)
+	}
+	if outputPlugin.checkpoints != nil {
+		outputPlugin.checkpoints.close()
+	}
+	// the old instance must not exit Fluent Bit once it is no longer in use
+	outputPlugin.timer.Reset()
+	outputPlugin.logger.Debugf("Closed plugin\n")
+	return err
+}
+
 // IsAggregate indicates if this instance of the plugin has KCL aggregation enabled.
 func (outputPlugin *OutputPlugin) IsAggregate() bool {
 	return outputPlugin.isAggregate
@@ -2,8 +2,13 @@ package kinesis
 
 import (
 	"encoding/json"
+	"errors"
 	"math/rand"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -17,6 +22,7 @@ import (
 	fluentbit "github.com/fluent/fluent-bit-go/output"
 	"github.com/golang/mock/gomock"
 	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,7 +31,7 @@ const concurrencyRetryLimit = 4
 // newMockOutputPlugin creates an mock OutputPlugin object
 func newMockOutputPlugin(client *mock_kinesis.MockPutRecordsClient, isAggregate bool) (*OutputPlugin, error) {
 
-	timer, _ := plugins.NewTimeout(func(d time.Duration) {
+	timeout, _ := plugins.NewTimeout(func(d time.Duration) {
 		logrus.Errorf("[kinesis] timeout threshold reached: Failed to send logs for %v", d)
 		logrus.Errorf("[kinesis] Quitting Fluent Bit")
 		os.Exit(1)
@@ -43,13 +49,18 @@ func newMockOutputPlugin(client *mock_kinesis.MockPutRecordsClient, isAggregate
 		client:                client,
 		dataKeys:              "",
 		partitionKey:          "",
-		timer:                 timer,
+		timer:                 newSendFailureTimer(timeout),
 		PluginID:              0,
+		logger:                newPluginLogger(0, "stream", "us-east-1"),
 		stringGen:             stringGen,
 		concurrencyRetryLimit: concurrencyRetryLimit,
 		isAggregate:           isAggregate,
 		aggregator:            aggregator,
 		replaceDots:           "-",
+		marshalErrors:         newMarshalErrorCounter(0),
+		latency:               newRecordLatency(0, 0),
+		maxFieldsExceeded:     newMaxFieldsCounter(0),
+		dropWhereDropped:      newDropWhereCounter(0),
 	}, nil
 }
 
@@ -134,6 +145,135 @@ func TestAddRecordAndFlush(t *testing.T) {
 	assert.Equal(t, retCode, fluentbit.FLB_OK, "Expected return code to be FLB_OK")
 }
 
+func TestFlushEmptyBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Times(0)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.Concurrency = 1
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected an empty flush to succeed")
+
+	retCode = outputPlugin.FlushConcurrent(0, records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected an empty concurrent flush to succeed")
+	assert.Equal(t, int32(0), outputPlugin.getGoroutineCount(), "Expected no goroutine to be started for an empty flush")
+}
+
+func TestFlushTargetBatchBytes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var batchBytes []int
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		size := 0
+		for _, record := range input.Records {
+			size += len(record.Data) + len(aws.StringValue(record.PartitionKey))
+		}
+		batchBytes = append(batchBytes, size)
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.targetBatchBytes = 64 * 1024
+
+	// records of varying size, between 1KiB and 8KiB
+	random := rand.New(rand.NewSource(1))
+	const maxRecordSize = 8*1024 + 1
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 300)
+	total := 0
+	for i := 0; i < 300; i++ {
+		record := &kinesis.PutRecordsRequestEntry{
+			Data:         make([]byte, 1024+random.Intn(7*1024)),
+			PartitionKey: aws.String("k"),
+		}
+		total += len(record.Data) + 1
+		records = append(records, record)
+	}
+
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+
+	sum := 0
+	for i, size := range batchBytes {
+		sum += size
+		assert.LessOrEqual(t, size, outputPlugin.targetBatchBytes, "Expected batches not to exceed the target")
+		if i < len(batchBytes)-1 {
+			assert.Greater(t, size, outputPlugin.targetBatchBytes-maxRecordSize, "Expected full batches to be within a record of the target")
+		}
+	}
+	assert.Equal(t, total, sum, "Expected every record to be sent")
+	assert.Greater(t, len(batchBytes), total/outputPlugin.targetBatchBytes-1)
+
+	// a record larger than the target is sent in a batch of its own
+	batchBytes = nil
+	records = []*kinesis.PutRecordsRequestEntry{
+		{Data: make([]byte, 1024), PartitionKey: aws.String("k")},
+		{Data: make([]byte, 100*1024), PartitionKey: aws.String("k")},
+		{Data: make([]byte, 1024), PartitionKey: aws.String("k")},
+	}
+	retCode = outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.Equal(t, []int{1025, 100*1024 + 1, 1025}, batchBytes)
+}
+
+func TestFlushForwardProgressWithLargeRecords(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	// alternating records at the size limit and tiny records, each with a partition key of the
+	// longest length, which a splitter counting only data would overfill batches with
+	key := strings.Repeat("k", partitionKeyMaxLength)
+	large := maximumRecordSize - len(key)
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 40)
+	for i := 0; i < 20; i++ {
+		records = append(records,
+			&kinesis.PutRecordsRequestEntry{Data: make([]byte, large), PartitionKey: aws.String(key)},
+			&kinesis.PutRecordsRequestEntry{Data: []byte{byte(i)}, PartitionKey: aws.String(key)},
+		)
+	}
+	want := len(records)
+
+	delivered := make(map[*kinesis.PutRecordsRequestEntry]bool)
+	calls := 0
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		calls++
+		size := 0
+		for _, record := range input.Records {
+			size += len(record.Data) + len(aws.StringValue(record.PartitionKey))
+		}
+		assert.LessOrEqual(t, len(input.Records), maximumRecordsPerPut)
+		assert.LessOrEqual(t, size, maximumPutRecordBatchSize, "Expected every batch to be within the request limit")
+
+		// the first request fails its large records, which are retried with the next batch
+		output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+		for _, record := range input.Records {
+			if calls == 1 && len(record.Data) == large {
+				output.FailedRecordCount = aws.Int64(aws.Int64Value(output.FailedRecordCount) + 1)
+				output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+					ErrorCode:    aws.String(kinesis.ErrCodeInternalFailureException),
+					ErrorMessage: aws.String("internal failure"),
+				})
+				continue
+			}
+			delivered[record] = true
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{SequenceNumber: aws.String("1")})
+		}
+		return output, nil
+	}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.Empty(t, records, "Expected no records to be left unsent")
+	assert.Len(t, delivered, want, "Expected every record to be sent")
+}
+
 func TestAddRecordAndFlushAggregate(t *testing.T) {
 	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
 
@@ -271,15 +411,16 @@ func RandStringRunes(n int) string {
 }
 
 func TestCompressionTruncation(t *testing.T) {
-	deftlvl := logrus.GetLevel();
-	logrus.SetLevel(0);
+	deftlvl := logrus.GetLevel()
+	logrus.SetLevel(0)
 
 	rand.Seed(0)
 	testData := []byte(RandStringRunes(4000))
 	testSuffix := "[truncate]"
 	outputPlugin := OutputPlugin{
 		PluginID: 10,
-		stream: "MyStream",
+		stream:   "MyStream",
+		logger:   newPluginLogger(10, "MyStream", "us-east-1"),
 	}
 	var compressedOutput, err = compressThenTruncate(gzipCompress, testData, 200, []byte(testSuffix), outputPlugin)
 	assert.Nil(t, err)
@@ -290,15 +431,16 @@ func TestCompressionTruncation(t *testing.T) {
 }
 
 func TestCompressionTruncationFailureA(t *testing.T) {
-	deftlvl := logrus.GetLevel();
-	logrus.SetLevel(0);
+	deftlvl := logrus.GetLevel()
+	logrus.SetLevel(0)
 
 	rand.Seed(0)
 	testData := []byte(RandStringRunes(4000))
 	testSuffix := "[truncate]"
 	outputPlugin := OutputPlugin{
 		PluginID: 10,
-		stream: "MyStream",
+		stream:   "MyStream",
+		logger:   newPluginLogger(10, "MyStream", "us-east-1"),
 	}
 	var _, err = compressThenTruncate(gzipCompress, testData, 20, []byte(testSuffix), outputPlugin)
 	assert.Contains(t, err.Error(), "no room for suffix")
@@ -307,15 +449,16 @@ func TestCompressionTruncationFailureA(t *testing.T) {
 }
 
 func TestCompressionTruncationFailureB(t *testing.T) {
-	deftlvl := logrus.GetLevel();
-	logrus.SetLevel(0);
+	deftlvl := logrus.GetLevel()
+	logrus.SetLevel(0)
 
 	rand.Seed(0)
 	testData := []byte{}
 	testSuffix := "[truncate]"
 	outputPlugin := OutputPlugin{
 		PluginID: 10,
-		stream: "MyStream",
+		stream:   "MyStream",
+		logger:   newPluginLogger(10, "MyStream", "us-east-1"),
 	}
 	var _, err = compressThenTruncate(gzipCompress, testData, 5, []byte(testSuffix), outputPlugin)
 	assert.Contains(t, err.Error(), "compressed empty to large")
@@ -403,3 +546,283 @@ func TestGetPartitionKey(t *testing.T) {
 	assert.Equal(t, false, hasValue, "Should not find value")
 	assert.Len(t, value, 0, "This should be an empty string")
 }
+
+func TestPluginLoggerFields(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused"))
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.PluginID = 3
+	outputPlugin.logger = newPluginLogger(3, "my-stream", "us-west-2")
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+
+	entries := hook.AllEntries()
+	assert.NotEmpty(t, entries, "Expected the failed flush to be logged")
+	for _, entry := range entries {
+		assert.Equal(t, 3, entry.Data["plugin_id"])
+		assert.Equal(t, "my-stream", entry.Data["stream"])
+		assert.Equal(t, "us-west-2", entry.Data["region"])
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	// the first attempt plus the single retry the budget allows
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused")).Times(2)
+
+	retrySleep = func(time.Duration) {}
+	defer func() { retrySleep = time.Sleep }()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.retryBudgetPerMinute = 1
+	now := time.Unix(0, 0)
+	outputPlugin.retryBudget = util.NewTokenBucketWithClock(1, time.Second, func() time.Time { return now })
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	outputPlugin.FlushWithRetries(len(records), records)
+
+	assert.False(t, outputPlugin.AllowRetry(), "Expected retry budget to be exhausted")
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "Retry budget") {
+			warned = true
+		}
+	}
+	assert.True(t, warned, "Expected retry budget exhaustion to be logged")
+	assert.Contains(t, hook.LastEntry().Message, "retry budget is exhausted")
+
+	now = now.Add(time.Second)
+	assert.True(t, outputPlugin.AllowRetry(), "Expected retry budget to refill")
+}
+
+func TestQuietSuppressesPerRecordInfoLogs(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.quiet = true
+	outputPlugin.partitionKey = "missing->key"
+	outputPlugin.Concurrency = 1
+	// saturate the concurrency limit so that flushes are rejected without calling Kinesis
+	outputPlugin.addGoroutineCount(1)
+	defer outputPlugin.addGoroutineCount(-1)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	for i := 0; i < 10; i++ {
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"testkey": []byte("test value"),
+		}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode)
+	}
+	retCode := outputPlugin.FlushConcurrent(len(records), records)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+
+	var errorCount int
+	for _, entry := range hook.AllEntries() {
+		assert.NotEqual(t, logrus.InfoLevel, entry.Level, "Expected no info logs when quiet, got: %s", entry.Message)
+		if entry.Level == logrus.ErrorLevel {
+			errorCount++
+		}
+	}
+	assert.Equal(t, 1, errorCount, "Expected the missing partition key to be logged once, not per record")
+}
+
+func TestFIPSEndpoint(t *testing.T) {
+	logger := newPluginLogger(0, "stream", "us-east-1")
+
+	client, err := newPutRecordsClient("", "", "us-east-1", "", "", "", true, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Contains(t, client.Endpoint, "fips", "Expected FIPS endpoint to be resolved")
+
+	client, err = newPutRecordsClient("arn:aws:iam::123456789012:role/test", "", "us-east-1", "", "", "", true, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Contains(t, client.Endpoint, "fips", "Expected FIPS endpoint to be resolved when assuming a role")
+
+	client, err = newPutRecordsClient("", "", "us-east-1", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.NotContains(t, client.Endpoint, "fips")
+
+	client, err = newPutRecordsClient("", "", "us-east-1", "https://kinesis.example.test", "", "", true, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://kinesis.example.test", client.Endpoint, "Expected explicit endpoint to take precedence")
+}
+
+func TestCloseReleasesGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	outputPlugin, err := NewOutputPlugin(&OutputPluginConfig{
+		Region:                    "us-east-1",
+		Stream:                    "stream",
+		RetryLimit:                concurrencyRetryLimit,
+		PartitionKeyHistogramTopN: 5,
+		SpillDir:                  t.TempDir(),
+		Workers:                   3,
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, runtime.NumGoroutine(), baseline, "Expected background goroutines to be running")
+
+	assert.NoError(t, outputPlugin.Close())
+	assert.NoError(t, outputPlugin.Close(), "Expected Close to be idempotent")
+
+	// stopped goroutines may take a moment to be reaped
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline, "Expected all goroutines to stop after Close")
+}
+
+func TestCloseSendsQueuedRecords(t *testing.T) {
+	for name, independent := range map[string]bool{"shared flush": false, "independent_secondary_flush": true} {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+			var mutex sync.Mutex
+			sent := make(map[string][]string)
+			mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+				func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					stream := aws.StringValue(input.StreamName)
+					sent[stream] = append(sent[stream], sentData(input)...)
+					return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+				}).Times(2)
+
+			outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+			outputPlugin.mirror = &mirror{stream: "errors"}
+			outputPlugin.deadLetters = &mirror{stream: "dlq"}
+			if independent {
+				outputPlugin.pipelines = outputPlugin.startStreamPipelines(outputPlugin.secondaryQueues()...)
+			}
+
+			// queued by the last chunk before Fluent Bit shuts down
+			outputPlugin.mirror.add(newTestEntries("mirrored")...)
+			outputPlugin.deadLetters.add(newTestEntries("rejected")...)
+
+			assert.NoError(t, outputPlugin.Close())
+			assert.Equal(t, map[string][]string{"errors": {"mirrored"}, "dlq": {"rejected"}}, sent)
+		})
+	}
+}
+
+func TestCloseLogsDroppedQueuedRecords(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused"))
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.deadLetters = &mirror{stream: "dlq"}
+	outputPlugin.deadLetters.add(newTestEntries("first", "second")...)
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+	assert.NoError(t, outputPlugin.Close())
+
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "Dropping 2 records queued for dlq stream dlq, which could not be sent before the plugin closed\n")
+}
+
+func TestFailedStartLeavesNoGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	for name, config := range map[string]*OutputPluginConfig{
+		"audit log":   {AuditLog: filepath.Join(missing, "audit.log")},
+		"status file": {StatusFile: filepath.Join(missing, "status.json"), AuditLog: filepath.Join(t.TempDir(), "audit.log")},
+	} {
+		config.Region = "us-east-1"
+		config.Stream = "stream"
+		config.RetryLimit = concurrencyRetryLimit
+		config.PartitionKeyHistogramTopN = 5
+		config.ErrorLogDedupInterval = time.Minute
+		config.ShardLoadLogInterval = time.Minute
+		config.Workers = 3
+		_, err := NewOutputPlugin(config)
+		assert.Error(t, err, name)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline, "Expected no goroutines to be left running")
+}
+
+func TestSizeKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.sizeKey = "record_size"
+
+	record := map[interface{}]interface{}{
+		"log":   []byte("hello world"),
+		"level": []byte("info"),
+	}
+	data, err := outputPlugin.processRecord(record, 0)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	size := decoded["record_size"]
+	delete(decoded, "record_size")
+
+	// the size is of the record as it was marshaled before the size was added
+	marshaled, _ := json.Marshal(decoded)
+	assert.Equal(t, float64(len(marshaled)), size)
+
+	data, err = injectSize([]byte("{}"), "size")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"size":2}`, string(data))
+}
+
+func TestMaxIngestRecordsPerSec(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.ingestPacer = util.NewPacer(100)
+	outputPlugin.ingestMaxWait = maxIngestWait
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	record := map[interface{}]interface{}{
+		"testkey": []byte("test value"),
+	}
+	timeStamp := time.Now()
+
+	// a burst of 20 records is spaced 10ms apart
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond, "Expected the burst to be paced")
+	assert.Len(t, records, 20)
+
+	// once the backlog exceeds the wait limit, records are pushed back instead
+	outputPlugin.ingestMaxWait = 0
+	for i := 0; i < 5; i++ {
+		outputPlugin.ingestPacer.Reserve(time.Hour)
+	}
+	retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode, "Expected AddRecord return code to be FLB_RETRY")
+	assert.Len(t, records, 20)
+}
@@ -0,0 +1,148 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+)
+
+// latencyBuckets are the upper bounds of the record latency histogram, in seconds
+var latencyBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// recordLatency measures the time between the event time of each record and when the
+// chunk holding it is sent to Kinesis, or handed to the concurrent flushes or workers.
+// The event times are kept per entry, so each record is observed at most once no matter
+// which flush path sends its chunk, or how often the chunk is retried.
+type recordLatency struct {
+	mutex     sync.Mutex
+	histogram *metrics.Histogram
+	// the event times of the records each entry holds, until the entry is flushed
+	pending map[*kinesis.PutRecordsRequestEntry][]time.Time
+	// the event times of aggregated records whose entry has not been emitted yet
+	unassigned []time.Time
+
+	// If non-zero, a summary is logged at most this often
+	logInterval time.Duration
+	lastLog     time.Time
+	count       int
+	sum         time.Duration
+	max         time.Duration
+}
+
+func newRecordLatency(pluginID int, logInterval time.Duration) *recordLatency {
+	return &recordLatency{
+		histogram: metrics.NewHistogram("kinesis_record_latency_seconds", "Time between the event time of records and when they were sent.",
+			metrics.Labels{"plugin_id": strconv.Itoa(pluginID)}, latencyBuckets),
+		pending:     make(map[*kinesis.PutRecordsRequestEntry][]time.Time),
+		logInterval: logInterval,
+		lastLog:     time.Now(),
+	}
+}
+
+// add records the event time of a record which will be flushed as entry, or as the next
+// aggregated entry if entry is nil
+func (l *recordLatency) add(entry *kinesis.PutRecordsRequestEntry, eventTime time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if entry == nil {
+		l.unassigned = append(l.unassigned, eventTime)
+		return
+	}
+	l.pending[entry] = append(l.pending[entry], eventTime)
+}
+
+// assign moves the event times of the aggregated records added so far to entry
+func (l *recordLatency) assign(entry *kinesis.PutRecordsRequestEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.unassigned) == 0 {
+		return
+	}
+	l.pending[entry] = append(l.pending[entry], l.unassigned...)
+	l.unassigned = nil
+}
+
+// take removes and returns the event times of the records held by entries
+func (l *recordLatency) take(entries []*kinesis.PutRecordsRequestEntry) []time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var eventTimes []time.Time
+	for _, entry := range entries {
+		if times, ok := l.pending[entry]; ok {
+			eventTimes = append(eventTimes, times...)
+			delete(l.pending, entry)
+		}
+	}
+	return eventTimes
+}
+
+// forget drops the event times of entries which won't be sent. The aggregated records not
+// yet in an entry are kept, as the aggregator holds on to them for the next chunk.
+func (l *recordLatency) forget(entries []*kinesis.PutRecordsRequestEntry) {
+	l.take(entries)
+}
+
+// flushed observes the latency of eventTimes if their chunk was accepted. Otherwise
+// Fluent Bit delivers the chunk again, and its records are added again.
+func (l *recordLatency) flushed(eventTimes []time.Time, retCode int, now time.Time) {
+	if retCode != fluentbit.FLB_OK {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, eventTime := range eventTimes {
+		latency := now.Sub(eventTime)
+		if latency < 0 {
+			// the clock of the producer is ahead of this host
+			latency = 0
+		}
+		l.histogram.Observe(latency.Seconds())
+		l.count++
+		l.sum += latency
+		if latency > l.max {
+			l.max = latency
+		}
+	}
+}
+
+// summary returns the count, average and maximum latency since the last summary once the
+// log interval has passed, and false if it has not or no records were sent
+func (l *recordLatency) summary(now time.Time) (int, time.Duration, time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.logInterval <= 0 || now.Sub(l.lastLog) < l.logInterval || l.count == 0 {
+		return 0, 0, 0, false
+	}
+	count, avg, max := l.count, l.sum/time.Duration(l.count), l.max
+	l.lastLog = now
+	l.count, l.sum, l.max = 0, 0, 0
+	return count, avg, max, true
+}
+
+// observeLatency records the latency of the records taken from a chunk once it has been flushed
+func (outputPlugin *OutputPlugin) observeLatency(eventTimes []time.Time, retCode int) {
+	now := time.Now()
+	outputPlugin.latency.flushed(eventTimes, retCode, now)
+	if count, avg, max, ok := outputPlugin.latency.summary(now); ok {
+		outputPlugin.flushInfof("Sent %d records in the last %v, latency since their event time: average %v, max %v\n",
+			count, outputPlugin.latency.logInterval, avg.Round(time.Millisecond), max.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,122 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordLatency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+	}, nil)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.latency = newRecordLatency(139, 0)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	for _, age := range []time.Duration{2 * time.Second, 10 * time.Second} {
+		eventTime := time.Now().Add(-age)
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte("record"),
+		}, &eventTime)
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+
+	histogram := outputPlugin.latency.histogram
+	assert.Equal(t, uint64(2), histogram.Count())
+	assert.InDelta(t, 12, histogram.Sum(), 1, "Expected the ages of the records to be observed")
+	assert.Empty(t, outputPlugin.latency.pending)
+}
+
+func TestRecordLatencyRetriedChunk(t *testing.T) {
+	latency := newRecordLatency(1390, 0)
+	now := time.Now()
+
+	entry := &kinesis.PutRecordsRequestEntry{}
+	latency.add(entry, now.Add(-time.Second))
+	latency.flushed(latency.take([]*kinesis.PutRecordsRequestEntry{entry}), fluentbit.FLB_RETRY, now)
+	assert.Equal(t, uint64(0), latency.histogram.Count(), "Expected retried chunks not to be observed")
+	assert.Empty(t, latency.pending, "Expected retried chunks to be added again on their next delivery")
+
+	// records with event times in the future count as sent immediately
+	latency.add(entry, now.Add(time.Minute))
+	latency.flushed(latency.take([]*kinesis.PutRecordsRequestEntry{entry}), fluentbit.FLB_OK, now)
+	assert.Equal(t, uint64(1), latency.histogram.Count())
+	assert.Equal(t, float64(0), latency.histogram.Sum())
+}
+
+func TestRecordLatencyCountsEachRecordOnce(t *testing.T) {
+	latency := newRecordLatency(1392, 0)
+	now := time.Now()
+
+	first := []*kinesis.PutRecordsRequestEntry{{}, {}}
+	second := []*kinesis.PutRecordsRequestEntry{{}}
+	for _, entry := range append(first, second...) {
+		latency.add(entry, now.Add(-time.Second))
+	}
+
+	// each chunk only observes its own records
+	latency.flushed(latency.take(second), fluentbit.FLB_OK, now)
+	assert.Equal(t, uint64(1), latency.histogram.Count())
+
+	// the rest of a resumed chunk is flushed again with the same entries
+	latency.flushed(latency.take(first), fluentbit.FLB_OK, now)
+	latency.flushed(latency.take(first[1:]), fluentbit.FLB_OK, now)
+	assert.Equal(t, uint64(3), latency.histogram.Count())
+	assert.Empty(t, latency.pending)
+}
+
+func TestRecordLatencyAggregated(t *testing.T) {
+	latency := newRecordLatency(1393, 0)
+	now := time.Now()
+
+	entry := &kinesis.PutRecordsRequestEntry{}
+	latency.add(nil, now.Add(-time.Second))
+	latency.add(nil, now.Add(-time.Second))
+	latency.assign(entry)
+	latency.flushed(latency.take([]*kinesis.PutRecordsRequestEntry{entry}), fluentbit.FLB_OK, now)
+	assert.Equal(t, uint64(2), latency.histogram.Count())
+
+	// a chunk abandoned while unpacking leaves nothing behind, but the records the
+	// aggregator still holds are counted with the next chunk
+	abandoned := &kinesis.PutRecordsRequestEntry{}
+	latency.add(abandoned, now)
+	latency.add(nil, now)
+	latency.forget([]*kinesis.PutRecordsRequestEntry{abandoned})
+	assert.Empty(t, latency.pending)
+	assert.Len(t, latency.unassigned, 1)
+}
+
+func TestRecordLatencySummary(t *testing.T) {
+	latency := newRecordLatency(1391, time.Minute)
+	now := latency.lastLog
+
+	var entries []*kinesis.PutRecordsRequestEntry
+	for _, age := range []time.Duration{time.Second, 3 * time.Second} {
+		entry := &kinesis.PutRecordsRequestEntry{}
+		latency.add(entry, now.Add(-age))
+		entries = append(entries, entry)
+	}
+	latency.flushed(latency.take(entries), fluentbit.FLB_OK, now)
+	_, _, _, ok := latency.summary(now.Add(30 * time.Second))
+	assert.False(t, ok, "Expected no summary before the interval has passed")
+
+	count, avg, max, ok := latency.summary(now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2*time.Second, avg)
+	assert.Equal(t, 3*time.Second, max)
+
+	_, _, _, ok = latency.summary(now.Add(3 * time.Minute))
+	assert.False(t, ok, "Expected no summary when no records were sent")
+}
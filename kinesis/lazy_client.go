@@ -0,0 +1,114 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// credentialsKey identifies the settings which decide the credentials of a client
+type credentialsKey struct {
+	region          string
+	roleARN         string
+	externalID      string
+	eksRole         string
+	stsEndpoint     string
+	useFIPSEndpoint bool
+	refreshBefore   time.Duration
+}
+
+// newCredentialsKey returns the key of the credentials for the settings, and the EKS pod execution role
+func newCredentialsKey(roleARN string, externalID string, region string, stsEndpoint string, useFIPSEndpoint bool, refreshBefore time.Duration) credentialsKey {
+	return credentialsKey{
+		region:          region,
+		roleARN:         roleARN,
+		externalID:      externalID,
+		eksRole:         os.Getenv("EKS_POD_EXECUTION_ROLE"),
+		stsEndpoint:     stsEndpoint,
+		useFIPSEndpoint: useFIPSEndpoint,
+		refreshBefore:   refreshBefore,
+	}
+}
+
+// sharedCredentials lets plugin instances with the same region and role share
+// credentials, so they are fetched and refreshed once rather than per instance
+var sharedCredentials = struct {
+	mutex       sync.Mutex
+	credentials map[credentialsKey]*credentials.Credentials
+}{
+	credentials: make(map[credentialsKey]*credentials.Credentials),
+}
+
+// loadSharedCredentials returns the credentials for the key, if another instance created them
+func loadSharedCredentials(key credentialsKey) *credentials.Credentials {
+	sharedCredentials.mutex.Lock()
+	defer sharedCredentials.mutex.Unlock()
+	return sharedCredentials.credentials[key]
+}
+
+// storeSharedCredentials makes the credentials available to instances created later
+func storeSharedCredentials(key credentialsKey, creds *credentials.Credentials) {
+	sharedCredentials.mutex.Lock()
+	defer sharedCredentials.mutex.Unlock()
+	if _, ok := sharedCredentials.credentials[key]; !ok {
+		sharedCredentials.credentials[key] = creds
+	}
+}
+
+// forgetSharedCredentials makes the next instance created for the key fetch its own credentials
+func forgetSharedCredentials(key credentialsKey) {
+	sharedCredentials.mutex.Lock()
+	defer sharedCredentials.mutex.Unlock()
+	delete(sharedCredentials.credentials, key)
+}
+
+// lazyClient builds the client on the first flush, instead of at startup
+type lazyClient struct {
+	mutex sync.Mutex
+	build func() (PutRecordsClient, error)
+}
+
+// ensureClient builds the client if it has not been built yet. Concurrent flushes
+// wait for a single build. If it fails the flush is retried, and the next flush
+// tries to build the client again.
+func (outputPlugin *OutputPlugin) ensureClient() error {
+	if outputPlugin.clientAvailable() {
+		return nil
+	}
+	if outputPlugin.lazyClient == nil {
+		return errClientUnavailable
+	}
+
+	outputPlugin.lazyClient.mutex.Lock()
+	defer outputPlugin.lazyClient.mutex.Unlock()
+	if outputPlugin.clientAvailable() {
+		return nil
+	}
+
+	client, err := outputPlugin.lazyClient.build()
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to create Kinesis client, will try again on the next flush: %v\n", err)
+		return err
+	}
+	// the store to clientPending publishes the client to flushing goroutines
+	outputPlugin.client = client
+	atomic.StoreInt32(&outputPlugin.clientPending, 0)
+	outputPlugin.logger.Debugf("Created Kinesis client on the first flush\n")
+	return nil
+}
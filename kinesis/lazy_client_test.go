@@ -0,0 +1,138 @@
+package kinesis
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOutputPluginLazyClientInit(t *testing.T) {
+	// creating the AWS session would fail, but nothing is built at startup
+	t.Setenv("AWS_STS_REGIONAL_ENDPOINTS", "invalid")
+
+	outputPlugin, err := NewOutputPlugin(&OutputPluginConfig{
+		Region:         "us-east-1",
+		Stream:         "stream",
+		LazyClientInit: true,
+	})
+	assert.NoError(t, err)
+	defer outputPlugin.Close()
+	assert.False(t, outputPlugin.clientAvailable())
+	assert.Nil(t, outputPlugin.client)
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected the flush to be retried when the client can't be built")
+	assert.Len(t, records, 1)
+}
+
+func TestLazyClientBuiltOnFirstFlush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+	}, nil).Times(8)
+
+	var builds int32
+	failures := int32(1)
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.clientPending = 1
+	outputPlugin.lazyClient = &lazyClient{
+		build: func() (PutRecordsClient, error) {
+			atomic.AddInt32(&builds, 1)
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				return nil, errors.New("no credentials")
+			}
+			return mockKinesis, nil
+		},
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&builds), "Expected no client to be built before the first flush")
+
+	newRecords := func() []*kinesis.PutRecordsRequestEntry {
+		return []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("data"), PartitionKey: aws.String("key")},
+		}
+	}
+
+	// a failed build retries the flush, and the next flush builds the client again
+	records := newRecords()
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&builds))
+
+	// concurrent flushes share a single build
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records := newRecords()
+			assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&builds))
+	assert.True(t, outputPlugin.clientAvailable())
+}
+
+func TestSharedCredentials(t *testing.T) {
+	logger := newPluginLogger(0, "stream", "eu-west-3")
+
+	first, err := newPutRecordsClient("arn:aws:iam::123456789012:role/shared", "", "eu-west-3", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	second, err := newPutRecordsClient("arn:aws:iam::123456789012:role/shared", "", "eu-west-3", "https://kinesis.example.test", "", "", false, 3, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Same(t, first.Config.Credentials, second.Config.Credentials, "Expected instances with the same region and role to share credentials")
+	assert.Equal(t, "https://kinesis.example.test", second.Endpoint, "Expected settings other than credentials to be kept")
+	assert.Equal(t, 3, second.MaxRetries())
+
+	other, err := newPutRecordsClient("arn:aws:iam::123456789012:role/other", "", "eu-west-3", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.NotSame(t, first.Config.Credentials, other.Config.Credentials, "Expected a different role to get its own credentials")
+}
+
+func TestSharedCredentialsAssumeRoleOnce(t *testing.T) {
+	stsClient := &mockAssumeRoler{duration: time.Hour}
+	var externalIDs []string
+	defer func(original func(client.ConfigProvider, string, ...func(*stscreds.AssumeRoleProvider)) *credentials.Credentials) {
+		newAssumeRoleCredentials = original
+	}(newAssumeRoleCredentials)
+	newAssumeRoleCredentials = func(c client.ConfigProvider, roleARN string, options ...func(*stscreds.AssumeRoleProvider)) *credentials.Credentials {
+		return stscreds.NewCredentialsWithClient(stsClient, roleARN, append(options, func(provider *stscreds.AssumeRoleProvider) {
+			externalIDs = append(externalIDs, aws.StringValue(provider.ExternalID))
+		})...)
+	}
+	logger := newPluginLogger(0, "stream", "ap-south-2")
+
+	// two output sections assuming the same role
+	for i := 0; i < 2; i++ {
+		client, err := newPutRecordsClient("arn:aws:iam::123456789012:role/once", "tenant", "ap-south-2", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = client.Config.Credentials.Get()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, stsClient.calls, "Expected the role to be assumed once")
+
+	other, err := newPutRecordsClient("arn:aws:iam::123456789012:role/once", "other-tenant", "ap-south-2", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	_, err = other.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stsClient.calls, "Expected a different external ID to assume the role again")
+	assert.Equal(t, []string{"tenant", "other-tenant"}, externalIDs)
+}
@@ -0,0 +1,85 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// OnMarshalError indicates what happens to a record which can't be encoded
+type OnMarshalError string
+
+const (
+	// OnMarshalErrorDrop logs and drops the record
+	OnMarshalErrorDrop OnMarshalError = "drop"
+	// OnMarshalErrorDLQ sends the record to dlq_stream, or drops it if that fails
+	OnMarshalErrorDLQ OnMarshalError = "dlq"
+)
+
+// marshalError is returned by processRecord when a decoded record can't be encoded
+type marshalError struct {
+	err    error
+	record map[interface{}]interface{}
+}
+
+func (e *marshalError) Error() string {
+	return fmt.Sprintf("failed to marshal record: %v", e.err)
+}
+
+func (e *marshalError) Unwrap() error {
+	return e.err
+}
+
+// keys returns the sorted keys of the record, which are safe to log unlike its values
+func (e *marshalError) keys() []string {
+	keys := make([]string, 0, len(e.record))
+	for k := range e.record {
+		keys = append(keys, stringOrByteArray(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newMarshalErrorCounter(pluginID int) *metrics.Counter {
+	return metrics.NewCounter("kinesis_marshal_errors_total", "Records which could not be marshaled.",
+		metrics.Labels{"plugin_id": strconv.Itoa(pluginID)})
+}
+
+// handleMarshalError counts a record which could not be marshaled, then drops or
+// dead-letters it according to on_marshal_error. Since the record can't be encoded
+// as JSON, a dead letter holds the record formatted by Go's %v verb. Dead letters are
+// queued and sent with the next flush.
+func (outputPlugin *OutputPlugin) handleMarshalError(e *marshalError, partitionKey string, hasPartitionKey bool) {
+	outputPlugin.marshalErrors.Inc()
+	outputPlugin.logger.Errorf("Failed to marshal record with keys %v: %v\n", e.keys(), e.err)
+
+	if outputPlugin.onMarshalError == OnMarshalErrorDLQ {
+		if !hasPartitionKey {
+			partitionKey = outputPlugin.stringGen.RandomString()
+		}
+		if outputPlugin.queueDeadLetter(&kinesis.PutRecordsRequestEntry{
+			Data:         []byte(fmt.Sprintf("%v", e.record)),
+			PartitionKey: aws.String(partitionKey),
+		}, e) {
+			return
+		}
+	}
+	outputPlugin.logger.Errorf("Dropping record which could not be marshaled\n")
+}
@@ -0,0 +1,94 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalErrorDropsRecord(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	before := outputPlugin.marshalErrors.Value()
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Now()
+	// NaN can't be represented in JSON
+	retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log":    []byte("secret"),
+		"metric": math.NaN(),
+	}, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected the batch to continue")
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log": []byte("ok"),
+	}, &timeStamp)
+
+	if assert.Len(t, records, 1, "Expected only the record which could be marshaled") {
+		assert.Equal(t, `{"log":"ok"}`, string(records[0].Data))
+	}
+	assert.Equal(t, before+1, outputPlugin.marshalErrors.Value())
+
+	var logged string
+	for _, entry := range hook.AllEntries() {
+		logged += entry.Message
+	}
+	assert.Contains(t, logged, "Failed to marshal record with keys [log metric]")
+	assert.NotContains(t, logged, "secret", "Expected record values not to be logged")
+}
+
+func TestMarshalErrorDeadLettersRecord(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var dlqRecords []*kinesis.PutRecordsRequestEntry
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			assert.Equal(t, "dlq", aws.StringValue(input.StreamName))
+			dlqRecords = append(dlqRecords, input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.dlqStream = "dlq"
+	outputPlugin.deadLetters = &mirror{stream: "dlq"}
+	outputPlugin.onMarshalError = OnMarshalErrorDLQ
+	outputPlugin.partitionKey = "key"
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	for _, key := range []string{"a", "b"} {
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"key":    []byte(key),
+			"metric": math.Inf(1),
+		}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode)
+	}
+	assert.Empty(t, records)
+	assert.Empty(t, dlqRecords, "Expected dead letters to wait for the flush")
+
+	// the dead letters of the chunk are sent in one request
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	if !assert.Len(t, dlqRecords, 2) {
+		return
+	}
+	var letter deadLetter
+	assert.NoError(t, json.Unmarshal(dlqRecords[0].Data, &letter))
+	assert.Equal(t, "a", letter.PartitionKey)
+	assert.True(t, strings.HasPrefix(letter.Error, "failed to marshal record"), letter.Error)
+	assert.Contains(t, string(letter.Data), "metric:+Inf")
+}
@@ -0,0 +1,59 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func newMaxFieldsCounter(pluginID int) *metrics.Counter {
+	return metrics.NewCounter("kinesis_max_fields_exceeded_total", "Records rejected for having more than max_fields fields.",
+		metrics.Labels{"plugin_id": strconv.Itoa(pluginID)})
+}
+
+// exceedsMaxFields counts and rejects a record with more top level fields than max_fields.
+// The record is queued for dlq_stream as it would have been sent to the stream, and sent
+// with the next flush or when the plugin closes, if one is configured, and dropped otherwise. It returns false if
+// the record is within the limit.
+func (outputPlugin *OutputPlugin) exceedsMaxFields(record map[interface{}]interface{}) bool {
+	if outputPlugin.maxFields <= 0 || len(record) <= outputPlugin.maxFields {
+		return false
+	}
+
+	outputPlugin.maxFieldsExceeded.Inc()
+	reason := fmt.Errorf("record has %d fields, more than max_fields %d", len(record), outputPlugin.maxFields)
+	outputPlugin.logger.Warnf("Rejecting record: %v\n", reason)
+	if outputPlugin.deadLetters == nil {
+		return true
+	}
+
+	partitionKey := outputPlugin.stringGen.RandomString()
+	data, err := outputPlugin.processRecord(record, len(partitionKey))
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to process rejected record for dlq_stream %s, dropping it: %v\n", outputPlugin.dlqStream, err)
+		return true
+	}
+	if !outputPlugin.queueDeadLetter(&kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(partitionKey),
+	}, reason) {
+		outputPlugin.logger.Errorf("Dropping record rejected by max_fields\n")
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxFieldsDropsRecord(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.maxFields = 2
+	before := outputPlugin.maxFieldsExceeded.Value()
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	timeStamp := time.Now()
+	retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"a": []byte("1"),
+		"b": []byte("2"),
+		"c": []byte("3"),
+	}, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected the batch to continue")
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"a": []byte("1"),
+		"b": []byte("2"),
+	}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"a": []byte("1"),
+	}, &timeStamp)
+
+	if assert.Len(t, records, 2, "Expected only the records within the limit") {
+		assert.Equal(t, `{"a":"1","b":"2"}`, string(records[0].Data))
+		assert.Equal(t, `{"a":"1"}`, string(records[1].Data))
+	}
+	assert.Equal(t, before+1, outputPlugin.maxFieldsExceeded.Value())
+}
+
+func TestMaxFieldsDeadLettersRecord(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var dlqRecords []*kinesis.PutRecordsRequestEntry
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			assert.Equal(t, "dlq", aws.StringValue(input.StreamName))
+			dlqRecords = append(dlqRecords, input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.dlqStream = "dlq"
+	outputPlugin.deadLetters = &mirror{stream: "dlq"}
+	outputPlugin.maxFields = 1
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	for i := 0; i < 3; i++ {
+		retCode := outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"a": []byte("1"),
+			"b": []byte("2"),
+		}, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode)
+	}
+	assert.Empty(t, records)
+	assert.Empty(t, dlqRecords, "Expected rejected records to wait for the flush")
+
+	// the rejected records of the chunk are sent in one request
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	if assert.Len(t, dlqRecords, 3) {
+		assert.Contains(t, string(dlqRecords[0].Data), "more than max_fields 1")
+	}
+}
+
+func TestMaxFieldsDeadLettersSentOnClose(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var dlqRecords []*kinesis.PutRecordsRequestEntry
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			assert.Equal(t, "dlq", aws.StringValue(input.StreamName))
+			dlqRecords = append(dlqRecords, input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.dlqStream = "dlq"
+	outputPlugin.deadLetters = &mirror{stream: "dlq"}
+	outputPlugin.maxFields = 1
+
+	// rejected by the last chunk before Fluent Bit stops, so no flush follows
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"a": []byte("1"),
+		"b": []byte("2"),
+	}, &timeStamp)
+	assert.Empty(t, dlqRecords)
+
+	assert.NoError(t, outputPlugin.Close())
+	assert.Len(t, dlqRecords, 1, "Expected the queued record to be sent when the plugin closes")
+}
@@ -0,0 +1,69 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// recordMetadata describes a record sent to the stream. It is sent to metadata_stream
+// as JSON, and its fields are part of the documented format so must not change.
+type recordMetadata struct {
+	// the top level keys of the record, sorted
+	Keys []string `json:"keys"`
+	// the size of the record's data in bytes
+	Size int `json:"size"`
+	// the event timestamp in RFC 3339 format, in UTC
+	Timestamp string `json:"timestamp"`
+	// empty if the record is aggregated with a random key
+	PartitionKey string `json:"partition_key"`
+}
+
+// addMetadata queues the metadata of a record for the metadata stream, which is sent
+// with the same partition key as the record, or a random key if it doesn't have one
+func (outputPlugin *OutputPlugin) addMetadata(record map[interface{}]interface{}, size int, partitionKey string, timeStamp time.Time) {
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, stringOrByteArray(k))
+	}
+	sort.Strings(keys)
+
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	data, err := json.Marshal(recordMetadata{
+		Keys:         keys,
+		Size:         size,
+		Timestamp:    timeStamp.UTC().Format(time.RFC3339Nano),
+		PartitionKey: partitionKey,
+	})
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to marshal record metadata: %v\n", err)
+		return
+	}
+
+	metadataKey := partitionKey
+	if metadataKey == "" {
+		metadataKey = outputPlugin.stringGen.RandomString()
+	}
+	if dropped := outputPlugin.metadata.add(&kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(metadataKey),
+	}); dropped > 0 {
+		outputPlugin.logger.Errorf("Dropped %d records queued for metadata stream %s\n", dropped, outputPlugin.metadata.stream)
+	}
+}
@@ -0,0 +1,89 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	sent := make(map[string][]*kinesis.PutRecordsRequestEntry)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			stream := aws.StringValue(input.StreamName)
+			sent[stream] = append(sent[stream], input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.partitionKey = "user"
+	outputPlugin.metadata = &mirror{stream: "metadata"}
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Date(2023, 11, 14, 22, 13, 20, 5, time.FixedZone("UTC+1", 3600))
+	for _, record := range []map[interface{}]interface{}{
+		{"log": []byte("first"), "user": []byte("alice")},
+		{"log": []byte("second")},
+	} {
+		retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode)
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+
+	if !assert.Len(t, sent["stream"], 2) || !assert.Len(t, sent["metadata"], 2, "Expected one metadata record per record") {
+		return
+	}
+	for i, expectedKeys := range [][]string{{"log", "user"}, {"log"}} {
+		record, metadataRecord := sent["stream"][i], sent["metadata"][i]
+		var metadata map[string]interface{}
+		assert.NoError(t, json.Unmarshal(metadataRecord.Data, &metadata))
+
+		keys := make([]string, 0, len(expectedKeys))
+		for _, key := range metadata["keys"].([]interface{}) {
+			keys = append(keys, key.(string))
+		}
+		assert.Equal(t, expectedKeys, keys)
+		assert.Equal(t, float64(len(record.Data)), metadata["size"])
+		assert.Equal(t, "2023-11-14T21:13:20.000000005Z", metadata["timestamp"])
+		assert.Equal(t, aws.StringValue(record.PartitionKey), metadata["partition_key"])
+		assert.Equal(t, aws.StringValue(record.PartitionKey), aws.StringValue(metadataRecord.PartitionKey),
+			"Expected the metadata to share the record's partition key")
+	}
+	assert.Equal(t, "alice", aws.StringValue(sent["stream"][0].PartitionKey))
+}
+
+func TestMetadataStreamSkipsDroppedRecords(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.metadata = &mirror{stream: "metadata"}
+	outputPlugin.dropWhere, _ = parseFieldComparison("level = debug")
+	outputPlugin.dedup = newDedupCache("id", time.Minute)
+	outputPlugin.dedup.sent["repeat"] = time.Now().Add(time.Minute)
+	outputPlugin.maxFields = 2
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	for _, record := range []map[interface{}]interface{}{
+		{"log": []byte("kept")},
+		{"log": []byte("filtered"), "level": []byte("debug")},
+		{"log": []byte("duplicate"), "id": []byte("repeat")},
+		{"log": []byte("wide"), "a": 1, "b": 2},
+	} {
+		assert.Equal(t, fluentbit.FLB_OK, outputPlugin.AddRecord(&records, record, &timeStamp))
+	}
+
+	assert.Len(t, records, 1)
+	assert.Len(t, outputPlugin.metadata.pending, 1, "Expected metadata only for the record which was accepted")
+}
@@ -0,0 +1,248 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+)
+
+// most records queued for the mirror stream, older records are dropped beyond this
+const mirrorMaxPending = 10 * maximumRecordsPerPut
+
+// recordCondition matches records whose value at a key equals, or does not equal, a value
+type recordCondition struct {
+	keys   []string
+	value  string
+	negate bool
+}
+
+// parseRecordCondition parses a condition of the form key=value or key!=value.
+// Nested keys are separated by '->', as for partition_key.
+func parseRecordCondition(condition string) (*recordCondition, error) {
+	negate := false
+	parts := strings.SplitN(condition, "!=", 2)
+	if len(parts) == 2 {
+		negate = true
+	} else {
+		parts = strings.SplitN(condition, "=", 2)
+	}
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("invalid condition '%s', must be of the form key=value or key!=value", condition)
+	}
+
+	keys := strings.Split(strings.TrimSpace(parts[0]), "->")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid condition '%s', key must not be empty", condition)
+		}
+	}
+	return &recordCondition{
+		keys:   keys,
+		value:  strings.TrimSpace(parts[1]),
+		negate: negate,
+	}, nil
+}
+
+// matches reports whether the record satisfies the condition.
+// A missing key never equals the value, so it matches a negated condition.
+func (c *recordCondition) matches(record map[interface{}]interface{}) bool {
+	var value interface{} = record
+	for _, key := range c.keys {
+		nested, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return c.negate
+		}
+		value = getFromMap(key, nested)
+	}
+
+	var str string
+	switch v := value.(type) {
+	case nil:
+		return c.negate
+	case []byte, string:
+		str = stringOrByteArray(v)
+	case map[interface{}]interface{}, []interface{}:
+		return c.negate
+	default:
+		str = fmt.Sprint(v)
+	}
+	return (str == c.value) != c.negate
+}
+
+// mirror holds the records which are also sent to a secondary stream, such as the
+// mirror or metadata stream. Records are queued by AddRecord and sent on the next Flush.
+type mirror struct {
+	stream    string
+	condition *recordCondition
+	mutex     sync.Mutex
+	pending   []*kinesis.PutRecordsRequestEntry
+}
+
+func newMirror(stream, condition string) (*mirror, error) {
+	c, err := parseRecordCondition(condition)
+	if err != nil {
+		return nil, err
+	}
+	return &mirror{
+		stream:    stream,
+		condition: c,
+	}, nil
+}
+
+// add queues records for the mirror stream, returning how many of the oldest
+// queued records were dropped to stay within mirrorMaxPending
+func (m *mirror) add(records ...*kinesis.PutRecordsRequestEntry) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pending = append(m.pending, records...)
+	dropped := len(m.pending) - mirrorMaxPending
+	if dropped <= 0 {
+		return 0
+	}
+	m.pending = append([]*kinesis.PutRecordsRequestEntry(nil), m.pending[dropped:]...)
+	return dropped
+}
+
+// empty reports whether no records are queued
+func (m *mirror) empty() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.pending) == 0
+}
+
+// take removes and returns every queued record
+func (m *mirror) take() []*kinesis.PutRecordsRequestEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	records := m.pending
+	m.pending = nil
+	return records
+}
+
+// flushMirror sends the records queued for a secondary stream, described by name in
+// logs. Records which could not be sent are queued again for the next flush, so the
+// stream receives records at least once, but failures never affect the return code of
+// the main flush.
+func (outputPlugin *OutputPlugin) flushMirror(m *mirror, name string) {
+	records := m.take()
+	if len(records) == 0 {
+		return
+	}
+	if retCode, _ := outputPlugin.flushStream(m.stream, &records); retCode != fluentbit.FLB_OK {
+		outputPlugin.logger.Warnf("Failed to send %d records to %s stream %s, they will be retried on the next flush\n", len(records), name, m.stream)
+		if dropped := m.add(records...); dropped > 0 {
+			outputPlugin.logger.Errorf("Dropped %d records queued for %s stream %s\n", dropped, name, m.stream)
+		}
+	}
+}
+
+// drainSecondaryQueues makes a last attempt to send the records queued for the secondary
+// streams when the plugin closes, without starting new batches once the deadline passes.
+// The records which could not be sent are counted in the log, since they are dropped.
+func (outputPlugin *OutputPlugin) drainSecondaryQueues(deadline time.Time) {
+	for _, q := range outputPlugin.secondaryQueues() {
+		records := q.queue.take()
+		if len(records) == 0 {
+			continue
+		}
+		outputPlugin.flushStreamUntil(q.queue.stream, &records, deadline)
+		if len(records) > 0 {
+			outputPlugin.logger.Errorf("Dropping %d records queued for %s stream %s, which could not be sent before the plugin closed\n", len(records), q.name, q.queue.stream)
+		}
+	}
+}
+
+// namedQueue is a queue of records for a secondary stream, with the name it is logged by
+type namedQueue struct {
+	queue *mirror
+	name  string
+}
+
+// streamPipeline sends the records queued for one secondary stream on a goroutine of its
+// own, so a slow or throttled stream never holds up flushes of the main stream or of the
+// other secondary streams, and a blocked main stream never holds them up either. Each flush
+// of the main stream wakes the goroutine, which sends whatever is queued by then. Queues
+// naming the same stream, such as a mirror and a metadata stream which are the same, share
+// its pipeline. Pipelines never touch the send failure timer of the main stream, and the
+// rest of the state they share with the main flush is safe for concurrent flushes.
+type streamPipeline struct {
+	stream string
+	queues []namedQueue
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startStreamPipelines starts a pipeline for each stream the queues name
+func (outputPlugin *OutputPlugin) startStreamPipelines(queues ...namedQueue) []*streamPipeline {
+	var pipelines []*streamPipeline
+	byStream := make(map[string]*streamPipeline)
+	for _, q := range queues {
+		p, ok := byStream[q.queue.stream]
+		if !ok {
+			p = &streamPipeline{
+				stream: q.queue.stream,
+				wake:   make(chan struct{}, 1),
+				stop:   make(chan struct{}),
+				done:   make(chan struct{}),
+			}
+			byStream[p.stream] = p
+			pipelines = append(pipelines, p)
+		}
+		p.queues = append(p.queues, q)
+	}
+	for _, p := range pipelines {
+		go p.run(outputPlugin)
+	}
+	return pipelines
+}
+
+func (p *streamPipeline) run(outputPlugin *OutputPlugin) {
+	defer close(p.done)
+	for {
+		select {
+		case <-p.wake:
+			p.flush(outputPlugin)
+		case <-p.stop:
+			// Close makes the last attempt for the records queued since the previous flush
+			return
+		}
+	}
+}
+
+func (p *streamPipeline) flush(outputPlugin *OutputPlugin) {
+	for _, q := range p.queues {
+		outputPlugin.flushMirror(q.queue, q.name)
+	}
+}
+
+// notify wakes the goroutine, unless it already has a flush pending
+func (p *streamPipeline) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the goroutine once its flush in progress, if any, is done
+func (p *streamPipeline) close() {
+	close(p.stop)
+	<-p.done
+}
@@ -0,0 +1,280 @@
+package kinesis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-firehose-for-fluent-bit/plugins"
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecordCondition(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"level":   []byte("error"),
+		"status":  500,
+		"code":    []byte("007"),
+		"version": []byte("1.0"),
+		"kubernetes": map[interface{}]interface{}{
+			"namespace_name": "payments",
+		},
+	}
+
+	for condition, expected := range map[string]bool{
+		"level=error":                          true,
+		"level = error":                        true,
+		"level=info":                           false,
+		"level!=info":                          true,
+		"level!=error":                         false,
+		"status=500":                           true,
+		"kubernetes->namespace_name=payments":  true,
+		"kubernetes->namespace_name!=payments": false,
+		"missing=error":                        false,
+		"missing!=error":                       true,
+		"level->nested=error":                  false,
+		// values are compared as strings, not as numbers
+		"code=7":       false,
+		"code=007":     true,
+		"version=1":    false,
+		"version!=1":   true,
+		"status=500.0": false,
+	} {
+		c, err := parseRecordCondition(condition)
+		if assert.NoError(t, err, condition) {
+			assert.Equal(t, expected, c.matches(record), condition)
+		}
+	}
+
+	for _, condition := range []string{"level", "=error", "kubernetes->=payments"} {
+		_, err := parseRecordCondition(condition)
+		assert.Error(t, err, condition)
+	}
+}
+
+func TestMirrorStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	sent := make(map[string][]string)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			stream := aws.StringValue(input.StreamName)
+			for _, record := range input.Records {
+				sent[stream] = append(sent[stream], string(record.Data))
+			}
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.logKey = "log"
+	outputPlugin.mirror, _ = newMirror("errors", "level=error")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	for _, record := range []map[interface{}]interface{}{
+		{"log": []byte("first"), "level": []byte("info")},
+		{"log": []byte("second"), "level": []byte("error")},
+		{"log": []byte("third")},
+	} {
+		retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+	}
+
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected Flush return code to be FLB_OK")
+	assert.Equal(t, []string{"first", "second", "third"}, sent["stream"])
+	assert.Equal(t, []string{"second"}, sent["errors"])
+}
+
+func TestMirrorStreamFailureIsRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var mirrored int
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			if aws.StringValue(input.StreamName) == "errors" {
+				mirrored++
+				if mirrored == 1 {
+					return nil, errors.New("connection refused")
+				}
+			}
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(4)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.mirror, _ = newMirror("errors", "level=error")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"level": []byte("error")}, &timeStamp)
+
+	// the main stream succeeds, so the failed mirror send does not fail the flush
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected Flush return code to be FLB_OK")
+	assert.Len(t, outputPlugin.mirror.pending, 1, "Expected the mirrored record to be queued again")
+
+	records = records[:0]
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"level": []byte("info")}, &timeStamp)
+	retCode = outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected Flush return code to be FLB_OK")
+	assert.Equal(t, 2, mirrored)
+	assert.Empty(t, outputPlugin.mirror.pending)
+}
+
+func TestSecondaryStreamsLeaveSendFailureTimer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	// the timer expires as soon as it starts
+	t.Setenv("SEND_FAILURE_TIMEOUT", "1ns")
+	expired := false
+	timeout, err := plugins.NewTimeout(func(time.Duration) { expired = true })
+	assert.NoError(t, err)
+	outputPlugin.timer = newSendFailureTimer(timeout)
+
+	records := func() *[]*kinesis.PutRecordsRequestEntry {
+		return &[]*kinesis.PutRecordsRequestEntry{{Data: []byte("data"), PartitionKey: aws.String("key")}}
+	}
+	ok := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused"))
+	outputPlugin.flushStream("errors", records())
+	outputPlugin.timer.Check()
+	assert.False(t, expired, "Expected a failing secondary stream not to start the timer")
+
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection refused"))
+	outputPlugin.flushStream("stream", records())
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(ok, nil)
+	outputPlugin.flushStream("errors", records())
+	outputPlugin.timer.Check()
+	assert.True(t, expired, "Expected a healthy secondary stream not to reset the timer of the failing main stream")
+}
+
+func TestIndependentSecondaryFlush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	// each request blocks until its stream is given a permit
+	permits := map[string]chan struct{}{"stream": make(chan struct{}, 1), "errors": make(chan struct{}, 1)}
+	sent := make(chan string, 4)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			stream := aws.StringValue(input.StreamName)
+			<-permits[stream]
+			sent <- stream
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(4)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.mirror, _ = newMirror("errors", "level=error")
+	outputPlugin.pipelines = outputPlugin.startStreamPipelines(outputPlugin.secondaryQueues()...)
+
+	timeStamp := time.Now()
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"level": []byte("error")}, &timeStamp)
+
+	// the main stream is flushed while the mirror stream is blocked
+	permits["stream"] <- struct{}{}
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected Flush return code to be FLB_OK")
+	assert.Equal(t, "stream", <-sent)
+
+	permits["errors"] <- struct{}{}
+	assert.Equal(t, "errors", <-sent)
+
+	// the mirror stream is flushed while the main stream is blocked
+	records = records[:0]
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"level": []byte("error")}, &timeStamp)
+	flushed := make(chan int)
+	go func() {
+		flushed <- outputPlugin.Flush(&records)
+	}()
+	permits["errors"] <- struct{}{}
+	select {
+	case stream := <-sent:
+		assert.Equal(t, "errors", stream)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the mirror stream to be flushed while the main stream is blocked")
+	}
+
+	permits["stream"] <- struct{}{}
+	assert.Equal(t, fluentbit.FLB_OK, <-flushed, "Expected Flush return code to be FLB_OK")
+	outputPlugin.pipelines[0].close()
+}
+
+func TestIndependentSecondaryFlushPerStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	// the mirror stream never answers until the end of the test
+	unblock := make(chan struct{})
+	sent := make(chan string, 8)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			stream := aws.StringValue(input.StreamName)
+			if stream == "errors" {
+				<-unblock
+			}
+			sent <- stream
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).AnyTimes()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.mirror, _ = newMirror("errors", "level=error")
+	outputPlugin.metadata = &mirror{stream: "metadata"}
+	outputPlugin.pipelines = outputPlugin.startStreamPipelines(outputPlugin.secondaryQueues()...)
+	assert.Len(t, outputPlugin.pipelines, 2, "Expected a pipeline for each stream")
+
+	timeStamp := time.Now()
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"level": []byte("error")}, &timeStamp)
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected Flush return code to be FLB_OK")
+
+	// the main and metadata streams are flushed while the mirror stream is blocked
+	received := map[string]bool{}
+	for len(received) < 2 {
+		select {
+		case stream := <-sent:
+			received[stream] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the main and metadata streams to be flushed, got %v", received)
+		}
+	}
+	assert.Equal(t, map[string]bool{"stream": true, "metadata": true}, received)
+
+	close(unblock)
+	assert.NoError(t, outputPlugin.Close())
+	assert.Equal(t, "errors", <-sent)
+}
+
+func TestStreamPipelinesShareStream(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.mirror, _ = newMirror("secondary", "level=error")
+	outputPlugin.metadata = &mirror{stream: "secondary"}
+
+	pipelines := outputPlugin.startStreamPipelines(outputPlugin.secondaryQueues()...)
+	if assert.Len(t, pipelines, 1, "Expected queues for the same stream to share its pipeline") {
+		assert.Len(t, pipelines[0].queues, 2)
+		pipelines[0].close()
+	}
+}
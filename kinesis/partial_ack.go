@@ -0,0 +1,25 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"errors"
+)
+
+// Fluent Bit acknowledges a chunk as a whole, the Go output API has no way to tell it
+// which records of a chunk were delivered. With partial_ack the plugin tracks that
+// itself: a flush which leaves failed records behind returns FLB_RETRY rather than
+// FLB_OK, and only the records left unsent are sent again, whether by the plugin's own
+// retries or, when Fluent Bit retries the chunk, by resuming it.
+var errPartialFailure = errors.New("some records failed to be delivered and are left to be retried")
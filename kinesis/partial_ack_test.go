@@ -0,0 +1,97 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the second record fails with a code which is not a throughput error, the rest are delivered
+func partialAckFailure() *kinesis.PutRecordsOutput {
+	return &kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(1),
+		Records: []*kinesis.PutRecordsResultEntry{
+			{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")},
+			{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("Internal service failure.")},
+			{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("2")},
+		},
+	}
+}
+
+func TestPartialAckRetriesOnlyFailedRecords(t *testing.T) {
+	retrySleep = func(time.Duration) {}
+	defer func() { retrySleep = time.Sleep }()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			assert.Len(t, input.Records, 3)
+			return partialAckFailure(), nil
+		}),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			require.Len(t, input.Records, 1, "Expected only the failed record to be retried")
+			assert.Equal(t, "1", string(input.Records[0].Data))
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+				Records:           []*kinesis.PutRecordsResultEntry{{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("3")}},
+			}, nil
+		}),
+	)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.Concurrency = 1
+	outputPlugin.partialAck = true
+
+	outputPlugin.FlushWithRetries(3, newTestEntries("0", "1", "2"))
+	assert.Equal(t, uint32(0), outputPlugin.getConcurrentRetries())
+}
+
+func TestPartialAckResumesChunk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(partialAckFailure(), nil)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.partialChunks = newPartialChunks()
+	outputPlugin.partialAck = true
+	assert.True(t, outputPlugin.ResumesChunks(), "Expected partial_ack to resume chunks without flush_deadline")
+
+	records := newTestEntries("0", "1", "2")
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode, "Expected a retry for the failed record")
+	require.Len(t, records, 1)
+	assert.Equal(t, "1", string(records[0].Data))
+
+	chunkKey := ChunkKey("tag", []byte("chunk"))
+	outputPlugin.KeepUnsent(chunkKey, records)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+		require.Len(t, input.Records, 1, "Expected the retried chunk to only send the failed record")
+		return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}, nil
+	})
+	resumed, ok := outputPlugin.ResumeChunk(chunkKey)
+	require.True(t, ok)
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&resumed))
+}
+
+func TestPartialFailureWithoutPartialAck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(partialAckFailure(), nil)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	assert.False(t, outputPlugin.ResumesChunks())
+
+	records := newTestEntries("0", "1", "2")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected the chunk to be acknowledged as a whole")
+}
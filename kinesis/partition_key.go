@@ -0,0 +1,221 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PartitionKeySource indicates how the partition key of each record is chosen
+type PartitionKeySource string
+
+const (
+	// PartitionKeySourceField uses the value of the partition_key field, or a random key if it is not set
+	PartitionKeySourceField PartitionKeySource = "field"
+	// PartitionKeySourceRecordHash uses a hash of the whole record, so identical records share a shard
+	PartitionKeySourceRecordHash PartitionKeySource = "record_hash"
+	// PartitionKeySourceRoundRobin cycles through a fixed pool of partition keys
+	PartitionKeySourceRoundRobin PartitionKeySource = "round_robin"
+	// PartitionKeySourceTimeBucket uses the start of the time bucket the event timestamp falls in,
+	// so records from the same window share a shard
+	PartitionKeySourceTimeBucket PartitionKeySource = "time_bucket"
+)
+
+const (
+	// DefaultTimeBucket groups records by the minute of their event timestamp
+	DefaultTimeBucket = time.Minute
+)
+
+// PartitionKeyInvalidAction decides what happens to partition keys which don't match partition_key_pattern
+type PartitionKeyInvalidAction string
+
+const (
+	// PartitionKeyInvalidSanitize keeps only the parts of the key which match the pattern
+	PartitionKeyInvalidSanitize PartitionKeyInvalidAction = "sanitize"
+	// PartitionKeyInvalidFallback sends the record with a random key, as if it had no partition key
+	PartitionKeyInvalidFallback PartitionKeyInvalidAction = "fallback"
+)
+
+// AggregationPartitionStrategy indicates which partition key aggregated records are sent with
+type AggregationPartitionStrategy string
+
+const (
+	// AggregationPartitionFirstRecord sends an aggregated record with the key of its first user record
+	AggregationPartitionFirstRecord AggregationPartitionStrategy = "first_record"
+	// AggregationPartitionRandom sends each aggregated record with a random key
+	AggregationPartitionRandom AggregationPartitionStrategy = "random"
+	// AggregationPartitionRoundRobin cycles aggregated records through the round_robin_keys
+	AggregationPartitionRoundRobin AggregationPartitionStrategy = "round_robin"
+)
+
+const (
+	// round_robin_keys may generate at most this many keys
+	maxRoundRobinKeys = 100000
+)
+
+const (
+	defaultRecordHashAlgorithm = "sha1"
+)
+
+const (
+	// PartitionKeyHashCRC32 replaces the partition key with its hex encoded CRC-32 (IEEE)
+	PartitionKeyHashCRC32 = "crc32"
+	// PartitionKeyHashFNV replaces the partition key with its hex encoded 32 bit FNV-1a hash
+	PartitionKeyHashFNV = "fnv"
+	// PartitionKeyHashMD5 replaces the partition key with its hex encoded MD5 hash
+	PartitionKeyHashMD5 = "md5"
+)
+
+// newHasher returns a constructor for the named hash algorithm
+func newHasher(algorithm string) (func() hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = defaultRecordHashAlgorithm
+	}
+
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "md5":
+		return md5.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm '%s', must be 'sha1', 'sha256' or 'md5'", algorithm)
+	}
+}
+
+// recordHash returns the hex encoded hash of the processed record data
+func (outputPlugin *OutputPlugin) recordHash(data []byte) string {
+	hasher := outputPlugin.recordHasher()
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// newPartitionKeyHash returns a function which replaces a partition key field value
+// with its hex encoded hash. The full value is hashed, so values longer than the
+// partition key limit which share a prefix still get different keys.
+func newPartitionKeyHash(algorithm string) (func(value string) string, error) {
+	var newHash func() hash.Hash
+	switch strings.ToLower(algorithm) {
+	case PartitionKeyHashCRC32:
+		newHash = func() hash.Hash { return crc32.NewIEEE() }
+	case PartitionKeyHashFNV:
+		newHash = func() hash.Hash { return fnv.New32a() }
+	case PartitionKeyHashMD5:
+		newHash = md5.New
+	default:
+		return nil, fmt.Errorf("unsupported partition key hash '%s', must be '%s', '%s' or '%s'", algorithm, PartitionKeyHashCRC32, PartitionKeyHashFNV, PartitionKeyHashMD5)
+	}
+
+	return func(value string) string {
+		hasher := newHash()
+		hasher.Write([]byte(value))
+		return hex.EncodeToString(hasher.Sum(nil))
+	}, nil
+}
+
+// generateRoundRobinKeys returns count partition keys which Kinesis maps to count
+// evenly split ranges of the hash key space, one key per range. Kinesis hashes
+// partition keys with MD5, so keys are found by trying candidates in order until
+// every range has one. The keys are deterministic, so every instance gets the same pool.
+func generateRoundRobinKeys(count int) ([]string, error) {
+	if count <= 0 || count > maxRoundRobinKeys {
+		return nil, fmt.Errorf("round_robin_keys must be between 1 and %d keys", maxRoundRobinKeys)
+	}
+
+	keys := make([]string, count)
+	remaining := count
+	for candidate := 0; remaining > 0; candidate++ {
+		key := "rr-" + strconv.Itoa(candidate)
+		sum := md5.Sum([]byte(key))
+		// the range the top 64 bits of the hash key fall in, of count equal ranges
+		hashRange, _ := bits.Mul64(binary.BigEndian.Uint64(sum[:8]), uint64(count))
+		if keys[hashRange] == "" {
+			keys[hashRange] = key
+			remaining--
+		}
+	}
+	return keys, nil
+}
+
+// nextRoundRobinKey returns the next key of the pool, it is goroutine safe
+func (outputPlugin *OutputPlugin) nextRoundRobinKey() string {
+	next := atomic.AddUint32(&outputPlugin.roundRobinNext, 1) - 1
+	return outputPlugin.roundRobinKeys[next%uint32(len(outputPlugin.roundRobinKeys))]
+}
+
+// keyPattern is the partition_key_pattern, compiled to find the valid parts of a key and
+// anchored to match whole keys. Without the anchors a match is the leftmost one, which
+// need not be the longest, so a key the pattern matches in full could be sanitized.
+type keyPattern struct {
+	parts *regexp.Regexp
+	whole *regexp.Regexp
+}
+
+func newKeyPattern(expr string) (*keyPattern, error) {
+	parts, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	whole, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPattern{parts: parts, whole: whole}, nil
+}
+
+// validPartitionKey checks a partition key field value against partition_key_pattern.
+// A key is valid if the pattern matches all of it. An invalid key is sanitized to the
+// concatenation of the parts the pattern matches, or with PartitionKeyInvalidFallback, or
+// if no part matches, it returns false so the record falls back to a random key.
+func (outputPlugin *OutputPlugin) validPartitionKey(value string) (string, bool) {
+	pattern := outputPlugin.partitionKeyPattern
+	if pattern.whole.MatchString(value) {
+		return value, true
+	}
+
+	if outputPlugin.invalidKeyAction == PartitionKeyInvalidSanitize {
+		if sanitized := strings.Join(pattern.parts.FindAllString(value, -1), ""); sanitized != "" {
+			outputPlugin.logger.Debugf("Partition key %q does not match partition_key_pattern, sanitized to %q\n", value, sanitized)
+			return sanitized, true
+		}
+	}
+	outputPlugin.logger.Debugf("Partition key %q does not match partition_key_pattern, using a random string instead\n", value)
+	return "", false
+}
+
+// timeBucketKey returns the Unix time in seconds of the start of the bucket the timestamp falls in
+func (outputPlugin *OutputPlugin) timeBucketKey(timeStamp time.Time) string {
+	bucket := int64(outputPlugin.timeBucket)
+	nanos := timeStamp.UnixNano()
+	start := nanos - nanos%bucket
+	if nanos%bucket < 0 {
+		start -= bucket
+	}
+	return strconv.FormatInt(start/int64(time.Second), 10)
+}
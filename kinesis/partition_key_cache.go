@@ -0,0 +1,85 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"container/list"
+	"sync"
+)
+
+// values longer than this are derived every time, so a few huge values can't hold on to
+// a lot of memory in the cache
+const partitionKeyCacheMaxValueLength = 1024
+
+// derivedPartitionKey is the partition key derived from a value of the partition_key field
+type derivedPartitionKey struct {
+	value string
+	key   string
+	// false if the value is empty once normalized
+	found bool
+	// false if the value was rejected by partition_key_pattern
+	valid bool
+}
+
+// partitionKeyCache is a least recently used cache of the partition keys derived from the
+// values of the partition_key field. The key derived from a value only depends on the value
+// and the configuration, so a value is its own fingerprint, and records whose value changes
+// never reuse the key of another value.
+type partitionKeyCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// most recently used first
+	order *list.List
+}
+
+func newPartitionKeyCache(size int) *partitionKeyCache {
+	return &partitionKeyCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns the key derived from a value, deriving and caching it if it isn't cached
+func (c *partitionKeyCache) get(value string, derive func(value string) derivedPartitionKey) derivedPartitionKey {
+	if len(value) > partitionKeyCacheMaxValueLength {
+		return derive(value)
+	}
+	c.mutex.Lock()
+	if element, ok := c.entries[value]; ok {
+		c.order.MoveToFront(element)
+		derived := element.Value.(derivedPartitionKey)
+		c.mutex.Unlock()
+		return derived
+	}
+	c.mutex.Unlock()
+
+	// derived without the lock, so a slow derivation doesn't hold up other records
+	derived := derive(value)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[value]; ok {
+		c.order.MoveToFront(element)
+		return derived
+	}
+	c.entries[value] = c.order.PushFront(derived)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(derivedPartitionKey).value)
+	}
+	return derived
+}
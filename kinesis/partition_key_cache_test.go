@@ -0,0 +1,95 @@
+package kinesis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newKeyDerivingPlugin(cacheSize int) *OutputPlugin {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "user"
+	outputPlugin.keyTrim = true
+	outputPlugin.keyLowercase = true
+	outputPlugin.partitionKeyPattern, _ = newKeyPattern(`[a-z0-9]+`)
+	outputPlugin.invalidKeyAction = PartitionKeyInvalidSanitize
+	outputPlugin.partitionKeyHash, _ = newPartitionKeyHash(PartitionKeyHashMD5)
+	if cacheSize > 0 {
+		outputPlugin.partitionKeyCache = newPartitionKeyCache(cacheSize)
+	}
+	return outputPlugin
+}
+
+func TestPartitionKeyCacheMatchesUncachedKeys(t *testing.T) {
+	cached := newKeyDerivingPlugin(2)
+	uncached := newKeyDerivingPlugin(0)
+
+	// values repeat, change, and come back after being evicted
+	values := []interface{}{
+		[]byte("alice"), []byte("alice"), []byte(" Alice "), []byte("bob"), "bob", []byte("al-ice"),
+		[]byte("carol"), []byte("alice"), []byte("   "), []byte("!!!"), []byte("carol"), nil,
+	}
+	for _, value := range values {
+		record := map[interface{}]interface{}{"log": []byte("message")}
+		if value != nil {
+			record["user"] = value
+		}
+		expectedKey, expectedFound := uncached.getPartitionKey(record)
+		key, found := cached.getPartitionKey(record)
+		assert.Equal(t, expectedKey, key, "Expected the cached key for %q to match", value)
+		assert.Equal(t, expectedFound, found, "Expected the cached result for %q to match", value)
+	}
+	assert.LessOrEqual(t, cached.partitionKeyCache.order.Len(), 2, "Expected the cache to be bounded")
+
+	key, _ := cached.getPartitionKey(map[interface{}]interface{}{"user": []byte("dave")})
+	other, _ := cached.getPartitionKey(map[interface{}]interface{}{"user": []byte("erin")})
+	assert.NotEqual(t, key, other, "Expected a changed value to get its own key")
+}
+
+func TestPartitionKeyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newPartitionKeyCache(2)
+	derivations := 0
+	derive := func(value string) derivedPartitionKey {
+		derivations++
+		return derivedPartitionKey{value: value, key: strings.ToUpper(value), found: true, valid: true}
+	}
+
+	assert.Equal(t, "A", cache.get("a", derive).key)
+	assert.Equal(t, "B", cache.get("b", derive).key)
+	// a is used again, so b is the least recently used
+	assert.Equal(t, "A", cache.get("a", derive).key)
+	assert.Equal(t, "C", cache.get("c", derive).key)
+	assert.Equal(t, 3, derivations)
+
+	cache.get("a", derive)
+	assert.Equal(t, 3, derivations, "Expected a to still be cached")
+	cache.get("b", derive)
+	assert.Equal(t, 4, derivations, "Expected b to have been evicted")
+
+	long := strings.Repeat("x", partitionKeyCacheMaxValueLength+1)
+	cache.get(long, derive)
+	cache.get(long, derive)
+	assert.Equal(t, 6, derivations, "Expected long values not to be cached")
+}
+
+func benchmarkPartitionKey(b *testing.B, cacheSize int) {
+	outputPlugin := newKeyDerivingPlugin(cacheSize)
+	records := make([]map[interface{}]interface{}, 16)
+	for i := range records {
+		records[i] = map[interface{}]interface{}{
+			"user": []byte(fmt.Sprintf("  User-%d@Example.COM  ", i)),
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		outputPlugin.getPartitionKey(records[i%len(records)])
+	}
+}
+
+func BenchmarkPartitionKey(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) { benchmarkPartitionKey(b, 0) })
+	b.Run("cached", func(b *testing.B) { benchmarkPartitionKey(b, 64) })
+}
@@ -0,0 +1,364 @@
+package kinesis
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordHashPartitionKey(t *testing.T) {
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 500)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKeySource = PartitionKeySourceRecordHash
+	outputPlugin.recordHasher, _ = newHasher("")
+
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"message": []byte("identical"),
+	}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"message": []byte("identical"),
+	}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"message": []byte("different"),
+	}, &timeStamp)
+
+	assert.Len(t, records, 3)
+	assert.Len(t, aws.StringValue(records[0].PartitionKey), 40, "Expected a hex encoded SHA-1 partition key")
+	assert.Equal(t, aws.StringValue(records[0].PartitionKey), aws.StringValue(records[1].PartitionKey), "Expected identical records to share a partition key")
+	assert.NotEqual(t, aws.StringValue(records[0].PartitionKey), aws.StringValue(records[2].PartitionKey), "Expected different records to have different partition keys")
+}
+
+func TestNewHasher(t *testing.T) {
+	for algorithm, size := range map[string]int{"sha1": 20, "SHA256": 32, "md5": 16} {
+		hasher, err := newHasher(algorithm)
+		assert.NoError(t, err)
+		assert.Equal(t, size, hasher().Size(), algorithm)
+	}
+
+	_, err := newHasher("crc")
+	assert.Error(t, err)
+}
+
+func TestNewPartitionKeyHash(t *testing.T) {
+	for algorithm, length := range map[string]int{"crc32": 8, "FNV": 8, "md5": 32} {
+		keyHash, err := newPartitionKeyHash(algorithm)
+		assert.NoError(t, err)
+		assert.Len(t, keyHash("service-1"), length, algorithm)
+		assert.Equal(t, keyHash("service-1"), keyHash("service-1"), "Expected hashing to be deterministic")
+		assert.NotEqual(t, keyHash("service-1"), keyHash("service-2"), algorithm)
+	}
+
+	_, err := newPartitionKeyHash("sha1")
+	assert.Error(t, err)
+}
+
+// kinesisShard maps a partition key to one of shards evenly split shards, as Kinesis
+// does by taking the MD5 hash of the key as a 128 bit integer
+func kinesisShard(partitionKey string, shards int) int {
+	sum := md5.Sum([]byte(partitionKey))
+	shard, _ := bits.Mul64(binary.BigEndian.Uint64(sum[:8]), uint64(shards))
+	return int(shard)
+}
+
+// shardsUsed sends the partition key field values through AddRecord and returns
+// the number of records which land on each shard
+func shardsUsed(partitionKeyHash string, values []string, shards int) []int {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "service"
+	if partitionKeyHash != "" {
+		outputPlugin.partitionKeyHash, _ = newPartitionKeyHash(partitionKeyHash)
+	}
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, len(values))
+	timeStamp := time.Now()
+	for _, value := range values {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"service": []byte(value),
+		}, &timeStamp)
+	}
+
+	counts := make([]int, shards)
+	for _, record := range records {
+		counts[kinesisShard(aws.StringValue(record.PartitionKey), shards)]++
+	}
+	return counts
+}
+
+func TestPartitionKeyHashDistribution(t *testing.T) {
+	const shards = 8
+
+	// clustered short values are already spread by the MD5 hash Kinesis applies,
+	// so hashing them first gives a similar distribution
+	var clustered []string
+	for i := 0; i < 8000; i++ {
+		clustered = append(clustered, fmt.Sprintf("service-%04d", i))
+	}
+	for _, algorithm := range []string{"", "crc32", "fnv", "md5"} {
+		for shard, count := range shardsUsed(algorithm, clustered, shards) {
+			assert.InDelta(t, 1000, count, 150, "Expected shard %d to get an even share with hash '%s'", shard, algorithm)
+		}
+	}
+
+	// values which only differ after the partition key limit are truncated to the
+	// same key, so they all land on one shard unless they are hashed first
+	prefix := strings.Repeat("a", partitionKeyMaxLength)
+	var long []string
+	for i := 0; i < 8000; i++ {
+		long = append(long, fmt.Sprintf("%s-%04d", prefix, i))
+	}
+	used := func(counts []int) int {
+		n := 0
+		for _, count := range counts {
+			if count > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 1, used(shardsUsed("", long, shards)))
+	for _, algorithm := range []string{"crc32", "fnv", "md5"} {
+		for shard, count := range shardsUsed(algorithm, long, shards) {
+			assert.InDelta(t, 1000, count, 150, "Expected shard %d to get an even share with hash '%s'", shard, algorithm)
+		}
+	}
+}
+
+func TestGenerateRoundRobinKeys(t *testing.T) {
+	for _, count := range []int{1, 4, 7, 64} {
+		keys, err := generateRoundRobinKeys(count)
+		assert.NoError(t, err)
+		assert.Len(t, keys, count)
+
+		// each key lands on its own shard of a stream with count evenly split shards
+		shards := make(map[int]bool)
+		for _, key := range keys {
+			shards[kinesisShard(key, count)] = true
+		}
+		assert.Len(t, shards, count, "Expected one key per shard for %d shards", count)
+	}
+
+	again, _ := generateRoundRobinKeys(4)
+	keys, _ := generateRoundRobinKeys(4)
+	assert.Equal(t, keys, again, "Expected the pool to be deterministic")
+
+	_, err := generateRoundRobinKeys(0)
+	assert.Error(t, err)
+}
+
+func TestRoundRobinPartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKeySource = PartitionKeySourceRoundRobin
+	outputPlugin.roundRobinKeys, _ = generateRoundRobinKeys(4)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4000)
+	timeStamp := time.Now()
+	for i := 0; i < 4000; i++ {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte("record"),
+		}, &timeStamp)
+	}
+
+	shardCounts := make(map[int]int)
+	for i, record := range records {
+		assert.Equal(t, outputPlugin.roundRobinKeys[i%4], aws.StringValue(record.PartitionKey))
+		shardCounts[kinesisShard(aws.StringValue(record.PartitionKey), 4)]++
+	}
+	assert.Equal(t, map[int]int{0: 1000, 1: 1000, 2: 1000, 3: 1000}, shardCounts)
+}
+
+func TestRoundRobinPartitionKeyConcurrent(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.roundRobinKeys = []string{"a", "b", "c"}
+
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := outputPlugin.nextRoundRobinKey()
+				mutex.Lock()
+				counts[key]++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"a": 2000, "b": 2000, "c": 2000}, counts)
+}
+
+func TestTimeBucketPartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKeySource = PartitionKeySourceTimeBucket
+	outputPlugin.timeBucket = time.Minute
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	for _, timeStamp := range []time.Time{
+		time.Date(2023, 11, 14, 22, 14, 0, 0, time.UTC),
+		time.Date(2023, 11, 14, 22, 14, 59, 999999999, time.UTC),
+		time.Date(2023, 11, 14, 22, 15, 0, 0, time.UTC),
+	} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte("record"),
+		}, &timeStamp)
+	}
+
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "1700000040", aws.StringValue(records[0].PartitionKey))
+		assert.Equal(t, aws.StringValue(records[0].PartitionKey), aws.StringValue(records[1].PartitionKey),
+			"Expected records within the same bucket to share a key")
+		assert.Equal(t, "1700000100", aws.StringValue(records[2].PartitionKey),
+			"Expected the next bucket to get the next key")
+	}
+}
+
+func TestPartitionKeyPattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		action PartitionKeyInvalidAction
+		key    string
+		want   string
+	}{
+		{"valid key is kept", PartitionKeyInvalidSanitize, "tenant-1", "tenant-1"},
+		{"control characters are stripped", PartitionKeyInvalidSanitize, "tenant\x00-\x1b1\n", "tenant-1"},
+		{"nothing left falls back", PartitionKeyInvalidSanitize, "\x01\x02", ""},
+		{"valid key is kept on fallback", PartitionKeyInvalidFallback, "tenant-1", "tenant-1"},
+		{"invalid key falls back", PartitionKeyInvalidFallback, "tenant\x00-1", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputPlugin, _ := newMockOutputPlugin(nil, false)
+			outputPlugin.partitionKey = "tenant"
+			outputPlugin.partitionKeyPattern, _ = newKeyPattern(`[A-Za-z0-9_.-]+`)
+			outputPlugin.invalidKeyAction = test.action
+
+			records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+			timeStamp := time.Now()
+			outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+				"tenant": []byte(test.key),
+			}, &timeStamp)
+
+			if assert.Len(t, records, 1) {
+				key := aws.StringValue(records[0].PartitionKey)
+				if test.want == "" {
+					assert.Len(t, key, outputPlugin.stringGen.Size, "Expected a random key")
+					assert.NotContains(t, key, "tenant")
+				} else {
+					assert.Equal(t, test.want, key)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionKeyPatternMatchesWholeKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	// the leftmost match of "ab" is "a", which is not all of the key
+	outputPlugin.partitionKeyPattern, _ = newKeyPattern(`a|ab`)
+	outputPlugin.invalidKeyAction = PartitionKeyInvalidFallback
+
+	key, valid := outputPlugin.validPartitionKey("ab")
+	assert.True(t, valid)
+	assert.Equal(t, "ab", key)
+
+	_, valid = outputPlugin.validPartitionKey("abc")
+	assert.False(t, valid)
+}
+
+func TestPartitionKeyFromMetadata(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "kubernetes->namespace"
+	outputPlugin.keyFromMetadata = true
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	body := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"log":        []byte("hello"),
+			"kubernetes": map[interface{}]interface{}{"namespace": []byte("body")},
+		}
+	}
+
+	outputPlugin.AddRecordWithMetadata(&records, body(), map[interface{}]interface{}{
+		"kubernetes": map[interface{}]interface{}{"namespace": []byte("metadata")},
+	}, &timeStamp)
+	// metadata without the key gets a random key, rather than the key from the body
+	outputPlugin.AddRecordWithMetadata(&records, body(), map[interface{}]interface{}{}, &timeStamp)
+
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "metadata", aws.StringValue(records[0].PartitionKey))
+		assert.NotContains(t, string(records[0].Data), "metadata", "Expected the metadata to stay out of the record body")
+		assert.Len(t, aws.StringValue(records[1].PartitionKey), outputPlugin.stringGen.Size)
+	}
+	warnings := func() int {
+		count := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				count++
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 0, warnings())
+
+	// Fluent Bit without metadata support falls back to the body, with a single warning
+	outputPlugin.AddRecordWithMetadata(&records, body(), nil, &timeStamp)
+	outputPlugin.AddRecord(&records, body(), &timeStamp)
+	if assert.Len(t, records, 4) {
+		assert.Equal(t, "body", aws.StringValue(records[2].PartitionKey))
+		assert.Equal(t, "body", aws.StringValue(records[3].PartitionKey))
+	}
+	assert.Equal(t, 1, warnings())
+}
+
+func TestNormalizePartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "tenant"
+	outputPlugin.keyTrim = true
+	outputPlugin.keyLowercase = true
+
+	for _, variant := range []string{"  Foo ", "foo", "FOO", "\tfoo\n"} {
+		value, ok := outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte(variant)})
+		assert.True(t, ok)
+		assert.Equal(t, "foo", value, "Expected %q to normalize to foo", variant)
+	}
+
+	// only whitespace is no key at all
+	_, ok := outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte("   ")})
+	assert.False(t, ok)
+
+	// normalized before the length limit and hashing
+	value, _ := outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte("   " + strings.Repeat("A", partitionKeyMaxLength))})
+	assert.Equal(t, strings.Repeat("a", partitionKeyMaxLength), value)
+	outputPlugin.partitionKeyHash, _ = newPartitionKeyHash(PartitionKeyHashMD5)
+	hashed, _ := outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte(" Foo")})
+	plain, _ := outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte("foo")})
+	assert.Equal(t, plain, hashed)
+
+	// each normalization is off by default
+	outputPlugin, _ = newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "tenant"
+	outputPlugin.keyTrim = true
+	value, _ = outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte(" Foo ")})
+	assert.Equal(t, "Foo", value)
+	outputPlugin.keyTrim, outputPlugin.keyLowercase = false, true
+	value, _ = outputPlugin.getPartitionKey(map[interface{}]interface{}{"tenant": []byte(" Foo ")})
+	assert.Equal(t, " foo ", value)
+}
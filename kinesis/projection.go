@@ -0,0 +1,135 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmespath/go-jmespath"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ProjectionNoMatch decides what happens to records which the projection finds nothing in
+type ProjectionNoMatch string
+
+const (
+	// ProjectionNoMatchDrop leaves records which the projection finds nothing in out of the batch
+	ProjectionNoMatchDrop ProjectionNoMatch = "drop"
+	// ProjectionNoMatchEmpty sends an empty JSON object for records which the projection finds nothing in
+	ProjectionNoMatchEmpty ProjectionNoMatch = "empty"
+)
+
+// errProjectionNoMatch is returned by processRecord for records which are dropped because
+// the projection found nothing in them
+var errProjectionNoMatch = errors.New("projection found nothing in the record")
+
+// recordProjection reshapes each record with a JMESPath expression, whose result is sent
+// in place of the record
+type recordProjection struct {
+	expression *jmespath.JMESPath
+	noMatch    ProjectionNoMatch
+}
+
+func newRecordProjection(expression string, noMatch ProjectionNoMatch) (*recordProjection, error) {
+	compiled, err := jmespath.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &recordProjection{
+		expression: compiled,
+		noMatch:    noMatch,
+	}, nil
+}
+
+// apply evaluates the expression against the decoded record and encodes the result. Strings
+// are sent as they are, like log_key values, and anything else as JSON. A null result, or an
+// empty list or object, such as a filter which matched nothing, counts as no match.
+func (p *recordProjection) apply(record map[interface{}]interface{}, json jsoniter.API) ([]byte, error) {
+	result, err := p.expression.Search(jmespathValue(record))
+	if err != nil {
+		return nil, fmt.Errorf("projection failed: %v", err)
+	}
+	switch v := result.(type) {
+	case string:
+		return []byte(v), nil
+	case nil:
+		return p.noMatchData()
+	case []interface{}:
+		if len(v) == 0 {
+			return p.noMatchData()
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return p.noMatchData()
+		}
+	}
+	return json.Marshal(result)
+}
+
+func (p *recordProjection) noMatchData() ([]byte, error) {
+	if p.noMatch == ProjectionNoMatchEmpty {
+		return []byte("{}"), nil
+	}
+	return nil, errProjectionNoMatch
+}
+
+// jmespathValue converts a decoded record into the types JMESPath expressions work on,
+// objects with string keys and float64 numbers, the way the record would decode from JSON
+func jmespathValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			name, ok := key.(string)
+			if !ok {
+				name = fmt.Sprint(key)
+			}
+			converted[name] = jmespathValue(nested)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, nested := range v {
+			converted[i] = jmespathValue(nested)
+		}
+		return converted
+	case []byte:
+		return string(v)
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,85 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func projectionRecord() map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"log":   []byte("request served"),
+		"level": []byte("info"),
+		"kubernetes": map[interface{}]interface{}{
+			"namespace_name": []byte("payments"),
+			"labels": map[interface{}]interface{}{
+				"app": []byte("checkout"),
+			},
+		},
+		"requests": []interface{}{
+			map[interface{}]interface{}{"path": []byte("/pay"), "status": int64(500)},
+			map[interface{}]interface{}{"path": []byte("/health"), "status": uint64(200)},
+			map[interface{}]interface{}{"path": []byte("/refund"), "status": int64(503)},
+		},
+	}
+}
+
+func TestProjection(t *testing.T) {
+	for expression, expected := range map[string]string{
+		"{message: log, namespace: kubernetes.namespace_name, app: kubernetes.labels.app}": `{"app":"checkout","message":"request served","namespace":"payments"}`,
+		"requests[?status >= `500`].path":                                                  `["/pay","/refund"]`,
+		"{errors: length(requests[?status >= `500`]), level: level}":                       `{"errors":2,"level":"info"}`,
+		"kubernetes.labels": `{"app":"checkout"}`,
+		"log":               `request served`,
+	} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		var err error
+		outputPlugin.projection, err = newRecordProjection(expression, ProjectionNoMatchDrop)
+		if !assert.NoError(t, err, expression) {
+			continue
+		}
+
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+		timeStamp := time.Now()
+		retCode := outputPlugin.AddRecord(&records, projectionRecord(), &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+		if assert.Len(t, records, 1, expression) {
+			assert.Equal(t, expected, string(records[0].Data), expression)
+		}
+	}
+}
+
+func TestProjectionNoMatch(t *testing.T) {
+	for _, expression := range []string{"missing.field", "requests[?status == `404`]"} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.projection, _ = newRecordProjection(expression, ProjectionNoMatchDrop)
+
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+		timeStamp := time.Now()
+		retCode := outputPlugin.AddRecord(&records, projectionRecord(), &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+		assert.Len(t, records, 0, expression)
+
+		outputPlugin.projection, _ = newRecordProjection(expression, ProjectionNoMatchEmpty)
+		retCode = outputPlugin.AddRecord(&records, projectionRecord(), &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected AddRecord return code to be FLB_OK")
+		if assert.Len(t, records, 1, expression) {
+			assert.Equal(t, "{}", string(records[0].Data), expression)
+		}
+	}
+}
+
+func TestInvalidProjection(t *testing.T) {
+	_, err := newRecordProjection("requests[?status >", ProjectionNoMatchDrop)
+	assert.Error(t, err)
+
+	_, err = NewOutputPlugin(&OutputPluginConfig{
+		Region:     "us-east-1",
+		Stream:     "stream",
+		Projection: "{message: }",
+	})
+	assert.Error(t, err)
+}
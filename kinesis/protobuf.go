@@ -0,0 +1,108 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"os"
+
+	jsoniter "github.com/json-iterator/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// RecordFormatProtobuf sends each record as a length delimited protobuf message
+const RecordFormatProtobuf RecordFormat = "protobuf"
+
+// ProtobufUnknownFields decides what happens to record fields which are not in the protobuf message
+type ProtobufUnknownFields string
+
+const (
+	// ProtobufUnknownFieldsDrop leaves fields which are not in the message out of the record
+	ProtobufUnknownFieldsDrop ProtobufUnknownFields = "drop"
+	// ProtobufUnknownFieldsError rejects records with fields which are not in the message
+	ProtobufUnknownFieldsError ProtobufUnknownFields = "error"
+)
+
+// protobufCodec encodes records as a protobuf message described by a descriptor set.
+// Record fields are mapped onto message fields the way the protobuf JSON mapping does,
+// by either the field name or its JSON name, and each encoded message is prefixed with
+// its length as a varint, so consumers can read it with parseDelimitedFrom or similar.
+type protobufCodec struct {
+	message       protoreflect.MessageType
+	unmarshalOpts protojson.UnmarshalOptions
+}
+
+// loadProtobufCodec reads a FileDescriptorSet, as written by protoc --descriptor_set_out
+// with --include_imports, and finds the message with the full name messageName in it
+func loadProtobufCodec(path string, messageName string, unknownFields ProtobufUnknownFields) (*protobufCodec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newProtobufCodec(data, messageName, unknownFields)
+}
+
+func newProtobufCodec(descriptorSet []byte, messageName string, unknownFields ProtobufUnknownFields) (*protobufCodec, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(descriptorSet, set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set: %v", err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %s not found in the protobuf descriptor set: %v", messageName, err)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message in the protobuf descriptor set", messageName)
+	}
+
+	return &protobufCodec{
+		message: dynamicpb.NewMessageType(message),
+		unmarshalOpts: protojson.UnmarshalOptions{
+			DiscardUnknown: unknownFields != ProtobufUnknownFieldsError,
+		},
+	}, nil
+}
+
+// encode maps the decoded record onto the message and returns its length delimited wire bytes
+func (c *protobufCodec) encode(record map[interface{}]interface{}) ([]byte, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	message := c.message.New().Interface()
+	if err := c.unmarshalOpts.Unmarshal(recordJSON, message); err != nil {
+		return nil, fmt.Errorf("record does not match protobuf message %s: %v", c.message.Descriptor().FullName(), err)
+	}
+	wire, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, protowire.SizeVarint(uint64(len(wire)))+len(wire))
+	data = protowire.AppendVarint(data, uint64(len(wire)))
+	return append(data, wire...), nil
+}
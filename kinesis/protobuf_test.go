@@ -0,0 +1,172 @@
+package kinesis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testProtobufDescriptorSet describes:
+//
+//	package logs.v1;
+//	enum Level { LEVEL_UNSPECIFIED = 0; INFO = 1; ERROR = 2; }
+//	message Source { string pod_name = 1; }
+//	message LogEvent {
+//	  string log = 1;
+//	  int64 count = 2;
+//	  Level level = 3;
+//	  repeated string tags = 4;
+//	  Source source = 5;
+//	  double ratio = 6;
+//	}
+func testProtobufDescriptorSet(t *testing.T) []byte {
+	field := func(name string, number int32, fieldType descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   fieldType.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("logs.proto"),
+		Package: proto.String("logs.v1"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Level"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("LEVEL_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("INFO"), Number: proto.Int32(1)},
+				{Name: proto.String("ERROR"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Source"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("pod_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false)},
+			},
+			{
+				Name: proto.String("LogEvent"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("log", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+					field("level", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".logs.v1.Level", false),
+					field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+					field("source", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".logs.v1.Source", false),
+					field("ratio", 6, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "", false),
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	assert.NoError(t, err)
+	return data
+}
+
+// decodeDelimitedProtobuf reads a length delimited message and checks nothing follows it
+func decodeDelimitedProtobuf(t *testing.T, codec *protobufCodec, data []byte) protoreflect.Message {
+	size, n := protowire.ConsumeVarint(data)
+	assert.Greater(t, n, 0)
+	assert.Equal(t, len(data)-n, int(size), "Expected the length prefix to cover the rest of the record")
+	message := dynamicpb.NewMessage(codec.message.Descriptor())
+	assert.NoError(t, proto.Unmarshal(data[n:], message))
+	return message
+}
+
+func TestProtobufEncode(t *testing.T) {
+	codec, err := newProtobufCodec(testProtobufDescriptorSet(t), "logs.v1.LogEvent", ProtobufUnknownFieldsDrop)
+	assert.NoError(t, err)
+
+	data, err := codec.encode(map[interface{}]interface{}{
+		"log":   "hello",
+		"count": 42,
+		"level": "ERROR",
+		"tags":  []interface{}{"a", "b"},
+		"source": map[interface{}]interface{}{
+			"podName": "app-1",
+		},
+		"ratio":   0.5,
+		"unknown": "dropped",
+	})
+	assert.NoError(t, err)
+
+	message := decodeDelimitedProtobuf(t, codec, data)
+	fields := message.Descriptor().Fields()
+	assert.Equal(t, "hello", message.Get(fields.ByName("log")).String())
+	assert.Equal(t, int64(42), message.Get(fields.ByName("count")).Int())
+	assert.Equal(t, protoreflect.EnumNumber(2), message.Get(fields.ByName("level")).Enum())
+	tags := message.Get(fields.ByName("tags")).List()
+	if assert.Equal(t, 2, tags.Len()) {
+		assert.Equal(t, "a", tags.Get(0).String())
+		assert.Equal(t, "b", tags.Get(1).String())
+	}
+	source := message.Get(fields.ByName("source")).Message()
+	assert.Equal(t, "app-1", source.Get(source.Descriptor().Fields().ByName("pod_name")).String(), "Expected the JSON name to map onto the field")
+	assert.Equal(t, 0.5, message.Get(fields.ByName("ratio")).Float())
+	assert.Empty(t, message.GetUnknown())
+}
+
+func TestProtobufEncodeErrors(t *testing.T) {
+	codec, err := newProtobufCodec(testProtobufDescriptorSet(t), "logs.v1.LogEvent", ProtobufUnknownFieldsError)
+	assert.NoError(t, err)
+
+	_, err = codec.encode(map[interface{}]interface{}{"log": "hello", "unknown": "field"})
+	assert.Error(t, err, "Expected unknown fields to be rejected")
+
+	_, err = codec.encode(map[interface{}]interface{}{"count": "not a number"})
+	assert.Error(t, err, "Expected a mismatched type to be rejected")
+
+	data, err := codec.encode(map[interface{}]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0}, data, "Expected an empty message to be a zero length prefix")
+}
+
+func TestNewProtobufCodecInvalid(t *testing.T) {
+	descriptorSet := testProtobufDescriptorSet(t)
+
+	_, err := newProtobufCodec([]byte("not a descriptor set"), "logs.v1.LogEvent", ProtobufUnknownFieldsDrop)
+	assert.Error(t, err)
+	_, err = newProtobufCodec(descriptorSet, "logs.v1.Missing", ProtobufUnknownFieldsDrop)
+	assert.Error(t, err)
+	_, err = newProtobufCodec(descriptorSet, "logs.v1.Level", ProtobufUnknownFieldsDrop)
+	assert.Error(t, err, "Expected an enum to be rejected as the record message")
+}
+
+func TestAddRecordProtobuf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.pb")
+	assert.NoError(t, os.WriteFile(path, testProtobufDescriptorSet(t), 0644))
+	codec, err := loadProtobufCodec(path, "logs.v1.LogEvent", ProtobufUnknownFieldsDrop)
+	assert.NoError(t, err)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.protobuf = codec
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log":    []byte("hello"),
+		"stream": []byte("stdout"),
+	}, &timeStamp)
+
+	if assert.Len(t, records, 1) {
+		message := decodeDelimitedProtobuf(t, codec, records[0].Data)
+		assert.Equal(t, "hello", message.Get(message.Descriptor().Fields().ByName("log")).String())
+	}
+}
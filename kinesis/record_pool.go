@@ -0,0 +1,124 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// With record_pool, the allocations of the flush path are reused across flushes and plugin
+// instances: the batches PutRecords is called with, and the buffers and writers records are
+// compressed with. Pooled objects never leave the flush which took them, the records a flush
+// leaves unsent and the compressed data of each record are copied out before they are returned.
+var (
+	requestBufPool = sync.Pool{
+		New: func() interface{} {
+			buf := make([]*kinesis.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
+			return &buf
+		},
+	}
+	compressBufPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+	zlibWriterPool = sync.Pool{
+		New: func() interface{} { return zlib.NewWriter(nil) },
+	}
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	}
+)
+
+// newRequestBuf returns an empty batch, taken from the pool with record_pool
+func (outputPlugin *OutputPlugin) newRequestBuf() []*kinesis.PutRecordsRequestEntry {
+	if !outputPlugin.recordPool {
+		return make([]*kinesis.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
+	}
+	return *(requestBufPool.Get().(*[]*kinesis.PutRecordsRequestEntry))
+}
+
+// releaseRequestBuf returns a batch to the pool with record_pool, once nothing refers to it
+func (outputPlugin *OutputPlugin) releaseRequestBuf(buf []*kinesis.PutRecordsRequestEntry) {
+	if !outputPlugin.recordPool || cap(buf) != maximumRecordsPerPut {
+		return
+	}
+	// forget the records, so the pool doesn't keep them alive
+	buf = buf[:cap(buf)]
+	for i := range buf {
+		buf[i] = nil
+	}
+	buf = buf[:0]
+	requestBufPool.Put(&buf)
+}
+
+// unsentRecords returns the records left in the batch followed by those not batched yet,
+// which the flush leaves in the buffer. With record_pool they are copied, since the batch
+// goes back to the pool.
+func (outputPlugin *OutputPlugin) unsentRecords(batch []*kinesis.PutRecordsRequestEntry, rest []*kinesis.PutRecordsRequestEntry) []*kinesis.PutRecordsRequestEntry {
+	if !outputPlugin.recordPool {
+		return append(batch, rest...)
+	}
+	unsent := make([]*kinesis.PutRecordsRequestEntry, 0, len(batch)+len(rest))
+	unsent = append(unsent, batch...)
+	return append(unsent, rest...)
+}
+
+// pooledZlibCompress is zlibCompress, with a writer and buffer from the pool
+func pooledZlibCompress(data []byte) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("No data to compress.  'nil' value passed as data")
+	}
+	b := compressBufPool.Get().(*bytes.Buffer)
+	defer compressBufPool.Put(b)
+	b.Reset()
+	zw := zlibWriterPool.Get().(*zlib.Writer)
+	defer zlibWriterPool.Put(zw)
+	zw.Reset(b)
+	return pooledCompress(zw, b, data)
+}
+
+// pooledGzipCompress is gzipCompress, with a writer and buffer from the pool
+func pooledGzipCompress(data []byte) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("No data to compress.  'nil' value passed as data")
+	}
+	b := compressBufPool.Get().(*bytes.Buffer)
+	defer compressBufPool.Put(b)
+	b.Reset()
+	zw := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(zw)
+	zw.Reset(b)
+	return pooledCompress(zw, b, data)
+}
+
+type compressWriter interface {
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+func pooledCompress(zw compressWriter, b *bytes.Buffer, data []byte) ([]byte, error) {
+	if _, err := zw.Write(data); err != nil {
+		return data, err
+	}
+	if err := zw.Close(); err != nil {
+		return data, err
+	}
+	// the buffer goes back to the pool, the record keeps a copy
+	return append([]byte(nil), b.Bytes()...), nil
+}
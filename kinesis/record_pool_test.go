@@ -0,0 +1,187 @@
+package kinesis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingClient records the data of every record it accepts, failing the first attempt
+// of the records fail picks with a throughput error
+type recordingClient struct {
+	mutex     sync.Mutex
+	delivered map[string]int
+	attempts  map[string]int
+	fail      func(data string) bool
+}
+
+func newRecordingClient(fail func(data string) bool) *recordingClient {
+	return &recordingClient{
+		delivered: make(map[string]int),
+		attempts:  make(map[string]int),
+		fail:      fail,
+	}
+}
+
+func (c *recordingClient) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, record := range input.Records {
+		data := string(record.Data)
+		c.attempts[data]++
+		if c.fail != nil && c.fail(data) && c.attempts[data] == 1 {
+			*output.FailedRecordCount++
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+				ErrorMessage: aws.String("Rate exceeded"),
+			})
+			continue
+		}
+		c.delivered[data]++
+		output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{})
+	}
+	return output, nil
+}
+
+func TestPooledCompressRoundTrip(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				data := []byte(fmt.Sprintf("goroutine %d record %d %s", i, j, bytes.Repeat([]byte("x"), j)))
+
+				compressed, err := pooledGzipCompress(data)
+				require.NoError(t, err)
+				reader, err := gzip.NewReader(bytes.NewReader(compressed))
+				require.NoError(t, err)
+				decompressed, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, data, decompressed)
+
+				compressed, err = pooledZlibCompress(data)
+				require.NoError(t, err)
+				zreader, err := zlib.NewReader(bytes.NewReader(compressed))
+				require.NoError(t, err)
+				decompressed, err = ioutil.ReadAll(zreader)
+				require.NoError(t, err)
+				assert.Equal(t, data, decompressed)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err := pooledGzipCompress(nil)
+	assert.Error(t, err)
+}
+
+func TestRecordPoolConcurrentFlushes(t *testing.T) {
+	retrySleep = func(time.Duration) {}
+	defer func() { retrySleep = time.Sleep }()
+
+	// every tenth record is throttled once, so batches are left with records to retry
+	client := newRecordingClient(func(data string) bool { return strings.HasSuffix(data, "3") })
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.client = client
+	outputPlugin.recordPool = true
+	outputPlugin.Concurrency = 8
+
+	const flushes = 16
+	const recordsPerFlush = 2*maximumRecordsPerPut + 17
+	var wg sync.WaitGroup
+	for i := 0; i < flushes; i++ {
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, recordsPerFlush)
+		for j := 0; j < recordsPerFlush; j++ {
+			records = append(records, &kinesis.PutRecordsRequestEntry{
+				Data:         []byte(fmt.Sprintf("flush %d record %d", i, j)),
+				PartitionKey: aws.String(fmt.Sprintf("flush-%d", i)),
+			})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputPlugin.FlushWithRetries(len(records), records)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, client.delivered, flushes*recordsPerFlush)
+	retried := 0
+	for _, attempts := range client.attempts {
+		if attempts > 1 {
+			retried++
+		}
+	}
+	assert.Equal(t, flushes*(recordsPerFlush/10+1), retried, "Expected the throttled records to be retried")
+	for i := 0; i < flushes; i++ {
+		for j := 0; j < recordsPerFlush; j++ {
+			data := fmt.Sprintf("flush %d record %d", i, j)
+			assert.Equal(t, 1, client.delivered[data], "Expected %q to be delivered once", data)
+		}
+	}
+}
+
+func TestRecordPoolLeavesUnsentRecords(t *testing.T) {
+	client := newRecordingClient(func(data string) bool { return data == "1" })
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.client = client
+	outputPlugin.recordPool = true
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("0"), PartitionKey: aws.String("key")},
+		{Data: []byte("1"), PartitionKey: aws.String("key")},
+	}
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+	require.Len(t, records, 1)
+
+	// flushes reusing the pooled batch must not change the records left unsent
+	for i := 0; i < 10; i++ {
+		other := []*kinesis.PutRecordsRequestEntry{{Data: []byte(fmt.Sprintf("other %d", i)), PartitionKey: aws.String("key")}}
+		assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&other))
+	}
+	assert.Equal(t, "1", string(records[0].Data))
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Equal(t, 1, client.delivered["1"])
+}
+
+func benchmarkFlush(b *testing.B, recordPool bool) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.client = newRecordingClient(nil)
+	outputPlugin.recordPool = recordPool
+	outputPlugin.compression = CompressionGzip
+	outputPlugin.logKey = "log"
+
+	timeStamp := time.Now()
+	log := []byte(`{"level":"info","message":"request handled","path":"/api/v1/items","status":200,"duration_ms":12}`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
+		for j := 0; j < maximumRecordsPerPut; j++ {
+			outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": log}, &timeStamp)
+		}
+		if retCode := outputPlugin.Flush(&records); retCode != fluentbit.FLB_OK {
+			b.Fatalf("flush returned %d", retCode)
+		}
+	}
+}
+
+func BenchmarkFlush(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkFlush(b, false) })
+	b.Run("record_pool", func(b *testing.B) { benchmarkFlush(b, true) })
+}
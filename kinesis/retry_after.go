@@ -0,0 +1,117 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retrySleep waits between the attempts of FlushWithRetries, it is replaced in tests
+var retrySleep = time.Sleep
+
+// retryAfterError is a throttling error which carries the delay the service asked
+// for, or the SDK suggested, before the request is retried
+type retryAfterError struct {
+	err   awserr.Error
+	delay time.Duration
+}
+
+func (e *retryAfterError) Error() string   { return e.err.Error() }
+func (e *retryAfterError) Code() string    { return e.err.Code() }
+func (e *retryAfterError) Message() string { return e.err.Message() }
+func (e *retryAfterError) OrigErr() error  { return e.err.OrigErr() }
+
+// RetryAfter returns the delay to wait before retrying
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.delay
+}
+
+// captureRetryAfterHandler records the retry delay of throttled requests on their error,
+// so FlushWithRetries can wait as long as the service wants rather than a fixed backoff
+var captureRetryAfterHandler = request.NamedHandler{
+	Name: "kinesis.CaptureRetryAfter",
+	Fn:   captureRetryAfter,
+}
+
+func captureRetryAfter(r *request.Request) {
+	aerr, ok := r.Error.(awserr.Error)
+	if !ok {
+		return
+	}
+	throttled := request.IsErrorThrottle(r.Error)
+	if r.HTTPResponse != nil {
+		throttled = throttled || r.HTTPResponse.StatusCode == http.StatusTooManyRequests ||
+			r.HTTPResponse.StatusCode == http.StatusServiceUnavailable
+	}
+	if !throttled {
+		return
+	}
+
+	var delay time.Duration
+	if r.HTTPResponse != nil {
+		delay = parseRetryAfter(r.HTTPResponse.Header.Get("Retry-After"), time.Now())
+	}
+	if delay <= 0 {
+		// the delay the SDK's retryer chose for its last retry
+		delay = r.RetryDelay
+	}
+	if delay > 0 {
+		r.Error = &retryAfterError{err: aerr, delay: delay}
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retryAfterHint returns the delay a throttling error asked for, or 0 if it has none
+func retryAfterHint(err error) time.Duration {
+	if hinted, ok := err.(interface{ RetryAfter() time.Duration }); ok {
+		return hinted.RetryAfter()
+	}
+	return 0
+}
+
+// retryDelay returns how long FlushWithRetries waits before its next attempt. The
+// delay the last error asked for is used if there is one, and otherwise the backoff
+// grows with the number of retries in progress. Both are capped by retry_max_delay.
+func (outputPlugin *OutputPlugin) retryDelay(currentRetries uint32, hint time.Duration) time.Duration {
+	delay := hint
+	if delay <= 0 {
+		if currentRetries > uint32(outputPlugin.concurrencyRetryLimit) {
+			delay = time.Duration((1<<uint32(outputPlugin.concurrencyRetryLimit))*100) * time.Millisecond
+		} else {
+			delay = time.Duration((1<<currentRetries)*100) * time.Millisecond
+		}
+	}
+	if outputPlugin.retryMaxDelay > 0 && delay > outputPlugin.retryMaxDelay {
+		delay = outputPlugin.retryMaxDelay
+	}
+	return delay
+}
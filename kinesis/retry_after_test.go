@@ -0,0 +1,98 @@
+package kinesis
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureRetryAfter(t *testing.T) {
+	throttled := awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		header     string
+		retryDelay time.Duration
+		want       time.Duration
+	}{
+		{"retry after seconds", throttled, http.StatusBadRequest, "3", 200 * time.Millisecond, 3 * time.Second},
+		{"sdk retry delay", throttled, http.StatusBadRequest, "", 200 * time.Millisecond, 200 * time.Millisecond},
+		{"service unavailable", awserr.New("ServiceUnavailableException", "Slow down", nil), http.StatusServiceUnavailable, "1", 0, time.Second},
+		{"no hint", throttled, http.StatusBadRequest, "", 0, 0},
+		{"not throttled", awserr.New(kinesis.ErrCodeResourceNotFoundException, "Stream not found", nil), http.StatusBadRequest, "3", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &request.Request{
+				Error:        test.err,
+				HTTPResponse: &http.Response{StatusCode: test.status, Header: http.Header{}},
+				RetryDelay:   test.retryDelay,
+			}
+			if test.header != "" {
+				r.HTTPResponse.Header.Set("Retry-After", test.header)
+			}
+			captureRetryAfter(r)
+
+			assert.Equal(t, test.want, retryAfterHint(r.Error))
+			aerr, ok := r.Error.(awserr.Error)
+			if assert.True(t, ok, "Expected the error to still be an awserr.Error") {
+				assert.Equal(t, test.err.(awserr.Error).Code(), aerr.Code())
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 40*time.Second, parseRetryAfter("Tue, 14 Nov 2023 22:14:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestFlushWithRetriesHonorsRetryAfter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	throttled := awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, &retryAfterError{err: throttled, delay: 2 * time.Second}),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, &retryAfterError{err: throttled, delay: time.Minute}),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("connection reset")),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+			FailedRecordCount: aws.Int64(0),
+		}, nil),
+	)
+
+	var delays []time.Duration
+	retrySleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { retrySleep = time.Sleep }()
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.concurrencyRetryLimit = 5
+	outputPlugin.retryMaxDelay = 10 * time.Second
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("data"), PartitionKey: aws.String("key")},
+	}
+	outputPlugin.FlushWithRetries(len(records), records)
+
+	assert.Equal(t, []time.Duration{
+		2 * time.Second,
+		// capped by retry_max_delay
+		10 * time.Second,
+		// errors without a hint back off by the number of retries in progress
+		800 * time.Millisecond,
+	}, delays)
+}
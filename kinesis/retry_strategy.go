@@ -0,0 +1,134 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// RetryStrategy is how records which failed with an error code are retried
+type RetryStrategy string
+
+const (
+	// RetryStrategyBackoff leaves the records to be retried with backoff, by Fluent Bit or
+	// FlushWithRetries, which is what happens to error codes without a strategy
+	RetryStrategyBackoff RetryStrategy = "backoff"
+	// RetryStrategyFixed resends the records within the flush after a fixed delay
+	RetryStrategyFixed RetryStrategy = "fixed"
+	// RetryStrategyImmediate resends the records within the flush right away
+	RetryStrategyImmediate RetryStrategy = "immediate"
+	// RetryStrategyDrop drops the records, sending them to the dlq_stream if one is set
+	RetryStrategyDrop RetryStrategy = "drop"
+
+	// DefaultErrorRetryFixedDelay is the delay of the fixed strategy
+	DefaultErrorRetryFixedDelay = 100 * time.Millisecond
+	// DefaultErrorRetryAttempts is how often the fixed and immediate strategies resend
+	// records within a flush before they are left to be retried with backoff
+	DefaultErrorRetryAttempts = 3
+)
+
+// errorRetryStrategies picks the retry strategy for the error code a request or record failed with
+type errorRetryStrategies struct {
+	strategies map[string]RetryStrategy
+	fixedDelay time.Duration
+	attempts   int
+}
+
+func newErrorRetryStrategies(strategies map[string]RetryStrategy, fixedDelay time.Duration, attempts int) *errorRetryStrategies {
+	if fixedDelay == 0 {
+		fixedDelay = DefaultErrorRetryFixedDelay
+	}
+	if attempts == 0 {
+		attempts = DefaultErrorRetryAttempts
+	}
+	return &errorRetryStrategies{
+		strategies: strategies,
+		fixedDelay: fixedDelay,
+		attempts:   attempts,
+	}
+}
+
+func (s *errorRetryStrategies) forCode(code string) RetryStrategy {
+	if strategy, ok := s.strategies[code]; ok {
+		return strategy
+	}
+	return RetryStrategyBackoff
+}
+
+// slowest returns the strategy for the failed records of a response which waits the
+// longest, so records are only resent within the flush if all of them may be
+func (s *errorRetryStrategies) slowest(response *kinesis.PutRecordsOutput) RetryStrategy {
+	var slowest RetryStrategy
+	for _, record := range response.Records {
+		if record.ErrorCode == nil {
+			continue
+		}
+		strategy := s.forCode(aws.StringValue(record.ErrorCode))
+		if strategy == RetryStrategyBackoff {
+			return RetryStrategyBackoff
+		}
+		if slowest != RetryStrategyFixed {
+			slowest = strategy
+		}
+	}
+	return slowest
+}
+
+// dropFailedRecords removes the records which failed with an error code retried with the
+// drop strategy, and returns the response for the records left
+func (outputPlugin *OutputPlugin) dropFailedRecords(records *[]*kinesis.PutRecordsRequestEntry, dataLength *int, response *kinesis.PutRecordsOutput) *kinesis.PutRecordsOutput {
+	kept := make([]*kinesis.PutRecordsRequestEntry, 0, len(*records))
+	filtered := &kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(0),
+		Records:           make([]*kinesis.PutRecordsResultEntry, 0, len(response.Records)),
+	}
+	dropped := make(map[string][]*kinesis.PutRecordsRequestEntry)
+	for i, result := range response.Records {
+		code := aws.StringValue(result.ErrorCode)
+		if result.ErrorCode != nil && outputPlugin.errorRetry.forCode(code) == RetryStrategyDrop {
+			dropped[code] = append(dropped[code], (*records)[i])
+			continue
+		}
+		if result.ErrorCode != nil {
+			*filtered.FailedRecordCount++
+		}
+		kept = append(kept, (*records)[i])
+		filtered.Records = append(filtered.Records, result)
+	}
+	if len(dropped) == 0 {
+		return response
+	}
+	for code, records := range dropped {
+		outputPlugin.dropRecords(records, code)
+	}
+	*records = append((*records)[:0], kept...)
+	*dataLength = 0
+	for _, record := range *records {
+		*dataLength += len(record.Data) + len(aws.StringValue(record.PartitionKey))
+	}
+	return filtered
+}
+
+// dropRecords drops records which failed with an error code retried with the drop strategy
+func (outputPlugin *OutputPlugin) dropRecords(records []*kinesis.PutRecordsRequestEntry, code string) {
+	outputPlugin.DiscardRecords(records)
+	if outputPlugin.DeadLetter(records, fmt.Errorf("failed with %s", code)) {
+		return
+	}
+	outputPlugin.sampledErrorf("Dropping %d records which failed with %s\n", len(records), code)
+}
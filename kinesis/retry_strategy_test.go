@@ -0,0 +1,124 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetryStrategyPlugin(t *testing.T, client *mock_kinesis.MockPutRecordsClient) (*OutputPlugin, *[]time.Duration) {
+	outputPlugin, _ := newMockOutputPlugin(client, false)
+	outputPlugin.errorRetry = newErrorRetryStrategies(map[string]RetryStrategy{
+		kinesis.ErrCodeProvisionedThroughputExceededException: RetryStrategyBackoff,
+		"InternalFailure":                       RetryStrategyFixed,
+		kinesis.ErrCodeInternalFailureException: RetryStrategyImmediate,
+		"ValidationException":                   RetryStrategyDrop,
+	}, 50*time.Millisecond, 2)
+
+	var sleeps []time.Duration
+	retrySleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	t.Cleanup(func() { retrySleep = time.Sleep })
+	return outputPlugin, &sleeps
+}
+
+func failedRecordsOutput(codes ...string) *kinesis.PutRecordsOutput {
+	output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, code := range codes {
+		if code == "" {
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{SequenceNumber: aws.String("1")})
+			continue
+		}
+		*output.FailedRecordCount++
+		output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+			ErrorCode:    aws.String(code),
+			ErrorMessage: aws.String(code),
+		})
+	}
+	return output
+}
+
+func TestErrorRetryStrategyBackoffForThrottling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)).Times(1)
+	outputPlugin, sleeps := newRetryStrategyPlugin(t, mockKinesis)
+
+	records := newTestEntries("first", "second")
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records), "Expected throttled records to be left for a retry with backoff")
+	assert.Len(t, records, 2)
+	assert.Empty(t, *sleeps)
+}
+
+func TestErrorRetryStrategyFixedForInternalFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(failedRecordsOutput("", "InternalFailure"), nil),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			assert.Len(t, input.Records, 1, "Expected only the failed record to be resent")
+			return failedRecordsOutput(""), nil
+		}),
+	)
+	outputPlugin, sleeps := newRetryStrategyPlugin(t, mockKinesis)
+
+	records := newTestEntries("first", "second")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records))
+	assert.Empty(t, records)
+	assert.Equal(t, []time.Duration{50 * time.Millisecond}, *sleeps, "Expected the fixed delay before resending")
+}
+
+func TestErrorRetryStrategyImmediateForInternalFailureException(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	internal := awserr.New(kinesis.ErrCodeInternalFailureException, "Internal service failure", nil)
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	// the first attempt and two resends, then the records are left for a retry with backoff
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, internal).Times(3)
+	outputPlugin, sleeps := newRetryStrategyPlugin(t, mockKinesis)
+
+	records := newTestEntries("first", "second")
+	assert.Equal(t, fluentbit.FLB_RETRY, outputPlugin.Flush(&records))
+	assert.Len(t, records, 2)
+	assert.Empty(t, *sleeps, "Expected no delay before resending")
+}
+
+func TestErrorRetryStrategyDropForValidationErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, awserr.New("ValidationException", "1 validation error detected", nil)),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(failedRecordsOutput("ValidationException", ""), nil),
+	)
+	outputPlugin, sleeps := newRetryStrategyPlugin(t, mockKinesis)
+
+	records := newTestEntries("first", "second")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected the records of a failed request to be dropped")
+	assert.Empty(t, records)
+
+	records = newTestEntries("first", "second")
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.Flush(&records), "Expected the failed record to be dropped")
+	assert.Empty(t, records)
+	assert.Empty(t, *sleeps)
+}
+
+func TestErrorRetryStrategySlowestWins(t *testing.T) {
+	strategies := newErrorRetryStrategies(map[string]RetryStrategy{
+		"InternalFailure":        RetryStrategyImmediate,
+		"KMSThrottlingException": RetryStrategyFixed,
+	}, 0, 0)
+	assert.Equal(t, RetryStrategyImmediate, strategies.slowest(failedRecordsOutput("", "InternalFailure")))
+	assert.Equal(t, RetryStrategyFixed, strategies.slowest(failedRecordsOutput("KMSThrottlingException", "InternalFailure")))
+	assert.Equal(t, RetryStrategyBackoff, strategies.slowest(failedRecordsOutput("InternalFailure", kinesis.ErrCodeProvisionedThroughputExceededException)), "Expected codes without a strategy to be retried with backoff")
+	assert.Equal(t, DefaultErrorRetryFixedDelay, strategies.fixedDelay)
+	assert.Equal(t, DefaultErrorRetryAttempts, strategies.attempts)
+}
@@ -0,0 +1,51 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+)
+
+// routeFlag forwards only the records whose flag field is set to the flag value, so
+// upstream can move records to the stream gradually, by setting the field on more of them
+type routeFlag struct {
+	condition *recordCondition
+	dropped   *metrics.Counter
+}
+
+// newRouteFlag returns the route flag for a key, where nested keys are separated by '->'
+// as in partition_key, and a value, which the field is compared with as a string
+func newRouteFlag(pluginID int, key, value string) *routeFlag {
+	return &routeFlag{
+		condition: &recordCondition{
+			keys:  strings.Split(key, "->"),
+			value: value,
+		},
+		dropped: metrics.NewCounter("kinesis_route_flag_dropped_total", "Records dropped for not carrying the route_flag_value.",
+			metrics.Labels{"plugin_id": strconv.Itoa(pluginID)}),
+	}
+}
+
+// notRouted counts and drops a record whose flag field doesn't match route_flag_value.
+// It returns false if the record is forwarded.
+func (outputPlugin *OutputPlugin) notRouted(record map[interface{}]interface{}) bool {
+	if outputPlugin.routeFlag == nil || outputPlugin.routeFlag.condition.matches(record) {
+		return false
+	}
+	outputPlugin.routeFlag.dropped.Inc()
+	return true
+}
@@ -0,0 +1,49 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteFlag(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.routeFlag = newRouteFlag(0, "migrate", "true")
+	before := outputPlugin.routeFlag.dropped.Value()
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 5)
+	timeStamp := time.Now()
+	for _, flag := range []interface{}{true, []byte("true"), false, []byte("no"), nil} {
+		record := map[interface{}]interface{}{"log": []byte("request")}
+		if flag != nil {
+			record["migrate"] = flag
+		}
+		retCode := outputPlugin.AddRecord(&records, record, &timeStamp)
+		assert.Equal(t, fluentbit.FLB_OK, retCode, "Expected the batch to continue")
+	}
+
+	if assert.Len(t, records, 2, "Expected only the records carrying the flag to be forwarded") {
+		assert.Equal(t, `{"log":"request","migrate":true}`, string(records[0].Data))
+		assert.Equal(t, `{"log":"request","migrate":"true"}`, string(records[1].Data))
+	}
+	assert.Equal(t, before+3, outputPlugin.routeFlag.dropped.Value())
+}
+
+func TestRouteFlagNestedKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.routeFlag = newRouteFlag(1, "kubernetes->labels->migrate", "1")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Now()
+	for _, value := range []interface{}{int64(1), int64(0)} {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"kubernetes": map[interface{}]interface{}{
+				"labels": map[interface{}]interface{}{"migrate": value},
+			},
+		}, &timeStamp)
+	}
+	assert.Len(t, records, 1)
+}
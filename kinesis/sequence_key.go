@@ -0,0 +1,24 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"sync/atomic"
+)
+
+// nextSequence returns the next value of the instance's sequence, starting from 1.
+// The sequence is kept in memory, so it starts again from 1 when Fluent Bit restarts.
+func (outputPlugin *OutputPlugin) nextSequence() uint64 {
+	return atomic.AddUint64(outputPlugin.sequence, 1)
+}
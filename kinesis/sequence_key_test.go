@@ -0,0 +1,66 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.sequenceKey = "seq"
+	outputPlugin.sequence = new(uint64)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 4)
+	timeStamp := time.Now()
+	for _, record := range []map[interface{}]interface{}{
+		{"log": []byte("first")},
+		{"log": []byte("second")},
+		// dropped, since NaN can't be marshaled
+		{"log": math.NaN()},
+		{"log": []byte("fourth")},
+	} {
+		outputPlugin.AddRecord(&records, record, &timeStamp)
+	}
+
+	var sequences []uint64
+	for _, record := range records {
+		var decoded struct {
+			Seq uint64 `json:"seq"`
+		}
+		assert.NoError(t, json.Unmarshal(record.Data, &decoded))
+		sequences = append(sequences, decoded.Seq)
+	}
+	assert.Equal(t, []uint64{1, 2, 4}, sequences, "Expected increasing sequences, with a gap for the dropped record")
+}
+
+func TestSequenceKeyConcurrent(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.sequence = new(uint64)
+
+	var mutex sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := uint64(0)
+			for j := 0; j < 1000; j++ {
+				sequence := outputPlugin.nextSequence()
+				assert.Greater(t, sequence, last, "Expected each goroutine to see increasing sequences")
+				last = sequence
+				mutex.Lock()
+				seen[sequence] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, 4000, "Expected every sequence to be unique")
+}
@@ -0,0 +1,133 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/sirupsen/logrus"
+)
+
+// shardLoadCount is the load of a shard over an interval
+type shardLoadCount struct {
+	shard   string
+	records uint64
+	bytes   uint64
+}
+
+// shardLoad counts how many records and bytes each shard of the stream received, by the
+// shard PutRecords reports each accepted record was written to
+type shardLoad struct {
+	mutex  sync.Mutex
+	counts map[string]*shardLoadCount
+	// records accepted without the response naming their shard
+	unattributed uint64
+}
+
+func newShardLoad() *shardLoad {
+	return &shardLoad{
+		counts: make(map[string]*shardLoadCount),
+	}
+}
+
+// add counts the records PutRecords accepted against their shards
+func (l *shardLoad) add(records []*kinesis.PutRecordsRequestEntry, response *kinesis.PutRecordsOutput) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, record := range records {
+		if i >= len(response.Records) {
+			l.unattributed++
+			continue
+		}
+		result := response.Records[i]
+		if result.ErrorCode != nil {
+			continue
+		}
+		shard := aws.StringValue(result.ShardId)
+		if shard == "" {
+			l.unattributed++
+			continue
+		}
+		count, ok := l.counts[shard]
+		if !ok {
+			count = &shardLoadCount{shard: shard}
+			l.counts[shard] = count
+		}
+		count.records++
+		count.bytes += uint64(len(record.Data) + len(aws.StringValue(record.PartitionKey)))
+	}
+}
+
+// rollup returns the load of each shard in descending order of bytes, along with the
+// records which could not be attributed, and resets the counts for the next interval
+func (l *shardLoad) rollup() ([]shardLoadCount, uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	counts := make([]shardLoadCount, 0, len(l.counts))
+	for _, count := range l.counts {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].bytes == counts[j].bytes {
+			return counts[i].shard < counts[j].shard
+		}
+		return counts[i].bytes > counts[j].bytes
+	})
+	unattributed := l.unattributed
+	l.counts = make(map[string]*shardLoadCount)
+	l.unattributed = 0
+	return counts, unattributed
+}
+
+// logPeriodically logs the load of each shard every interval, until the returned channel is closed
+func (l *shardLoad) logPeriodically(interval time.Duration, logger *logrus.Entry) chan struct{} {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				counts, unattributed := l.rollup()
+				if len(counts) > 0 || unattributed > 0 {
+					logger.Infof("Shard load over the last %s: %s\n", interval, formatShardLoad(counts, unattributed, interval))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// formatShardLoad renders the load of each shard per second, which is what the shard limits are given in
+func formatShardLoad(counts []shardLoadCount, unattributed uint64, interval time.Duration) string {
+	seconds := interval.Seconds()
+	parts := make([]string, 0, len(counts))
+	for _, c := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d records (%.1f/s), %d bytes (%.1f KiB/s)", c.shard, c.records, float64(c.records)/seconds, c.bytes, float64(c.bytes)/1024/seconds))
+	}
+	formatted := fmt.Sprintf("[%s]", strings.Join(parts, ", "))
+	if unattributed > 0 {
+		formatted += fmt.Sprintf(", %d records not attributed to a shard", unattributed)
+	}
+	return formatted
+}
@@ -0,0 +1,61 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+)
+
+func TestPutRecordsShardLoad(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.shardLoad = newShardLoad()
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{PartitionKey: aws.String("a"), Data: []byte("0123456789")},
+		{PartitionKey: aws.String("b"), Data: []byte("0123")},
+		{PartitionKey: aws.String("d"), Data: []byte("0123")},
+		{PartitionKey: aws.String("e"), Data: []byte("0123")},
+		{PartitionKey: aws.String("f"), Data: []byte("0123")},
+	}
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+		FailedRecordCount: aws.Int64(1),
+		Records: []*kinesis.PutRecordsResultEntry{
+			{ShardId: aws.String("shardId-000000000000"), SequenceNumber: aws.String("1")},
+			{ShardId: aws.String("shardId-000000000002"), SequenceNumber: aws.String("2")},
+			{ErrorCode: aws.String(kinesis.ErrCodeProvisionedThroughputExceededException)},
+			{ShardId: aws.String("shardId-000000000002"), SequenceNumber: aws.String("3")},
+			// a response which doesn't name the shard
+			{SequenceNumber: aws.String("4")},
+		},
+	}, nil)
+	dataLength := 0
+	_, _, err := outputPlugin.putBatch(outputPlugin.stream, &records, &dataLength)
+	require.NoError(t, err)
+
+	counts, unattributed := outputPlugin.shardLoad.rollup()
+	assert.Equal(t, uint64(1), unattributed)
+	assert.Equal(t, []shardLoadCount{
+		// the failed record is not counted; bytes include the partition key
+		{shard: "shardId-000000000000", records: 1, bytes: 11},
+		{shard: "shardId-000000000002", records: 2, bytes: 10},
+	}, counts)
+
+	counts, unattributed = outputPlugin.shardLoad.rollup()
+	assert.Empty(t, counts, "Expected rollup to reset the counts")
+	assert.Zero(t, unattributed)
+}
+
+func TestFormatShardLoad(t *testing.T) {
+	formatted := formatShardLoad([]shardLoadCount{{shard: "shardId-000000000000", records: 20, bytes: 20480}}, 3, 10*time.Second)
+	assert.Equal(t, "[shardId-000000000000=20 records (2.0/s), 20480 bytes (2.0 KiB/s)], 3 records not attributed to a shard", formatted)
+}
@@ -0,0 +1,153 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ShutdownDump indicates where records still in flight when Close times out are written
+type ShutdownDump string
+
+const (
+	// ShutdownDumpNone drops the records
+	ShutdownDumpNone ShutdownDump = "none"
+	// ShutdownDumpStderr writes the records to stderr, to be captured by the container log
+	ShutdownDumpStderr ShutdownDump = "stderr"
+	// ShutdownDumpFile appends the records to a file
+	ShutdownDumpFile ShutdownDump = "file"
+)
+
+// inflightRecords tracks the records held by concurrent flushes and workers, so
+// they can be dumped if they are still unsent when Close gives up waiting
+type inflightRecords struct {
+	mutex   sync.Mutex
+	next    int
+	batches map[int][]*kinesis.PutRecordsRequestEntry
+}
+
+func newInflightRecords() *inflightRecords {
+	return &inflightRecords{
+		batches: make(map[int][]*kinesis.PutRecordsRequestEntry),
+	}
+}
+
+// add starts tracking a batch, returning its id
+func (r *inflightRecords) add(records []*kinesis.PutRecordsRequestEntry) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	id := r.next
+	r.next++
+	r.batches[id] = records
+	return id
+}
+
+// update replaces a batch with the records of it which are still unsent
+func (r *inflightRecords) update(id int, records []*kinesis.PutRecordsRequestEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.batches[id]; ok {
+		r.batches[id] = records
+	}
+}
+
+func (r *inflightRecords) remove(id int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.batches, id)
+}
+
+// take stops tracking every batch and returns their records
+func (r *inflightRecords) take() []*kinesis.PutRecordsRequestEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var records []*kinesis.PutRecordsRequestEntry
+	for _, batch := range r.batches {
+		records = append(records, batch...)
+	}
+	r.batches = make(map[int][]*kinesis.PutRecordsRequestEntry)
+	return records
+}
+
+// nopWriteCloser keeps stderr open once the dump is written
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newShutdownDumpSink returns a function opening the writer records are dumped to
+func newShutdownDumpSink(dump ShutdownDump, path string) func() (io.WriteCloser, error) {
+	switch dump {
+	case ShutdownDumpStderr:
+		return func() (io.WriteCloser, error) {
+			return nopWriteCloser{os.Stderr}, nil
+		}
+	case ShutdownDumpFile:
+		return func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		}
+	default:
+		return nil
+	}
+}
+
+// dumpInflight writes the records still held by in-flight flushes, and the queued records
+// no flush will send, to the shutdown dump sink, one JSON line per record in the dead
+// letter format. The flushes keep running, so a record which is sent after all will also
+// have been dumped.
+func (outputPlugin *OutputPlugin) dumpInflight(reason error, queued []*kinesis.PutRecordsRequestEntry) {
+	records := append(outputPlugin.inflight.take(), queued...)
+	if len(records) == 0 {
+		return
+	}
+
+	sink, err := outputPlugin.shutdownDumpSink()
+	if err != nil {
+		outputPlugin.logger.Errorf("Failed to open shutdown dump, dropping (%d) unsent records: %v\n", len(records), err)
+		return
+	}
+	defer sink.Close()
+
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	writer := bufio.NewWriter(sink)
+	for _, record := range records {
+		data, err := json.Marshal(&deadLetter{
+			OriginalStream: outputPlugin.stream,
+			PartitionKey:   aws.StringValue(record.PartitionKey),
+			Error:          reason.Error(),
+			Data:           record.Data,
+		})
+		if err == nil {
+			_, err = fmt.Fprintf(writer, "%s\n", data)
+		}
+		if err != nil {
+			outputPlugin.logger.Errorf("Failed to write shutdown dump, dropping unsent records: %v\n", err)
+			return
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		outputPlugin.logger.Errorf("Failed to write shutdown dump, dropping unsent records: %v\n", err)
+		return
+	}
+	outputPlugin.logger.Warnf("Wrote (%d) unsent records to the shutdown dump\n", len(records))
+}
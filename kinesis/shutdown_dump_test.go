@@ -0,0 +1,162 @@
+package kinesis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownDumpAfterDrainTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { closeTimeout = timeout }(closeTimeout)
+	closeTimeout = 50 * time.Millisecond
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			// Kinesis never answers before the drain times out
+			close(started)
+			<-unblock
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	var dump bytes.Buffer
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.Concurrency = 1
+	outputPlugin.inflight = newInflightRecords()
+	outputPlugin.shutdownDumpSink = func() (io.WriteCloser, error) {
+		return nopWriteCloser{&dump}, nil
+	}
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte(`{"log":"first"}`), PartitionKey: aws.String("a")},
+		{Data: []byte(`{"log":"second"}`), PartitionKey: aws.String("b")},
+	}
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.FlushConcurrent(len(records), records))
+	<-started
+	assert.Error(t, outputPlugin.Close(), "Expected the drain to time out")
+	close(unblock)
+
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	dumped := make(map[string]string)
+	for _, line := range lines {
+		var letter deadLetter
+		assert.NoError(t, json.Unmarshal([]byte(line), &letter))
+		assert.Equal(t, "stream", letter.OriginalStream)
+		assert.Contains(t, letter.Error, "timed out")
+		dumped[letter.PartitionKey] = string(letter.Data)
+	}
+	assert.Equal(t, map[string]string{"a": `{"log":"first"}`, "b": `{"log":"second"}`}, dumped)
+}
+
+func TestShutdownDumpIncludesQueuedWorkerBatches(t *testing.T) {
+	defer func(timeout time.Duration) { closeTimeout = timeout }(closeTimeout)
+	closeTimeout = 50 * time.Millisecond
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			// the worker is stuck on its first batch, the others stay queued
+			close(started)
+			<-unblock
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	var dump bytes.Buffer
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.inflight = newInflightRecords()
+	outputPlugin.shutdownDumpSink = func() (io.WriteCloser, error) {
+		return nopWriteCloser{&dump}, nil
+	}
+	hash, _ := newKeyHashFunc(WorkerHashFNV)
+	outputPlugin.workers = newFlushWorkers(1, hash, func(worker int, records []*kinesis.PutRecordsRequestEntry) {
+		outputPlugin.FlushWithRetries(len(records), records)
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		records := []*kinesis.PutRecordsRequestEntry{{Data: []byte(key), PartitionKey: aws.String(key)}}
+		assert.Equal(t, fluentbit.FLB_OK, outputPlugin.DispatchToWorkers(1, records))
+		if key == "a" {
+			<-started
+		}
+	}
+
+	closed := make(chan error)
+	go func() { closed <- outputPlugin.Close() }()
+	select {
+	case err := <-closed:
+		assert.Error(t, err, "Expected the drain to time out")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to give up once the close timeout passed")
+	}
+	close(unblock)
+
+	dumped := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(dump.String()), "\n") {
+		var letter deadLetter
+		assert.NoError(t, json.Unmarshal([]byte(line), &letter))
+		dumped[letter.PartitionKey] = string(letter.Data)
+	}
+	assert.Equal(t, map[string]string{"a": "a", "b": "b", "c": "c"}, dumped)
+}
+
+func TestShutdownDumpFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unsent.jsonl")
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.inflight = newInflightRecords()
+	outputPlugin.shutdownDumpSink = newShutdownDumpSink(ShutdownDumpFile, path)
+
+	outputPlugin.inflight.add([]*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("first"), PartitionKey: aws.String("a")},
+	})
+	id := outputPlugin.inflight.add([]*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("sent"), PartitionKey: aws.String("b")},
+	})
+	// the batch was sent, so it is no longer in flight
+	outputPlugin.inflight.update(id, nil)
+
+	outputPlugin.dumpInflight(errors.New("shutdown"), nil)
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var letters []deadLetter
+	for scanner.Scan() {
+		var letter deadLetter
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "first", string(letters[0].Data))
+		assert.Equal(t, "shutdown", letters[0].Error)
+	}
+}
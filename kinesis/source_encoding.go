@@ -0,0 +1,158 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// SourceEncoding is the encoding of the string values of the records a plugin receives
+type SourceEncoding string
+
+const (
+	// SourceEncodingUTF8 keeps values as they are, apart from their invalid sequences
+	SourceEncodingUTF8 SourceEncoding = "utf-8"
+	// SourceEncodingLatin1 transcodes values from ISO 8859-1
+	SourceEncodingLatin1 SourceEncoding = "latin1"
+	// SourceEncodingWindows1252 transcodes values from Windows code page 1252
+	SourceEncodingWindows1252 SourceEncoding = "windows-1252"
+	// SourceEncodingUTF16LE transcodes values from little endian UTF-16, unless they start with a big endian BOM
+	SourceEncodingUTF16LE SourceEncoding = "utf-16le"
+	// SourceEncodingUTF16BE transcodes values from big endian UTF-16, unless they start with a little endian BOM
+	SourceEncodingUTF16BE SourceEncoding = "utf-16be"
+	// SourceEncodingAuto detects the encoding of each value, see detectEncoding
+	SourceEncodingAuto SourceEncoding = "auto"
+)
+
+// InvalidEncoding is what is done with sequences which are invalid in the source encoding
+type InvalidEncoding string
+
+const (
+	// InvalidEncodingReplace replaces invalid sequences with the Unicode replacement character
+	InvalidEncodingReplace InvalidEncoding = "replace"
+	// InvalidEncodingDrop removes invalid sequences
+	InvalidEncodingDrop InvalidEncoding = "drop"
+)
+
+var sourceEncodings = map[SourceEncoding]encoding.Encoding{
+	SourceEncodingLatin1:      charmap.ISO8859_1,
+	SourceEncodingWindows1252: charmap.Windows1252,
+	SourceEncodingUTF16LE:     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	SourceEncodingUTF16BE:     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+}
+
+// transcoder converts the string values of records to valid UTF-8
+type transcoder struct {
+	encoding    SourceEncoding
+	dropInvalid bool
+}
+
+func newTranscoder(sourceEncoding SourceEncoding, invalid InvalidEncoding) *transcoder {
+	return &transcoder{
+		encoding:    sourceEncoding,
+		dropInvalid: invalid == InvalidEncodingDrop,
+	}
+}
+
+// transcode converts the string and byte slice values of a record, and of the maps and
+// arrays nested in it, to UTF-8 strings. Keys are left as they are.
+func (t *transcoder) transcode(record map[interface{}]interface{}) {
+	for k, v := range record {
+		record[k] = t.transcodeValue(v)
+	}
+}
+
+func (t *transcoder) transcodeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return t.decode(v)
+	case string:
+		return t.decode([]byte(v))
+	case map[interface{}]interface{}:
+		t.transcode(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = t.transcodeValue(v[i])
+		}
+	}
+	return value
+}
+
+// decode converts a value from the source encoding to UTF-8
+func (t *transcoder) decode(value []byte) string {
+	sourceEncoding := t.encoding
+	if sourceEncoding == SourceEncodingAuto {
+		sourceEncoding = detectEncoding(value)
+	}
+
+	decoder, ok := sourceEncodings[sourceEncoding]
+	if !ok {
+		if t.dropInvalid {
+			return strings.ToValidUTF8(string(value), "")
+		}
+		return strings.ToValidUTF8(string(value), string(utf8.RuneError))
+	}
+	decoded, err := decoder.NewDecoder().Bytes(value)
+	if err != nil {
+		// decoders replace invalid sequences rather than failing, but keep the value readable if they do
+		return strings.ToValidUTF8(string(value), string(utf8.RuneError))
+	}
+	if t.dropInvalid {
+		// decoders replace invalid sequences with the replacement character
+		return strings.ReplaceAll(string(decoded), string(utf8.RuneError), "")
+	}
+	return string(decoded)
+}
+
+// detectEncoding guesses the encoding of a value. Values starting with a UTF-16 byte order
+// mark, or with a zero in at least half of their odd or even bytes, as ASCII text encoded
+// in UTF-16 has, are UTF-16. Otherwise values which are valid UTF-8 are UTF-8, and the
+// rest Latin-1, which any byte sequence is valid in.
+func detectEncoding(value []byte) SourceEncoding {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == 0xff && value[1] == 0xfe:
+			return SourceEncodingUTF16LE
+		case value[0] == 0xfe && value[1] == 0xff:
+			return SourceEncodingUTF16BE
+		}
+	}
+	if len(value) >= 2 && len(value)%2 == 0 {
+		var evenZeros, oddZeros int
+		for i := 0; i < len(value); i += 2 {
+			if value[i] == 0 {
+				evenZeros++
+			}
+			if value[i+1] == 0 {
+				oddZeros++
+			}
+		}
+		units := len(value) / 2
+		if oddZeros*2 >= units && evenZeros < oddZeros {
+			return SourceEncodingUTF16LE
+		}
+		if evenZeros*2 >= units && oddZeros < evenZeros {
+			return SourceEncodingUTF16BE
+		}
+	}
+	if utf8.Valid(value) {
+		return SourceEncodingUTF8
+	}
+	return SourceEncodingLatin1
+}
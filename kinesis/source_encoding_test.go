@@ -0,0 +1,84 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+// "café ñ" in each encoding
+var (
+	latin1Cafe  = []byte{'c', 'a', 'f', 0xe9, ' ', 0xf1}
+	utf16LECafe = []byte{'c', 0, 'a', 0, 'f', 0, 0xe9, 0, ' ', 0, 0xf1, 0}
+	utf16BECafe = []byte{0xfe, 0xff, 0, 'c', 0, 'a', 0, 'f', 0, 0xe9, 0, ' ', 0, 0xf1}
+)
+
+func TestTranscodeLatin1(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"log":    latin1Cafe,
+		"nested": map[interface{}]interface{}{"values": []interface{}{latin1Cafe, 42}},
+	}
+	newTranscoder(SourceEncodingLatin1, InvalidEncodingReplace).transcode(record)
+	assert.Equal(t, "café ñ", record["log"])
+	assert.Equal(t, []interface{}{"café ñ", 42}, record["nested"].(map[interface{}]interface{})["values"])
+}
+
+func TestTranscodeUTF16(t *testing.T) {
+	record := map[interface{}]interface{}{"le": utf16LECafe, "be": utf16BECafe}
+	newTranscoder(SourceEncodingUTF16LE, InvalidEncodingReplace).transcode(record)
+	assert.Equal(t, "café ñ", record["le"])
+	assert.Equal(t, "café ñ", record["be"], "Expected the byte order mark to override the configured order")
+}
+
+func TestTranscodeAuto(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"latin1": latin1Cafe,
+		"utf16":  utf16LECafe,
+		"utf8":   []byte("café ñ"),
+		"string": "plain",
+	}
+	newTranscoder(SourceEncodingAuto, InvalidEncodingReplace).transcode(record)
+	assert.Equal(t, map[interface{}]interface{}{
+		"latin1": "café ñ",
+		"utf16":  "café ñ",
+		"utf8":   "café ñ",
+		"string": "plain",
+	}, record)
+}
+
+func TestTranscodeInvalidSequences(t *testing.T) {
+	// an unpaired surrogate in UTF-16 and a truncated sequence in UTF-8
+	utf16 := []byte{'o', 0, 'k', 0, 0x00, 0xd8, '!', 0}
+	utf8 := []byte{'o', 'k', 0xc3, '!'}
+
+	record := map[interface{}]interface{}{"utf16": utf16}
+	newTranscoder(SourceEncodingUTF16LE, InvalidEncodingReplace).transcode(record)
+	assert.Equal(t, "ok�!", record["utf16"])
+	record = map[interface{}]interface{}{"utf16": utf16}
+	newTranscoder(SourceEncodingUTF16LE, InvalidEncodingDrop).transcode(record)
+	assert.Equal(t, "ok!", record["utf16"])
+
+	record = map[interface{}]interface{}{"utf8": utf8}
+	newTranscoder(SourceEncodingUTF8, InvalidEncodingReplace).transcode(record)
+	assert.Equal(t, "ok�!", record["utf8"])
+	record = map[interface{}]interface{}{"utf8": utf8}
+	newTranscoder(SourceEncodingUTF8, InvalidEncodingDrop).transcode(record)
+	assert.Equal(t, "ok!", record["utf8"])
+}
+
+func TestSourceEncodingInAddRecord(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.transcoder = newTranscoder(SourceEncodingAuto, InvalidEncodingReplace)
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": latin1Cafe}, &timeStamp)
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": utf16LECafe}, &timeStamp)
+
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		assert.Contains(t, string(record.Data), `"log":"café ñ"`)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// DefaultSourcePathTagFormat builds the path from the first group of source_path_tag_regex
+const DefaultSourcePathTagFormat = "/$1"
+
+// sourcePath adds the path of the file a record was read from to the record. The path is
+// taken from a field of the record metadata or body, or else extracted from the tag.
+type sourcePath struct {
+	key       string
+	field     string
+	tagRegex  *regexp.Regexp
+	tagFormat string
+}
+
+func newSourcePath(key string, field string, tagRegex string, tagFormat string) (*sourcePath, error) {
+	path := &sourcePath{
+		key:       key,
+		field:     field,
+		tagFormat: tagFormat,
+	}
+	if path.tagFormat == "" {
+		path.tagFormat = DefaultSourcePathTagFormat
+	}
+	if tagRegex != "" {
+		var err error
+		if path.tagRegex, err = regexp.Compile(tagRegex); err != nil {
+			return nil, err
+		}
+		if path.tagRegex.NumSubexp() == 0 {
+			return nil, fmt.Errorf("%s has no group to take the path from", tagRegex)
+		}
+	}
+	return path, nil
+}
+
+// find returns the source path of a record, from the field of its metadata or body,
+// or else from the tag
+func (s *sourcePath) find(record map[interface{}]interface{}, metadata map[interface{}]interface{}, tag string) (string, bool) {
+	if s.field != "" {
+		for _, fields := range []map[interface{}]interface{}{metadata, record} {
+			for k, v := range fields {
+				if stringOrByteArray(k) == s.field {
+					if path := stringOrByteArray(v); path != "" {
+						return path, true
+					}
+				}
+			}
+		}
+	}
+	return s.fromTag(tag)
+}
+
+// fromTag extracts the path from the tag with the regex and format. The tail input
+// replaces the slashes of a path with dots when it expands the tag from it, so dots
+// in the groups of the match become slashes again, while the format is kept as is.
+func (s *sourcePath) fromTag(tag string) (string, bool) {
+	if s.tagRegex == nil || tag == "" {
+		return "", false
+	}
+	match := s.tagRegex.FindStringSubmatchIndex(tag)
+	if match == nil {
+		return "", false
+	}
+	src := []byte(tag)
+	for i := 2; i < len(match); i += 2 {
+		if match[i] >= 0 {
+			group := src[match[i]:match[i+1]]
+			copy(group, bytes.ReplaceAll(group, []byte("."), []byte("/")))
+		}
+	}
+	return string(s.tagRegex.Expand(nil, []byte(s.tagFormat), src, match)), true
+}
+
+// addTo adds the source path to the record, if it is known
+func (s *sourcePath) addTo(record map[interface{}]interface{}, metadata map[interface{}]interface{}, tag string) {
+	if path, ok := s.find(record, metadata, tag); ok {
+		record[s.key] = path
+	}
+}
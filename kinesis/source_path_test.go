@@ -0,0 +1,64 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourcePathFromTag(t *testing.T) {
+	path, err := newSourcePath("source_path", "", `^tail\.(.+)\.(log)$`, "/$1.$2")
+	assert.NoError(t, err)
+
+	extracted, ok := path.fromTag("tail.var.log.app.log")
+	assert.True(t, ok)
+	assert.Equal(t, "/var/log/app.log", extracted)
+
+	_, ok = path.fromTag("forward.app")
+	assert.False(t, ok, "Expected no path for a tag the regex does not match")
+
+	path, err = newSourcePath("source_path", "", `^kube\.(.+)$`, "")
+	assert.NoError(t, err)
+	extracted, _ = path.fromTag("kube.var.log.containers.app")
+	assert.Equal(t, "/var/log/containers/app", extracted, "Expected the default format to use the first group")
+}
+
+func TestSourcePathInvalidRegex(t *testing.T) {
+	_, err := newSourcePath("source_path", "", `^tail\.(`, "")
+	assert.Error(t, err)
+	_, err = newSourcePath("source_path", "", `^tail\..+$`, "")
+	assert.Error(t, err, "Expected an error for a regex without groups")
+}
+
+func TestSourcePathAddedToTaggedRecords(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.sourcePath, _ = newSourcePath("source_path", "path", `^tail\.(.+)\.(log)$`, "/$1.$2")
+
+	timeStamp := time.Now()
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 3)
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.AddTaggedRecord(&records, map[interface{}]interface{}{
+		"log": "from the tag",
+	}, nil, "tail.var.log.app.log", &timeStamp))
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.AddTaggedRecord(&records, map[interface{}]interface{}{
+		"log":  "from the record",
+		"path": []byte("/var/log/other.log"),
+	}, nil, "tail.var.log.app.log", &timeStamp))
+	assert.Equal(t, fluentbit.FLB_OK, outputPlugin.AddTaggedRecord(&records, map[interface{}]interface{}{
+		"log": "unknown",
+	}, nil, "forward.app", &timeStamp))
+
+	assert.Len(t, records, 3)
+	assert.Contains(t, string(records[0].Data), `"source_path":"/var/log/app.log"`)
+	assert.Contains(t, string(records[1].Data), `"source_path":"/var/log/other.log"`)
+	assert.NotContains(t, string(records[2].Data), "source_path", "Expected no source path when none is found")
+}
+
+func TestSourcePathFromMetadata(t *testing.T) {
+	path, _ := newSourcePath("source_path", "file", "", "")
+	record := map[interface{}]interface{}{"log": "line"}
+	path.addTo(record, map[interface{}]interface{}{"file": "/var/log/app.log"}, "")
+	assert.Equal(t, "/var/log/app.log", record["source_path"])
+}
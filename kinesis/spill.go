@@ -0,0 +1,425 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+const (
+	spillFileSuffix    = ".spill"
+	spillTmpFileSuffix = ".tmp"
+
+	spillDrainInitialBackoff = 100 * time.Millisecond
+	spillDrainMaxBackoff     = 30 * time.Second
+)
+
+// spillQueue is a FIFO queue of record batches persisted to disk.
+// Each batch is written to its own file, named with a monotonically increasing
+// sequence number so that ordering is preserved across restarts. Files are
+// written to a temporary name and renamed into place, so a crash never leaves
+// a partially written batch in the queue.
+type spillQueue struct {
+	dir   string
+	mutex sync.Mutex
+	// sequence number of the next file to be written
+	nextSeq uint64
+	// sequence numbers of the files currently in the queue, oldest first
+	files []uint64
+	// total size of the files currently in the queue
+	bytes int64
+	// signals the drainer that a new batch was queued
+	notify chan struct{}
+	// held by Flush and the drainer while they send, so the drainer never sends while Flush does
+	flushMutex sync.Mutex
+}
+
+// newSpillQueue opens the spill queue in dir, picking up any batches left over from a previous run
+func newSpillQueue(dir string) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &spillQueue{
+		dir:    dir,
+		notify: make(chan struct{}, 1),
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, spillTmpFileSuffix) {
+			// left behind by a crash while writing, the batch was never acknowledged
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, spillFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.files = append(q.files, seq)
+		q.bytes += info.Size()
+	}
+	sort.Slice(q.files, func(i, j int) bool { return q.files[i] < q.files[j] })
+	if len(q.files) > 0 {
+		q.nextSeq = q.files[len(q.files)-1] + 1
+	}
+
+	return q, nil
+}
+
+func (q *spillQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, spillFileSuffix))
+}
+
+// push persists a batch of records to the back of the queue
+func (q *spillQueue) push(records []*kinesis.PutRecordsRequestEntry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	seq := q.nextSeq
+	size, err := q.write(q.path(seq), records)
+	if err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.files = append(q.files, seq)
+	q.bytes += size
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek returns the batch at the front of the queue, or false if the queue is empty
+func (q *spillQueue) peek() (uint64, []*kinesis.PutRecordsRequestEntry, bool, error) {
+	q.mutex.Lock()
+	if len(q.files) == 0 {
+		q.mutex.Unlock()
+		return 0, nil, false, nil
+	}
+	seq := q.files[0]
+	q.mutex.Unlock()
+
+	records, err := readSpillFile(q.path(seq))
+	return seq, records, true, err
+}
+
+// replace atomically overwrites a queued batch with the records which remain unsent
+func (q *spillQueue) replace(seq uint64, records []*kinesis.PutRecordsRequestEntry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	oldSize := fileSize(q.path(seq))
+	newSize, err := q.write(q.path(seq), records)
+	if err != nil {
+		return err
+	}
+	q.bytes += newSize - oldSize
+	return nil
+}
+
+// remove deletes the batch at the front of the queue once it has been sent
+func (q *spillQueue) remove(seq uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	size := fileSize(q.path(seq))
+	if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(q.files) > 0 && q.files[0] == seq {
+		q.files = q.files[1:]
+	}
+	q.bytes -= size
+	return nil
+}
+
+// pending indicates whether there are batches in the queue
+func (q *spillQueue) pending() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.files) > 0
+}
+
+// size returns the number of bytes currently queued on disk
+func (q *spillQueue) size() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.bytes
+}
+
+// write encodes the records to a temporary file and renames it into place
+func (q *spillQueue) write(path string, records []*kinesis.PutRecordsRequestEntry) (int64, error) {
+	return writeRecordsFile(q.dir, path, records)
+}
+
+// writeRecordsFile encodes the records to a temporary file in dir and renames it to path,
+// so a crash never leaves a partially written file behind under path
+func writeRecordsFile(dir string, path string, records []*kinesis.PutRecordsRequestEntry) (int64, error) {
+	tmpPath := path + spillTmpFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		writeSpillField(writer, []byte(aws.StringValue(record.PartitionKey)))
+		writeSpillField(writer, []byte(aws.StringValue(record.ExplicitHashKey)))
+		writeSpillField(writer, record.Data)
+	}
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	syncDir(dir)
+
+	return fileSize(path), nil
+}
+
+func writeSpillField(writer *bufio.Writer, field []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(field)))
+	writer.Write(lenBuf[:n])
+	writer.Write(field)
+}
+
+func readSpillFile(path string) ([]*kinesis.PutRecordsRequestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, maximumRecordsPerPut)
+	for {
+		partitionKey, err := readSpillField(reader)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		explicitHashKey, err := readSpillField(reader)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readSpillField(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		record := &kinesis.PutRecordsRequestEntry{
+			Data:         data,
+			PartitionKey: aws.String(string(partitionKey)),
+		}
+		if len(explicitHashKey) > 0 {
+			record.ExplicitHashKey = aws.String(string(explicitHashKey))
+		}
+		records = append(records, record)
+	}
+}
+
+func readSpillField(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(reader, field); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return field, nil
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// syncDir makes a rename durable, errors are ignored since not all platforms support it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// drainSpillQueue sends spilled batches to Kinesis, oldest first, until stop is closed.
+// A batch is only removed from disk once every record in it has been sent. Batches are
+// sent under the queue's flushMutex, so the drain never sends while Fluent Bit's Flush does.
+func (outputPlugin *OutputPlugin) drainSpillQueue(stop chan struct{}) {
+	backoff := spillDrainInitialBackoff
+	// wait sleeps for the backoff, which doubles each time, and returns false once stop is closed
+	wait := func() bool {
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return false
+		}
+		backoff *= 2
+		if backoff > spillDrainMaxBackoff {
+			backoff = spillDrainMaxBackoff
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		seq, records, ok, err := outputPlugin.spill.peek()
+		if err != nil {
+			outputPlugin.logger.Errorf("Failed to read spilled records, dropping batch %d: %v\n", seq, err)
+			if !outputPlugin.dropSpilledBatch(seq) && !wait() {
+				return
+			}
+			continue
+		}
+		if !ok {
+			select {
+			case <-outputPlugin.spill.notify:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		// flush rather than Flush, whose latency tracking belongs to the Fluent Bit flush goroutine
+		outputPlugin.spill.flushMutex.Lock()
+		retCode, _ := outputPlugin.flush(&records)
+		outputPlugin.spill.flushMutex.Unlock()
+		switch retCode {
+		case output.FLB_OK:
+			outputPlugin.logger.Debugf("Sent spilled batch %d\n", seq)
+			if !outputPlugin.dropSpilledBatch(seq) && !wait() {
+				return
+			}
+			backoff = spillDrainInitialBackoff
+			continue
+		case output.FLB_ERROR:
+			outputPlugin.logger.Errorf("Failed to send (%d) spilled records with error, dropping batch %d\n", len(records), seq)
+			if !outputPlugin.dropSpilledBatch(seq) && !wait() {
+				return
+			}
+			continue
+		}
+
+		// persist the progress made so records which were sent are not resent after a crash
+		if err := outputPlugin.spill.replace(seq, records); err != nil {
+			outputPlugin.logger.Errorf("Failed to update spill file for batch %d: %v\n", seq, err)
+		}
+		outputPlugin.flushInfof("Going to retry (%d) spilled records in %s\n", len(records), backoff)
+		if !wait() {
+			return
+		}
+	}
+}
+
+// dropSpilledBatch removes a batch from the spill queue, returning false if its file could not
+// be removed, in which case the drain backs off rather than spinning on the same batch
+func (outputPlugin *OutputPlugin) dropSpilledBatch(seq uint64) bool {
+	if err := outputPlugin.spill.remove(seq); err != nil {
+		outputPlugin.logger.Errorf("Failed to remove spill file for batch %d: %v\n", seq, err)
+		return false
+	}
+	return true
+}
+
+// SpillBackpressure reports whether chunks should be returned to Fluent Bit with FLB_RETRY
+// because the spill queue is too large. It starts once the queue grows beyond the high
+// watermark, and stops once the queue has drained below the low watermark.
+func (outputPlugin *OutputPlugin) SpillBackpressure() bool {
+	if outputPlugin.spill == nil || outputPlugin.spillHighWatermark <= 0 {
+		return false
+	}
+
+	size := outputPlugin.spill.size()
+	if atomic.LoadInt32(&outputPlugin.spillThrottled) == 0 {
+		if size <= outputPlugin.spillHighWatermark {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&outputPlugin.spillThrottled, 0, 1) {
+			outputPlugin.logger.Warnf("Spill queue of %d bytes is above spill_high_watermark, returning retry until it drains below %d bytes\n", size, outputPlugin.spillLowWatermark)
+		}
+		return true
+	}
+
+	if size >= outputPlugin.spillLowWatermark {
+		outputPlugin.flushInfof("flush returning retry, spill queue above low watermark (%d bytes)\n", size)
+		return true
+	}
+	if atomic.CompareAndSwapInt32(&outputPlugin.spillThrottled, 1, 0) {
+		outputPlugin.logger.Infof("Spill queue of %d bytes has drained below spill_low_watermark, accepting chunks again\n", size)
+	}
+	return false
+}
+
+// recordsSize returns the number of bytes the records count towards the PutRecords limits
+func recordsSize(records []*kinesis.PutRecordsRequestEntry) int64 {
+	var size int64
+	for _, record := range records {
+		size += int64(len(record.Data) + len(aws.StringValue(record.PartitionKey)))
+	}
+	return size
+}
@@ -0,0 +1,280 @@
+package kinesis
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEntries(data ...string) []*kinesis.PutRecordsRequestEntry {
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, len(data))
+	for _, d := range data {
+		records = append(records, &kinesis.PutRecordsRequestEntry{
+			Data:         []byte(d),
+			PartitionKey: aws.String("key-" + d),
+		})
+	}
+	return records
+}
+
+// numberedTestData returns the data of count records, "0" to the last index
+func numberedTestData(count int) []string {
+	data := make([]string, count)
+	for i := range data {
+		data[i] = strconv.Itoa(i)
+	}
+	return data
+}
+
+func TestSpillQueueOrdering(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := newSpillQueue(dir)
+	assert.NoError(t, err)
+	assert.False(t, queue.pending())
+
+	first := newTestEntries("a", "b")
+	first[1].ExplicitHashKey = aws.String("1234")
+	assert.NoError(t, queue.push(first))
+	assert.NoError(t, queue.push(newTestEntries("c")))
+	assert.True(t, queue.pending())
+	assert.Greater(t, queue.size(), int64(0))
+
+	// a crash while writing leaves a temporary file behind, which must be ignored
+	os.WriteFile(filepath.Join(dir, "00000000000000000002.spill.tmp"), []byte("partial"), 0600)
+
+	// reopening the queue picks up the batches in the order they were written
+	queue, err = newSpillQueue(dir)
+	assert.NoError(t, err)
+
+	seq, records, ok, err := queue.peek()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "a", string(records[0].Data))
+	assert.Equal(t, "key-a", aws.StringValue(records[0].PartitionKey))
+	assert.Nil(t, records[0].ExplicitHashKey)
+	assert.Equal(t, "1234", aws.StringValue(records[1].ExplicitHashKey))
+
+	// partial progress is persisted
+	assert.NoError(t, queue.replace(seq, records[1:]))
+	_, records, _, _ = queue.peek()
+	assert.Len(t, records, 1)
+	assert.Equal(t, "b", string(records[0].Data))
+
+	assert.NoError(t, queue.remove(seq))
+	_, records, ok, _ = queue.peek()
+	assert.True(t, ok)
+	assert.Equal(t, "c", string(records[0].Data))
+
+	// new batches are written after the ones left over from the previous run
+	assert.NoError(t, queue.push(newTestEntries("d")))
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000002.spill"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000002.spill.tmp"))
+	assert.True(t, os.IsNotExist(err), "Expected leftover temporary file to be removed")
+}
+
+func TestFlushConcurrentSpillsWhenBufferFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var sent []string
+	var sentMutex sync.Mutex
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			<-unblock
+			sentMutex.Lock()
+			for _, r := range input.Records {
+				sent = append(sent, string(r.Data))
+			}
+			sentMutex.Unlock()
+			wg.Done()
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.Concurrency = 4
+	outputPlugin.bufferMaxBytes = 20
+
+	spill, err := newSpillQueue(t.TempDir())
+	assert.NoError(t, err)
+	outputPlugin.spill = spill
+	outputPlugin.spillStop = make(chan struct{})
+	defer close(outputPlugin.spillStop)
+
+	retCode := outputPlugin.FlushConcurrent(1, newTestEntries("first-chunk"))
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+
+	// the first chunk is still in flight, so the second exceeds the buffer and is spilled
+	retCode = outputPlugin.FlushConcurrent(1, newTestEntries("second-chunk"))
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+	assert.True(t, spill.pending(), "Expected second chunk to be spilled to disk")
+
+	go outputPlugin.drainSpillQueue(outputPlugin.spillStop)
+	close(unblock)
+	wg.Wait()
+
+	assert.Eventually(t, func() bool { return !spill.pending() }, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"first-chunk", "second-chunk"}, sent)
+	assert.Equal(t, int64(0), spill.size(), "Expected spill files to be removed once sent")
+}
+
+func TestSpillDrainWaitsForFlush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	var inflight, maxInflight int32
+	var sent []string
+	var mutex sync.Mutex
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			mutex.Lock()
+			inflight++
+			if inflight > maxInflight {
+				maxInflight = inflight
+			}
+			for _, r := range input.Records {
+				sent = append(sent, string(r.Data))
+			}
+			first := len(sent) == 1
+			mutex.Unlock()
+			if first {
+				// Flush is stuck in its request while the drain has a batch to send
+				close(started)
+				<-unblock
+			}
+			mutex.Lock()
+			inflight--
+			mutex.Unlock()
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		}).Times(2)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	spill, err := newSpillQueue(t.TempDir())
+	assert.NoError(t, err)
+	outputPlugin.spill = spill
+	outputPlugin.spillStop = make(chan struct{})
+	defer close(outputPlugin.spillStop)
+
+	flushed := make(chan int)
+	go func() {
+		records := newTestEntries("chunk")
+		flushed <- outputPlugin.Flush(&records)
+	}()
+	<-started
+	assert.NoError(t, spill.push(newTestEntries("spilled")))
+	go outputPlugin.drainSpillQueue(outputPlugin.spillStop)
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	assert.Equal(t, fluentbit.FLB_OK, <-flushed)
+
+	assert.Eventually(t, func() bool { return !spill.pending() }, time.Second, 10*time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"chunk", "spilled"}, sent)
+	assert.Equal(t, int32(1), maxInflight, "Expected the drain to wait for Flush to finish")
+}
+
+func TestSpillDrainBacksOffOnUnremovableBatch(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	dir := t.TempDir()
+	spill, err := newSpillQueue(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, spill.push(newTestEntries("unreadable")))
+	// a directory in place of the batch can be neither read nor removed
+	path := spill.path(0)
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, os.MkdirAll(filepath.Join(path, "nested"), 0700))
+	outputPlugin.spill = spill
+
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		outputPlugin.drainSpillQueue(stop)
+	}()
+	time.Sleep(250 * time.Millisecond)
+	close(stop)
+	<-done
+
+	attempts := 0
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "Failed to read spilled records") {
+			attempts++
+		}
+	}
+	assert.Greater(t, attempts, 0)
+	assert.LessOrEqual(t, attempts, 3, "Expected the drain to back off instead of spinning on the batch")
+}
+
+func TestFlushConcurrentRetriesWhenBufferFullWithoutSpill(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.Concurrency = 4
+	outputPlugin.bufferMaxBytes = 20
+	outputPlugin.addInflightBytes(15)
+
+	retCode := outputPlugin.FlushConcurrent(1, newTestEntries("too-large"))
+	assert.Equal(t, fluentbit.FLB_RETRY, retCode)
+}
+
+func TestSpillBackpressure(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	spill, err := newSpillQueue(t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	outputPlugin.spill = spill
+	outputPlugin.spillHighWatermark = 300
+	outputPlugin.spillLowWatermark = 200
+
+	batch := []*kinesis.PutRecordsRequestEntry{{
+		Data:         make([]byte, 90),
+		PartitionKey: aws.String("key"),
+	}}
+	assert.False(t, outputPlugin.SpillBackpressure(), "Expected chunks to be accepted while the queue is empty")
+
+	// each batch takes 96 bytes on disk
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, spill.push(batch))
+	}
+	assert.False(t, outputPlugin.SpillBackpressure(), "Expected chunks to be accepted up to the high watermark")
+	assert.NoError(t, spill.push(batch))
+	assert.True(t, outputPlugin.SpillBackpressure(), "Expected chunks to be retried above the high watermark")
+
+	// draining below the high watermark isn't enough to resume
+	seq, _, _, _ := spill.peek()
+	assert.NoError(t, spill.remove(seq))
+	assert.True(t, outputPlugin.SpillBackpressure(), "Expected chunks to be retried until the low watermark")
+
+	seq, _, _, _ = spill.peek()
+	assert.NoError(t, spill.remove(seq))
+	assert.False(t, outputPlugin.SpillBackpressure(), "Expected chunks to be accepted below the low watermark")
+
+	// and the queue must exceed the high watermark again before chunks are retried
+	assert.NoError(t, spill.push(batch))
+	assert.False(t, outputPlugin.SpillBackpressure())
+}
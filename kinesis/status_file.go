@@ -0,0 +1,137 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStatusInterval is how often the status file is written if no interval is configured
+	DefaultStatusInterval = 10 * time.Second
+)
+
+// pluginStatus holds the counters written to the status file
+type pluginStatus struct {
+	path string
+	// records the main stream accepted, and records it failed to accept on each attempt
+	sent   uint64
+	failed uint64
+	// records currently being flushed, including those waiting for a retry
+	buffered int64
+	// Unix time in nanoseconds of the last PutRecords call which sent a record, 0 if none has
+	lastSuccess int64
+}
+
+// statusFile is the JSON written to the status file
+type statusFile struct {
+	RecordsSent     uint64     `json:"records_sent"`
+	RecordsFailed   uint64     `json:"records_failed"`
+	RecordsBuffered int64      `json:"records_buffered"`
+	SpillBytes      int64      `json:"spill_bytes"`
+	LastSuccess     *time.Time `json:"last_success"`
+	Updated         time.Time  `json:"updated"`
+}
+
+// recordStatus counts the records of a PutRecords call, if it sent them to the main stream,
+// for the status file and EMF metrics
+func (outputPlugin *OutputPlugin) recordStatus(stream string, sent, failed int) {
+	if stream != outputPlugin.stream {
+		return
+	}
+	if outputPlugin.emf != nil {
+		outputPlugin.emf.addSent(sent, failed)
+	}
+	if outputPlugin.status == nil {
+		return
+	}
+	atomic.AddUint64(&outputPlugin.status.sent, uint64(sent))
+	atomic.AddUint64(&outputPlugin.status.failed, uint64(failed))
+	if sent > 0 {
+		atomic.StoreInt64(&outputPlugin.status.lastSuccess, time.Now().UnixNano())
+	}
+}
+
+// addBuffered counts records while they are being flushed
+func (outputPlugin *OutputPlugin) addBuffered(n int) {
+	if outputPlugin.status != nil {
+		atomic.AddInt64(&outputPlugin.status.buffered, int64(n))
+	}
+}
+
+// writeStatus replaces the status file with the current counters. The file is written to a
+// temporary file and renamed into place, so readers never see a partly written file.
+func (outputPlugin *OutputPlugin) writeStatus() error {
+	status := statusFile{
+		RecordsSent:     atomic.LoadUint64(&outputPlugin.status.sent),
+		RecordsFailed:   atomic.LoadUint64(&outputPlugin.status.failed),
+		RecordsBuffered: atomic.LoadInt64(&outputPlugin.status.buffered),
+		Updated:         time.Now().UTC(),
+	}
+	if outputPlugin.spill != nil {
+		status.SpillBytes = outputPlugin.spill.size()
+	}
+	if lastSuccess := atomic.LoadInt64(&outputPlugin.status.lastSuccess); lastSuccess > 0 {
+		t := time.Unix(0, lastSuccess).UTC()
+		status.LastSuccess = &t
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	path := outputPlugin.status.path
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeStatusPeriodically writes the status file every interval, until the returned channel is closed
+func (outputPlugin *OutputPlugin) writeStatusPeriodically(interval time.Duration) chan struct{} {
+	if interval <= 0 {
+		interval = DefaultStatusInterval
+	}
+	stop := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := outputPlugin.writeStatus(); err != nil {
+					outputPlugin.logger.Warnf("Failed to write status file %s: %v\n", outputPlugin.status.path, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
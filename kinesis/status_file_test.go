@@ -0,0 +1,73 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	gomock.InOrder(
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(&kinesis.PutRecordsOutput{
+			FailedRecordCount: aws.Int64(1),
+			Records: []*kinesis.PutRecordsResultEntry{
+				{SequenceNumber: aws.String("1")},
+				{SequenceNumber: aws.String("2")},
+				{ErrorCode: aws.String(kinesis.ErrCodeProvisionedThroughputExceededException), ErrorMessage: aws.String("Rate exceeded")},
+			},
+		}, nil),
+		mockKinesis.EXPECT().PutRecords(gomock.Any()).Return(nil, errors.New("service unavailable")),
+	)
+
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	path := filepath.Join(t.TempDir(), "status.json")
+	outputPlugin.status = &pluginStatus{path: path}
+
+	readStatus := func() statusFile {
+		assert.NoError(t, outputPlugin.writeStatus())
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var status statusFile
+		assert.NoError(t, json.Unmarshal(data, &status))
+		return status
+	}
+
+	status := readStatus()
+	assert.Equal(t, uint64(0), status.RecordsSent)
+	assert.Nil(t, status.LastSuccess, "Expected no last success before any records are sent")
+
+	start := time.Now()
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("a"), PartitionKey: aws.String("a")},
+		{Data: []byte("b"), PartitionKey: aws.String("b")},
+		{Data: []byte("c"), PartitionKey: aws.String("c")},
+	}
+	outputPlugin.Flush(&records)
+	// the retried record fails again
+	outputPlugin.Flush(&records)
+
+	outputPlugin.addBuffered(5)
+	status = readStatus()
+	assert.Equal(t, uint64(2), status.RecordsSent)
+	assert.Equal(t, uint64(2), status.RecordsFailed)
+	assert.Equal(t, int64(5), status.RecordsBuffered, "Expected only the records still being flushed to be buffered")
+	if assert.NotNil(t, status.LastSuccess) {
+		assert.False(t, status.LastSuccess.Before(start.Add(-time.Second)))
+	}
+
+	leftover, _ := filepath.Glob(path + ".*.tmp")
+	assert.Empty(t, leftover, "Expected the temporary file to be renamed into place")
+}
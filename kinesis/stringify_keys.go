@@ -0,0 +1,73 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// stringifyKeys converts the keys of a decoded record, and of the maps nested in it, to
+// strings, so every feature looking up fields by name sees them and the JSON encoding of
+// a key does not depend on its type. msgpack binary keys already decode as strings, since
+// byte slices can't be map keys. If a converted key clashes with a string key, the value
+// of the string key is kept.
+func stringifyKeys(record map[interface{}]interface{}) {
+	for k, v := range record {
+		v = stringifyNested(v)
+		if key, ok := k.(string); ok {
+			record[key] = v
+			continue
+		}
+
+		key := stringifyKey(k)
+		delete(record, k)
+		if _, exists := record[key]; !exists {
+			record[key] = v
+		}
+	}
+}
+
+func stringifyNested(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		stringifyKeys(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = stringifyNested(v[i])
+		}
+	}
+	return value
+}
+
+// stringifyKey formats a key the way JSON would format it as a value: integers and floats
+// in decimal, booleans as true or false and nil as null
+func stringifyKey(key interface{}) string {
+	switch k := key.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(k)
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case uint64:
+		return strconv.FormatUint(k, 10)
+	case float32:
+		return strconv.FormatFloat(float64(k), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64)
+	default:
+		return fmt.Sprint(k)
+	}
+}
@@ -0,0 +1,64 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestStringifyKeys(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.stringifyKeys = true
+	outputPlugin.replaceDots = ""
+
+	// {1: "one", bin("raw"): "bytes"}, a msgpack map with an integer and a binary key
+	data := []byte{0x82, 0x01, 0xa3, 'o', 'n', 'e', 0xc4, 0x03, 'r', 'a', 'w', 0xa5, 'b', 'y', 't', 'e', 's'}
+	var decoded interface{}
+	assert.NoError(t, codec.NewDecoderBytes(data, new(codec.MsgpackHandle)).Decode(&decoded))
+	record := decoded.(map[interface{}]interface{})
+
+	record[true] = []byte("bool")
+	record[nil] = []byte("nil")
+	record[2.5] = []byte("float")
+	record["nested"] = map[interface{}]interface{}{
+		int64(-3): []interface{}{
+			map[interface{}]interface{}{uint64(4): []byte("in array")},
+		},
+	}
+	// clashes with the string key, whose value is kept
+	record[int64(7)] = []byte("integer seven")
+	record["7"] = []byte("string seven")
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, record, &timeStamp)
+	if assert.Len(t, records, 1) {
+		assert.JSONEq(t, `{
+			"1": "one",
+			"raw": "bytes",
+			"true": "bool",
+			"null": "nil",
+			"2.5": "float",
+			"nested": {"-3": [{"4": "in array"}]},
+			"7": "string seven"
+		}`, string(records[0].Data))
+	}
+}
+
+func TestStringifyKeysPartitionKey(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.stringifyKeys = true
+	outputPlugin.partitionKey = "42"
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		uint64(42): []byte("key"),
+	}, &timeStamp)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "key", *records[0].PartitionKey, "Expected numeric keys to be found by name")
+	}
+}
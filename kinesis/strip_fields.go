@@ -0,0 +1,52 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"strings"
+)
+
+// parseStripFields splits each of the strip_internal_fields keys into its path of nested keys
+func parseStripFields(keys []string) [][]string {
+	paths := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		paths = append(paths, strings.Split(key, "->"))
+	}
+	return paths
+}
+
+// stripFields removes the fields at each path from the record. Paths the record
+// doesn't have are ignored.
+func stripFields(record map[interface{}]interface{}, paths [][]string) {
+	for _, path := range paths {
+		parent := record
+		for _, key := range path[:len(path)-1] {
+			nested, ok := getFromMap(key, parent).(map[interface{}]interface{})
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = nested
+		}
+		if parent == nil {
+			continue
+		}
+		last := path[len(path)-1]
+		for k := range parent {
+			if stringOrByteArray(k) == last {
+				delete(parent, k)
+			}
+		}
+	}
+}
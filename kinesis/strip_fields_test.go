@@ -0,0 +1,39 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripInternalFields(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKey = "tenant"
+	outputPlugin.timeKey = "time"
+	outputPlugin.timeKeyEpochNanos = true
+	outputPlugin.stripFields = parseStripFields([]string{"tenant", "time", "kubernetes->pod_id", "missing->key"})
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log":    []byte("hello"),
+		"tenant": []byte("a"),
+		"kubernetes": map[interface{}]interface{}{
+			"pod_id":   []byte("1234"),
+			"pod_name": []byte("app"),
+		},
+	}, &timeStamp)
+
+	assert.Len(t, records, 1)
+	assert.Equal(t, "a", aws.StringValue(records[0].PartitionKey), "Expected the partition key to be taken before the field is stripped")
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(records[0].Data, &decoded))
+	assert.Equal(t, map[string]interface{}{
+		"log":        "hello",
+		"kubernetes": map[string]interface{}{"pod_name": "app"},
+	}, decoded)
+}
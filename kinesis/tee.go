@@ -0,0 +1,86 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/util"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const defaultTeeRecordsPerSecond = 100
+
+// teeLine is the NDJSON representation of a record written to the tee
+type teeLine struct {
+	Stream       string `json:"stream"`
+	PartitionKey string `json:"partition_key"`
+	// Data holds the payload when it is valid UTF-8, otherwise DataBase64 holds it base64 encoded
+	Data       *string `json:"data,omitempty"`
+	DataBase64 []byte  `json:"data_base64,omitempty"`
+}
+
+// recordTee copies outgoing records to a writer, for debugging what is sent to Kinesis.
+// It is rate limited so that it can't drown the console; records over the limit are not teed.
+type recordTee struct {
+	mutex   sync.Mutex
+	writer  io.Writer
+	limiter *util.TokenBucket
+}
+
+func newRecordTee(writer io.Writer, recordsPerSecond int) *recordTee {
+	if recordsPerSecond <= 0 {
+		recordsPerSecond = defaultTeeRecordsPerSecond
+	}
+	return &recordTee{
+		writer:  writer,
+		limiter: util.NewTokenBucket(recordsPerSecond, time.Second),
+	}
+}
+
+// write tees each record as a line of JSON, it returns the number of records teed
+func (t *recordTee) write(stream string, records []*kinesis.PutRecordsRequestEntry) int {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	teed := 0
+	for _, record := range records {
+		if !t.limiter.Take() {
+			break
+		}
+		line := teeLine{
+			Stream:       stream,
+			PartitionKey: aws.StringValue(record.PartitionKey),
+		}
+		if utf8.Valid(record.Data) {
+			line.Data = aws.String(string(record.Data))
+		} else {
+			line.DataBase64 = record.Data
+		}
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			continue
+		}
+		t.writer.Write(append(encoded, '\n'))
+		teed++
+	}
+	return teed
+}
@@ -0,0 +1,72 @@
+package kinesis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis/mock_kinesis"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	fluentbit "github.com/fluent/fluent-bit-go/output"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeeMatchesSentPayloads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockKinesis := mock_kinesis.NewMockPutRecordsClient(ctrl)
+
+	var sent []*kinesis.PutRecordsRequestEntry
+	mockKinesis.EXPECT().PutRecords(gomock.Any()).DoAndReturn(
+		func(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+			sent = append(sent, input.Records...)
+			return &kinesis.PutRecordsOutput{
+				FailedRecordCount: aws.Int64(0),
+			}, nil
+		})
+
+	var teed bytes.Buffer
+	outputPlugin, _ := newMockOutputPlugin(mockKinesis, false)
+	outputPlugin.tee = newRecordTee(&teed, 100)
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte(`{"log":"first"}`), PartitionKey: aws.String("a")},
+		{Data: []byte{0x1f, 0x8b, 0xff}, PartitionKey: aws.String("b")},
+	}
+	retCode := outputPlugin.Flush(&records)
+	assert.Equal(t, fluentbit.FLB_OK, retCode)
+
+	scanner := bufio.NewScanner(&teed)
+	var lines []teeLine
+	for scanner.Scan() {
+		var line teeLine
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	assert.Len(t, lines, len(sent))
+	for i, record := range sent {
+		assert.Equal(t, "stream", lines[i].Stream)
+		assert.Equal(t, aws.StringValue(record.PartitionKey), lines[i].PartitionKey)
+		if lines[i].Data != nil {
+			assert.Equal(t, string(record.Data), *lines[i].Data)
+		} else {
+			assert.Equal(t, record.Data, lines[i].DataBase64, "Expected binary data to be base64 encoded")
+		}
+	}
+}
+
+func TestTeeRateLimit(t *testing.T) {
+	var teed bytes.Buffer
+	tee := newRecordTee(&teed, 2)
+
+	records := []*kinesis.PutRecordsRequestEntry{
+		{Data: []byte("1"), PartitionKey: aws.String("a")},
+		{Data: []byte("2"), PartitionKey: aws.String("a")},
+		{Data: []byte("3"), PartitionKey: aws.String("a")},
+	}
+	assert.Equal(t, 2, tee.write("stream", records))
+	assert.Equal(t, 2, bytes.Count(teed.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,42 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/strftime"
+)
+
+const (
+	// TimeFmtEpochNanos formats the time_key as an integer count of nanoseconds since the unix epoch
+	TimeFmtEpochNanos = "epoch_nanos"
+)
+
+// nanoseconds appends the zero-padded, 9 digit nanoseconds of the time, like %N in GNU date
+var nanoseconds = strftime.AppendFunc(func(b []byte, t time.Time) []byte {
+	return append(b, fmt.Sprintf("%09d", t.Nanosecond())...)
+})
+
+// newTimeFormatter parses a strftime format for the time_key. On top of the standard
+// specifiers, %L, %f and %N give the milliseconds, microseconds and nanoseconds of
+// the time, and %s gives the seconds since the unix epoch.
+func newTimeFormatter(format string) (*strftime.Strftime, error) {
+	return strftime.New(format,
+		strftime.WithMilliseconds('L'),
+		strftime.WithMicroseconds('f'),
+		strftime.WithSpecification('N', nanoseconds),
+		strftime.WithUnixSeconds('s'))
+}
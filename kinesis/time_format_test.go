@@ -0,0 +1,86 @@
+package kinesis
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeFormatterFractionalSeconds(t *testing.T) {
+	timeStamp := time.Date(2023, 4, 5, 6, 7, 8, 123456789, time.UTC)
+
+	for format, expected := range map[string]string{
+		"%Y-%m-%dT%H:%M:%S.%L": "2023-04-05T06:07:08.123",
+		"%Y-%m-%dT%H:%M:%S.%f": "2023-04-05T06:07:08.123456",
+		"%Y-%m-%dT%H:%M:%S.%N": "2023-04-05T06:07:08.123456789",
+		"%s.%N":                "1680674828.123456789",
+	} {
+		formatter, err := newTimeFormatter(format)
+		assert.NoError(t, err)
+		buf := new(bytes.Buffer)
+		assert.NoError(t, formatter.Format(buf, timeStamp))
+		assert.Equal(t, expected, buf.String(), format)
+	}
+
+	// leading zeros are kept
+	formatter, _ := newTimeFormatter("%N")
+	buf := new(bytes.Buffer)
+	formatter.Format(buf, time.Unix(0, 42))
+	assert.Equal(t, "000000042", buf.String())
+}
+
+func TestTimeKeyNanoseconds(t *testing.T) {
+	timeStamp := time.Unix(1680674828, 123456789)
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 2)
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.timeKey = "time"
+	outputPlugin.fmtStrftime, _ = newTimeFormatter("%s.%N")
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": "a"}, &timeStamp)
+
+	outputPlugin.fmtStrftime = nil
+	outputPlugin.timeKeyEpochNanos = true
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": "b"}, &timeStamp)
+
+	var formatted, epochNanos map[string]interface{}
+	assert.NoError(t, json.Unmarshal(records[0].Data, &formatted))
+	assert.Equal(t, "1680674828.123456789", formatted["time"])
+
+	decoder := json.NewDecoder(bytes.NewReader(records[1].Data))
+	decoder.UseNumber()
+	assert.NoError(t, decoder.Decode(&epochNanos))
+	assert.Equal(t, json.Number("1680674828123456789"), epochNanos["time"])
+}
+
+func TestTimeKeyTimezone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if !assert.NoError(t, err) {
+		return
+	}
+	timeStamp := time.Unix(1680674828, 123000000)
+
+	for location, expected := range map[*time.Location]string{
+		time.UTC: "2023-04-05T06:07:08.123+0000",
+		newYork:  "2023-04-05T02:07:08.123-0400",
+	} {
+		outputPlugin, _ := newMockOutputPlugin(nil, false)
+		outputPlugin.timeKey = "time"
+		outputPlugin.fmtStrftime, _ = newTimeFormatter("%Y-%m-%dT%H:%M:%S.%L%z")
+		outputPlugin.timeKeyLocation = location
+
+		records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+			"log": []byte("one"),
+		}, &timeStamp)
+		if !assert.Len(t, records, 1) {
+			continue
+		}
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal(records[0].Data, &record))
+		assert.Equal(t, expected, record["time"], location.String())
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lestrrat-go/strftime"
+)
+
+const (
+	// TimestampFormatRFC3339 parses RFC 3339 timestamps, with or without fractional seconds
+	TimestampFormatRFC3339 = "rfc3339"
+	// TimestampFormatEpoch parses seconds since the unix epoch, which may have a fraction
+	TimestampFormatEpoch = "epoch"
+	// TimestampFormatEpochMillis parses milliseconds since the unix epoch
+	TimestampFormatEpochMillis = "epoch_ms"
+)
+
+// DefaultTimestampFormats are the formats accepted if timestamp_formats is not set
+var DefaultTimestampFormats = []string{TimestampFormatRFC3339, TimestampFormatEpoch}
+
+// timestampNormalizer replaces the first of several timestamp fields a record has with
+// a single field in a single format
+type timestampNormalizer struct {
+	sourceKeys []string
+	targetKey  string
+	// rfc3339, epoch, epoch_ms, or a Go time layout
+	formats []string
+	// nil if the target is formatted as epoch_nanos
+	formatter      *strftime.Strftime
+	failuresLogged int32
+}
+
+func newTimestampNormalizer(sourceKeys []string, targetKey string, formats []string, targetFormat string) (*timestampNormalizer, error) {
+	if len(sourceKeys) == 0 || targetKey == "" {
+		return nil, fmt.Errorf("both source keys and a target key are required")
+	}
+	if len(formats) == 0 {
+		formats = DefaultTimestampFormats
+	}
+	n := &timestampNormalizer{
+		sourceKeys: sourceKeys,
+		targetKey:  targetKey,
+		formats:    formats,
+	}
+	if targetFormat == "" {
+		targetFormat = defaultTimeFmt
+	}
+	if targetFormat != TimeFmtEpochNanos {
+		formatter, err := newTimeFormatter(targetFormat)
+		if err != nil {
+			return nil, err
+		}
+		n.formatter = formatter
+	}
+	return n, nil
+}
+
+// parse parses a timestamp value with the first format which accepts it
+func (n *timestampNormalizer) parse(value interface{}) (time.Time, error) {
+	var str string
+	var number float64
+	isNumber := true
+	switch v := value.(type) {
+	case []byte, string:
+		str = stringOrByteArray(v)
+		var err error
+		number, err = strconv.ParseFloat(str, 64)
+		isNumber = err == nil
+	case int64:
+		number = float64(v)
+	case uint64:
+		number = float64(v)
+	case int:
+		number = float64(v)
+	case float64:
+		number = v
+	default:
+		return time.Time{}, fmt.Errorf("unsupported timestamp type %T", value)
+	}
+
+	for _, format := range n.formats {
+		switch format {
+		case TimestampFormatEpoch, TimestampFormatEpochMillis:
+			if !isNumber {
+				continue
+			}
+			unit := time.Second
+			if format == TimestampFormatEpochMillis {
+				unit = time.Millisecond
+			}
+			whole, fraction := math.Modf(number)
+			return time.Unix(0, 0).Add(time.Duration(whole)*unit + time.Duration(math.Round(fraction*float64(unit)))).UTC(), nil
+		case TimestampFormatRFC3339:
+			if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+				return t, nil
+			}
+		default:
+			if t, err := time.Parse(format, str); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%v' does not match any of the formats %s", str, strings.Join(n.formats, ", "))
+}
+
+// normalizeTimestamp sets the target key from the first source key the record has, removing
+// the source keys. If the record has none, or it can't be parsed, the event time is used
+// instead and the source keys are kept.
+func (outputPlugin *OutputPlugin) normalizeTimestamp(record map[interface{}]interface{}, eventTime time.Time) error {
+	n := outputPlugin.timestampNormalizer
+	t := eventTime
+	for _, key := range n.sourceKeys {
+		value := getFromMap(key, record)
+		if value == "" {
+			continue
+		}
+		parsed, err := n.parse(value)
+		if err != nil {
+			if atomic.CompareAndSwapInt32(&n.failuresLogged, 0, 1) {
+				outputPlugin.logger.Warnf("Could not parse timestamp key %s, using the event time instead. Further failures are only logged at debug level: %v\n", key, err)
+			} else {
+				outputPlugin.logger.Debugf("Could not parse timestamp key %s, using the event time instead: %v\n", key, err)
+			}
+			break
+		}
+		t = parsed
+		for k := range record {
+			for _, sourceKey := range n.sourceKeys {
+				if stringOrByteArray(k) == sourceKey {
+					delete(record, k)
+				}
+			}
+		}
+		break
+	}
+
+	if n.formatter == nil {
+		record[n.targetKey] = t.UnixNano()
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := n.formatter.Format(buf, t.UTC()); err != nil {
+		return err
+	}
+	record[n.targetKey] = buf.String()
+	return nil
+}
@@ -0,0 +1,68 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTimestamp(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	var err error
+	outputPlugin.timestampNormalizer, err = newTimestampNormalizer([]string{"@timestamp", "ts", "time"}, "timestamp",
+		[]string{TimestampFormatRFC3339, TimestampFormatEpoch, "02/Jan/2006:15:04:05 -0700"}, "%Y-%m-%dT%H:%M:%S.%LZ")
+	if !assert.NoError(t, err) {
+		return
+	}
+	eventTime := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		record   map[interface{}]interface{}
+		expected map[interface{}]interface{}
+	}{
+		{
+			name:     "@timestamp",
+			record:   map[interface{}]interface{}{"@timestamp": []byte("2021-03-04T05:06:07.123+01:00"), "log": "a"},
+			expected: map[interface{}]interface{}{"timestamp": "2021-03-04T04:06:07.123Z", "log": "a"},
+		},
+		{
+			name:     "ts",
+			record:   map[interface{}]interface{}{"ts": 1614834367.5, "log": "a"},
+			expected: map[interface{}]interface{}{"timestamp": "2021-03-04T05:06:07.500Z", "log": "a"},
+		},
+		{
+			name:     "time",
+			record:   map[interface{}]interface{}{"time": "04/Mar/2021:05:06:07 +0000", "log": "a"},
+			expected: map[interface{}]interface{}{"timestamp": "2021-03-04T05:06:07.000Z", "log": "a"},
+		},
+		{
+			name:     "first present key wins and every source key is removed",
+			record:   map[interface{}]interface{}{"ts": int64(1614834367), "time": "04/Mar/2021:00:00:00 +0000"},
+			expected: map[interface{}]interface{}{"timestamp": "2021-03-04T05:06:07.000Z"},
+		},
+		{
+			name:     "parse failure falls back to the event time and keeps the field",
+			record:   map[interface{}]interface{}{"@timestamp": []byte("yesterday"), "log": "a"},
+			expected: map[interface{}]interface{}{"@timestamp": []byte("yesterday"), "timestamp": "2023-11-14T22:13:20.000Z", "log": "a"},
+		},
+		{
+			name:     "no source key falls back to the event time",
+			record:   map[interface{}]interface{}{"log": "a"},
+			expected: map[interface{}]interface{}{"timestamp": "2023-11-14T22:13:20.000Z", "log": "a"},
+		},
+	} {
+		assert.NoError(t, outputPlugin.normalizeTimestamp(tc.record, eventTime), tc.name)
+		assert.Equal(t, tc.expected, tc.record, tc.name)
+	}
+}
+
+func TestNormalizeTimestampEpochNanos(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.timestampNormalizer, _ = newTimestampNormalizer([]string{"ts"}, "ts", []string{TimestampFormatEpochMillis}, TimeFmtEpochNanos)
+
+	record := map[interface{}]interface{}{"ts": []byte("1614834367123")}
+	assert.NoError(t, outputPlugin.normalizeTimestamp(record, time.Now()))
+	assert.Equal(t, map[interface{}]interface{}{"ts": int64(1614834367123000000)}, record)
+}
@@ -0,0 +1,72 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"time"
+)
+
+// TimestampUnit indicates how integer record timestamps are interpreted
+type TimestampUnit string
+
+const (
+	// TimestampUnitAuto guesses the unit of each timestamp from its magnitude
+	TimestampUnitAuto TimestampUnit = "auto"
+	// TimestampUnitSeconds interprets integer timestamps as seconds since the epoch
+	TimestampUnitSeconds TimestampUnit = "s"
+	// TimestampUnitMilliseconds interprets integer timestamps as milliseconds since the epoch
+	TimestampUnitMilliseconds TimestampUnit = "ms"
+	// TimestampUnitMicroseconds interprets integer timestamps as microseconds since the epoch
+	TimestampUnitMicroseconds TimestampUnit = "us"
+	// TimestampUnitNanoseconds interprets integer timestamps as nanoseconds since the epoch
+	TimestampUnitNanoseconds TimestampUnit = "ns"
+)
+
+// Upper bounds used by TimestampUnitAuto. Each unit covers the years 1973 to 5138, so
+// any current timestamp falls in exactly one range.
+const (
+	maxAutoSeconds      = 1e11
+	maxAutoMilliseconds = 1e14
+	maxAutoMicroseconds = 1e17
+)
+
+// Time converts an integer timestamp since the epoch in this unit to a time
+func (unit TimestampUnit) Time(ts uint64) time.Time {
+	switch unit {
+	case TimestampUnitAuto:
+		switch {
+		case ts < maxAutoSeconds:
+			return TimestampUnitSeconds.Time(ts)
+		case ts < maxAutoMilliseconds:
+			return TimestampUnitMilliseconds.Time(ts)
+		case ts < maxAutoMicroseconds:
+			return TimestampUnitMicroseconds.Time(ts)
+		default:
+			return TimestampUnitNanoseconds.Time(ts)
+		}
+	case TimestampUnitMilliseconds:
+		return time.Unix(int64(ts/1e3), int64(ts%1e3)*int64(time.Millisecond))
+	case TimestampUnitMicroseconds:
+		return time.Unix(int64(ts/1e6), int64(ts%1e6)*int64(time.Microsecond))
+	case TimestampUnitNanoseconds:
+		return time.Unix(int64(ts/1e9), int64(ts%1e9))
+	default:
+		return time.Unix(int64(ts), 0)
+	}
+}
+
+// IntegerTimestamp converts an integer record timestamp to a time, using the configured timestamp_unit
+func (outputPlugin *OutputPlugin) IntegerTimestamp(ts uint64) time.Time {
+	return outputPlugin.timestampUnit.Time(ts)
+}
@@ -0,0 +1,55 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampUnit(t *testing.T) {
+	expected := time.Unix(1600000000, 123456789)
+	tests := []struct {
+		unit     TimestampUnit
+		ts       uint64
+		expected time.Time
+	}{
+		{TimestampUnitSeconds, 1600000000, time.Unix(1600000000, 0)},
+		{"", 1600000000, time.Unix(1600000000, 0)},
+		{TimestampUnitMilliseconds, 1600000000123, expected.Truncate(time.Millisecond)},
+		{TimestampUnitMicroseconds, 1600000000123456, expected.Truncate(time.Microsecond)},
+		{TimestampUnitNanoseconds, 1600000000123456789, expected},
+	}
+	for _, test := range tests {
+		assert.True(t, test.expected.Equal(test.unit.Time(test.ts)), "Expected %v for %d in unit '%s', got %v", test.expected, test.ts, test.unit, test.unit.Time(test.ts))
+	}
+}
+
+func TestTimestampUnitAuto(t *testing.T) {
+	expected := time.Unix(1600000000, 123456789)
+	tests := []struct {
+		ts       uint64
+		expected time.Time
+	}{
+		{0, time.Unix(0, 0)},
+		{1600000000, time.Unix(1600000000, 0)},
+		{1600000000123, expected.Truncate(time.Millisecond)},
+		{1600000000123456, expected.Truncate(time.Microsecond)},
+		{1600000000123456789, expected},
+		// the last second and first millisecond of the ranges
+		{99999999999, time.Unix(99999999999, 0)},
+		{100000000000, time.Unix(100000000, 0)},
+	}
+	for _, test := range tests {
+		actual := TimestampUnitAuto.Time(test.ts)
+		assert.True(t, test.expected.Equal(actual), "Expected %v for %d, got %v", test.expected, test.ts, actual)
+	}
+}
+
+func TestIntegerTimestamp(t *testing.T) {
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	assert.True(t, time.Unix(1600000000, 0).Equal(outputPlugin.IntegerTimestamp(1600000000)), "Expected seconds by default")
+
+	outputPlugin.timestampUnit = TimestampUnitMilliseconds
+	assert.True(t, time.Unix(1600000000, 0).Equal(outputPlugin.IntegerTimestamp(1600000000000)))
+}
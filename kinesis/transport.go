@@ -0,0 +1,81 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newHTTPClient creates the HTTP client shared by the Kinesis and STS clients
+func newHTTPClient(config *OutputPluginConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = newProxyFunc(config.HTTPProxy, config.HTTPSProxy, config.NoProxy)
+	if config.MaxIdleConns > 0 {
+		// all requests go to the same Kinesis host, so allow it to use the whole pool
+		transport.MaxIdleConns = config.MaxIdleConns
+		transport.MaxIdleConnsPerHost = config.MaxIdleConns
+	}
+	if config.HTTPTimeout > 0 {
+		// bounds each stage of a single attempt, unlike HTTPRequestTimeout which bounds the whole request
+		transport.DialContext = (&net.Dialer{
+			Timeout:   config.HTTPTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = config.HTTPTimeout
+		transport.ResponseHeaderTimeout = config.HTTPTimeout
+	}
+	if config.TLSMinVersion != 0 || len(config.TLSCipherSuites) > 0 {
+		// used for the STS and EKS Pod Identity requests too, which share the client
+		tlsConfig := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		}
+		tlsConfig.MinVersion = config.TLSMinVersion
+		tlsConfig.CipherSuites = config.TLSCipherSuites
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Timeout:   config.HTTPRequestTimeout,
+		Transport: transport,
+	}
+}
+
+// newProxyFunc returns the proxy selection function for the HTTP transport.
+// Explicitly configured values take precedence over the standard HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables; when neither is set, requests
+// are sent directly.
+func newProxyFunc(httpProxy, httpsProxy, noProxy string) func(*http.Request) (*url.URL, error) {
+	proxyConfig := httpproxy.FromEnvironment()
+	if httpProxy != "" {
+		proxyConfig.HTTPProxy = httpProxy
+	}
+	if httpsProxy != "" {
+		proxyConfig.HTTPSProxy = httpsProxy
+	}
+	if noProxy != "" {
+		proxyConfig.NoProxy = noProxy
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
@@ -0,0 +1,192 @@
+package kinesis
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsclient "github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutRecordsThroughProxy(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// requests sent through a proxy carry the absolute URL of the target
+		proxiedHost = r.URL.Host
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"FailedRecordCount":0,"Records":[{"SequenceNumber":"1","ShardId":"shardId-000000000000"}]}`))
+	}))
+	defer proxy.Close()
+
+	httpClient := newHTTPClient(&OutputPluginConfig{
+		HTTPProxy: proxy.URL,
+	})
+	client, err := newPutRecordsClient("", "", "us-west-2", "http://kinesis.example.test", "", "", false, aws.UseServiceDefaultRetries, 0, newPluginLogger(0, "stream", "us-west-2"), httpClient)
+	assert.NoError(t, err)
+
+	_, err = client.PutRecords(&kinesis.PutRecordsInput{
+		StreamName: aws.String("stream"),
+		Records: []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("data"), PartitionKey: aws.String("key")},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "kinesis.example.test", proxiedHost, "Expected request to be sent through the proxy")
+}
+
+func TestSigningRegion(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"FailedRecordCount":0,"Records":[{"SequenceNumber":"1","ShardId":"shardId-000000000000"}]}`))
+	}))
+	defer server.Close()
+
+	input := &kinesis.PutRecordsInput{
+		StreamName: aws.String("stream"),
+		Records: []*kinesis.PutRecordsRequestEntry{
+			{Data: []byte("data"), PartitionKey: aws.String("key")},
+		},
+	}
+	logger := newPluginLogger(0, "stream", "ca-central-1")
+
+	client, err := newPutRecordsClient("", "", "ca-central-1", server.URL, "eu-west-1", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	_, err = client.PutRecords(input)
+	assert.NoError(t, err)
+	assert.Contains(t, authorization, "/eu-west-1/kinesis/aws4_request", "Expected the request to be signed for the signing region")
+
+	client, err = newPutRecordsClient("", "", "ca-central-1", server.URL, "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	_, err = client.PutRecords(input)
+	assert.NoError(t, err)
+	assert.Contains(t, authorization, "/ca-central-1/kinesis/aws4_request", "Expected the request to be signed for the region by default")
+
+	client, err = newPutRecordsClient("", "", "ca-central-1", "", "eu-west-1", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", client.SigningRegion)
+	assert.Equal(t, "https://kinesis.ca-central-1.amazonaws.com", client.Endpoint, "Expected the endpoint to still be resolved from the region")
+}
+
+func TestProxyFunc(t *testing.T) {
+	for _, env := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+
+	req, _ := http.NewRequest("POST", "http://kinesis.us-west-2.amazonaws.com", nil)
+
+	// fall back to the environment when unset
+	proxyURL, err := newProxyFunc("", "", "")(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "env-proxy:3128", proxyURL.Host)
+
+	// explicit config takes precedence over the environment
+	proxyURL, err = newProxyFunc("http://config-proxy:8080", "", "")(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "config-proxy:8080", proxyURL.Host)
+
+	// hosts matching no_proxy are sent directly
+	proxyURL, err = newProxyFunc("http://config-proxy:8080", "", ".amazonaws.com")(req)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyURL)
+
+	// no proxy when neither the config nor the environment set one
+	t.Setenv("HTTP_PROXY", "")
+	proxyURL, err = newProxyFunc("", "", "")(req)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyURL)
+}
+
+func TestHTTPClientDefaults(t *testing.T) {
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+
+	httpClient := newHTTPClient(&OutputPluginConfig{})
+	transport := httpClient.Transport.(*http.Transport)
+	assert.Equal(t, time.Duration(0), httpClient.Timeout)
+	assert.Equal(t, defaultTransport.MaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(t, defaultTransport.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultTransport.TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	assert.Equal(t, defaultTransport.ResponseHeaderTimeout, transport.ResponseHeaderTimeout)
+}
+
+func TestHTTPClientConfig(t *testing.T) {
+	httpClient := newHTTPClient(&OutputPluginConfig{
+		HTTPRequestTimeout: time.Minute,
+		MaxIdleConns:       64,
+		HTTPTimeout:        5 * time.Second,
+	})
+	transport := httpClient.Transport.(*http.Transport)
+	assert.Equal(t, time.Minute, httpClient.Timeout)
+	assert.Equal(t, 64, transport.MaxIdleConns)
+	assert.Equal(t, 64, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 5*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 5*time.Second, transport.ResponseHeaderTimeout)
+}
+
+func TestSDKMaxRetries(t *testing.T) {
+	logger := newPluginLogger(0, "stream", "us-west-2")
+
+	client, err := newPutRecordsClient("", "", "us-west-2", "", "", "", false, 7, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, client.MaxRetries())
+
+	client, err = newPutRecordsClient("arn:aws:iam::123456789012:role/test", "", "us-west-2", "", "", "", false, 0, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, client.MaxRetries(), "Expected SDK retries to be configurable when assuming a role")
+
+	client, err = newPutRecordsClient("", "", "us-west-2", "", "", "", false, aws.UseServiceDefaultRetries, 0, logger, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, awsclient.DefaultRetryerMaxNumRetries, client.MaxRetries(), "Expected the SDK default when unset")
+}
+
+func TestTLSSettings(t *testing.T) {
+	transport := newHTTPClient(&OutputPluginConfig{}).Transport.(*http.Transport)
+	if transport.TLSClientConfig != nil {
+		assert.Zero(t, transport.TLSClientConfig.MinVersion, "Expected the default TLS settings when none are configured")
+		assert.Nil(t, transport.TLSClientConfig.CipherSuites, "Expected the default TLS settings when none are configured")
+	}
+
+	transport = newHTTPClient(&OutputPluginConfig{
+		TLSMinVersion:   tls.VersionTLS12,
+		TLSCipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}).Transport.(*http.Transport)
+	if assert.NotNil(t, transport.TLSClientConfig) {
+		assert.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, transport.TLSClientConfig.CipherSuites)
+	}
+
+	// a TLS 1.2 server is accepted with TLS 1.2 as the minimum, and refused with TLS 1.3
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	rootCAs := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	transport.TLSClientConfig.RootCAs = rootCAs
+	response, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, uint16(tls.VersionTLS12), response.TLS.Version)
+	}
+
+	transport = newHTTPClient(&OutputPluginConfig{TLSMinVersion: tls.VersionTLS13}).Transport.(*http.Transport)
+	transport.TLSClientConfig.RootCAs = rootCAs
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultValidateStreamTimeout is how long stream validation is retried by default
+	DefaultValidateStreamTimeout  = 30 * time.Second
+	validateStreamInitialInterval = 200 * time.Millisecond
+	validateStreamMaxInterval     = 5 * time.Second
+)
+
+// streamDescriber is implemented by Kinesis clients which can describe a stream
+type streamDescriber interface {
+	DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error)
+}
+
+// errors which retrying validation won't fix
+var permanentValidationErrorCodes = map[string]bool{
+	kinesis.ErrCodeResourceNotFoundException: true,
+	kinesis.ErrCodeInvalidArgumentException:  true,
+	"AccessDeniedException":                  true,
+}
+
+// validateStream checks the stream exists and can be written to with DescribeStreamSummary.
+// Transient failures, such as throttling, and streams which are still being created are
+// retried with backoff until the timeout passes. Errors which retrying won't fix, such as
+// a missing stream, are returned right away.
+func validateStream(describer streamDescriber, stream string, timeout time.Duration, logger *logrus.Entry) error {
+	deadline := time.Now().Add(timeout)
+	interval := validateStreamInitialInterval
+	for attempt := 1; ; attempt++ {
+		retryable, err := describeStream(describer, stream)
+		if err == nil {
+			return nil
+		}
+		if !retryable {
+			return err
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			return fmt.Errorf("stream validation did not succeed within %s after %d attempts: %v", timeout, attempt, err)
+		}
+		logger.Warnf("Failed to validate stream, will try again in %s: %v\n", interval, err)
+		retrySleep(interval)
+		interval *= 2
+		if interval > validateStreamMaxInterval {
+			interval = validateStreamMaxInterval
+		}
+	}
+}
+
+// describeStream returns an error unless the stream is active or being updated, which
+// is when it accepts records, along with whether the error is worth retrying
+func describeStream(describer streamDescriber, stream string) (bool, error) {
+	output, err := describer.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(stream),
+	})
+	if err != nil {
+		aerr, ok := err.(awserr.Error)
+		return !ok || !permanentValidationErrorCodes[aerr.Code()], err
+	}
+	if output.StreamDescriptionSummary == nil {
+		return true, fmt.Errorf("DescribeStreamSummary returned no description of stream %s", stream)
+	}
+	switch status := aws.StringValue(output.StreamDescriptionSummary.StreamStatus); status {
+	case kinesis.StreamStatusActive, kinesis.StreamStatusUpdating:
+		return false, nil
+	case kinesis.StreamStatusCreating:
+		return true, fmt.Errorf("stream %s is still being created", stream)
+	default:
+		return false, fmt.Errorf("stream %s is %s", stream, status)
+	}
+}
+
+// streamDescriberOf returns the client stream validation uses, the primary client with
+// fanout_regions, or false if the client can't describe streams
+func streamDescriberOf(client PutRecordsClient) (streamDescriber, bool) {
+	if fanout, ok := client.(*fanoutClient); ok {
+		client = fanout.primary
+	}
+	describer, ok := client.(streamDescriber)
+	return describer, ok
+}
@@ -0,0 +1,103 @@
+package kinesis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStreamDescriber returns its errors in turn, then describes the stream with the status
+type fakeStreamDescriber struct {
+	errs   []error
+	status string
+	calls  int
+}
+
+func (d *fakeStreamDescriber) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	d.calls++
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return nil, err
+	}
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &kinesis.StreamDescriptionSummary{
+			StreamName:   input.StreamName,
+			StreamStatus: aws.String(d.status),
+		},
+	}, nil
+}
+
+func TestValidateStreamRetriesTransientFailures(t *testing.T) {
+	var delays []time.Duration
+	retrySleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { retrySleep = time.Sleep }()
+
+	describer := &fakeStreamDescriber{
+		errs: []error{
+			awserr.New(kinesis.ErrCodeLimitExceededException, "Rate exceeded", nil),
+			awserr.New(kinesis.ErrCodeLimitExceededException, "Rate exceeded", nil),
+		},
+		status: kinesis.StreamStatusActive,
+	}
+	err := validateStream(describer, "stream", time.Minute, newPluginLogger(0, "stream", "us-east-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, describer.calls, "Expected validation to succeed on the third attempt")
+	assert.Equal(t, []time.Duration{200 * time.Millisecond, 400 * time.Millisecond}, delays, "Expected the retries to back off")
+}
+
+func TestValidateStreamPermanentFailure(t *testing.T) {
+	retrySleep = func(time.Duration) {}
+	defer func() { retrySleep = time.Sleep }()
+
+	describer := &fakeStreamDescriber{
+		errs: []error{awserr.New(kinesis.ErrCodeResourceNotFoundException, "Stream stream not found", nil)},
+	}
+	err := validateStream(describer, "stream", time.Minute, newPluginLogger(0, "stream", "us-east-1"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, describer.calls, "Expected a missing stream not to be retried")
+
+	describer = &fakeStreamDescriber{status: kinesis.StreamStatusDeleting}
+	err = validateStream(describer, "stream", time.Minute, newPluginLogger(0, "stream", "us-east-1"))
+	assert.EqualError(t, err, "stream stream is DELETING")
+	assert.Equal(t, 1, describer.calls)
+}
+
+func TestValidateStreamTimeout(t *testing.T) {
+	var slept time.Duration
+	retrySleep = func(d time.Duration) { slept += d }
+	defer func() { retrySleep = time.Sleep }()
+
+	errs := make([]error, 100)
+	for i := range errs {
+		errs[i] = errors.New("connection reset by peer")
+	}
+	describer := &fakeStreamDescriber{errs: errs}
+	err := validateStream(describer, "stream", 2*time.Millisecond, newPluginLogger(0, "stream", "us-east-1"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, describer.calls, "Expected no retry which would outlast the timeout")
+	assert.Equal(t, time.Duration(0), slept)
+}
+
+func TestStreamDescriberOfFanout(t *testing.T) {
+	primary := &describingClient{}
+	describer, ok := streamDescriberOf(newFanoutClient(primary, "stream", nil, 0, newPluginLogger(0, "stream", "us-east-1")))
+	assert.True(t, ok)
+	assert.Equal(t, primary, describer, "Expected the primary client to validate the stream")
+
+	_, ok = streamDescriberOf(&firehoseClient{})
+	assert.False(t, ok)
+}
+
+type describingClient struct {
+	fakeStreamDescriber
+}
+
+func (c *describingClient) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	return &kinesis.PutRecordsOutput{}, nil
+}
@@ -0,0 +1,19 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+// PluginVersion is the version of the plugin build, which version_key adds to records.
+// Release builds set it from the VERSION file with
+// -ldflags "-X github.com/aws/amazon-kinesis-streams-for-fluent-bit/kinesis.PluginVersion=<version>"
+var PluginVersion = "dev"
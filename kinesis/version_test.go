@@ -0,0 +1,31 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionKey(t *testing.T) {
+	defer func(version string) { PluginVersion = version }(PluginVersion)
+	// as if the build had set it with -ldflags -X
+	PluginVersion = "1.2.3-test"
+
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.versionKey = "plugin_version"
+
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, 1)
+	timeStamp := time.Now()
+	outputPlugin.AddRecord(&records, map[interface{}]interface{}{
+		"log": []byte("hello"),
+	}, &timeStamp)
+
+	if assert.Len(t, records, 1) {
+		var decoded map[string]interface{}
+		assert.NoError(t, json.Unmarshal(records[0].Data, &decoded))
+		assert.Equal(t, "1.2.3-test", decoded["plugin_version"])
+	}
+}
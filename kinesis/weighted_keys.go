@@ -0,0 +1,71 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// PartitionKeySourceWeighted picks each partition key at random from weighted_partition_keys,
+// in proportion to the weight of each key
+const PartitionKeySourceWeighted PartitionKeySource = "weighted"
+
+// WeightedPartitionKey is a partition key with the relative number of records sent with it
+type WeightedPartitionKey struct {
+	Key    string
+	Weight int
+}
+
+// weightedKeys picks partition keys at random in proportion to their weights
+type weightedKeys struct {
+	keys []string
+	// the running total of the weights up to and including each key
+	cumulative []int64
+}
+
+func newWeightedKeys(weighted []WeightedPartitionKey) (*weightedKeys, error) {
+	if len(weighted) == 0 {
+		return nil, fmt.Errorf("weighted_partition_keys must list at least one key")
+	}
+	w := &weightedKeys{}
+	seen := make(map[string]bool, len(weighted))
+	var total int64
+	for _, k := range weighted {
+		if k.Key == "" || len(k.Key) > 256 {
+			return nil, fmt.Errorf("weighted partition key '%s' must be between 1 and 256 characters", k.Key)
+		}
+		if seen[k.Key] {
+			return nil, fmt.Errorf("weighted partition key '%s' is listed more than once", k.Key)
+		}
+		seen[k.Key] = true
+		if k.Weight <= 0 {
+			return nil, fmt.Errorf("weight %d of partition key '%s' must be greater than 0", k.Weight, k.Key)
+		}
+		total += int64(k.Weight)
+		w.keys = append(w.keys, k.Key)
+		w.cumulative = append(w.cumulative, total)
+	}
+	return w, nil
+}
+
+// pick returns a key chosen at random by weight, it is goroutine safe
+func (w *weightedKeys) pick() string {
+	n := rand.Int63n(w.cumulative[len(w.cumulative)-1])
+	i := sort.Search(len(w.cumulative), func(i int) bool {
+		return w.cumulative[i] > n
+	})
+	return w.keys[i]
+}
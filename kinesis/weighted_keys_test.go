@@ -0,0 +1,84 @@
+package kinesis
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedPartitionKeys(t *testing.T) {
+	weighted, err := newWeightedKeys([]WeightedPartitionKey{
+		{Key: "canary", Weight: 1},
+		{Key: "main", Weight: 7},
+		{Key: "shadow", Weight: 2},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	outputPlugin, _ := newMockOutputPlugin(nil, false)
+	outputPlugin.partitionKeySource = PartitionKeySourceWeighted
+	outputPlugin.weightedKeys = weighted
+
+	const total = 100000
+	counts := make(map[string]int)
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, total)
+	timeStamp := time.Now()
+	for i := 0; i < total; i++ {
+		outputPlugin.AddRecord(&records, map[interface{}]interface{}{"log": []byte("event")}, &timeStamp)
+	}
+	for _, record := range records {
+		counts[aws.StringValue(record.PartitionKey)]++
+	}
+
+	assert.Len(t, counts, 3)
+	assert.InDelta(t, 0.1, float64(counts["canary"])/total, 0.01)
+	assert.InDelta(t, 0.7, float64(counts["main"])/total, 0.01)
+	assert.InDelta(t, 0.2, float64(counts["shadow"])/total, 0.01)
+}
+
+func TestWeightedPartitionKeysConcurrent(t *testing.T) {
+	weighted, _ := newWeightedKeys([]WeightedPartitionKey{
+		{Key: "canary", Weight: 1},
+		{Key: "main", Weight: 3},
+	})
+
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for i := 0; i < 10000; i++ {
+				local[weighted.pick()]++
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			for key, count := range local {
+				counts[key] += count
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 80000, counts["canary"]+counts["main"])
+	assert.InDelta(t, 0.25, float64(counts["canary"])/80000, 0.01)
+}
+
+func TestWeightedPartitionKeysValidation(t *testing.T) {
+	for name, keys := range map[string][]WeightedPartitionKey{
+		"no keys":       nil,
+		"zero weight":   {{Key: "a", Weight: 1}, {Key: "b", Weight: 0}},
+		"negative":      {{Key: "a", Weight: -1}},
+		"empty key":     {{Key: "", Weight: 1}},
+		"duplicate key": {{Key: "a", Weight: 1}, {Key: "a", Weight: 2}},
+	} {
+		_, err := newWeightedKeys(keys)
+		assert.Error(t, err, name)
+	}
+}
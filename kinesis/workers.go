@@ -0,0 +1,199 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package kinesis
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fluent/fluent-bit-go/output"
+)
+
+const (
+	// WorkerHashFNV routes partition keys to workers with 32 bit FNV-1a
+	WorkerHashFNV = "fnv"
+	// WorkerHashCRC32 routes partition keys to workers with CRC-32 (IEEE)
+	WorkerHashCRC32 = "crc32"
+
+	// number of batches each worker can have queued before flushes are retried
+	workerQueueSize = 8
+)
+
+// keyHashFunc maps a partition key to a worker
+type keyHashFunc func(key string) uint32
+
+func newKeyHashFunc(name string) (keyHashFunc, error) {
+	switch name {
+	case WorkerHashFNV, "":
+		return func(key string) uint32 {
+			hasher := fnv.New32a()
+			hasher.Write([]byte(key))
+			return hasher.Sum32()
+		}, nil
+	case WorkerHashCRC32:
+		return func(key string) uint32 {
+			return crc32.ChecksumIEEE([]byte(key))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported worker hash '%s', must be '%s' or '%s'", name, WorkerHashFNV, WorkerHashCRC32)
+	}
+}
+
+// flushWorkers sends records on a fixed set of goroutines, each with its own queue.
+// Every record for a partition key is routed to the same worker, and each worker
+// sends its batches one at a time, so records for a key are sent in order while
+// records for different keys are sent in parallel.
+type flushWorkers struct {
+	queues []chan []*kinesis.PutRecordsRequestEntry
+	hash   keyHashFunc
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newFlushWorkers starts count workers, which call flush for each batch routed to them
+func newFlushWorkers(count int, hash keyHashFunc, flush func(worker int, records []*kinesis.PutRecordsRequestEntry)) *flushWorkers {
+	w := &flushWorkers{
+		queues: make([]chan []*kinesis.PutRecordsRequestEntry, count),
+		hash:   hash,
+		stop:   make(chan struct{}),
+	}
+	for i := range w.queues {
+		w.queues[i] = make(chan []*kinesis.PutRecordsRequestEntry, workerQueueSize)
+		w.wg.Add(1)
+		go w.run(i, flush)
+	}
+	return w
+}
+
+func (w *flushWorkers) run(worker int, flush func(worker int, records []*kinesis.PutRecordsRequestEntry)) {
+	defer w.wg.Done()
+	for {
+		select {
+		case records := <-w.queues[worker]:
+			flush(worker, records)
+		case <-w.stop:
+			w.drain(worker, flush)
+			return
+		}
+	}
+}
+
+// route returns the worker which handles the partition key
+func (w *flushWorkers) route(partitionKey string) int {
+	return int(w.hash(partitionKey) % uint32(len(w.queues)))
+}
+
+// dispatch splits the records into a batch per worker, preserving their order.
+// Batches are only queued if every worker they route to has room, so a chunk is
+// either queued in full or not at all. dispatch must not be called concurrently.
+func (w *flushWorkers) dispatch(records []*kinesis.PutRecordsRequestEntry) bool {
+	batches := make(map[int][]*kinesis.PutRecordsRequestEntry)
+	for _, record := range records {
+		worker := w.route(aws.StringValue(record.PartitionKey))
+		batches[worker] = append(batches[worker], record)
+	}
+
+	for worker := range batches {
+		if len(w.queues[worker]) == cap(w.queues[worker]) {
+			return false
+		}
+	}
+	for worker, batch := range batches {
+		w.queues[worker] <- batch
+	}
+	return true
+}
+
+// drain flushes the batches left in a worker's queue once it is stopped
+func (w *flushWorkers) drain(worker int, flush func(worker int, records []*kinesis.PutRecordsRequestEntry)) {
+	for {
+		select {
+		case records := <-w.queues[worker]:
+			flush(worker, records)
+		default:
+			return
+		}
+	}
+}
+
+// close stops the workers once they have flushed every queued batch, waiting at most
+// timeout. If the workers are still busy by then, the batches none of them has started
+// are taken off the queues and returned, and drained is false.
+func (w *flushWorkers) close(timeout time.Duration) (queued []*kinesis.PutRecordsRequestEntry, drained bool) {
+	close(w.stop)
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil, true
+	case <-time.After(timeout):
+	}
+	for _, queue := range w.queues {
+		for taken := false; !taken; {
+			select {
+			case records := <-queue:
+				queued = append(queued, records...)
+			default:
+				taken = true
+			}
+		}
+	}
+	return queued, false
+}
+
+// DispatchToWorkers queues the records on the flush workers, keyed by partition key
+// Returns FLB_OK, or FLB_RETRY if a worker's queue is full
+func (outputPlugin *OutputPlugin) DispatchToWorkers(count int, records []*kinesis.PutRecordsRequestEntry) (retCode int) {
+	if outputPlugin.nothingToFlush(records) {
+		outputPlugin.logger.Debugf("No records to flush\n")
+		return output.FLB_OK
+	}
+	eventTimes := outputPlugin.latency.take(records)
+	defer func() { outputPlugin.observeLatency(eventTimes, retCode) }()
+	size := recordsSize(records)
+	if outputPlugin.bufferMaxBytes > 0 && outputPlugin.getInflightBytes()+size > outputPlugin.bufferMaxBytes {
+		outputPlugin.flushInfof("flush returning retry, buffer limit reached (%d bytes)\n", outputPlugin.getInflightBytes())
+		return output.FLB_RETRY
+	}
+
+	// each batch releases its own size once sent, see FlushWithRetries
+	outputPlugin.addInflightBytes(size)
+	dispatch := outputPlugin.workers.dispatch
+	if outputPlugin.preserveChunkOrder {
+		dispatch = outputPlugin.workers.dispatchChunk
+	}
+	if !dispatch(records) {
+		outputPlugin.addInflightBytes(-size)
+		outputPlugin.flushInfof("flush returning retry, worker queue full\n")
+		return output.FLB_RETRY
+	}
+	outputPlugin.logger.Debugf("Queued %d records on the flush workers\n", count)
+	return output.FLB_OK
+}
+
+// Workers returns the number of flush workers, or zero if records are not flushed by key
+func (outputPlugin *OutputPlugin) Workers() int {
+	if outputPlugin.workers == nil {
+		return 0
+	}
+	return len(outputPlugin.workers.queues)
+}
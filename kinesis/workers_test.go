@@ -0,0 +1,99 @@
+package kinesis
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkersKeepKeysOnOneWorker(t *testing.T) {
+	for _, hashName := range []string{WorkerHashFNV, WorkerHashCRC32} {
+		hash, err := newKeyHashFunc(hashName)
+		assert.NoError(t, err)
+
+		const chunks, recordsPerChunk = 50, 20
+		var mutex sync.Mutex
+		var sent sync.WaitGroup
+		sent.Add(chunks * recordsPerChunk)
+		workerForKey := make(map[string]int)
+		lastSeqForKey := make(map[string]int)
+
+		workers := newFlushWorkers(4, hash, func(worker int, records []*kinesis.PutRecordsRequestEntry) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, record := range records {
+				key := aws.StringValue(record.PartitionKey)
+				seq, _ := strconv.Atoi(string(record.Data))
+				if previous, ok := workerForKey[key]; ok {
+					assert.Equal(t, previous, worker, "Expected key %s to always be handled by the same worker", key)
+					assert.Greater(t, seq, lastSeqForKey[key], "Expected records for key %s to be sent in order", key)
+				}
+				workerForKey[key] = worker
+				lastSeqForKey[key] = seq
+				sent.Done()
+			}
+		})
+
+		seq := 0
+		for chunk := 0; chunk < chunks; chunk++ {
+			records := make([]*kinesis.PutRecordsRequestEntry, 0, recordsPerChunk)
+			for i := 0; i < recordsPerChunk; i++ {
+				seq++
+				records = append(records, &kinesis.PutRecordsRequestEntry{
+					Data:         []byte(strconv.Itoa(seq)),
+					PartitionKey: aws.String(fmt.Sprintf("key-%d", seq%13)),
+				})
+			}
+			for !workers.dispatch(records) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		sent.Wait()
+		workers.close(time.Minute)
+
+		usedWorkers := make(map[int]bool)
+		for _, worker := range workerForKey {
+			usedWorkers[worker] = true
+		}
+		assert.Len(t, workerForKey, 13)
+		assert.Greater(t, len(usedWorkers), 1, "Expected keys to be spread across workers with %s", hashName)
+	}
+}
+
+func TestWorkersDispatchAllOrNothing(t *testing.T) {
+	hash, _ := newKeyHashFunc(WorkerHashFNV)
+	block := make(chan struct{})
+	workers := newFlushWorkers(2, hash, func(worker int, records []*kinesis.PutRecordsRequestEntry) {
+		<-block
+	})
+
+	record := []*kinesis.PutRecordsRequestEntry{{Data: []byte("data"), PartitionKey: aws.String("key")}}
+	worker := workers.route("key")
+	// one batch is held by the blocked worker, the rest fill its queue
+	for i := 0; i <= workerQueueSize; i++ {
+		for !workers.dispatch(record) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.False(t, workers.dispatch(record), "Expected dispatch to fail once the worker's queue is full")
+	assert.Equal(t, workerQueueSize, len(workers.queues[worker]))
+
+	close(block)
+	workers.close(time.Minute)
+}
+
+func TestNewKeyHashFunc(t *testing.T) {
+	hash, err := newKeyHashFunc("")
+	assert.NoError(t, err, "Expected fnv to be the default")
+	assert.NotNil(t, hash)
+
+	_, err = newKeyHashFunc("md5")
+	assert.Error(t, err)
+}
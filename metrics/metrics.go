@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Labels identify a single series of a metric, for example the plugin instance it belongs to
+type Labels map[string]string
+
+// Counter is a goroutine safe, monotonically increasing count
+type Counter struct {
+	value uint64
+}
+
+// Add increases the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Histogram is a goroutine safe distribution of observations, counted in cumulative buckets
+type Histogram struct {
+	// upper bounds of the buckets, in increasing order
+	bounds []float64
+	// observations per bucket, the last one for observations above every bound
+	counts []uint64
+	count  uint64
+	// the sum of the observations, as the bits of a float64
+	sum uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds a single observation
+func (h *Histogram) Observe(v float64) {
+	bucket := sort.SearchFloat64s(h.bounds, v)
+	atomic.AddUint64(&h.counts[bucket], 1)
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sum, old, sum) {
+			return
+		}
+	}
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns the sum of the observations
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&h.sum))
+}
+
+type family struct {
+	help       string
+	series     map[string]*Counter
+	histograms map[string]*Histogram
+}
+
+// Registry holds named counters and renders them in the Prometheus text exposition format
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// DefaultRegistry is shared by all plugin instances in the process
+var DefaultRegistry = NewRegistry()
+
+// Counter returns the counter with the given name and labels, creating it if it does not exist
+func (r *Registry) Counter(name, help string, labels Labels) *Counter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{
+			help:   help,
+			series: make(map[string]*Counter),
+		}
+		r.families[name] = f
+	}
+
+	key := formatLabels(labels)
+	c, ok := f.series[key]
+	if !ok {
+		c = &Counter{}
+		f.series[key] = c
+	}
+	return c
+}
+
+// Histogram returns the histogram with the given name and labels, creating it with the
+// bucket upper bounds if it does not exist. Bounds must be in increasing order.
+func (r *Registry) Histogram(name, help string, labels Labels, bounds []float64) *Histogram {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{
+			help:       help,
+			histograms: make(map[string]*Histogram),
+		}
+		r.families[name] = f
+	}
+
+	key := formatLabels(labels)
+	h, ok := f.histograms[key]
+	if !ok {
+		h = newHistogram(bounds)
+		f.histograms[key] = h
+	}
+	return h
+}
+
+// NewCounter returns a counter from the DefaultRegistry
+func NewCounter(name, help string, labels Labels) *Counter {
+	return DefaultRegistry.Counter(name, help, labels)
+}
+
+// NewHistogram returns a histogram from the DefaultRegistry
+func NewHistogram(name, help string, labels Labels, bounds []float64) *Histogram {
+	return DefaultRegistry.Histogram(name, help, labels, bounds)
+}
+
+// WritePrometheus writes every counter in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		if f.histograms != nil {
+			if err := writeHistograms(w, name, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, f.help, name); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, err := fmt.Fprintf(w, "%s%s %d\n", name, key, f.series[key].Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeHistograms writes each series of a histogram family as cumulative buckets, a sum and a count
+func writeHistograms(w io.Writer, name string, f *family) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, f.help, name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(f.histograms))
+	for key := range f.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h := f.histograms[key]
+		var cumulative uint64
+		for i := range h.counts {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			le := "+Inf"
+			if i < len(h.bounds) {
+				le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(key, "le", le), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, key, strconv.FormatFloat(h.Sum(), 'g', -1, 64), name, key, h.Count()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withLabel adds a label to labels rendered by formatLabels
+func withLabel(formatted, name, value string) string {
+	label := fmt.Sprintf("%s=%q", name, value)
+	if formatted == "" {
+		return "{" + label + "}"
+	}
+	return formatted[:len(formatted)-1] + "," + label + "}"
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WritePrometheus(w)
+	})
+}
+
+// Server serves a registry over HTTP
+type Server struct {
+	*http.Server
+	listener net.Listener
+}
+
+// Close stops the server and releases its address immediately
+func (s *Server) Close() error {
+	err := s.Server.Close()
+	// the listener may not have been handed to the server yet
+	s.listener.Close()
+	return err
+}
+
+// Serve exposes the registry on /metrics at the given address, until the returned server is closed
+func (r *Registry) Serve(address string) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &Server{
+		Server: &http.Server{
+			Addr:    listener.Addr().String(),
+			Handler: mux,
+		},
+		listener: listener,
+	}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// formatLabels renders labels in a stable order, for example {plugin_id="0",stream="logs"}
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
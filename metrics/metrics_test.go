@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	a := registry.Counter("test_total", "A test counter.", Labels{"plugin_id": "0"})
+	b := registry.Counter("test_total", "A test counter.", Labels{"plugin_id": "1"})
+	a.Inc()
+	a.Add(2)
+	b.Inc()
+
+	assert.Equal(t, uint64(3), a.Value())
+	assert.Same(t, a, registry.Counter("test_total", "A test counter.", Labels{"plugin_id": "0"}), "Expected the same series to be returned")
+
+	var buf bytes.Buffer
+	assert.NoError(t, registry.WritePrometheus(&buf))
+	assert.Equal(t, "# HELP test_total A test counter.\n"+
+		"# TYPE test_total counter\n"+
+		"test_total{plugin_id=\"0\"} 3\n"+
+		"test_total{plugin_id=\"1\"} 1\n", buf.String())
+}
+
+func TestServe(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("served_total", "Served.", nil).Inc()
+
+	server, err := registry.Serve("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr + "/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "served_total 1\n")
+}
+
+func TestHistogramRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	h := registry.Histogram("latency_seconds", "A test histogram.", Labels{"plugin_id": "0"}, []float64{0.5, 1, 5})
+	for _, v := range []float64{0.1, 0.5, 2, 10} {
+		h.Observe(v)
+	}
+	assert.Equal(t, uint64(4), h.Count())
+	assert.Equal(t, 12.6, h.Sum())
+	assert.Same(t, h, registry.Histogram("latency_seconds", "A test histogram.", Labels{"plugin_id": "0"}, nil), "Expected the same series to be returned")
+
+	var buf bytes.Buffer
+	assert.NoError(t, registry.WritePrometheus(&buf))
+	assert.Equal(t, "# HELP latency_seconds A test histogram.\n"+
+		"# TYPE latency_seconds histogram\n"+
+		"latency_seconds_bucket{plugin_id=\"0\",le=\"0.5\"} 2\n"+
+		"latency_seconds_bucket{plugin_id=\"0\",le=\"1\"} 2\n"+
+		"latency_seconds_bucket{plugin_id=\"0\",le=\"5\"} 3\n"+
+		"latency_seconds_bucket{plugin_id=\"0\",le=\"+Inf\"} 4\n"+
+		"latency_seconds_sum{plugin_id=\"0\"} 12.6\n"+
+		"latency_seconds_count{plugin_id=\"0\"} 4\n", buf.String())
+}
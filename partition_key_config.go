@@ -0,0 +1,57 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rawLogKey is the field Fluent Bit inputs put the raw log line in
+const rawLogKey = "log"
+
+// validatePartitionKeyConfig rejects partition keys which are malformed or would
+// partition on the raw log body, which spreads records with no useful locality and
+// can exceed the partition key length limit. partitionKey may be a nested path with
+// '->' separators, and may use the '$' record accessor prefix by mistake, so both
+// are normalized before the checks. logKey is the field sent as the whole record
+// when log_key is set, which is the raw body as well.
+func validatePartitionKeyConfig(partitionKey, logKey string) error {
+	if partitionKey == "" {
+		return nil
+	}
+
+	segments := strings.Split(partitionKey, "->")
+	for i, segment := range segments {
+		segment = strings.TrimSpace(segment)
+		if i == 0 {
+			segment = strings.TrimPrefix(segment, "$")
+		}
+		if segment == "" {
+			return fmt.Errorf("'partition_key' %s has an empty key in its path", partitionKey)
+		}
+		segments[i] = segment
+	}
+
+	if len(segments) > 1 {
+		return nil
+	}
+	if segments[0] == rawLogKey {
+		return fmt.Errorf("'%s' cannot be set as the partition key", rawLogKey)
+	}
+	if logKey != "" && segments[0] == strings.TrimPrefix(strings.TrimSpace(logKey), "$") {
+		return fmt.Errorf("'partition_key' %s cannot be the same as 'log_key', since that field is sent as the whole record", partitionKey)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePartitionKeyConfig(t *testing.T) {
+	for _, partitionKey := range []string{"", "service", "log->level", "kubernetes->pod_name", "$kubernetes->pod_name", "message"} {
+		assert.NoError(t, validatePartitionKeyConfig(partitionKey, ""), partitionKey)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		partitionKey string
+		logKey       string
+	}{
+		{"raw log", "log", ""},
+		{"padded raw log", " log ", ""},
+		{"record accessor raw log", "$log", ""},
+		{"log key", "message", "message"},
+		{"record accessor log key", "$message", "message"},
+		{"empty leading segment", "->log", ""},
+		{"empty trailing segment", "kubernetes->", ""},
+		{"empty middle segment", "kubernetes->->pod_name", ""},
+		{"only separator", "->", ""},
+	} {
+		assert.Error(t, validatePartitionKeyConfig(tc.partitionKey, tc.logKey), tc.name)
+	}
+}
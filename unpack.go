@@ -0,0 +1,121 @@
+// Copyright 2019-2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//  http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+)
+
+// unpackStats counts the malformed entries skipped while decoding a chunk
+type unpackStats struct {
+	// entries or records which were msgpack nil
+	nullRecords int
+	// records which were an empty map
+	zeroLengthRecords int
+	// entries which could not be decoded as a [timestamp, record] pair
+	unmarshalErrors int
+}
+
+func (s unpackStats) malformed() int {
+	return s.nullRecords + s.zeroLengthRecords + s.unmarshalErrors
+}
+
+// decodeChunk decodes each [timestamp, record] entry in a chunk of msgpack data, calling fn
+// for every well formed record. Fluent Bit 2.1 and later write entries as
+// [[timestamp, metadata], record], whose metadata is passed to fn, while metadata is nil
+// for entries in the older format. Malformed entries are skipped and counted. Decoding
+// stops if fn returns anything other than FLB_OK, and that return code is passed back.
+func decodeChunk(data []byte, fn func(ts interface{}, metadata map[interface{}]interface{}, record map[interface{}]interface{}) int) (unpackStats, int) {
+	var stats unpackStats
+
+	// Decode the same way as the Fluent Bit decoder, but without hiding decode errors
+	handle := new(codec.MsgpackHandle)
+	handle.SetExt(reflect.TypeOf(output.FLBTime{}), 0, &output.FLBTime{})
+	dec := codec.NewDecoderBytes(data, handle)
+
+	for {
+		var entry interface{}
+		if err := dec.Decode(&entry); err != nil {
+			if err != io.EOF {
+				// the rest of the chunk can't be decoded once the stream is corrupt
+				stats.unmarshalErrors++
+			}
+			return stats, output.FLB_OK
+		}
+		if entry == nil {
+			stats.nullRecords++
+			continue
+		}
+
+		pair, ok := entry.([]interface{})
+		if !ok || len(pair) != 2 {
+			stats.unmarshalErrors++
+			continue
+		}
+
+		ts := pair[0]
+		var metadata map[interface{}]interface{}
+		if header, ok := ts.([]interface{}); ok {
+			if len(header) != 2 {
+				stats.unmarshalErrors++
+				continue
+			}
+			ts = header[0]
+			if metadata, ok = header[1].(map[interface{}]interface{}); !ok {
+				metadata = make(map[interface{}]interface{})
+			}
+		}
+
+		switch record := pair[1].(type) {
+		case nil:
+			stats.nullRecords++
+		case map[interface{}]interface{}:
+			if len(record) == 0 {
+				stats.zeroLengthRecords++
+				continue
+			}
+			if retCode := fn(ts, metadata, record); retCode != output.FLB_OK {
+				return stats, retCode
+			}
+		default:
+			stats.unmarshalErrors++
+		}
+	}
+}
+
+// recordUnpackStats adds the malformed entries found in a chunk to the plugin's metrics,
+// and summarizes them in a single log line
+func recordUnpackStats(pluginID int, logger *logrus.Entry, stats unpackStats) {
+	if stats.malformed() == 0 {
+		return
+	}
+
+	labels := metrics.Labels{"plugin_id": strconv.Itoa(pluginID)}
+	metrics.NewCounter("kinesis_unpack_null_records_total", "Records skipped because they were null.", labels).Add(uint64(stats.nullRecords))
+	metrics.NewCounter("kinesis_unpack_zero_length_records_total", "Records skipped because they were empty.", labels).Add(uint64(stats.zeroLengthRecords))
+	metrics.NewCounter("kinesis_unpack_unmarshal_errors_total", "Entries skipped because they could not be decoded.", labels).Add(uint64(stats.unmarshalErrors))
+
+	logger.WithFields(logrus.Fields{
+		"null_records":        stats.nullRecords,
+		"zero_length_records": stats.zeroLengthRecords,
+		"unmarshal_errors":    stats.unmarshalErrors,
+	}).Warnf("Skipped %d malformed records while unpacking chunk", stats.malformed())
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-kinesis-streams-for-fluent-bit/metrics"
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+// encodeChunk encodes entries back to back, the way Fluent Bit passes a chunk to the plugin
+func encodeChunk(t *testing.T, entries ...interface{}) []byte {
+	var data []byte
+	enc := codec.NewEncoderBytes(&data, new(codec.MsgpackHandle))
+	for _, entry := range entries {
+		assert.NoError(t, enc.Encode(entry))
+	}
+	return data
+}
+
+func decodeAll(t *testing.T, data []byte) (unpackStats, []map[interface{}]interface{}) {
+	var records []map[interface{}]interface{}
+	stats, retCode := decodeChunk(data, func(ts interface{}, metadata map[interface{}]interface{}, record map[interface{}]interface{}) int {
+		records = append(records, record)
+		return output.FLB_OK
+	})
+	assert.Equal(t, output.FLB_OK, retCode)
+	return stats, records
+}
+
+func TestDecodeChunkWellFormed(t *testing.T) {
+	stats, records := decodeAll(t, encodeChunk(t,
+		[]interface{}{uint64(1600000000), map[string]interface{}{"log": "one"}},
+		[]interface{}{uint64(1600000001), map[string]interface{}{"log": "two"}},
+	))
+	assert.Equal(t, unpackStats{}, stats)
+	assert.Len(t, records, 2)
+	assert.Equal(t, []byte("two"), records[1]["log"])
+}
+
+func TestDecodeChunkNullRecords(t *testing.T) {
+	stats, records := decodeAll(t, encodeChunk(t,
+		nil,
+		[]interface{}{uint64(1600000000), nil},
+		[]interface{}{uint64(1600000001), map[string]interface{}{"log": "ok"}},
+	))
+	assert.Equal(t, unpackStats{nullRecords: 2}, stats)
+	assert.Len(t, records, 1)
+}
+
+func TestDecodeChunkZeroLengthRecords(t *testing.T) {
+	stats, records := decodeAll(t, encodeChunk(t,
+		[]interface{}{uint64(1600000000), map[string]interface{}{}},
+		[]interface{}{uint64(1600000001), map[string]interface{}{"log": "ok"}},
+	))
+	assert.Equal(t, unpackStats{zeroLengthRecords: 1}, stats)
+	assert.Len(t, records, 1)
+}
+
+func TestDecodeChunkUnmarshalErrors(t *testing.T) {
+	stats, records := decodeAll(t, encodeChunk(t,
+		"not an entry",
+		[]interface{}{uint64(1600000000)},
+		[]interface{}{uint64(1600000001), "not a map"},
+		[]interface{}{uint64(1600000002), map[string]interface{}{"log": "ok"}},
+	))
+	assert.Equal(t, unpackStats{unmarshalErrors: 3}, stats)
+	assert.Len(t, records, 1)
+
+	// a corrupt chunk can't be decoded past the corruption, 0xc1 is never used by msgpack
+	data := encodeChunk(t, []interface{}{uint64(1600000000), map[string]interface{}{"log": "ok"}})
+	stats, records = decodeAll(t, append(append(data, 0xc1), data...))
+	assert.Equal(t, unpackStats{unmarshalErrors: 1}, stats)
+	assert.Len(t, records, 1)
+}
+
+func TestRecordUnpackStats(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer hook.Reset()
+
+	labels := metrics.Labels{"plugin_id": "42"}
+	nullRecords := metrics.NewCounter("kinesis_unpack_null_records_total", "", labels)
+	zeroLengthRecords := metrics.NewCounter("kinesis_unpack_zero_length_records_total", "", labels)
+	unmarshalErrors := metrics.NewCounter("kinesis_unpack_unmarshal_errors_total", "", labels)
+
+	logger := logrus.WithField("plugin_id", 42)
+	recordUnpackStats(42, logger, unpackStats{})
+	assert.Empty(t, hook.AllEntries(), "Expected well formed chunks not to be logged")
+
+	recordUnpackStats(42, logger, unpackStats{nullRecords: 1, zeroLengthRecords: 2, unmarshalErrors: 3})
+	recordUnpackStats(42, logger, unpackStats{unmarshalErrors: 1})
+	assert.Equal(t, uint64(1), nullRecords.Value())
+	assert.Equal(t, uint64(2), zeroLengthRecords.Value())
+	assert.Equal(t, uint64(4), unmarshalErrors.Value())
+
+	assert.Len(t, hook.AllEntries(), 2, "Expected a single log line per chunk")
+	entry := hook.AllEntries()[0]
+	assert.Equal(t, logrus.WarnLevel, entry.Level)
+	assert.Equal(t, 1, entry.Data["null_records"])
+	assert.Equal(t, 2, entry.Data["zero_length_records"])
+	assert.Equal(t, 3, entry.Data["unmarshal_errors"])
+}
+
+func TestDecodeChunkEventTimeNanoseconds(t *testing.T) {
+	// an entry with a Fluent Bit EventTime: msgpack fixext 8 of type 0, holding
+	// big endian seconds then nanoseconds
+	data := []byte{0x92, 0xd7, 0x00, 0x64, 0x2d, 0x0f, 0x0c, 0x07, 0x5b, 0xcd, 0x15}
+	data = append(data, encodeChunk(t, map[string]interface{}{"log": "one"})...)
+
+	var timestamps []interface{}
+	_, retCode := decodeChunk(data, func(ts interface{}, metadata map[interface{}]interface{}, record map[interface{}]interface{}) int {
+		timestamps = append(timestamps, ts)
+		return output.FLB_OK
+	})
+	assert.Equal(t, output.FLB_OK, retCode)
+	if assert.Len(t, timestamps, 1) {
+		assert.Equal(t, output.FLBTime{Time: time.Unix(1680674572, 123456789)}, timestamps[0])
+	}
+}
+
+func TestDecodeChunkMetadata(t *testing.T) {
+	data := encodeChunk(t,
+		[]interface{}{[]interface{}{uint64(1600000000), map[string]interface{}{"tenant": "a"}}, map[string]interface{}{"log": "one"}},
+		[]interface{}{[]interface{}{uint64(1600000001), map[string]interface{}{}}, map[string]interface{}{"log": "two"}},
+		[]interface{}{uint64(1600000002), map[string]interface{}{"log": "three"}},
+		[]interface{}{[]interface{}{uint64(1600000003)}, map[string]interface{}{"log": "four"}},
+	)
+
+	var timestamps []interface{}
+	var metadata []map[interface{}]interface{}
+	stats, retCode := decodeChunk(data, func(ts interface{}, meta map[interface{}]interface{}, record map[interface{}]interface{}) int {
+		timestamps = append(timestamps, ts)
+		metadata = append(metadata, meta)
+		return output.FLB_OK
+	})
+	assert.Equal(t, output.FLB_OK, retCode)
+	assert.Equal(t, unpackStats{unmarshalErrors: 1}, stats, "Expected a header without metadata to be malformed")
+	assert.Equal(t, []interface{}{uint64(1600000000), uint64(1600000001), uint64(1600000002)}, timestamps)
+	if assert.Len(t, metadata, 3) {
+		assert.Equal(t, map[interface{}]interface{}{"tenant": []byte("a")}, metadata[0])
+		assert.NotNil(t, metadata[1], "Expected empty metadata to be distinguished from the older format")
+		assert.Empty(t, metadata[1])
+		assert.Nil(t, metadata[2])
+	}
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Pacer is a goroutine safe leaky bucket, which spaces items evenly so that
+// they are never processed faster than a fixed rate
+type Pacer struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	// the earliest time the next item may be processed
+	next time.Time
+	now  func() time.Time
+}
+
+// NewPacer creates a Pacer which lets through ratePerSecond items every second
+func NewPacer(ratePerSecond int) *Pacer {
+	return newPacer(ratePerSecond, time.Now)
+}
+
+func newPacer(ratePerSecond int, now func() time.Time) *Pacer {
+	return &Pacer{
+		interval: time.Second / time.Duration(ratePerSecond),
+		next:     now(),
+		now:      now,
+	}
+}
+
+// Reserve reserves a slot for one item and returns how long the caller must wait
+// before processing it. If the wait would be longer than maxWait, no slot is
+// reserved and it returns false.
+func (p *Pacer) Reserve(maxWait time.Duration) (time.Duration, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	next := p.next
+	if next.Before(now) {
+		// unused slots while idle are not saved up for a later burst
+		next = now
+	}
+	wait := next.Sub(now)
+	if wait > maxWait {
+		return wait, false
+	}
+	p.next = next.Add(p.interval)
+	return wait, true
+}
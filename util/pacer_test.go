@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacer(t *testing.T) {
+	now := time.Unix(0, 0)
+	pacer := newPacer(10, func() time.Time { return now })
+
+	// a burst is spaced out at the configured rate
+	for i := 0; i < 5; i++ {
+		wait, ok := pacer.Reserve(time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(i)*100*time.Millisecond, wait)
+	}
+
+	// reservations which would wait too long are refused and do not take a slot
+	wait, ok := pacer.Reserve(200 * time.Millisecond)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// idle time is not saved up for a later burst
+	now = now.Add(time.Hour)
+	wait, ok = pacer.Reserve(0)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), wait)
+	_, ok = pacer.Reserve(0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a goroutine safe token bucket rate limiter
+type TokenBucket struct {
+	mutex    sync.Mutex
+	capacity float64
+	tokens   float64
+	// tokens added per nanosecond
+	refillRate float64
+	last       time.Time
+	now        func() time.Time
+}
+
+// NewTokenBucket creates a full bucket holding capacity tokens, which refills
+// at a rate of capacity tokens every refillInterval
+func NewTokenBucket(capacity int, refillInterval time.Duration) *TokenBucket {
+	return NewTokenBucketWithClock(capacity, refillInterval, time.Now)
+}
+
+// NewTokenBucketWithClock is NewTokenBucket with the clock the bucket is refilled by
+func NewTokenBucketWithClock(capacity int, refillInterval time.Duration, now func() time.Time) *TokenBucket {
+	return &TokenBucket{
+		capacity:   float64(capacity),
+		tokens:     float64(capacity),
+		refillRate: float64(capacity) / float64(refillInterval),
+		last:       now(),
+		now:        now,
+	}
+}
+
+// Take removes a token from the bucket, it returns false if the bucket is empty
+func (b *TokenBucket) Take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Available returns the number of whole tokens in the bucket
+func (b *TokenBucket) Available() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	return int(b.tokens)
+}
+
+func (b *TokenBucket) refill() {
+	now := b.now()
+	elapsed := now.Sub(b.last)
+	b.last = now
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += float64(elapsed) * b.refillRate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
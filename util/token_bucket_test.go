@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	bucket := NewTokenBucketWithClock(3, time.Minute, func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, bucket.Take(), "Expected full bucket to hand out its capacity")
+	}
+	assert.False(t, bucket.Take(), "Expected empty bucket to refuse tokens")
+
+	// a third of the interval refills a third of the capacity
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, 1, bucket.Available())
+	assert.True(t, bucket.Take())
+	assert.False(t, bucket.Take())
+
+	// refilling never exceeds the capacity
+	now = now.Add(time.Hour)
+	assert.Equal(t, 3, bucket.Available())
+}